@@ -63,7 +63,7 @@ type ClusterSpec struct {
 	// +optional
 
 	// GatewayHost, the Full Qualified Domain Name or IP of the gateway/ingress of this cluster
-	// If it's an IP address, only IPv4 is supported
+	// If it's an IP address, both IPv4 and IPv6 are supported
 	GatewayHost string `json:"gatewayHost,omitempty"`
 
 	// +kubebuilder:default=80
@@ -74,16 +74,38 @@ type ClusterSpec struct {
 	// The port number of the gateway
 	GatewayPort int32 `json:"gatewayPort,omitempty"`
 
-	// FIXME: temp solution, should NOT store this as plain text.
-	//  consider use cli to add cluster to control plane, import kubeconfig
-	//  and create a Secret with proper SA to store it as bytes
-
 	// +optional
 
 	// Kubeconfig, The kubeconfig of the cluster you want to connnect to
 	// This's not needed if ClusterMode is InCluster, it will use InCluster
 	// config
+	// Deprecated: it's stored as plain text, use KubeconfigSecretRef instead.
+	//  The inline kubeconfig is moved to a Secret automatically.
 	Kubeconfig string `json:"kubeconfig,omitempty"`
+
+	// +optional
+
+	// KubeconfigSecretRef, The Secret which stores the kubeconfig of the cluster you want to connect to
+	// This's not needed if ClusterMode is InCluster, it will use InCluster
+	// config
+	KubeconfigSecretRef *KubeconfigSecretReference `json:"kubeconfigSecretRef,omitempty"`
+}
+
+// KubeconfigSecretReference refers to the key of a Secret which stores a kubeconfig
+type KubeconfigSecretReference struct {
+	// Name, the name of the Secret
+	Name string `json:"name"`
+
+	// +optional
+
+	// Namespace, the namespace of the Secret, defaults to the namespace of FSM
+	Namespace string `json:"namespace,omitempty"`
+
+	// +kubebuilder:default=kubeconfig
+	// +optional
+
+	// Key, the key of the kubeconfig in the Secret data
+	Key string `json:"key,omitempty"`
 }
 
 // ClusterStatus defines the observed state of Cluster
@@ -94,6 +116,16 @@ type ClusterStatus struct {
 	// +listType=map
 	// +listMapKey=type
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// +optional
+
+	// LastHeartbeatTime, the last time the health of the cluster is probed
+	LastHeartbeatTime *metav1.Time `json:"lastHeartbeatTime,omitempty"`
+
+	// +optional
+
+	// FsmVersion, the version of FSM installed in the cluster
+	FsmVersion string `json:"fsmVersion,omitempty"`
 }
 
 // ClusterConditionType identifies a specific condition.
@@ -103,6 +135,14 @@ const (
 	// ClusterManaged means that the cluster has joined the CLusterSet successfully
 	//  and is managed by Control Plane.
 	ClusterManaged ClusterConditionType = "Managed"
+
+	// ClusterReady means that both the API server and the gateway of the cluster are reachable,
+	//  the cluster is removed from multicluster routing if it's not ready.
+	ClusterReady ClusterConditionType = "Ready"
+
+	// ClusterDegraded means that the cluster works but not in a healthy state,
+	//  i.e. the version of FSM is different from the Control Plane.
+	ClusterDegraded ClusterConditionType = "Degraded"
 )
 
 // +genclient
@@ -119,6 +159,8 @@ const (
 // +kubebuilder:printcolumn:name="Gateway Port",type="integer",priority=0,JSONPath=".spec.gatewayPort"
 // +kubebuilder:printcolumn:name="Managed",type="string",priority=0,JSONPath=".status.conditions[?(@.type=='Managed')].status"
 // +kubebuilder:printcolumn:name="Managed Age",type="date",priority=0,JSONPath=".status.conditions[?(@.type=='Managed')].lastTransitionTime"
+// +kubebuilder:printcolumn:name="Ready",type="string",priority=0,JSONPath=".status.conditions[?(@.type=='Ready')].status"
+// +kubebuilder:printcolumn:name="Last Heartbeat",type="date",priority=1,JSONPath=".status.lastHeartbeatTime"
 // +kubebuilder:printcolumn:name="Age",type="date",priority=0,JSONPath=".metadata.creationTimestamp"
 
 // Cluster is the Schema for the clusters API
@@ -38,6 +38,9 @@ type TrafficTarget struct {
 	// Format: [region]/[zone]/[group]/[cluster]
 	ClusterKey string `json:"clusterKey"`
 
+	// Weight of the cluster in an ActiveActive policy, relative to the others. The cluster is excluded if it's 0,
+	// it defaults to 100 if not specified.
+	// +kubebuilder:validation:Minimum=0
 	// +optional
 	Weight *int `json:"weight,omitempty"`
 }
@@ -55,6 +58,17 @@ type GlobalTrafficPolicySpec struct {
 
 // GlobalTrafficPolicyStatus defines the observed state of GlobalTrafficPolicy
 type GlobalTrafficPolicyStatus struct {
+	// +optional
+	// The generation of the policy which the effective policy is calculated from
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// +optional
+	// Type of global load distribution in effect
+	LbType LoadBalancerType `json:"lbType,omitempty"`
+
+	// +optional
+	// Clusters which the traffic is currently distributed to, with the effective weights
+	Targets []TrafficTarget `json:"targets,omitempty"`
 }
 
 // +genclient
@@ -52,9 +52,22 @@ type ServiceExportRule struct {
 	//   matches /foo/bar/baz, but does not match /foo/barbaz).
 
 	// +kubebuilder:validation:Enum=Exact;Prefix
-	PathType *networkingv1.PathType `json:"pathType"`
+	// +optional
+	// PathType is required if Protocol of the ServiceExport is HTTP
+	PathType *networkingv1.PathType `json:"pathType,omitempty"`
 }
 
+// ServiceExportProtocol is the protocol the service is exported with
+type ServiceExportProtocol string
+
+const (
+	// ServiceExportProtocolHTTP exports the service by the paths of Rules through the Ingress controller
+	ServiceExportProtocolHTTP ServiceExportProtocol = "HTTP"
+
+	// ServiceExportProtocolTCP exports each port of the service on a dedicated port of the cluster gateway
+	ServiceExportProtocolTCP ServiceExportProtocol = "TCP"
+)
+
 type PathRewrite struct {
 	From string `json:"from,omitempty"`
 	To   string `json:"to,omitempty"`
@@ -62,6 +75,13 @@ type PathRewrite struct {
 
 // ServiceExportSpec defines the desired state of ServiceExport
 type ServiceExportSpec struct {
+	// +kubebuilder:default=HTTP
+	// +kubebuilder:validation:Enum=HTTP;TCP
+	// +optional
+	// Protocol of exporting the service, for TCP the ports of Rules are exported on the ports allocated
+	// on the cluster gateway, and the paths of Rules are ignored
+	Protocol ServiceExportProtocol `json:"protocol,omitempty"`
+
 	// +optional
 	// PathRewrite, it shares ONE rewrite rule for the same ServiceExport
 	PathRewrite *PathRewrite `json:"pathRewrite,omitempty"`
@@ -99,6 +119,28 @@ type ServiceExportStatus struct {
 	// +listType=map
 	// +listMapKey=type
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// +optional
+	// GatewayPorts are the ports allocated on the cluster gateway for the service exported with TCP protocol
+	GatewayPorts []GatewayPort `json:"gatewayPorts,omitempty"`
+
+	// +optional
+	// GatewayAddress is the address of the Service exposing the gateway ports if it's a LoadBalancer, other clusters
+	// reach the gateway ports by it instead of the gateway host of cluster
+	GatewayAddress string `json:"gatewayAddress,omitempty"`
+}
+
+// GatewayPort is the port allocated on the cluster gateway for a port of TCP exported service
+type GatewayPort struct {
+	// The port number of service
+	PortNumber int32 `json:"portNumber"`
+
+	// The port number on the cluster gateway
+	GatewayPort int32 `json:"gatewayPort"`
+
+	// +optional
+	// The node port of the gateway port, it's set if the cluster gateway is exposed as NodePort
+	NodePort int32 `json:"nodePort,omitempty"`
 }
 
 // ServiceExportConditionType identifies a specific condition.
@@ -155,3 +197,34 @@ type ServiceExportList struct {
 func init() {
 	SchemeBuilder.Register(&ServiceExport{}, &ServiceExportList{})
 }
+
+// IsTCP returns true if the service is exported with TCP protocol
+func (s *ServiceExport) IsTCP() bool {
+	return s.Spec.Protocol == ServiceExportProtocolTCP
+}
+
+// GatewayPortOf returns the port allocated on the cluster gateway for the port of service, 0 if not allocated yet
+func (s *ServiceExport) GatewayPortOf(port int32) int32 {
+	for _, p := range s.Status.GatewayPorts {
+		if p.PortNumber == port {
+			return p.GatewayPort
+		}
+	}
+
+	return 0
+}
+
+// ExposedPortOf returns the port other clusters reach the port of service through the cluster gateway, it's the
+// node port if the gateway is exposed as NodePort, 0 if not allocated yet
+func (s *ServiceExport) ExposedPortOf(port int32) int32 {
+	for _, p := range s.Status.GatewayPorts {
+		if p.PortNumber == port {
+			if p.NodePort != 0 {
+				return p.NodePort
+			}
+			return p.GatewayPort
+		}
+	}
+
+	return 0
+}
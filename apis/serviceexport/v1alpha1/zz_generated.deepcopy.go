@@ -35,6 +35,21 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewayPort) DeepCopyInto(out *GatewayPort) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewayPort.
+func (in *GatewayPort) DeepCopy() *GatewayPort {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewayPort)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PathRewrite) DeepCopyInto(out *PathRewrite) {
 	*out = *in
@@ -171,6 +186,11 @@ func (in *ServiceExportStatus) DeepCopyInto(out *ServiceExportStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.GatewayPorts != nil {
+		in, out := &in.GatewayPorts, &out.GatewayPorts
+		*out = make([]GatewayPort, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceExportStatus.
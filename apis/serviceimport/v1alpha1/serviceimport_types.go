@@ -25,15 +25,20 @@
 package v1alpha1
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"strings"
 )
 
 // ServiceImportType designates the type of a ServiceImport
 type ServiceImportType string
 
+const derivedServicePrefix = "clusterset-"
+
 const (
 	// ClusterSetIP are only accessible via the ClusterSet IP.
 	ClusterSetIP ServiceImportType = "ClusterSetIP"
@@ -190,3 +195,15 @@ type ServiceImportList struct {
 func init() {
 	SchemeBuilder.Register(&ServiceImport{}, &ServiceImportList{})
 }
+
+// DerivedServiceName is the name of the Service derived from the ServiceImport, it's different from the name of
+// ServiceImport so that it never conflicts with the local Service of the same name
+func (s *ServiceImport) DerivedServiceName() string {
+	name := derivedServicePrefix + s.Name
+	if len(name) <= validation.DNS1035LabelMaxLength {
+		return name
+	}
+
+	hash := sha256.Sum256([]byte(s.Namespace + "/" + s.Name))
+	return derivedServicePrefix + hex.EncodeToString(hash[:])[:validation.DNS1035LabelMaxLength-len(derivedServicePrefix)]
+}
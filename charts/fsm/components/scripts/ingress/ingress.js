@@ -22,4 +22,15 @@
  * SOFTWARE.
  */
 
-JSON.decode(pipy.load('config/ingress.json'))
\ No newline at end of file
+((
+  router = JSON.decode(pipy.load('config/router.json')),
+  balancer = JSON.decode(pipy.load('config/balancer.json')),
+  certificates = JSON.decode(pipy.load('config/certificates.json')),
+  tcpRouter = JSON.decode(pipy.load('config/tcp-router.json')),
+) => ({
+  trustedCAs: certificates?.trustedCAs || [],
+  certificates: certificates?.certificates || {},
+  routes: router?.routes || {},
+  services: balancer?.services || {},
+  tcpRoutes: tcpRouter?.routes || {},
+}))()
@@ -28,8 +28,26 @@
     issuingCAs
   } = pipy.solve('config.js'),
 
+  tcpRoutes = pipy.solve('ingress.js').tcpRoutes,
+
+  // listens on the gateway ports allocated for the services exported with TCP protocol
+  listenTCP = conf => (
+    Object.entries(tcpRoutes).forEach(
+      ([port, service]) => (
+        conf
+          .listen(Number.parseInt(port))
+          .onStart(
+            () => void(__route = service)
+          )
+          .link('inbound-tcp')
+      )
+    ),
+    conf
+  ),
+
   ) =>
 
+  listenTCP(
   pipy({
     _passthroughTarget: undefined,
   })
@@ -37,6 +55,7 @@
     __route: undefined,
     __isTLS: false,
   })
+  )
 
   .branch(
     Boolean(config?.http?.enabled), (
@@ -114,4 +133,7 @@
     .demuxHTTP().to(
       $=>$.chain(config.plugins)
     )
+
+  .pipeline('inbound-tcp')
+    .use('tcp.js')
 )()
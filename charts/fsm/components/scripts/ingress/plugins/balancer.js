@@ -23,6 +23,9 @@
  */
 ((
     ingress = pipy.solve('ingress.js'),
+    zone = os.env.PIPY_INGRESS_ZONE || '',
+    // IPv6 addresses must be enclosed in brackets
+    address = ep => ep.ip.indexOf(':') >= 0 ? `[${ep.ip}]:${ep.port}` : `${ep.ip}:${ep.port}`,
     upstreamMapIssuingCA = {},
     upstreamIssuingCAs = [],
     addUpstreamIssuingCA = (ca) => (
@@ -34,6 +37,11 @@
         )
       ))()
     ),
+    // endpoints failed to connect are considered unhealthy for a while, a passive health check
+    unhealthyPeriod = 10000,
+    unhealthy = {},
+    isHealthy = target => !(unhealthy[target] > Date.now()),
+    markUnhealthy = target => target && (unhealthy[target] = Date.now() + unhealthyPeriod),
     balancers = {
       'round-robin': algo.RoundRobinLoadBalancer,
       'least-work': algo.LeastWorkLoadBalancer,
@@ -43,18 +51,33 @@
       Object.fromEntries(
         Object.entries(ingress.services).map(
           ([k, v]) =>(
-            ((targets, balancer, balancerInst) => (
-              targets = v?.upstream?.endpoints?.map?.(ep => `${ep.ip}:${ep.port}`),
+            ((targets, localTargets, balancer, balancerInst, localBalancerInst, minLocalEndpoints, hasEnoughLocal) => (
+              targets = v?.upstream?.endpoints?.map?.(address),
+              localTargets = (zone && v?.topology?.enabled) ? (
+                v?.upstream?.endpoints?.filter?.(ep => ep.zone === zone)?.map?.(address)
+              ) : [],
               v?.upstream?.sslCert?.ca && (
                 addUpstreamIssuingCA(v.upstream.sslCert.ca)
               ),
               balancer = balancers[v?.balancer || 'round-robin'] || balancers['round-robin'],
               balancerInst = new balancer(targets || []),
+              minLocalEndpoints = v?.topology?.minLocalEndpoints || 1,
+              // prefers endpoints in the same zone, only if there're enough of them
+              localBalancerInst = (localTargets?.length > 0 && localTargets.length >= minLocalEndpoints) ? (
+                new balancer(localTargets)
+              ) : null,
+              // spills over to endpoints in all zones once the healthy local ones are not enough
+              hasEnoughLocal = () => Boolean(localBalancerInst) && (
+                localTargets.filter(isHealthy).length >= minLocalEndpoints
+              ),
 
               [k, {
                 balancer: balancerInst,
+                localBalancer: localBalancerInst,
+                localTargets: Object.fromEntries((localBalancerInst ? localTargets : []).map(t => [t, true])),
+                hasEnoughLocal,
                 cache: v?.sticky && new algo.Cache(
-                  () => balancerInst.next()
+                  () => (hasEnoughLocal() && localBalancerInst.next()) || balancerInst.next()
                 ),
                 upstreamSSLName: v?.upstream?.sslName || null,
                 upstreamSSLVerify: v?.upstream?.sslVerify || false,
@@ -98,7 +121,8 @@
       service?.cache && key ? (
         service?.cache?.get(key)
       ) : (
-        service?.balancer?.next({})
+        // spills over to endpoints in all zones if none of the local ones is available
+        (service?.hasEnoughLocal?.() && service.localBalancer.next({})) || service?.balancer?.next({})
       )
     ),
   })
@@ -115,7 +139,7 @@
         _serviceCache = new algo.Cache(
           // k is a balancer, v is a target
           (k) => _select(k, _sourceIP),
-          (k, v) => k.balancer.deselect(v?.id),
+          (k, v) => (k.localTargets[v?.id] && k.localBalancer ? k.localBalancer : k.balancer).deselect(v?.id),
         ),
         _targetCache = new algo.Cache(
           // k is a target, v is a connection ID
@@ -199,4 +223,7 @@
         $=>$.chain()
       )
     )
+    .handleStreamEnd(
+      (e) => e?.error && markUnhealthy(_target?.id)
+    )
 )()
\ No newline at end of file
@@ -0,0 +1,77 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+((
+  ingress = pipy.solve('ingress.js'),
+  // IPv6 addresses must be enclosed in brackets
+  address = ep => ep.ip.indexOf(':') >= 0 ? `[${ep.ip}]:${ep.port}` : `${ep.ip}:${ep.port}`,
+  balancers = {
+    'round-robin': algo.RoundRobinLoadBalancer,
+    'least-work': algo.LeastWorkLoadBalancer,
+    'hashing': algo.HashingLoadBalancer,
+  },
+  // only the services routed by gateway ports are balanced here
+  services = (
+    Object.fromEntries(
+      Object.values(ingress.tcpRoutes).map(
+        k => [
+          k,
+          new (balancers[ingress.services[k]?.balancer] || balancers['round-robin'])(
+            ingress.services[k]?.upstream?.endpoints?.map?.(address) || []
+          )
+        ]
+      )
+    )
+  ),
+
+) => pipy({
+  _balancer: null,
+  _target: null,
+})
+
+.import({
+  __route: 'main',
+})
+
+.pipeline()
+  .onStart(
+    () => void(
+      _balancer = services[__route],
+      _target = _balancer?.next?.({})
+    )
+  )
+  .branch(
+    () => Boolean(_target), (
+      $=>$.connect(() => _target.id)
+    ), (
+      $=>$.replaceStreamStart(new StreamEnd)
+    )
+  )
+  .onEnd(
+    () => void(
+      _target && _balancer.deselect(_target.id)
+    )
+  )
+
+)()
@@ -31,8 +31,44 @@ Service Address - repo-service
 Service URL(http) - repo-service
 */}}
 {{- define "fsm.repo-service.url" -}}
+{{- if eq .Values.fsm.repo.backend "file" }}
+{{- printf "file://%s" .Values.fsm.repo.file.path -}}
+{{- else if eq .Values.fsm.repo.backend "configmap" }}
+{{- printf "configmap://%s" (include "fsm.namespace" .) -}}
+{{- else }}
 {{- printf "%s://%s" .Values.fsm.repo.schema (include "fsm.repo-service.addr" .) -}}
 {{- end }}
+{{- end }}
+
+{{/*
+Whether the Pipy repo is installed
+*/}}
+{{- define "fsm.repo.installed" -}}
+{{- if and (not .Values.fsm.repo.preProvision.enabled) (eq .Values.fsm.repo.backend "pipy") }}true{{- end }}
+{{- end }}
+
+{{/*
+Whether the connections to Pipy repo are secured by TLS
+*/}}
+{{- define "fsm.repo.tls.enabled" -}}
+{{- if and (eq .Values.fsm.repo.backend "pipy") (eq .Values.fsm.repo.schema "https") }}true{{- end }}
+{{- end }}
+
+{{/*
+Whether codebases are served by manager, it's true if the repo backend isn't pipy or Pipy repo requires mTLS
+*/}}
+{{- define "fsm.repo.served-by-manager" -}}
+{{- if or (ne .Values.fsm.repo.backend "pipy") (and (include "fsm.repo.tls.enabled" .) .Values.fsm.repo.tls.mTLS) }}true{{- end }}
+{{- end }}
+
+{{/*
+Service URL - codebases served by manager if the repo backend isn't pipy or Pipy repo requires mTLS
+*/}}
+{{- define "fsm.repo-serve.url" -}}
+{{- if include "fsm.repo.served-by-manager" . }}
+{{- printf "%s://%s:%d" (ternary "https" "http" (eq (include "fsm.repo.tls.enabled" .) "true")) (include "fsm.manager.host" .) (int .Values.fsm.services.manager.repoPort) -}}
+{{- end }}
+{{- end }}
 
 {{/*
 Service Host - webhook-service
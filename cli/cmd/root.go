@@ -50,6 +50,7 @@ func init() {
 	actionConfig := new(action.Configuration)
 	RootCmd.AddCommand(newCmdInstall(actionConfig, stdout))
 	RootCmd.AddCommand(newCmdUninstall(actionConfig, os.Stdin, stdout))
+	RootCmd.AddCommand(newCmdSnapshot(os.Stdin, stdout))
 	RootCmd.AddCommand(newCmdVersion(stdout))
 
 	// run when each command's execute method is called
@@ -0,0 +1,389 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/flomesh-io/fsm-classic/pkg/kube"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// managerMetricsPort is the port of the metrics server of manager which serves the repo snapshot API, it's bound
+	// to localhost of the pod, so it's reached by port forwarding
+	managerMetricsPort = 8080
+	managerAppLabel    = "flomesh.io/app=fsm-manager"
+)
+
+type snapshotSummary struct {
+	Name      string            `json:"name,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Codebases []codebaseSummary `json:"codebases"`
+}
+
+type codebaseSummary struct {
+	Path    string `json:"path"`
+	Version int64  `json:"version"`
+	Files   int    `json:"files"`
+}
+
+type snapshotCmd struct {
+	out       io.Writer
+	in        io.Reader
+	namespace string
+	token     string
+	k8sApi    *kube.K8sAPI
+}
+
+func newCmdSnapshot(in io.Reader, out io.Writer) *cobra.Command {
+	snapshot := &snapshotCmd{
+		out: out,
+		in:  in,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "manage snapshots of the ingress and services codebases in repo",
+		Long: `manage snapshots of the ingress and services codebases in repo.
+
+The commands call the repo snapshot API of fsm-manager through port forwarding, the
+bearer token must be allowed to get or post the non-resource URLs /repo/snapshots/*.`,
+		PersistentPreRunE: func(_ *cobra.Command, args []string) error {
+			api, err := kube.NewAPI(30 * time.Second)
+			if err != nil {
+				return errors.Errorf("Error creating K8sAPI Client: %s", err)
+			}
+			snapshot.k8sApi = api
+
+			return nil
+		},
+	}
+
+	f := cmd.PersistentFlags()
+	f.StringVar(&snapshot.namespace, "namespace", "flomesh", "Namespace of the service mesh")
+	f.StringVar(&snapshot.token, "token", "", "Bearer token to access the manager, the token of kubeconfig is used if it's empty")
+
+	cmd.AddCommand(snapshot.newCmdList())
+	cmd.AddCommand(snapshot.newCmdSave())
+	cmd.AddCommand(snapshot.newCmdExport())
+	cmd.AddCommand(snapshot.newCmdRestore())
+	cmd.AddCommand(snapshot.newCmdResume())
+
+	return cmd
+}
+
+func (s *snapshotCmd) newCmdList() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "list stored snapshots, the newest comes first",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, args []string) error {
+			summaries := make([]snapshotSummary, 0)
+			if err := s.call(http.MethodGet, "/repo/snapshots", nil, nil, &summaries); err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(s.out, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "NAME\tCREATED\tCODEBASES")
+			for _, summary := range summaries {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", summary.Name, summary.CreatedAt.Local().Format(time.RFC3339), codebasesOf(summary))
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+func (s *snapshotCmd) newCmdSave() *cobra.Command {
+	return &cobra.Command{
+		Use:   "save",
+		Short: "take a snapshot of the current codebases and store it",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, args []string) error {
+			summary := snapshotSummary{}
+			if err := s.call(http.MethodPost, "/repo/snapshots", nil, nil, &summary); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(s.out, "Snapshot %s is saved: %s\n", summary.Name, codebasesOf(summary))
+			return nil
+		},
+	}
+}
+
+func (s *snapshotCmd) newCmdExport() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "export [NAME]",
+		Short: "export a stored snapshot, or the current codebases if NAME is omitted, as a tarball",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			query := url.Values{}
+			name := "current"
+			if len(args) > 0 {
+				name = args[0]
+				query.Set("name", name)
+			}
+			if output == "" {
+				output = name + ".tar.gz"
+			}
+
+			file, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			if err := s.call(http.MethodGet, "/repo/snapshots/export", query, nil, file); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(s.out, "Snapshot %s is exported to %s\n", name, output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "File of the exported tarball, defaults to <NAME>.tar.gz")
+
+	return cmd
+}
+
+func (s *snapshotCmd) newCmdRestore() *cobra.Command {
+	var file string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "restore [NAME]",
+		Short: "restore a stored snapshot, a tarball, or the last known-good snapshot if neither is given",
+		Long: `restore a stored snapshot, a tarball, or the last known-good snapshot if neither is given.
+
+Codebases are pinned to the restored snapshot, routes are not pushed to repo until
+'fsm snapshot resume' is run.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			query := url.Values{}
+			target := "the last known-good snapshot"
+			var body io.Reader
+			switch {
+			case len(args) > 0 && file != "":
+				return errors.New("NAME and --file can't be given at the same time")
+			case len(args) > 0:
+				query.Set("name", args[0])
+				target = fmt.Sprintf("snapshot %s", args[0])
+			case file != "":
+				f, err := os.Open(file)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				body = f
+				target = file
+			}
+
+			if !force {
+				confirm, err := confirm(s.in, s.out, fmt.Sprintf("\nRestore the ingress and services codebases to %s?", target), 3)
+				if !confirm || err != nil {
+					return err
+				}
+			}
+
+			summary := snapshotSummary{}
+			if err := s.call(http.MethodPost, "/repo/snapshots/restore", query, body, &summary); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(s.out, "Codebases are restored to %s: %s\n", summary.Name, codebasesOf(summary))
+			fmt.Fprintf(s.out, "Routes are not pushed to repo until 'fsm snapshot resume' is run\n")
+			return nil
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&file, "file", "f", "", "Tarball exported by 'fsm snapshot export' to restore")
+	f.BoolVar(&force, "force", false, "Restore without prompting for confirmation")
+
+	return cmd
+}
+
+func (s *snapshotCmd) newCmdResume() *cobra.Command {
+	return &cobra.Command{
+		Use:   "resume",
+		Short: "unpin the restored snapshot, all routes are pushed to repo on next sync",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, args []string) error {
+			if err := s.call(http.MethodPost, "/repo/snapshots/resume", nil, nil, nil); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(s.out, "Pushing routes to repo is resumed\n")
+			return nil
+		},
+	}
+}
+
+// call sends the request to a running manager, the response is decoded into result if it's JSON, or copied to it
+// if it's an io.Writer
+func (s *snapshotCmd) call(method, path string, query url.Values, body io.Reader, result interface{}) error {
+	token, err := s.bearerToken()
+	if err != nil {
+		return err
+	}
+
+	localPort, stop, err := s.forwardManager()
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	u := url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", localPort), Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("%s %s: %s, %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	switch r := result.(type) {
+	case nil:
+		return nil
+	case io.Writer:
+		_, err = io.Copy(r, resp.Body)
+		return err
+	default:
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+}
+
+func (s *snapshotCmd) bearerToken() (string, error) {
+	switch {
+	case s.token != "":
+		return s.token, nil
+	case s.k8sApi.Config.BearerToken != "":
+		return s.k8sApi.Config.BearerToken, nil
+	case s.k8sApi.Config.BearerTokenFile != "":
+		token, err := os.ReadFile(s.k8sApi.Config.BearerTokenFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(token)), nil
+	default:
+		return "", errors.New("kubeconfig has no bearer token, pass one by --token, e.g. $(kubectl create token <service-account>)")
+	}
+}
+
+// forwardManager forwards a local port to the metrics server of a running manager pod
+func (s *snapshotCmd) forwardManager() (uint16, func(), error) {
+	pod, err := s.managerPod()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	transport, upgrader, err := spdy.RoundTripperFor(s.k8sApi.Config)
+	if err != nil {
+		return 0, nil, err
+	}
+	req := s.k8sApi.Client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())
+
+	stopCh, readyCh := make(chan struct{}), make(chan struct{})
+	fw, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{fmt.Sprintf("0:%d", managerMetricsPort)}, stopCh, readyCh, io.Discard, io.Discard)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fw.ForwardPorts()
+	}()
+
+	select {
+	case err := <-errCh:
+		return 0, nil, errors.Errorf("Error forwarding port to pod %s/%s: %s", pod.Namespace, pod.Name, err)
+	case <-readyCh:
+	}
+
+	ports, err := fw.GetPorts()
+	if err != nil {
+		close(stopCh)
+		return 0, nil, err
+	}
+
+	return ports[0].Local, func() { close(stopCh) }, nil
+}
+
+func (s *snapshotCmd) managerPod() (*corev1.Pod, error) {
+	pods, err := s.k8sApi.Client.CoreV1().
+		Pods(s.namespace).
+		List(context.TODO(), metav1.ListOptions{LabelSelector: managerAppLabel})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == corev1.PodRunning {
+			return &pods.Items[i], nil
+		}
+	}
+
+	return nil, errors.Errorf("no running fsm-manager pod is found in namespace %s", s.namespace)
+}
+
+func codebasesOf(summary snapshotSummary) string {
+	codebases := make([]string, 0, len(summary.Codebases))
+	for _, codebase := range summary.Codebases {
+		codebases = append(codebases, fmt.Sprintf("%s@%d(%d files)", codebase.Path, codebase.Version, codebase.Files))
+	}
+
+	return strings.Join(codebases, ", ")
+}
@@ -52,6 +52,9 @@ import (
 const (
 	HealthPath = "/healthz"
 	ReadyPath  = "/readyz"
+
+	// IngressZoneEnvName is the env passed to pipy, so that the balancer is able to prefer endpoints in the same zone
+	IngressZoneEnvName = "PIPY_INGRESS_ZONE"
 )
 
 type startArgs struct {
@@ -90,8 +93,12 @@ func main() {
 	spawn := ing.calcPipySpawn()
 	klog.Infof("PIPY SPAWN = %d", spawn)
 
+	// detect the topology zone of the ingress pod
+	zone := ing.ingressZone()
+	klog.Infof("Ingress Zone = %q", zone)
+
 	// start pipy
-	startPipy(spawn, ingressRepoUrl)
+	startPipy(spawn, ingressRepoUrl, zone, mc.RepoClientTLSArgs())
 
 	startHealthAndReadyProbeServer()
 }
@@ -185,13 +192,36 @@ func (i *ingress) getIngressCpuLimitsQuota() (*resource.Quantity, error) {
 	return nil, errors.Errorf("No container named 'ingress' in POD %q", pod.Name)
 }
 
-func startPipy(spawn int64, ingressRepoUrl string) {
+func (i *ingress) ingressZone() string {
+	pod, err := i.getIngressPod()
+	if err != nil {
+		klog.Warningf("Failed to get ingress pod, topology aware routing is disabled: %s", err)
+		return ""
+	}
+
+	if pod.Spec.NodeName == "" {
+		return ""
+	}
+
+	node, err := i.k8sApi.Client.CoreV1().Nodes().Get(context.TODO(), pod.Spec.NodeName, metav1.GetOptions{})
+	if err != nil {
+		klog.Warningf("Failed to get node %s, topology aware routing is disabled: %s", pod.Spec.NodeName, err)
+		return ""
+	}
+
+	return node.Labels[corev1.LabelTopologyZone]
+}
+
+func startPipy(spawn int64, ingressRepoUrl string, zone string, tlsArgs []string) {
 	args := []string{ingressRepoUrl}
 	if spawn > 1 {
 		args = append([]string{"--reuse-port", fmt.Sprintf("--threads=%d", spawn)}, args...)
 	}
+	// certificates to load codebase from https:// repo
+	args = append(tlsArgs, args...)
 
 	cmd := exec.Command("pipy", args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", IngressZoneEnvName, zone))
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
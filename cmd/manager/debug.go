@@ -0,0 +1,72 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/flomesh-io/fsm-classic/pkg/cache"
+	"github.com/flomesh-io/fsm-classic/pkg/kube"
+	"k8s.io/klog/v2"
+	"net/http"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	debugRoutesPath = "/debug/routes"
+)
+
+// registerDebugHandlers serves the debug endpoints on the metrics server, requests must carry a bearer token
+// which is allowed to GET the path
+func registerDebugHandlers(mgr manager.Manager, api *kube.K8sAPI) {
+	if err := mgr.AddMetricsExtraHandler(debugRoutesPath, kube.WithAuth(api, http.HandlerFunc(debugRoutes))); err != nil {
+		klog.Error(err, "unable to set up debug handler")
+		os.Exit(1)
+	}
+}
+
+// debugRoutes dumps the routing state computed by the local cache, it's filterable by query parameters namespace and host
+func debugRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter := cache.RoutesFilter{
+		Namespace: r.URL.Query().Get("namespace"),
+		Host:      r.URL.Query().Get("host"),
+	}
+
+	dump, ok := cache.DumpActiveRoutes(filter)
+	if !ok {
+		http.Error(w, "Local cache is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(dump); err != nil {
+		klog.Errorf("Failed to encode routes: %s", err)
+	}
+}
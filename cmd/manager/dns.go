@@ -0,0 +1,79 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	"github.com/flomesh-io/fsm-classic/pkg/dns"
+	"github.com/flomesh-io/fsm-classic/pkg/kube"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+func registerClusterSetDNS(mgr manager.Manager, api *kube.K8sAPI, mc *config.MeshConfig) {
+	if !mc.ClusterSetDNS.Enabled {
+		return
+	}
+
+	port := mc.ClusterSetDNS.Port
+	if port == 0 {
+		port = commons.DefaultClusterSetDNSPort
+	}
+
+	server := dns.NewServer(mgr.GetClient(), mc.ClusterSetDomain(), port)
+	if err := mgr.Add(server); err != nil {
+		klog.Errorf("unable to add ClusterSet DNS server, %s", err)
+		os.Exit(1)
+	}
+
+	if !mc.ClusterSetDNS.CoreDNS.Patch {
+		return
+	}
+
+	// CoreDNS forwards the queries of ClusterSet domain to the manager
+	coreDNS := types.NamespacedName{
+		Namespace: mc.ClusterSetDNS.CoreDNS.Namespace,
+		Name:      mc.ClusterSetDNS.CoreDNS.ConfigMap,
+	}
+	if coreDNS.Namespace == "" {
+		coreDNS.Namespace = commons.DefaultCoreDNSNamespace
+	}
+	if coreDNS.Name == "" {
+		coreDNS.Name = commons.DefaultCoreDNSConfigMapName
+	}
+	service := types.NamespacedName{Namespace: mc.GetMeshNamespace(), Name: mc.ClusterSetDNS.Service}
+	if service.Name == "" {
+		service.Name = commons.ManagerDeploymentName
+	}
+
+	patcher := dns.NewCorefilePatcher(api.Client, mc.ClusterSetDomain(), port, service, coreDNS)
+	if err := mgr.Add(patcher); err != nil {
+		klog.Errorf("unable to add Corefile patcher of CoreDNS, %s", err)
+		os.Exit(1)
+	}
+}
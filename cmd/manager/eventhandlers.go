@@ -30,6 +30,7 @@ import (
 	"github.com/flomesh-io/fsm-classic/pkg/config"
 	"github.com/flomesh-io/fsm-classic/pkg/config/listener"
 	lcfg "github.com/flomesh-io/fsm-classic/pkg/config/listener/config"
+	"github.com/flomesh-io/fsm-classic/pkg/event"
 	"github.com/flomesh-io/fsm-classic/pkg/kube"
 	"github.com/flomesh-io/fsm-classic/pkg/repo"
 	corev1 "k8s.io/api/core/v1"
@@ -39,7 +40,7 @@ import (
 	"time"
 )
 
-func registerEventHandler(mgr manager.Manager, api *kube.K8sAPI, configStore *config.Store, certMgr certificate.Manager, repoClient *repo.PipyRepoClient) {
+func registerEventHandler(mgr manager.Manager, api *kube.K8sAPI, configStore *config.Store, certMgr certificate.Manager, repoClient *repo.PipyRepoClient, broker *event.Broker) {
 
 	// FIXME: make it configurable
 	resyncPeriod := 15 * time.Minute
@@ -57,6 +58,7 @@ func registerEventHandler(mgr manager.Manager, api *kube.K8sAPI, configStore *co
 		ConfigStore:        configStore,
 		CertificateManager: certMgr,
 		RepoClient:         repoClient,
+		Broker:             broker,
 	}
 
 	listeners := []config.MeshConfigChangeListener{
@@ -64,6 +66,8 @@ func registerEventHandler(mgr manager.Manager, api *kube.K8sAPI, configStore *co
 		listener.NewIngressConfigListener(listenerConfig),
 		listener.NewProxyProfileConfigListener(listenerConfig),
 		listener.NewLoggingConfigListener(listenerConfig),
+		listener.NewCacheConfigListener(listenerConfig),
+		listener.NewTracingConfigListener(listenerConfig),
 	}
 
 	config.RegisterConfigurationHanlder(
@@ -0,0 +1,106 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/flomesh-io/fsm-classic/pkg/cache"
+	"github.com/flomesh-io/fsm-classic/pkg/repo"
+	"k8s.io/klog/v2"
+	"net/http"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"time"
+)
+
+const (
+	informersSyncTimeout = 500 * time.Millisecond
+)
+
+// addLivenessAndReadinessCheck registers the probes, each readiness check is also served on /readyz/<name>
+func addLivenessAndReadinessCheck(mgr manager.Manager, repoClient *repo.PipyRepoClient) {
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		klog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+
+	readyzChecks := map[string]healthz.Checker{
+		"repo":      repoChecker(repoClient),
+		"informers": informersChecker(mgr),
+		"routes":    routesChecker(mgr),
+	}
+	for name, check := range readyzChecks {
+		if err := mgr.AddReadyzCheck(name, check); err != nil {
+			klog.Error(err, "unable to set up ready check", "check", name)
+			os.Exit(1)
+		}
+	}
+}
+
+// repoChecker checks if the Pipy repo is reachable
+func repoChecker(repoClient *repo.PipyRepoClient) healthz.Checker {
+	return func(_ *http.Request) error {
+		if !repoClient.IsRepoUp() {
+			return fmt.Errorf("repo is not reachable")
+		}
+
+		return nil
+	}
+}
+
+// informersChecker checks if the informer caches of the manager are synced
+func informersChecker(mgr manager.Manager) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), informersSyncTimeout)
+		defer cancel()
+
+		if !mgr.GetCache().WaitForCacheSync(ctx) {
+			return fmt.Errorf("informer caches are not synced")
+		}
+
+		return nil
+	}
+}
+
+// routesChecker checks if the service and ingress routes have been pushed to the repo at least once, the local
+// cache is started only after its informers are synced. The local cache runs in the leader only, a standby replica
+// is reported ready, otherwise it never becomes ready and a rolling update can't hand over the leadership.
+func routesChecker(mgr manager.Manager) healthz.Checker {
+	return func(_ *http.Request) error {
+		select {
+		case <-mgr.Elected():
+		default:
+			return nil
+		}
+
+		if !cache.ActiveRoutesSynced() {
+			return fmt.Errorf("initial routes are not pushed to repo")
+		}
+
+		return nil
+	}
+}
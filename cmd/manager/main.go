@@ -28,6 +28,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"github.com/flomesh-io/fsm-classic/pkg/certificate"
 	"github.com/flomesh-io/fsm-classic/pkg/commons"
 	"github.com/flomesh-io/fsm-classic/pkg/config"
 	"github.com/flomesh-io/fsm-classic/pkg/event"
@@ -56,8 +57,8 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	gwschema "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned/scheme"
+	mcsschema "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -70,6 +71,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(flomeshscheme.AddToScheme(scheme))
 	utilruntime.Must(gwschema.AddToScheme(scheme))
+	utilruntime.Must(mcsschema.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -109,6 +111,17 @@ func main() {
 		os.Exit(1)
 	}
 
+	// TLS & authentication of connections to pipy repo
+	if err := setupRepoClientOptions(k8sApi, certMgr, mc); err != nil {
+		os.Exit(1)
+	}
+
+	// certificates of the repo server and pods which load codebases from it
+	if err := ensureRepoTLSSecrets(k8sApi, certMgr, mc); err != nil {
+		klog.Errorf("Failed to issue certificates of repo: %s", err)
+		os.Exit(1)
+	}
+
 	// upload init scripts to pipy repo
 	repoClient := repo.NewRepoClient(mc.RepoRootURL())
 	initRepo(repoClient)
@@ -122,6 +135,10 @@ func main() {
 	// setup Logging
 	setupLogging(k8sApi, repoClient, mc)
 
+	// setup Tracing
+	shutdownTracing := setupTracing(mc)
+	defer shutdownTracing()
+
 	// create a new manager for controllers
 	mgr := newManager(kubeconfig, options)
 
@@ -137,14 +154,27 @@ func main() {
 	// register Reconcilers
 	registerReconcilers(mgr, k8sApi, controlPlaneConfigStore, certMgr, broker)
 
-	registerEventHandler(mgr, k8sApi, controlPlaneConfigStore, certMgr, repoClient)
+	registerEventHandler(mgr, k8sApi, controlPlaneConfigStore, certMgr, repoClient, broker)
+
+	// serve DNS records of imported services
+	registerClusterSetDNS(mgr, k8sApi, mc)
+
+	// serve codebases to sidecars and ingress if the backend isn't Pipy repo
+	registerRepoServer(mgr, repoClient, certMgr, mc)
 
 	// add endpoints for Liveness and Readiness check
-	addLivenessAndReadinessCheck(mgr)
+	addLivenessAndReadinessCheck(mgr, repoClient)
+
+	// add debug endpoints
+	registerDebugHandlers(mgr, k8sApi)
+
+	// add repo snapshot endpoints
+	snapshotStore := newRepoSnapshotStore(k8sApi, repoClient, mc)
+	registerSnapshotHandlers(mgr, k8sApi, snapshotStore)
 	//+kubebuilder:scaffold:builder
 
 	// start the controller manager
-	startManager(mgr, mc, repoClient)
+	startManager(mgr, k8sApi, certMgr, mc, repoClient, snapshotStore)
 }
 
 func processFlags() *startArgs {
@@ -210,18 +240,7 @@ func getClusterUID(api *kube.K8sAPI, mc *config.MeshConfig) string {
 	return string(ns.UID)
 }
 
-func addLivenessAndReadinessCheck(mgr manager.Manager) {
-	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
-		klog.Error(err, "unable to set up health check")
-		os.Exit(1)
-	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		klog.Error(err, "unable to set up ready check")
-		os.Exit(1)
-	}
-}
-
-func startManager(mgr manager.Manager, mc *config.MeshConfig, repoClient *repo.PipyRepoClient) {
+func startManager(mgr manager.Manager, api *kube.K8sAPI, certMgr certificate.Manager, mc *config.MeshConfig, repoClient *repo.PipyRepoClient, snapshotStore *repoSnapshotStore) {
 	klog.V(5).Infof("===> RepoRecoverIntervalInSeconds: %d", mc.Repo.RecoverIntervalInSeconds)
 	s := gocron.NewScheduler(time.Local)
 	s.SingletonModeAll()
@@ -230,6 +249,21 @@ func startManager(mgr manager.Manager, mc *config.MeshConfig, repoClient *repo.P
 		Do(rebuildRepoJob, repoClient, mgr.GetClient(), mc); err != nil {
 		klog.Errorf("Error happened while rebuilding repo: %s", err)
 	}
+	if _, err := s.Every(5).Minutes().
+		Name("gc-repo").
+		Do(gcRepoJob, repoClient, mgr.GetClient(), mc); err != nil {
+		klog.Errorf("Error happened while garbage collecting repo: %s", err)
+	}
+	if _, err := s.Every(1).Minutes().
+		Name("snapshot-repo").
+		Do(snapshotRepoJob, snapshotStore); err != nil {
+		klog.Errorf("Error happened while taking snapshot of repo: %s", err)
+	}
+	if _, err := s.Every(1).Hours().
+		Name("renew-repo-certs").
+		Do(ensureRepoTLSSecrets, api, certMgr, mc); err != nil {
+		klog.Errorf("Error happened while renewing certificates of repo: %s", err)
+	}
 	s.RegisterEventListeners(
 		gocron.AfterJobRuns(func(jobName string) {
 			klog.Infof(">>>>>> afterJobRuns: %s\n", jobName)
@@ -28,6 +28,7 @@ import (
 	clusterv1alpha1 "github.com/flomesh-io/fsm-classic/controllers/cluster/v1alpha1"
 	"github.com/flomesh-io/fsm-classic/controllers/flb"
 	gatewayv1beta1 "github.com/flomesh-io/fsm-classic/controllers/gateway/v1beta1"
+	mcsv1alpha1 "github.com/flomesh-io/fsm-classic/controllers/mcs/v1alpha1"
 	nsigv1alpha1 "github.com/flomesh-io/fsm-classic/controllers/namespacedingress/v1alpha1"
 	proxyprofilev1alpha1 "github.com/flomesh-io/fsm-classic/controllers/proxyprofile/v1alpha1"
 	svcexpv1alpha1 "github.com/flomesh-io/fsm-classic/controllers/serviceexport/v1alpha1"
@@ -37,7 +38,6 @@ import (
 	"github.com/flomesh-io/fsm-classic/pkg/config"
 	"github.com/flomesh-io/fsm-classic/pkg/event"
 	"github.com/flomesh-io/fsm-classic/pkg/kube"
-	"github.com/flomesh-io/fsm-classic/pkg/util"
 	"k8s.io/klog/v2"
 	"os"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -54,6 +54,10 @@ func registerReconcilers(mgr manager.Manager, api *kube.K8sAPI, controlPlaneConf
 		registerGatewayAPIs(mgr, api, controlPlaneConfigStore)
 	}
 
+	if mc.MCSApi.Enabled {
+		registerMCSAPIs(mgr, api, controlPlaneConfigStore)
+	}
+
 	if mc.Ingress.Namespaced {
 		registerNamespacedIngress(mgr, api, controlPlaneConfigStore, certMgr)
 	}
@@ -100,7 +104,6 @@ func registerCluster(mgr manager.Manager, api *kube.K8sAPI, controlPlaneConfigSt
 		controlPlaneConfigStore,
 		broker,
 		certMgr,
-		util.RegisterOSExitHandlers(),
 	)).SetupWithManager(mgr); err != nil {
 		klog.Fatal(err, "unable to create controller", "controller", "Cluster")
 		os.Exit(1)
@@ -134,6 +137,30 @@ func registerServiceImport(mgr manager.Manager, api *kube.K8sAPI, controlPlaneCo
 	}
 }
 
+func registerMCSAPIs(mgr manager.Manager, api *kube.K8sAPI, controlPlaneConfigStore *config.Store) {
+	if err := (&mcsv1alpha1.ServiceExportReconciler{
+		Client:                  mgr.GetClient(),
+		K8sAPI:                  api,
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("MCSServiceExport"),
+		ControlPlaneConfigStore: controlPlaneConfigStore,
+	}).SetupWithManager(mgr); err != nil {
+		klog.Fatal(err, "unable to create controller", "controller", "MCSServiceExport")
+		os.Exit(1)
+	}
+
+	if err := (&mcsv1alpha1.ServiceImportReconciler{
+		Client:                  mgr.GetClient(),
+		K8sAPI:                  api,
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("MCSServiceImport"),
+		ControlPlaneConfigStore: controlPlaneConfigStore,
+	}).SetupWithManager(mgr); err != nil {
+		klog.Fatal(err, "unable to create controller", "controller", "MCSServiceImport")
+		os.Exit(1)
+	}
+}
+
 func registerNamespacedIngress(mgr manager.Manager, api *kube.K8sAPI, controlPlaneConfigStore *config.Store, certMgr certificate.Manager) {
 	if err := (&nsigv1alpha1.NamespacedIngressReconciler{
 		Client:                  mgr.GetClient(),
@@ -26,28 +26,178 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	nsigv1alpha1 "github.com/flomesh-io/fsm-classic/apis/namespacedingress/v1alpha1"
 	pfv1alpha1 "github.com/flomesh-io/fsm-classic/apis/proxyprofile/v1alpha1"
 	pfhelper "github.com/flomesh-io/fsm-classic/apis/proxyprofile/v1alpha1/helper"
+	"github.com/flomesh-io/fsm-classic/pkg/certificate"
 	"github.com/flomesh-io/fsm-classic/pkg/commons"
 	"github.com/flomesh-io/fsm-classic/pkg/config"
 	"github.com/flomesh-io/fsm-classic/pkg/config/utils"
+	"github.com/flomesh-io/fsm-classic/pkg/kube"
 	"github.com/flomesh-io/fsm-classic/pkg/repo"
 	"io/ioutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"strings"
 	"time"
 )
 
 const (
 	ScriptsRoot = "/repo/scripts"
+
+	// gcGracePeriod is how long a codebase must stay orphaned before it's garbage collected
+	gcGracePeriod = 2 * time.Minute
+)
+
+var (
+	// orphanedSince is when the orphaned codebases were seen orphaned first, gcRepoJob never runs concurrently
+	orphanedSince = map[string]time.Time{}
 )
 
+// setupRepoClientOptions configures TLS and bearer token of connections to Pipy repo, it must be called
+// before any repo client is created. The options only apply to clients of the local repo.
+func setupRepoClientOptions(api *kube.K8sAPI, certMgr certificate.Manager, mc *config.MeshConfig) error {
+	opts := repo.ClientOptions{}
+
+	if mc.IsRepoTLSEnabled() {
+		tlsConfig, err := repoTLSConfig(api, certMgr, mc)
+		if err != nil {
+			klog.Errorf("Failed to build TLS config of repo: %s", err)
+			return err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+
+	if mc.Repo.Auth.TokenSecretName != "" {
+		tokenSource := newRepoTokenSource(api, mc.GetRepoTokenSecretNamespace(), mc.Repo.Auth.TokenSecretName)
+		// fails fast if the token isn't available at startup
+		if _, err := tokenSource.Token(context.TODO()); err != nil {
+			klog.Errorf("Failed to get token of repo: %s", err)
+			return err
+		}
+		opts.TokenSource = tokenSource.Token
+	}
+
+	repo.SetClientOptions(mc.RepoRootURL(), opts)
+
+	return nil
+}
+
+func repoTLSConfig(api *kube.K8sAPI, certMgr certificate.Manager, mc *config.MeshConfig) (*tls.Config, error) {
+	caPEM, err := repoCAPEM(api, certMgr, mc)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no valid CA certificate is found for repo")
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:            pool,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: mc.Repo.TLS.InsecureSkipVerify,
+	}
+
+	// only the client certificate of manager is issued by the repo writer CA, it's the one trusted by the repo
+	if mc.Repo.TLS.MTLS {
+		writerCA, err := ensureRepoWriterCA(api, mc.GetMeshNamespace())
+		if err != nil {
+			return nil, err
+		}
+		cn := fmt.Sprintf("%s.%s", commons.ManagerDeploymentName, mc.GetMeshNamespace())
+		clientCert := newRotatingCertificate(writerCA, cn, commons.RepoManagerCertValidityPeriod)
+		if _, err := clientCert.GetClientCertificate(nil); err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = clientCert.GetClientCertificate
+	}
+
+	return tlsConfig, nil
+}
+
+// repoCAPEM returns the CA to verify the certificate of repo
+func repoCAPEM(api *kube.K8sAPI, certMgr certificate.Manager, mc *config.MeshConfig) ([]byte, error) {
+	if mc.Repo.TLS.CASecretName != "" {
+		secret, err := api.Client.CoreV1().
+			Secrets(mc.GetRepoCASecretNamespace()).
+			Get(context.TODO(), mc.Repo.TLS.CASecretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		return secret.Data[commons.RootCACertName], nil
+	}
+
+	rootCert, err := certMgr.GetRootCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	return rootCert.CA, nil
+}
+
+func registerRepoServer(mgr manager.Manager, repoClient *repo.PipyRepoClient, certMgr certificate.Manager, mc *config.MeshConfig) {
+	if !mc.IsRepoServedByManager() {
+		return
+	}
+
+	// reads of Pipy repo are served over mTLS, the client certificates of pods are verified by FSM CA
+	var tlsConfig *tls.Config
+	if mc.IsPipyRepo() && mc.IsRepoTLSEnabled() {
+		var err error
+		if tlsConfig, err = repoServerTLSConfig(certMgr, mc); err != nil {
+			klog.Errorf("unable to build TLS config of repo server, %s", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := mgr.Add(repoClient.NewServer(mc.RepoServePort(), tlsConfig)); err != nil {
+		klog.Errorf("unable to add repo server, %s", err)
+		os.Exit(1)
+	}
+}
+
+// repoServerTLSConfig requires client certificates issued by FSM CA, the certificate of server is issued for the host
+// of the serve URL and renewed before it expires
+func repoServerTLSConfig(certMgr certificate.Manager, mc *config.MeshConfig) (*tls.Config, error) {
+	rootCert, err := certMgr.GetRootCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(rootCert.CA) {
+		return nil, fmt.Errorf("no valid CA certificate is found in FSM CA bundle")
+	}
+
+	u, err := url.Parse(mc.RepoServeURL())
+	if err != nil {
+		return nil, err
+	}
+	serverCert := newRotatingCertificate(certMgr, u.Hostname(), commons.RepoManagerCertValidityPeriod)
+	if _, err := serverCert.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		GetCertificate: serverCert.GetCertificate,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		ClientCAs:      pool,
+		MinVersion:     tls.VersionTLS12,
+	}, nil
+}
+
 func initRepo(repoClient *repo.PipyRepoClient) {
 	// wait until pipy repo is up or timeout after 5 minutes
 	if err := wait.PollImmediate(5*time.Second, 60*5*time.Second, func() (bool, error) {
@@ -64,7 +214,7 @@ func initRepo(repoClient *repo.PipyRepoClient) {
 	}
 
 	// initialize the repo
-	if err := repoClient.Batch([]repo.Batch{ingressBatch(), servicesBatch()}); err != nil {
+	if err := repoClient.Batch(context.TODO(), []repo.Batch{ingressBatch(), servicesBatch()}); err != nil {
 		os.Exit(1)
 	}
 }
@@ -142,7 +292,7 @@ func rebuildRepoJob(repoClient *repo.PipyRepoClient, client client.Client, mc *c
 		batches = append(batches, servicesBatch())
 	}
 	if len(batches) > 0 {
-		if err := repoClient.Batch(batches); err != nil {
+		if err := repoClient.Batch(context.TODO(), batches); err != nil {
 			klog.Errorf("Failed to write config to repo: %s", err)
 			return err
 		}
@@ -210,3 +360,82 @@ func rebuildRepoJob(repoClient *repo.PipyRepoClient, client client.Client, mc *c
 	klog.Infof("<<<<<< rebuilding repo - end >>>>>> ")
 	return nil
 }
+
+// gcRepoJob removes codebases of NamespacedIngresses, ProxyProfiles and sidecars which don't exist anymore.
+// The codebases are listed before the resources, a codebase created after the resources are listed is never seen as
+// orphaned. As the resources are read from the cache of manager, a codebase is only deleted if it's still orphaned
+// after gcGracePeriod, deleting a codebase also deletes the codebases which derive it.
+func gcRepoJob(repoClient *repo.PipyRepoClient, client client.Client, mc *config.MeshConfig) error {
+	klog.Infof("<<<<<< garbage collecting repo - start >>>>>> ")
+
+	if !repoClient.IsRepoUp() {
+		klog.V(2).Info("Repo is not up, sleeping ...")
+		return nil
+	}
+
+	// the paths with empty names are the parent paths of the managed codebases
+	prefixes := []string{
+		mc.NamespacedIngressCodebasePath(""),
+		pfhelper.GetProxyProfilePath("", mc),
+		strings.TrimSuffix(pfhelper.GetSidecarPath("", "", mc), "/"),
+	}
+
+	var codebases []string
+	for _, prefix := range prefixes {
+		paths, err := repoClient.ListCodebases(prefix)
+		if err != nil {
+			return err
+		}
+		codebases = append(codebases, paths...)
+	}
+
+	expected := make(map[string]bool)
+
+	nsigList := &nsigv1alpha1.NamespacedIngressList{}
+	if err := client.List(context.TODO(), nsigList); err != nil {
+		return err
+	}
+	for _, nsig := range nsigList.Items {
+		expected[mc.NamespacedIngressCodebasePath(nsig.Namespace)] = true
+	}
+
+	pfList := &pfv1alpha1.ProxyProfileList{}
+	if err := client.List(context.TODO(), pfList); err != nil {
+		return err
+	}
+	for _, pf := range pfList.Items {
+		expected[pfhelper.GetProxyProfilePath(pf.Name, mc)] = true
+		for _, sidecar := range pf.Spec.Sidecars {
+			expected[pfhelper.GetSidecarPath(pf.Name, sidecar.Name, mc)] = true
+		}
+	}
+
+	now := time.Now()
+	orphaned := make(map[string]time.Time)
+	for _, path := range codebases {
+		if expected[path] {
+			continue
+		}
+
+		since, ok := orphanedSince[path]
+		if !ok {
+			since = now
+		}
+		if now.Sub(since) < gcGracePeriod {
+			klog.V(2).Infof("Codebase %q is orphaned since %s, deleting it after the grace period", path, since.Format(time.RFC3339))
+			orphaned[path] = since
+			continue
+		}
+
+		klog.V(2).Infof("Codebase %q is orphaned, deleting it ...", path)
+		if err := repoClient.DeleteCodebase(path); err != nil {
+			klog.Errorf("Failed to delete orphaned codebase %q: %s", path, err)
+			orphaned[path] = since
+			continue
+		}
+	}
+	orphanedSince = orphaned
+
+	klog.Infof("<<<<<< garbage collecting repo - end >>>>>> ")
+	return nil
+}
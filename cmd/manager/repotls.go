@@ -0,0 +1,457 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"github.com/flomesh-io/fsm-classic/pkg/certificate"
+	certutils "github.com/flomesh-io/fsm-classic/pkg/certificate/utils"
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	"github.com/flomesh-io/fsm-classic/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// repoTokenRefreshInterval is how long the token of repo is cached before it's read from the Secret again
+	repoTokenRefreshInterval = time.Minute
+)
+
+// needsRenewal returns true once less than 1/3 of the validity is left
+func needsRenewal(notAfter time.Time, validity time.Duration) bool {
+	return time.Until(notAfter) < validity/3
+}
+
+// certIssuer issues certificates, it's implemented by certificate.Manager and repoWriterCA
+type certIssuer interface {
+	IssueCertificate(cn string, validityPeriod time.Duration, dnsNames []string) (*certificate.Certificate, error)
+}
+
+// rotatingCertificate issues a certificate of manager, i.e. the client certificate to repo, and renews it before it
+// expires
+type rotatingCertificate struct {
+	issuer   certIssuer
+	cn       string
+	validity time.Duration
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	notAfter time.Time
+}
+
+func newRotatingCertificate(issuer certIssuer, cn string, validity time.Duration) *rotatingCertificate {
+	return &rotatingCertificate{issuer: issuer, cn: cn, validity: validity}
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate, it's called on each TLS handshake
+func (c *rotatingCertificate) GetClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.get()
+}
+
+// GetCertificate implements tls.Config.GetCertificate, it's called on each TLS handshake
+func (c *rotatingCertificate) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.get()
+}
+
+func (c *rotatingCertificate) get() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cert != nil && !needsRenewal(c.notAfter, c.validity) {
+		return c.cert, nil
+	}
+
+	cert, err := c.issuer.IssueCertificate(c.cn, c.validity, []string{c.cn})
+	if err == nil {
+		var tlsCert tls.Certificate
+		if tlsCert, err = tls.X509KeyPair(cert.CrtPEM, cert.KeyPEM); err == nil {
+			klog.V(2).Infof("Issued certificate %q of repo, it expires at %s", c.cn, cert.Expiration)
+			c.cert = &tlsCert
+			c.notAfter = cert.Expiration
+			return c.cert, nil
+		}
+	}
+
+	// keeps using the current one until it expires
+	if c.cert != nil && time.Now().Before(c.notAfter) {
+		klog.Warningf("Failed to renew certificate %q of repo, the current one expires at %s: %s", c.cn, c.notAfter, err)
+		return c.cert, nil
+	}
+
+	return nil, err
+}
+
+// repoWriterCA issues the client certificate of manager to Pipy repo with mTLS, the repo trusts only it. As sidecars
+// and ingress hold certificates of the FSM certificate manager, they can't write codebases with them.
+type repoWriterCA struct {
+	cert  *x509.Certificate
+	key   *rsa.PrivateKey
+	caPEM []byte
+}
+
+// ensureRepoWriterCA loads the writer CA from its Secret, it's created if it doesn't exist
+func ensureRepoWriterCA(api *kube.K8sAPI, namespace string) (*repoWriterCA, error) {
+	secrets := api.Client.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(context.TODO(), commons.RepoWriterCASecretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if secret, err = newRepoWriterCASecret(namespace); err != nil {
+			return nil, err
+		}
+
+		klog.V(2).Infof("Creating Secret %s/%s of repo writer CA", namespace, commons.RepoWriterCASecretName)
+		secret, err = secrets.Create(context.TODO(), secret, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			// created by another replica
+			secret, err = secrets.Get(context.TODO(), commons.RepoWriterCASecretName, metav1.GetOptions{})
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	caPEM := secret.Data[commons.RootCACertName]
+	cert, err := certutils.ConvertPEMCertToX509(caPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate of repo writer CA: %w", err)
+	}
+	key, err := certutils.ConvertPEMPrivateKeyToX509(secret.Data[commons.RootCAPrivateKeyName])
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key of repo writer CA: %w", err)
+	}
+
+	return &repoWriterCA{cert: cert, key: key, caPEM: caPEM}, nil
+}
+
+func newRepoWriterCASecret(namespace string) (*corev1.Secret, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, certificate.SerialNumberLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commons.RepoWriterCASecretName},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(commons.RepoWriterCAValidityPeriod),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	caPEM, err := certutils.CertToPEM(der)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := certutils.RSAKeyToPEM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: commons.RepoWriterCASecretName, Namespace: namespace},
+		Data: map[string][]byte{
+			commons.RootCACertName:       caPEM,
+			commons.RootCAPrivateKeyName: keyPEM,
+		},
+	}, nil
+}
+
+// IssueCertificate implements certIssuer, the certificate is for client authentication only
+func (ca *repoWriterCA) IssueCertificate(cn string, validityPeriod time.Duration, dnsNames []string) (*certificate.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, certificate.SerialNumberLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(validityPeriod),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+
+	crtPEM, err := certutils.CertToPEM(der)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := certutils.RSAKeyToPEM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &certificate.Certificate{
+		CommonName:   cn,
+		SerialNumber: serial.String(),
+		CA:           ca.caPEM,
+		CrtPEM:       crtPEM,
+		KeyPEM:       keyPEM,
+		Expiration:   template.NotAfter,
+	}, nil
+}
+
+// repoTokenSource reads the token of repo from the Secret, it's cached for repoTokenRefreshInterval
+type repoTokenSource struct {
+	api       *kube.K8sAPI
+	namespace string
+	name      string
+
+	mu        sync.Mutex
+	token     string
+	refreshAt time.Time
+}
+
+func newRepoTokenSource(api *kube.K8sAPI, namespace, name string) *repoTokenSource {
+	return &repoTokenSource{api: api, namespace: namespace, name: name}
+}
+
+func (s *repoTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Before(s.refreshAt) {
+		return s.token, nil
+	}
+
+	token, err := s.read(ctx)
+	if err != nil {
+		if s.token != "" {
+			klog.Warningf("Failed to refresh token of repo, keeps using the current one: %s", err)
+			return s.token, nil
+		}
+		return "", err
+	}
+
+	s.token = token
+	s.refreshAt = time.Now().Add(repoTokenRefreshInterval)
+
+	return s.token, nil
+}
+
+func (s *repoTokenSource) read(ctx context.Context) (string, error) {
+	secret, err := s.api.Client.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get token Secret %s/%s of repo: %w", s.namespace, s.name, err)
+	}
+
+	token := strings.TrimSpace(string(secret.Data[commons.RepoTokenKey]))
+	if token == "" {
+		return "", fmt.Errorf("key %q of Secret %s/%s is empty", commons.RepoTokenKey, secret.Namespace, secret.Name)
+	}
+
+	return token, nil
+}
+
+// ensureRepoTLSSecrets issues the certificate of the repo server and the client certificate of pods which load
+// codebases from repo, and renews them before they expire. The renewed client certificate is synced to the copies in
+// namespaces of sidecars. Pipy loads certificates on start, the repo and pods pick up renewed ones once restarted.
+// With mTLS, the repo trusts only the repo writer CA of manager, the pods load codebases from the manager with their
+// client certificates, so that they can't write codebases.
+func ensureRepoTLSSecrets(api *kube.K8sAPI, certMgr certificate.Manager, mc *config.MeshConfig) error {
+	if !mc.IsPipyRepo() || !mc.IsRepoTLSEnabled() {
+		return nil
+	}
+
+	rootCert, err := certMgr.GetRootCertificate()
+	if err != nil {
+		return err
+	}
+
+	ns := mc.GetMeshNamespace()
+
+	// the repo certificate is issued by FSM if no CA is provided, the ca.crt of it is what the repo trusts
+	if mc.Repo.TLS.CASecretName == "" {
+		trustedPEM := rootCert.CA
+		if mc.Repo.TLS.MTLS {
+			writerCA, err := ensureRepoWriterCA(api, ns)
+			if err != nil {
+				return err
+			}
+			trustedPEM = writerCA.caPEM
+		}
+
+		u, err := url.Parse(mc.RepoRootURL())
+		if err != nil {
+			return err
+		}
+		host := u.Hostname()
+		if _, err := ensureCertSecret(api, ns, commons.RepoServerTLSSecretName, nil, trustedPEM, func() (*certificate.Certificate, error) {
+			return certMgr.IssueCertificate(host, commons.RepoCertValidityPeriod, []string{host})
+		}); err != nil {
+			return err
+		}
+	}
+
+	// pods verify the repo with ca.crt, it's the manager which serves codebases to them with mTLS
+	caPEM := rootCert.CA
+	if !mc.IsRepoServedByManager() {
+		if caPEM, err = repoCAPEM(api, certMgr, mc); err != nil {
+			return err
+		}
+	}
+
+	var issue func() (*certificate.Certificate, error)
+	if mc.Repo.TLS.MTLS {
+		cn := fmt.Sprintf("repo-client.%s", ns)
+		issue = func() (*certificate.Certificate, error) {
+			return certMgr.IssueCertificate(cn, commons.RepoCertValidityPeriod, []string{cn})
+		}
+	}
+	labels := map[string]string{commons.RepoClientTLSSecretLabel: "true"}
+	secret, err := ensureCertSecret(api, ns, commons.RepoClientTLSSecretName, labels, caPEM, issue)
+	if err != nil {
+		return err
+	}
+
+	return syncRepoClientTLSSecrets(api, secret)
+}
+
+// ensureCertSecret creates or renews the Secret with ca.crt and the certificate issued by issue, the certificate is
+// left out if issue is nil
+func ensureCertSecret(api *kube.K8sAPI, namespace, name string, labels map[string]string, caPEM []byte, issue func() (*certificate.Certificate, error)) (*corev1.Secret, error) {
+	secret, err := api.Client.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	exists := err == nil
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	if exists && bytes.Equal(secret.Data[commons.RootCACertName], caPEM) && !certNeedsRenewal(secret, issue != nil) {
+		return secret, nil
+	}
+
+	data := map[string][]byte{commons.RootCACertName: caPEM}
+	if issue != nil {
+		cert, err := issue()
+		if err != nil {
+			return nil, err
+		}
+		data[commons.TLSCertName] = cert.CrtPEM
+		data[commons.TLSPrivateKeyName] = cert.KeyPEM
+	}
+
+	if !exists {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			Data:       data,
+		}
+		klog.V(2).Infof("Creating Secret %s/%s of repo certificate", namespace, name)
+		return api.Client.CoreV1().Secrets(namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
+	}
+
+	secret.Data = data
+	klog.V(2).Infof("Renewing Secret %s/%s of repo certificate", namespace, name)
+	return api.Client.CoreV1().Secrets(namespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+}
+
+func certNeedsRenewal(secret *corev1.Secret, withCert bool) bool {
+	crtPEM, ok := secret.Data[commons.TLSCertName]
+	if !withCert {
+		return ok
+	}
+	if !ok {
+		return true
+	}
+
+	cert, err := certutils.ConvertPEMCertToX509(crtPEM)
+	if err != nil {
+		return true
+	}
+
+	return needsRenewal(cert.NotAfter, commons.RepoCertValidityPeriod)
+}
+
+// syncRepoClientTLSSecrets updates the copies of client Secret in namespaces of sidecars
+func syncRepoClientTLSSecrets(api *kube.K8sAPI, source *corev1.Secret) error {
+	copies, err := api.Client.CoreV1().Secrets(corev1.NamespaceAll).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: commons.RepoClientTLSSecretLabel + "=true",
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range copies.Items {
+		c := &copies.Items[i]
+		if c.Namespace == source.Namespace && c.Name == source.Name {
+			continue
+		}
+		if c.Name != commons.RepoClientTLSSecretName || secretDataEqual(c.Data, source.Data) {
+			continue
+		}
+
+		c.Data = source.Data
+		if _, err := api.Client.CoreV1().Secrets(c.Namespace).Update(context.TODO(), c, metav1.UpdateOptions{}); err != nil {
+			klog.Errorf("Failed to sync Secret %s/%s of repo client certificate: %s", c.Namespace, c.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func secretDataEqual(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if !bytes.Equal(v, b[k]) {
+			return false
+		}
+	}
+
+	return true
+}
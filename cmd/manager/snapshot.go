@@ -0,0 +1,543 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/flomesh-io/fsm-classic/pkg/cache"
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	"github.com/flomesh-io/fsm-classic/pkg/kube"
+	"github.com/flomesh-io/fsm-classic/pkg/repo"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"net/http"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sort"
+	"time"
+)
+
+const (
+	repoSnapshotsPath        = "/repo/snapshots"
+	repoSnapshotsExportPath  = "/repo/snapshots/export"
+	repoSnapshotsRestorePath = "/repo/snapshots/restore"
+	repoSnapshotsResumePath  = "/repo/snapshots/resume"
+
+	repoSnapshotPrefix  = "fsm-repo-snapshot-"
+	repoSnapshotDataKey = "snapshot.tar.gz"
+	// maxRepoSnapshots is the number of snapshots kept in ConfigMaps, older ones are deleted
+	maxRepoSnapshots = 10
+	// maxRepoSnapshotBodySize limits the size of uploaded tarball
+	maxRepoSnapshotBodySize = 16 << 20
+	// maxRepoSnapshotSize is the limit of the size of a ConfigMap enforced by the API server, with some room left
+	// for the metadata
+	maxRepoSnapshotSize = 1<<20 - 16<<10
+
+	repoRestorePinSnapshotKey = "snapshot"
+	// repoRestoreHoldTimeout is how long the manager which pushes routes is waited for to hold pushes after pinned
+	repoRestoreHoldTimeout = 3 * time.Minute
+)
+
+// repoSnapshotSummary describes a stored snapshot, Name is empty for a live snapshot
+type repoSnapshotSummary struct {
+	Name      string                 `json:"name,omitempty"`
+	CreatedAt time.Time              `json:"createdAt"`
+	Codebases []repoCodebaseSnapshot `json:"codebases"`
+}
+
+type repoCodebaseSnapshot struct {
+	Path    string `json:"path"`
+	Version int64  `json:"version"`
+	Files   int    `json:"files"`
+}
+
+// repoSnapshotStore keeps snapshots of the ingress and services codebases in ConfigMaps of the mesh namespace,
+// each ConfigMap has a gzipped tarball written by repo.WriteSnapshots
+type repoSnapshotStore struct {
+	api        *kube.K8sAPI
+	repoClient *repo.PipyRepoClient
+	mc         *config.MeshConfig
+}
+
+func newRepoSnapshotStore(api *kube.K8sAPI, repoClient *repo.PipyRepoClient, mc *config.MeshConfig) *repoSnapshotStore {
+	return &repoSnapshotStore{
+		api:        api,
+		repoClient: repoClient,
+		mc:         mc,
+	}
+}
+
+func (s *repoSnapshotStore) codebases() []string {
+	return []string{
+		s.mc.GetDefaultIngressPath(),
+		s.mc.GetDefaultServicesPath(),
+	}
+}
+
+// take reads the current content of codebases from repo
+func (s *repoSnapshotStore) take(ctx context.Context) ([]*repo.Snapshot, error) {
+	snapshots := make([]*repo.Snapshot, 0)
+	for _, path := range s.codebases() {
+		snapshot, err := s.repoClient.Snapshot(ctx, path)
+		if err != nil {
+			if repo.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, nil
+}
+
+// save stores the snapshots in a new ConfigMap and deletes the oldest ones beyond maxRepoSnapshots
+func (s *repoSnapshotStore) save(ctx context.Context, snapshots []*repo.Snapshot) (*corev1.ConfigMap, error) {
+	var buf bytes.Buffer
+	if err := repo.WriteSnapshots(&buf, snapshots); err != nil {
+		return nil, err
+	}
+	if buf.Len() > maxRepoSnapshotSize {
+		return nil, fmt.Errorf("size of repo snapshot %d exceeds the limit %d of ConfigMap, export it as a tarball instead", buf.Len(), maxRepoSnapshotSize)
+	}
+
+	cm, err := s.api.Client.CoreV1().
+		ConfigMaps(s.mc.GetMeshNamespace()).
+		Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: repoSnapshotPrefix,
+				Namespace:    s.mc.GetMeshNamespace(),
+				Labels:       map[string]string{commons.RepoSnapshotLabel: "true"},
+			},
+			BinaryData: map[string][]byte{repoSnapshotDataKey: buf.Bytes()},
+		}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("Repo snapshot %s is saved", cm.Name)
+
+	stored, err := s.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := maxRepoSnapshots; i < len(stored); i++ {
+		if err := s.api.Client.CoreV1().
+			ConfigMaps(s.mc.GetMeshNamespace()).
+			Delete(ctx, stored[i].Name, metav1.DeleteOptions{}); err != nil {
+			klog.Warningf("Failed to delete repo snapshot %s: %s", stored[i].Name, err)
+		}
+	}
+
+	return cm, nil
+}
+
+// list returns the stored snapshots, the newest comes first
+func (s *repoSnapshotStore) list(ctx context.Context) ([]corev1.ConfigMap, error) {
+	cms, err := s.api.Client.CoreV1().
+		ConfigMaps(s.mc.GetMeshNamespace()).
+		List(ctx, metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(map[string]string{commons.RepoSnapshotLabel: "true"}).String(),
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	result := cms.Items
+	sort.Slice(result, func(i, j int) bool {
+		ti, tj := result[i].CreationTimestamp, result[j].CreationTimestamp
+		if ti.Equal(&tj) {
+			return result[i].Name > result[j].Name
+		}
+		return tj.Before(&ti)
+	})
+
+	return result, nil
+}
+
+func (s *repoSnapshotStore) load(ctx context.Context, name string) ([]*repo.Snapshot, error) {
+	cm, err := s.api.Client.CoreV1().
+		ConfigMaps(s.mc.GetMeshNamespace()).
+		Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshotsOf(cm)
+}
+
+func snapshotsOf(cm *corev1.ConfigMap) ([]*repo.Snapshot, error) {
+	if cm.Labels[commons.RepoSnapshotLabel] != "true" {
+		return nil, fmt.Errorf("ConfigMap %s/%s is not a repo snapshot", cm.Namespace, cm.Name)
+	}
+
+	return repo.ReadSnapshots(bytes.NewReader(cm.BinaryData[repoSnapshotDataKey]))
+}
+
+// previous returns the newest stored snapshot which differs from the current content of repo, it's the last
+// known-good config if the current one is broken
+func (s *repoSnapshotStore) previous(ctx context.Context) (string, []*repo.Snapshot, error) {
+	current, err := s.take(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	stored, err := s.list(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for i := range stored {
+		snapshots, err := snapshotsOf(&stored[i])
+		if err != nil {
+			klog.Warningf("Ignore invalid repo snapshot %s: %s", stored[i].Name, err)
+			continue
+		}
+
+		if !sameVersions(current, snapshots) {
+			return stored[i].Name, snapshots, nil
+		}
+	}
+
+	return "", nil, fmt.Errorf("no snapshot differs from the current repo")
+}
+
+// restore restores all codebases of the snapshots, the codebases are pinned to the restored snapshot before that, so
+// that routes are not pushed to repo until an operator resumes them by unpin. The snapshot is restored once the
+// leader acknowledges the pin, so that no push in flight overwrites it.
+func (s *repoSnapshotStore) restore(ctx context.Context, name string, snapshots []*repo.Snapshot) error {
+	allowed := make(map[string]bool)
+	for _, path := range s.codebases() {
+		allowed[path] = true
+	}
+
+	for _, snapshot := range snapshots {
+		if !allowed[snapshot.Path] {
+			return fmt.Errorf("codebase %q of snapshot is not managed by this cluster", snapshot.Path)
+		}
+	}
+
+	restoredAt, err := s.pin(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to pin codebases to snapshot %s: %w", name, err)
+	}
+
+	if err := s.waitForHeld(ctx, restoredAt); err != nil {
+		return fmt.Errorf("codebases are pinned to snapshot %s but not restored, pushes of routes aren't held: %w", name, err)
+	}
+
+	for _, snapshot := range snapshots {
+		if err := s.repoClient.Restore(ctx, snapshot); err != nil {
+			return fmt.Errorf("codebases are partially restored and still pinned: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// pin creates or updates the marker ConfigMap which holds pushes of routes, it's checked by the local cache of the
+// leader before each push, so that it works no matter which replica serves the request and survives restarts.
+// It returns the restoredAt of the pin, which identifies it.
+func (s *repoSnapshotStore) pin(ctx context.Context, name string) (string, error) {
+	restoredAt := time.Now().UTC().Format(time.RFC3339Nano)
+	data := map[string]string{
+		repoRestorePinSnapshotKey:           name,
+		commons.RepoRestorePinRestoredAtKey: restoredAt,
+	}
+
+	cms := s.api.Client.CoreV1().ConfigMaps(s.mc.GetMeshNamespace())
+	cm, err := cms.Get(ctx, commons.RepoRestorePinConfigMapName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = cms.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      commons.RepoRestorePinConfigMapName,
+				Namespace: s.mc.GetMeshNamespace(),
+			},
+			Data: data,
+		}, metav1.CreateOptions{})
+		return restoredAt, err
+	case err != nil:
+		return "", err
+	default:
+		cm.Data = data
+		_, err = cms.Update(ctx, cm, metav1.UpdateOptions{})
+		return restoredAt, err
+	}
+}
+
+// waitForHeld waits until the leader marks the pin as held, it does so once no push is in flight
+func (s *repoSnapshotStore) waitForHeld(ctx context.Context, restoredAt string) error {
+	cms := s.api.Client.CoreV1().ConfigMaps(s.mc.GetMeshNamespace())
+
+	ctx, cancel := context.WithTimeout(ctx, repoRestoreHoldTimeout)
+	defer cancel()
+
+	return wait.PollImmediateUntilWithContext(ctx, time.Second, func(ctx context.Context) (bool, error) {
+		cm, err := cms.Get(ctx, commons.RepoRestorePinConfigMapName, metav1.GetOptions{})
+		if err != nil {
+			klog.Warningf("Failed to get the restore pin: %s", err)
+			return false, nil
+		}
+
+		return cm.Annotations[commons.RepoRestorePinHeldAnnotation] == restoredAt, nil
+	})
+}
+
+// unpin deletes the marker ConfigMap, the local cache pushes all routes on next sync
+func (s *repoSnapshotStore) unpin(ctx context.Context) error {
+	err := s.api.Client.CoreV1().
+		ConfigMaps(s.mc.GetMeshNamespace()).
+		Delete(ctx, commons.RepoRestorePinConfigMapName, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+func sameVersions(a, b []*repo.Snapshot) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	versions := make(map[string]int64)
+	for _, snapshot := range a {
+		versions[snapshot.Path] = snapshot.Version
+	}
+	for _, snapshot := range b {
+		if v, ok := versions[snapshot.Path]; !ok || v != snapshot.Version {
+			return false
+		}
+	}
+
+	return true
+}
+
+func summaryOf(name string, snapshots []*repo.Snapshot) repoSnapshotSummary {
+	summary := repoSnapshotSummary{
+		Name:      name,
+		Codebases: make([]repoCodebaseSnapshot, 0, len(snapshots)),
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.CreatedAt.After(summary.CreatedAt) {
+			summary.CreatedAt = snapshot.CreatedAt
+		}
+		summary.Codebases = append(summary.Codebases, repoCodebaseSnapshot{
+			Path:    snapshot.Path,
+			Version: snapshot.Version,
+			Files:   len(snapshot.Files),
+		})
+	}
+
+	return summary
+}
+
+// snapshotRepoJob saves a snapshot once routes have been pushed successfully and repo has been changed since the
+// latest snapshot, so that there's always a recent known-good config to roll back to
+func snapshotRepoJob(store *repoSnapshotStore) error {
+	running, pushErr := cache.ActivePushError()
+	if !running || pushErr != nil {
+		klog.V(5).Infof("Skip taking repo snapshot, local cache running: %t, push error: %v", running, pushErr)
+		return nil
+	}
+
+	ctx := context.TODO()
+	current, err := store.take(ctx)
+	if err != nil {
+		return err
+	}
+	if len(current) == 0 {
+		return nil
+	}
+
+	stored, err := store.list(ctx)
+	if err != nil {
+		return err
+	}
+	if len(stored) > 0 {
+		if latest, err := snapshotsOf(&stored[0]); err == nil && sameVersions(current, latest) {
+			return nil
+		}
+	}
+
+	_, err = store.save(ctx, current)
+	return err
+}
+
+// registerSnapshotHandlers serves the repo snapshot API on the metrics server, requests are authorized like the
+// debug endpoints, POST requests require the post verb of the non-resource URL:
+//
+//	GET  /repo/snapshots                  lists stored snapshots
+//	POST /repo/snapshots                  takes a snapshot and stores it
+//	GET  /repo/snapshots/export?name=     exports a stored snapshot or the current repo as a tarball
+//	POST /repo/snapshots/restore?name=    restores a stored snapshot, the uploaded tarball if the request has a
+//	                                      body, or the last known-good one if neither is given, routes are not
+//	                                      pushed to repo until resumed
+//	POST /repo/snapshots/resume           unpins the restored snapshot, all routes are pushed on next sync
+func registerSnapshotHandlers(mgr manager.Manager, api *kube.K8sAPI, store *repoSnapshotStore) {
+	handlers := map[string]http.HandlerFunc{
+		repoSnapshotsPath:        store.handleSnapshots,
+		repoSnapshotsExportPath:  store.handleExport,
+		repoSnapshotsRestorePath: store.handleRestore,
+		repoSnapshotsResumePath:  store.handleResume,
+	}
+
+	for path, handler := range handlers {
+		if err := mgr.AddMetricsExtraHandler(path, kube.WithAuth(api, handler)); err != nil {
+			klog.Error(err, "unable to set up repo snapshot handler")
+			os.Exit(1)
+		}
+	}
+}
+
+func (s *repoSnapshotStore) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		stored, err := s.list(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		summaries := make([]repoSnapshotSummary, 0, len(stored))
+		for i := range stored {
+			snapshots, err := snapshotsOf(&stored[i])
+			if err != nil {
+				klog.Warningf("Ignore invalid repo snapshot %s: %s", stored[i].Name, err)
+				continue
+			}
+			summaries = append(summaries, summaryOf(stored[i].Name, snapshots))
+		}
+
+		writeJSON(w, http.StatusOK, summaries)
+	case http.MethodPost:
+		snapshots, err := s.take(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		cm, err := s.save(r.Context(), snapshots)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, summaryOf(cm.Name, snapshots))
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *repoSnapshotStore) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	var snapshots []*repo.Snapshot
+	var err error
+	if name == "" {
+		name = "current"
+		snapshots, err = s.take(r.Context())
+	} else {
+		snapshots, err = s.load(r.Context(), name)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".tar.gz"))
+	if err := repo.WriteSnapshots(w, snapshots); err != nil {
+		klog.Errorf("Failed to export repo snapshot %s: %s", name, err)
+	}
+}
+
+func (s *repoSnapshotStore) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	var snapshots []*repo.Snapshot
+	var err error
+	switch {
+	case name != "":
+		snapshots, err = s.load(r.Context(), name)
+	case r.ContentLength != 0 && r.Body != nil:
+		name = "uploaded"
+		snapshots, err = repo.ReadSnapshots(http.MaxBytesReader(w, r.Body, maxRepoSnapshotBodySize))
+	default:
+		name, snapshots, err = s.previous(r.Context())
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.restore(r.Context(), name, snapshots); err != nil {
+		klog.Errorf("Failed to restore repo snapshot %s: %s", name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	klog.Infof("Repo is restored to snapshot %s, routes are not pushed until resumed", name)
+
+	writeJSON(w, http.StatusOK, summaryOf(name, snapshots))
+}
+
+func (s *repoSnapshotStore) handleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.unpin(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	klog.Infof("Repo is unpinned from the restored snapshot, routes are pushed on next sync")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		klog.Errorf("Failed to encode response: %s", err)
+	}
+}
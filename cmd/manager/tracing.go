@@ -0,0 +1,51 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package main
+
+import (
+	"context"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	"github.com/flomesh-io/fsm-classic/pkg/config/listener"
+	"github.com/flomesh-io/fsm-classic/pkg/tracing"
+	"k8s.io/klog/v2"
+	"time"
+)
+
+// setupTracing installs the OTLP exporter if tracing is enabled in MeshConfig, it's reconfigured by the tracing
+// config listener once MeshConfig is changed. The returned func flushes pending spans and must be called before
+// exiting.
+func setupTracing(mc *config.MeshConfig) func() {
+	if err := listener.ConfigureTracing(mc); err != nil {
+		klog.Errorf("Failed to setup tracing, tracing is disabled: %s", err)
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := tracing.Shutdown(ctx); err != nil {
+			klog.Errorf("Failed to shutdown tracing: %s", err)
+		}
+	}
+}
@@ -187,7 +187,7 @@ func registerToWebhookServer(mgr manager.Manager, api *kube.K8sAPI, controlPlane
 		webhooks.DefaultingWebhookFor(clusterwh.NewDefaulter(api, controlPlaneConfigStore)),
 	)
 	hookServer.Register(commons.ClusterValidatingWebhookPath,
-		webhooks.ValidatingWebhookFor(clusterwh.NewValidator(api)),
+		webhooks.ValidatingWebhookFor(clusterwh.NewValidator(api, controlPlaneConfigStore)),
 	)
 
 	// ProxyProfile
@@ -37,6 +37,7 @@ import (
 	"github.com/flomesh-io/fsm-classic/pkg/config"
 	"github.com/flomesh-io/fsm-classic/pkg/event"
 	"github.com/flomesh-io/fsm-classic/pkg/kube"
+	"github.com/flomesh-io/fsm-classic/pkg/metrics"
 	"github.com/flomesh-io/fsm-classic/pkg/repo"
 	"github.com/flomesh-io/fsm-classic/pkg/util"
 	appv1 "k8s.io/api/apps/v1"
@@ -45,13 +46,15 @@ import (
 	metautil "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
-	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlevent "sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 	"sync"
 	"time"
 )
@@ -67,12 +70,21 @@ type ClusterReconciler struct {
 	certMgr     certificate.Manager
 	backgrounds map[string]*connectorBackground
 	mu          sync.Mutex
+
+	// reconnectCh triggers the reconciliation of a Cluster whose connector is destroyed for reconnecting
+	reconnectCh       chan ctrlevent.GenericEvent
+	reconnectAttempts map[string]int
 }
 
 type connectorBackground struct {
 	isInCluster bool
 	context     cctx.ConnectorContext
 	connector   conn.Connector
+
+	// failures is the number of consecutive failed health probes
+	failures int
+	// droppedFromRouting is true if the endpoints of the cluster have been removed from ServiceImports
+	droppedFromRouting bool
 }
 
 func New(
@@ -83,7 +95,6 @@ func New(
 	store *config.Store,
 	broker *event.Broker,
 	certMgr certificate.Manager,
-	stop <-chan struct{},
 ) *ClusterReconciler {
 	r := &ClusterReconciler{
 		Client:      client,
@@ -94,9 +105,10 @@ func New(
 		broker:      broker,
 		certMgr:     certMgr,
 		backgrounds: make(map[string]*connectorBackground),
-	}
 
-	go r.processEvent(broker, stop)
+		reconnectCh:       make(chan ctrlevent.GenericEvent, reconnectQueueSize),
+		reconnectAttempts: make(map[string]int),
+	}
 
 	return r
 }
@@ -132,8 +144,25 @@ func (r *ClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, err
 	}
 
+	if cluster.DeletionTimestamp != nil {
+		return r.leaveClusterSet(ctx, cluster)
+	}
+
+	if err := r.addFinalizer(ctx, cluster); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	mc := r.configStore.MeshConfig.GetConfig()
 
+	// Move the inline kubeconfig to a Secret, the update of Cluster triggers another reconciliation
+	if !cluster.Spec.IsInCluster && cluster.Spec.Kubeconfig != "" && cluster.Spec.KubeconfigSecretRef == nil {
+		if err := r.migrateKubeconfig(ctx, cluster, mc); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{}, nil
+	}
+
 	result, err := r.deriveCodebases(mc)
 	if err != nil {
 		return result, err
@@ -142,7 +171,7 @@ func (r *ClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	key := cluster.Key()
 	klog.V(5).Infof("Cluster key is %s", key)
 	bg, exists := r.backgrounds[key]
-	if exists && bg.context.SpecHash != util.SimpleHash(cluster.Spec) {
+	if exists && bg.context.SpecHash != r.specHash(ctx, cluster, mc) {
 		klog.V(5).Infof("Background context of cluster [%s] exists, ")
 		// exists and the spec changed, then stop it and start a new one
 		if result, err = r.recreateConnector(ctx, bg, cluster, mc); err != nil {
@@ -189,6 +218,7 @@ func (r *ClusterReconciler) recreateConnector(ctx context.Context, bg *connector
 
 	close(bg.context.StopCh)
 	delete(r.backgrounds, cluster.Key())
+	metrics.DeleteClusterConnector(cluster.Key())
 
 	return r.newConnector(ctx, cluster, mc)
 }
@@ -201,15 +231,19 @@ func (r *ClusterReconciler) destroyConnector(cluster *clusterv1alpha1.Cluster) {
 	if bg, exists := r.backgrounds[key]; exists {
 		close(bg.context.StopCh)
 		delete(r.backgrounds, key)
+		metrics.DeleteClusterConnector(key)
 	}
 }
 
 func (r *ClusterReconciler) newConnector(ctx context.Context, cluster *clusterv1alpha1.Cluster, mc *config.MeshConfig) (ctrl.Result, error) {
 	key := cluster.Key()
 
-	kubeconfig, result, err := getKubeConfig(cluster)
+	kubeconfig, result, err := r.getKubeConfig(ctx, cluster, mc)
 	if err != nil {
 		klog.Errorf("Failed to get kubeconfig for cluster %q: %s", cluster.Key(), err)
+		if !cluster.Spec.IsInCluster {
+			r.markClusterUnreachable(ctx, cluster, err)
+		}
 		return result, err
 	}
 
@@ -222,7 +256,7 @@ func (r *ClusterReconciler) newConnector(ctx context.Context, cluster *clusterv1
 		ClusterKey:      key,
 		KubeConfig:      kubeconfig,
 		ConnectorConfig: connCfg,
-		SpecHash:        util.SimpleHash(cluster.Spec),
+		SpecHash:        r.specHash(ctx, cluster, mc),
 	}
 	_, cancel := context.WithCancel(&background)
 	stop := util.RegisterExitHandlers(cancel)
@@ -231,15 +265,26 @@ func (r *ClusterReconciler) newConnector(ctx context.Context, cluster *clusterv1
 
 	connector, err := conn.NewConnector(&background, r.broker, r.certMgr, 15*time.Minute)
 	if err != nil {
+		// the reconciliation is retried with backoff
 		klog.Errorf("Failed to create connector for cluster %q: %s", cluster.Key(), err)
+		if !cluster.Spec.IsInCluster {
+			r.markClusterUnreachable(ctx, cluster, err)
+		}
 		return ctrl.Result{}, err
 	}
 
-	r.backgrounds[key] = &connectorBackground{
+	bg := &connectorBackground{
 		isInCluster: cluster.Spec.IsInCluster,
 		context:     background,
 		connector:   connector,
 	}
+	r.backgrounds[key] = bg
+
+	clusterType := metrics.ClusterTypeRemote
+	if cluster.Spec.IsInCluster {
+		clusterType = metrics.ClusterTypeLocal
+	}
+	metrics.SetClusterConnectorUp(key, clusterType, true)
 
 	success := true
 	errorMsg := ""
@@ -248,8 +293,13 @@ func (r *ClusterReconciler) newConnector(ctx context.Context, cluster *clusterv1
 			success = false
 			errorMsg = err.Error()
 			klog.Errorf("Failed to run connector for cluster %q: %s", cluster.Key(), err)
-			close(stop)
-			delete(r.backgrounds, key)
+			metrics.SetClusterConnectorUp(key, clusterType, false)
+
+			r.mu.Lock()
+			attempt := r.reconnectAttempts[key]
+			r.reconnectAttempts[key] = attempt + 1
+			r.reconnect(bg, reconnectDelay(attempt))
+			r.mu.Unlock()
 		}
 	}()
 
@@ -264,37 +314,6 @@ func (r *ClusterReconciler) newConnector(ctx context.Context, cluster *clusterv1
 	return ctrl.Result{}, nil
 }
 
-func getKubeConfig(cluster *clusterv1alpha1.Cluster) (*rest.Config, ctrl.Result, error) {
-	if cluster.Spec.IsInCluster {
-		kubeconfig, err := rest.InClusterConfig()
-		if err != nil {
-			return nil, ctrl.Result{}, err
-		}
-
-		return kubeconfig, ctrl.Result{}, nil
-	} else {
-		return remoteKubeConfig(cluster)
-	}
-}
-
-func remoteKubeConfig(cluster *clusterv1alpha1.Cluster) (*rest.Config, ctrl.Result, error) {
-	// use the current context in kubeconfig
-	kubeconfig, err := clientcmd.BuildConfigFromKubeconfigGetter("", func() (*clientcmdapi.Config, error) {
-		cfg, err := clientcmd.Load([]byte(cluster.Spec.Kubeconfig))
-		if err != nil {
-			return nil, err
-		}
-
-		return cfg, nil
-	})
-
-	if err != nil {
-		return nil, ctrl.Result{}, err
-	}
-
-	return kubeconfig, ctrl.Result{}, nil
-}
-
 func (r *ClusterReconciler) connectorConfig(cluster *clusterv1alpha1.Cluster, mc *config.MeshConfig) (*config.ConnectorConfig, error) {
 	if cluster.Spec.IsInCluster {
 		return config.NewConnectorConfig(
@@ -325,6 +344,10 @@ func (r *ClusterReconciler) processEvent(broker *event.Broker, stop <-chan struc
 	msgBus := broker.GetMessageBus()
 	svcExportCreatedCh := msgBus.Sub(string(event.ServiceExportCreated))
 	defer broker.Unsub(msgBus, svcExportCreatedCh)
+	svcExportDeletedCh := msgBus.Sub(string(event.ServiceExportDeleted))
+	defer broker.Unsub(msgBus, svcExportDeletedCh)
+	clusterHealthCh := msgBus.Sub(string(event.ClusterHealthProbed))
+	defer broker.Unsub(msgBus, clusterHealthCh)
 
 	for {
 		// FIXME: refine it later
@@ -368,6 +391,53 @@ func (r *ClusterReconciler) processEvent(broker *event.Broker, stop <-chan struc
 			}
 
 			r.processServiceExportCreatedEvent(svcExportEvt)
+		case msg, ok := <-svcExportDeletedCh:
+			mc := r.configStore.MeshConfig.GetConfig()
+			// ONLY Control Plane takes care of the federation of service export/import
+			if mc.IsManaged && mc.Cluster.ControlPlaneUID != "" && mc.Cluster.UID != mc.Cluster.ControlPlaneUID {
+				klog.V(5).Infof("Ignore processing ServiceExportDeleted event due to cluster is managed and not a control plane ...")
+				continue
+			}
+
+			if !ok {
+				klog.Warningf("Channel closed for ServiceExport")
+				continue
+			}
+			klog.V(5).Infof("Received event ServiceExportDeleted %v", msg)
+
+			e, ok := msg.(event.Message)
+			if !ok {
+				klog.Errorf("Received unexpected message %T on channel, expected Message", e)
+				continue
+			}
+
+			svcExportEvt, ok := e.OldObj.(*event.ServiceExportEvent)
+			if !ok {
+				klog.Errorf("Received unexpected object %T, expected *event.ServiceExportEvent", svcExportEvt)
+				continue
+			}
+
+			r.reacceptLosers(svcExportEvt)
+		case msg, ok := <-clusterHealthCh:
+			if !ok {
+				klog.Warningf("Channel closed for ClusterHealth")
+				continue
+			}
+			klog.V(5).Infof("Received event ClusterHealthProbed %v", msg)
+
+			e, ok := msg.(event.Message)
+			if !ok {
+				klog.Errorf("Received unexpected message %T on channel, expected Message", e)
+				continue
+			}
+
+			health, ok := e.NewObj.(*event.ClusterHealthEvent)
+			if !ok {
+				klog.Errorf("Received unexpected object %T, expected *event.ClusterHealthEvent", health)
+				continue
+			}
+
+			r.processClusterHealthEvent(health)
 		case <-stop:
 			klog.Infof("Received stop signal.")
 			return
@@ -376,62 +446,53 @@ func (r *ClusterReconciler) processEvent(broker *event.Broker, stop <-chan struc
 }
 
 func (r *ClusterReconciler) processServiceExportCreatedEvent(svcExportEvt *event.ServiceExportEvent) {
+	export := svcExportEvt.ServiceExport
+
+	// the connectors are copied under the lock, the remote calls of checking the export don't hold it
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	bg, exists := r.backgrounds[svcExportEvt.ClusterKey()]
+	dropped := exists && bg.droppedFromRouting
+	peers := r.exportPeers()
+	r.mu.Unlock()
+
+	if dropped {
+		// the ServiceExports of the cluster are exported again once it's ready and the new connector is synced
+		klog.Warningf("[%s] ServiceExport %s/%s is ignored as the cluster is dropped from multicluster routing", svcExportEvt.ClusterKey(), export.Namespace, export.Name)
+		return
+	}
 
-	export := svcExportEvt.ServiceExport
-	if r.isFirstTimeExport(svcExportEvt) {
+	if isFirstTimeExport(svcExportEvt, peers) {
 		klog.V(5).Infof("[%s] ServiceExport %s/%s is exported first in the cluster set, will be accepted", svcExportEvt.Geo.Key(), export.Namespace, export.Name)
 		r.acceptServiceExport(svcExportEvt)
-	} else {
-		valid, err := r.isValidServiceExport(svcExportEvt)
-		if valid {
-			klog.V(5).Infof("[%s] ServiceExport %s/%s is valid, will be accepted", svcExportEvt.Geo.Key(), export.Namespace, export.Name)
-			r.acceptServiceExport(svcExportEvt)
-		} else {
-			klog.V(5).Infof("[%s] ServiceExport %s/%s is invalid, will be rejected", svcExportEvt.Geo.Key(), export.Namespace, export.Name)
-			r.rejectServiceExport(svcExportEvt, err)
-		}
+		return
 	}
-}
 
-func (r *ClusterReconciler) isFirstTimeExport(event *event.ServiceExportEvent) bool {
-	export := event.ServiceExport
-	for _, bg := range r.backgrounds {
-		if bg.isInCluster {
-			continue
-		}
-		remoteConnector := bg.connector.(*conn.RemoteConnector)
-		if remoteConnector.ServiceImportExists(export) {
-			klog.Warningf("[%s] ServiceExport %s/%s exists in Cluster %s", event.Geo.Key(), export.Namespace, export.Name, bg.context.ClusterKey)
-			return false
-		}
+	policy := r.configStore.MeshConfig.GetConfig().ServiceExportConflictPolicy()
+	conflicts, err := findConflicts(svcExportEvt, peers, policy)
+	if err != nil {
+		klog.Warningf("[%s] Failed to check conflicts of ServiceExport %s/%s, will retry: %s", svcExportEvt.Geo.Key(), export.Namespace, export.Name, err)
+		r.retryServiceExport(svcExportEvt)
+		return
+	}
+	if len(conflicts) == 0 {
+		klog.V(5).Infof("[%s] ServiceExport %s/%s is valid, will be accepted", svcExportEvt.Geo.Key(), export.Namespace, export.Name)
+		r.acceptServiceExport(svcExportEvt)
+		return
 	}
 
-	return true
+	r.resolveConflicts(svcExportEvt, conflicts, policy)
 }
 
-func (r *ClusterReconciler) isValidServiceExport(svcExportEvt *event.ServiceExportEvent) (bool, error) {
-	export := svcExportEvt.ServiceExport
-	for _, bg := range r.backgrounds {
-		if bg.isInCluster {
-			continue
-		}
-
-		connectorContext := bg.context
-		if connectorContext.ClusterKey == svcExportEvt.ClusterKey() {
-			// no need to test against itself
-			continue
-		}
-
-		remoteConnector := bg.connector.(*conn.RemoteConnector)
-		if err := remoteConnector.ValidateServiceExport(svcExportEvt.ServiceExport, svcExportEvt.Service); err != nil {
-			klog.Warningf("[%s] ServiceExport %s/%s has conflict in Cluster %s", svcExportEvt.Geo.Key(), export.Namespace, export.Name, connectorContext.ClusterKey)
-			return false, err
+func isFirstTimeExport(event *event.ServiceExportEvent, peers []exportPeer) bool {
+	export := event.ServiceExport
+	for _, peer := range peers {
+		if peer.connector.ServiceImportExists(export) {
+			klog.Warningf("[%s] ServiceExport %s/%s exists in Cluster %s", event.Geo.Key(), export.Namespace, export.Name, peer.key)
+			return false
 		}
 	}
 
-	return true, nil
+	return true
 }
 
 func (r *ClusterReconciler) acceptServiceExport(svcExportEvt *event.ServiceExportEvent) {
@@ -492,9 +553,24 @@ func (r *ClusterReconciler) failedJoinClusterSet(ctx context.Context, cluster *c
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// the events of connectors are processed by the leader only, same as the reconciliation of Clusters
+	if err := mgr.Add(r); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&clusterv1alpha1.Cluster{}).
-		Owns(&corev1.Secret{}).
+		For(
+			&clusterv1alpha1.Cluster{},
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
+		).
+		Watches(
+			&source.Channel{Source: r.reconnectCh},
+			&handler.EnqueueRequestForObject{},
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.secretToClusters),
+		).
 		Owns(&appv1.Deployment{}).
 		Complete(r)
 }
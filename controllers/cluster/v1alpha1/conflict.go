@@ -0,0 +1,217 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package v1alpha1
+
+import (
+	"fmt"
+	svcexpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceexport/v1alpha1"
+	conn "github.com/flomesh-io/fsm-classic/pkg/cluster"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	"github.com/flomesh-io/fsm-classic/pkg/event"
+	metautil "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/klog/v2"
+	"time"
+)
+
+// exportConflict is a ServiceExport of same Service in another cluster which conflicts with the one being processed
+type exportConflict struct {
+	geo    *config.ConnectorConfig
+	export *svcexpv1alpha1.ServiceExport
+	err    error
+}
+
+// exportPeer is the connector of a remote cluster, it's copied from the backgrounds so that the remote calls of
+// checking ServiceExports are made without holding r.mu
+type exportPeer struct {
+	key                string
+	geo                *config.ConnectorConfig
+	connector          *conn.RemoteConnector
+	droppedFromRouting bool
+}
+
+// conflictRetryDelay is how long to wait before re-evaluating a ServiceExport whose conflicts cannot be checked
+const conflictRetryDelay = 5 * time.Second
+
+// exportPeers returns the connectors of remote clusters, r.mu must be held by the caller.
+func (r *ClusterReconciler) exportPeers() []exportPeer {
+	peers := make([]exportPeer, 0)
+	for _, bg := range r.backgrounds {
+		if bg.isInCluster {
+			continue
+		}
+
+		peers = append(peers, exportPeer{
+			key:                bg.context.ClusterKey,
+			geo:                bg.context.ConnectorConfig,
+			connector:          bg.connector.(*conn.RemoteConnector),
+			droppedFromRouting: bg.droppedFromRouting,
+		})
+	}
+
+	return peers
+}
+
+// findConflicts returns the accepted exports of same Service in other clusters which conflict with the export, an
+// error is returned if any cluster cannot be checked, the export must be re-evaluated later then
+func findConflicts(svcExportEvt *event.ServiceExportEvent, peers []exportPeer, policy config.ServiceExportConflictPolicy) ([]exportConflict, error) {
+	export := svcExportEvt.ServiceExport
+	conflicts := make([]exportConflict, 0)
+
+	for _, peer := range peers {
+		if peer.key == svcExportEvt.ClusterKey() {
+			// no need to test against itself
+			continue
+		}
+
+		if peer.droppedFromRouting {
+			// the exports of a cluster dropped from routing don't take part in the cluster set
+			continue
+		}
+
+		exp, err := peer.connector.GetServiceExport(export)
+		if err != nil {
+			return nil, err
+		}
+
+		if exp == nil || !isAcceptedExport(exp) {
+			// only the exports take part in the cluster set can conflict
+			continue
+		}
+
+		if err := peer.connector.ValidateServiceExport(export, svcExportEvt.Service, policy); err != nil {
+			klog.Warningf("[%s] ServiceExport %s/%s has conflict in Cluster %s", svcExportEvt.Geo.Key(), export.Namespace, export.Name, peer.key)
+			conflicts = append(conflicts, exportConflict{geo: peer.geo, export: exp, err: err})
+		}
+	}
+
+	return conflicts, nil
+}
+
+func isAcceptedExport(export *svcexpv1alpha1.ServiceExport) bool {
+	return !metautil.IsStatusConditionFalse(export.Status.Conditions, string(svcexpv1alpha1.ServiceExportValid)) &&
+		!metautil.IsStatusConditionTrue(export.Status.Conditions, string(svcexpv1alpha1.ServiceExportConflict))
+}
+
+// resolveConflicts decides the winner by policy, the losers are rejected with the cluster which won and why
+func (r *ClusterReconciler) resolveConflicts(svcExportEvt *event.ServiceExportEvent, conflicts []exportConflict, policy config.ServiceExportConflictPolicy) {
+	export := svcExportEvt.ServiceExport
+
+	if policy != config.OldestWins {
+		// FirstWins, incompatible ports of MergeCompatiblePorts are resolved as FirstWins as well
+		winner := conflicts[0]
+		klog.V(5).Infof("[%s] ServiceExport %s/%s loses to Cluster %s, will be rejected", svcExportEvt.Geo.Key(), export.Namespace, export.Name, winner.geo.Key())
+		r.rejectServiceExport(svcExportEvt, fmt.Errorf("cluster %s won by policy %s as it exported the service first: %s", winner.geo.Key(), policy, winner.err))
+		return
+	}
+
+	var winner *exportConflict
+	for i, c := range conflicts {
+		if !export.CreationTimestamp.Before(&c.export.CreationTimestamp) && (winner == nil || c.export.CreationTimestamp.Before(&winner.export.CreationTimestamp)) {
+			winner = &conflicts[i]
+		}
+	}
+
+	if winner != nil {
+		klog.V(5).Infof("[%s] ServiceExport %s/%s loses to Cluster %s, will be rejected", svcExportEvt.Geo.Key(), export.Namespace, export.Name, winner.geo.Key())
+		r.rejectServiceExport(svcExportEvt, fmt.Errorf("cluster %s won by policy %s: %s", winner.geo.Key(), policy, olderReason(winner.export, export, winner.err)))
+		return
+	}
+
+	klog.V(5).Infof("[%s] ServiceExport %s/%s is the oldest, will be accepted", svcExportEvt.Geo.Key(), export.Namespace, export.Name)
+	r.acceptServiceExport(svcExportEvt)
+	for _, c := range conflicts {
+		klog.V(5).Infof("[%s] ServiceExport %s/%s loses to Cluster %s, will be rejected", c.geo.Key(), export.Namespace, export.Name, svcExportEvt.Geo.Key())
+		r.rejectServiceExport(
+			&event.ServiceExportEvent{Geo: c.geo, ServiceExport: c.export},
+			fmt.Errorf("cluster %s won by policy %s: %s", svcExportEvt.Geo.Key(), policy, olderReason(export, c.export, c.err)),
+		)
+	}
+}
+
+func olderReason(winner, loser *svcexpv1alpha1.ServiceExport, err error) string {
+	return fmt.Sprintf("its ServiceExport was created at %s, not later than %s, %s",
+		winner.CreationTimestamp.Format(time.RFC3339), loser.CreationTimestamp.Format(time.RFC3339), err)
+}
+
+// retryServiceExport re-evaluates the ServiceExport later, it's used when the conflicts cannot be checked for now
+func (r *ClusterReconciler) retryServiceExport(svcExportEvt *event.ServiceExportEvent) {
+	time.AfterFunc(conflictRetryDelay, func() {
+		r.broker.Enqueue(
+			event.Message{
+				Kind:   event.ServiceExportCreated,
+				OldObj: nil,
+				NewObj: svcExportEvt,
+			},
+		)
+	})
+}
+
+// reacceptLosers re-evaluates the exports of same Service which were rejected due to conflict, once the export in
+// one cluster is withdrawn the winner may be gone, a loser takes its place if it doesn't conflict with the rest
+func (r *ClusterReconciler) reacceptLosers(withdrawn *event.ServiceExportEvent) {
+	export := withdrawn.ServiceExport
+
+	r.mu.Lock()
+	peers := r.exportPeers()
+	r.mu.Unlock()
+
+	for _, peer := range peers {
+		if peer.droppedFromRouting || peer.key == withdrawn.ClusterKey() {
+			continue
+		}
+
+		geo, remoteConnector := peer.geo, peer.connector
+		exp, err := remoteConnector.GetServiceExport(export)
+		if err != nil {
+			klog.Errorf("[%s] Failed to re-evaluate ServiceExport %s/%s: %s", geo.Key(), export.Namespace, export.Name, err)
+			continue
+		}
+
+		if exp == nil ||
+			metautil.IsStatusConditionFalse(exp.Status.Conditions, string(svcexpv1alpha1.ServiceExportValid)) ||
+			!metautil.IsStatusConditionTrue(exp.Status.Conditions, string(svcexpv1alpha1.ServiceExportConflict)) {
+			continue
+		}
+
+		svc, err := remoteConnector.GetService(exp)
+		if err != nil {
+			klog.Errorf("[%s] Failed to re-evaluate ServiceExport %s/%s: %s", geo.Key(), export.Namespace, export.Name, err)
+			continue
+		}
+		if svc == nil {
+			continue
+		}
+
+		// the loser is cleared and evaluated one by one, so the next loser is checked against it if it's accepted
+		exp, err = remoteConnector.ClearServiceExportConflict(exp)
+		if err != nil {
+			klog.Errorf("[%s] Failed to re-evaluate ServiceExport %s/%s: %s", geo.Key(), export.Namespace, export.Name, err)
+			continue
+		}
+
+		klog.V(5).Infof("[%s] ServiceExport %s/%s is withdrawn from Cluster %s, re-evaluating the rejected one", geo.Key(), export.Namespace, export.Name, withdrawn.ClusterKey())
+		r.processServiceExportCreatedEvent(&event.ServiceExportEvent{Geo: geo, ServiceExport: exp, Service: svc})
+	}
+}
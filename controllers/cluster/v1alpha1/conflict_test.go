@@ -0,0 +1,144 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	svcexpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceexport/v1alpha1"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	"github.com/flomesh-io/fsm-classic/pkg/event"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolveConflicts(t *testing.T) {
+	now := time.Now()
+	geo := func(name string) *config.ConnectorConfig {
+		c, err := config.NewConnectorConfig("default", "default", "default", name, "", 0, true, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	export := func(age time.Duration) *svcexpv1alpha1.ServiceExport {
+		return &svcexpv1alpha1.ServiceExport{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:         "default",
+				Name:              "httpbin",
+				CreationTimestamp: metav1.Time{Time: now.Add(-age)},
+			},
+		}
+	}
+	conflict := func(name string, age time.Duration) exportConflict {
+		return exportConflict{geo: geo(name), export: export(age), err: fmt.Errorf("spec.ports conflict")}
+	}
+
+	testCases := []struct {
+		name      string
+		policy    config.ServiceExportConflictPolicy
+		age       time.Duration
+		conflicts []exportConflict
+		accepted  []string
+		rejected  []string
+	}{
+		{
+			name:      "FirstWins rejects the new export",
+			policy:    config.FirstWins,
+			age:       time.Hour,
+			conflicts: []exportConflict{conflict("c1", time.Minute)},
+			rejected:  []string{"new"},
+		},
+		{
+			name:      "MergeCompatiblePorts resolves incompatible ports as FirstWins",
+			policy:    config.MergeCompatiblePorts,
+			age:       time.Hour,
+			conflicts: []exportConflict{conflict("c1", time.Minute)},
+			rejected:  []string{"new"},
+		},
+		{
+			name:      "OldestWins rejects the new export if an older one exists",
+			policy:    config.OldestWins,
+			age:       time.Minute,
+			conflicts: []exportConflict{conflict("c1", 2*time.Minute), conflict("c2", time.Hour)},
+			rejected:  []string{"new"},
+		},
+		{
+			name:      "OldestWins keeps the existing export created at the same time",
+			policy:    config.OldestWins,
+			age:       time.Minute,
+			conflicts: []exportConflict{conflict("c1", time.Minute)},
+			rejected:  []string{"new"},
+		},
+		{
+			name:      "OldestWins accepts the oldest export and rejects the others",
+			policy:    config.OldestWins,
+			age:       time.Hour,
+			conflicts: []exportConflict{conflict("c1", time.Minute), conflict("c2", 2*time.Minute)},
+			accepted:  []string{"new"},
+			rejected:  []string{"c1", "c2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		stop := make(chan struct{})
+		broker := event.NewBroker(stop)
+		msgBus := broker.GetMessageBus()
+		acceptedCh := msgBus.Sub(string(event.ServiceExportAccepted))
+		rejectedCh := msgBus.Sub(string(event.ServiceExportRejected))
+
+		r := &ClusterReconciler{broker: broker}
+		r.resolveConflicts(&event.ServiceExportEvent{Geo: geo("new"), ServiceExport: export(tc.age)}, tc.conflicts, tc.policy)
+
+		var accepted, rejected []string
+		timeout := time.After(2 * time.Second)
+	collect:
+		for len(accepted)+len(rejected) < len(tc.accepted)+len(tc.rejected) {
+			select {
+			case msg := <-acceptedCh:
+				accepted = append(accepted, msg.(event.Message).NewObj.(*event.ServiceExportEvent).Geo.Name())
+			case msg := <-rejectedCh:
+				rejected = append(rejected, msg.(event.Message).NewObj.(*event.ServiceExportEvent).Geo.Name())
+			case <-timeout:
+				break collect
+			}
+		}
+		sort.Strings(rejected)
+
+		if !reflect.DeepEqual(accepted, tc.accepted) {
+			t.Errorf("%s: expected accepted %v, got %v", tc.name, tc.accepted, accepted)
+		}
+		if !reflect.DeepEqual(rejected, tc.rejected) {
+			t.Errorf("%s: expected rejected %v, got %v", tc.name, tc.rejected, rejected)
+		}
+
+		close(stop)
+		go broker.Unsub(msgBus, acceptedCh)
+		go broker.Unsub(msgBus, rejectedCh)
+	}
+}
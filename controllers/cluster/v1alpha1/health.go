@@ -0,0 +1,246 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	clusterv1alpha1 "github.com/flomesh-io/fsm-classic/apis/cluster/v1alpha1"
+	conn "github.com/flomesh-io/fsm-classic/pkg/cluster"
+	"github.com/flomesh-io/fsm-classic/pkg/event"
+	"github.com/flomesh-io/fsm-classic/pkg/metrics"
+	"github.com/flomesh-io/fsm-classic/pkg/version"
+	retry "github.com/sethvargo/go-retry"
+	metautil "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sretry "k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlevent "sigs.k8s.io/controller-runtime/pkg/event"
+	"strings"
+	"time"
+)
+
+const (
+	// the connector is recreated once the API server is unreachable for unhealthyThreshold consecutive probes
+	unhealthyThreshold = 3
+
+	minReconnectDelay = 5 * time.Second
+	maxReconnectDelay = 5 * time.Minute
+
+	// reconnectQueueSize is the buffer of reconnectCh, so that the timers of reconnecting never block
+	reconnectQueueSize = 64
+)
+
+func (r *ClusterReconciler) processClusterHealthEvent(health *event.ClusterHealthEvent) {
+	key := health.ClusterKey()
+	if err := r.updateClusterHealth(context.TODO(), health); err != nil {
+		klog.Errorf("[%s] Failed to update health status of cluster: %s", key, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bg, exists := r.backgrounds[key]
+	if !exists {
+		// the probe is from a connector which has been destroyed
+		return
+	}
+
+	if health.IsReady() {
+		bg.failures = 0
+		delete(r.reconnectAttempts, key)
+
+		if bg.droppedFromRouting {
+			// the ServiceExports of the cluster are exported again once the new connector is synced
+			klog.Infof("[%s] Cluster is ready again, reconnecting ...", key)
+			r.reconnect(bg, 0)
+		}
+
+		return
+	}
+
+	bg.failures++
+	if !bg.droppedFromRouting {
+		klog.Warningf("[%s] Cluster is not ready, removing it from multicluster routing ...", key)
+		bg.droppedFromRouting = true
+		r.dropFromRouting(key)
+	}
+
+	if health.APIServerError != "" && bg.failures >= unhealthyThreshold {
+		attempt := r.reconnectAttempts[key]
+		r.reconnectAttempts[key] = attempt + 1
+		r.reconnect(bg, reconnectDelay(attempt))
+	}
+}
+
+// updateClusterHealth sets the Ready and Degraded conditions and the heartbeat of the Cluster
+func (r *ClusterReconciler) updateClusterHealth(ctx context.Context, health *event.ClusterHealthEvent) error {
+	return k8sretry.RetryOnConflict(k8sretry.DefaultRetry, func() error {
+		cluster := &clusterv1alpha1.Cluster{}
+		if err := r.Get(ctx, client.ObjectKey{Name: health.Geo.Name()}, cluster); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+
+		ready := metav1.Condition{
+			Type:               string(clusterv1alpha1.ClusterReady),
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: cluster.Generation,
+			LastTransitionTime: metav1.Time{Time: health.ProbeTime},
+			Reason:             "Healthy",
+			Message:            "API server and gateway are reachable.",
+		}
+		switch {
+		case health.APIServerError != "":
+			ready.Status = metav1.ConditionFalse
+			ready.Reason = "APIServerUnreachable"
+			ready.Message = fmt.Sprintf("API server is unreachable: %s", health.APIServerError)
+		case health.GatewayError != "":
+			ready.Status = metav1.ConditionFalse
+			ready.Reason = "GatewayUnreachable"
+			ready.Message = health.GatewayError
+		}
+		metautil.SetStatusCondition(&cluster.Status.Conditions, ready)
+
+		degraded := metav1.Condition{
+			Type:               string(clusterv1alpha1.ClusterDegraded),
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: cluster.Generation,
+			LastTransitionTime: metav1.Time{Time: health.ProbeTime},
+			Reason:             "AsExpected",
+			Message:            "Cluster works as expected.",
+		}
+		if health.APIServerError == "" && health.GatewayError != "" {
+			degraded.Status = metav1.ConditionTrue
+			degraded.Reason = "GatewayUnreachable"
+			degraded.Message = health.GatewayError
+		} else if isVersionMismatch(health.FsmVersion) {
+			degraded.Status = metav1.ConditionTrue
+			degraded.Reason = "VersionMismatch"
+			degraded.Message = fmt.Sprintf("FSM version %s is different from the Control Plane %s.", health.FsmVersion, version.Version)
+		}
+		metautil.SetStatusCondition(&cluster.Status.Conditions, degraded)
+
+		cluster.Status.LastHeartbeatTime = &metav1.Time{Time: health.ProbeTime}
+		if health.FsmVersion != "" {
+			cluster.Status.FsmVersion = health.FsmVersion
+		}
+
+		return r.Status().Update(ctx, cluster)
+	})
+}
+
+// markClusterUnreachable sets the Ready condition to false if the connector cannot be created
+func (r *ClusterReconciler) markClusterUnreachable(ctx context.Context, cluster *clusterv1alpha1.Cluster, err error) {
+	metautil.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{
+		Type:               string(clusterv1alpha1.ClusterReady),
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: cluster.Generation,
+		LastTransitionTime: metav1.Time{Time: time.Now()},
+		Reason:             "ConnectFailed",
+		Message:            fmt.Sprintf("Failed to connect to cluster: %s", err),
+	})
+
+	if err := r.Status().Update(ctx, cluster); err != nil {
+		klog.Errorf("[%s] Failed to update status of cluster: %s", cluster.Key(), err)
+	}
+}
+
+// dropFromRouting removes the endpoints of the cluster from the ServiceImports of all other clusters
+func (r *ClusterReconciler) dropFromRouting(key string) {
+	for _, bg := range r.backgrounds {
+		if bg.isInCluster || bg.context.ClusterKey == key {
+			continue
+		}
+
+		remoteConnector := bg.connector.(*conn.RemoteConnector)
+		clusterKey := bg.context.ClusterKey
+		go func() {
+			backoff := retry.WithMaxRetries(5, retry.NewFibonacci(1*time.Second))
+			if err := retry.Do(context.TODO(), backoff, func(ctx context.Context) error {
+				if err := remoteConnector.RemoveClusterEndpoints(key); err != nil {
+					// This marks the error as retryable
+					return retry.RetryableError(err)
+				}
+
+				return nil
+			}); err != nil {
+				klog.Errorf("[%s] Failed to remove endpoints of cluster %s: %s", clusterKey, key, err)
+			}
+		}()
+	}
+}
+
+// reconnect destroys the connector and triggers a reconciliation of the Cluster after the delay,
+// a new connector is created by the reconciliation. r.mu must be held by the caller.
+func (r *ClusterReconciler) reconnect(bg *connectorBackground, delay time.Duration) {
+	key := bg.context.ClusterKey
+	if current, exists := r.backgrounds[key]; !exists || current != bg {
+		return
+	}
+
+	close(bg.context.StopCh)
+	delete(r.backgrounds, key)
+	metrics.DeleteClusterConnector(key)
+
+	klog.Infof("[%s] Reconnecting to cluster in %s ...", key, delay)
+	r.requeueCluster(bg.context.ConnectorConfig.Name(), delay)
+}
+
+// requeueCluster triggers a reconciliation of the Cluster after the delay, the send never blocks the timer, it's
+// tried again later if reconnectCh is full, e.g. the controller hasn't started consuming it yet
+func (r *ClusterReconciler) requeueCluster(name string, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		select {
+		case r.reconnectCh <- ctrlevent.GenericEvent{
+			Object: &clusterv1alpha1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: name}},
+		}:
+		default:
+			klog.Warningf("Queue of reconnecting is full, reconnecting to cluster %s again in %s ...", name, minReconnectDelay)
+			r.requeueCluster(name, minReconnectDelay)
+		}
+	})
+}
+
+func reconnectDelay(attempt int) time.Duration {
+	delay := minReconnectDelay
+	for i := 0; i < attempt && delay < maxReconnectDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxReconnectDelay {
+		return maxReconnectDelay
+	}
+
+	return delay
+}
+
+func isVersionMismatch(fsmVersion string) bool {
+	if fsmVersion == "" || version.Version == "" || version.Version == "unknown" {
+		return false
+	}
+
+	return strings.TrimPrefix(fsmVersion, "v") != strings.TrimPrefix(version.Version, "v")
+}
@@ -0,0 +1,199 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	clusterv1alpha1 "github.com/flomesh-io/fsm-classic/apis/cluster/v1alpha1"
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	"github.com/flomesh-io/fsm-classic/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"time"
+)
+
+func (r *ClusterReconciler) getKubeConfig(ctx context.Context, cluster *clusterv1alpha1.Cluster, mc *config.MeshConfig) (*rest.Config, ctrl.Result, error) {
+	if cluster.Spec.IsInCluster {
+		kubeconfig, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, ctrl.Result{}, err
+		}
+
+		return kubeconfig, ctrl.Result{}, nil
+	} else {
+		return r.remoteKubeConfig(ctx, cluster, mc)
+	}
+}
+
+func (r *ClusterReconciler) remoteKubeConfig(ctx context.Context, cluster *clusterv1alpha1.Cluster, mc *config.MeshConfig) (*rest.Config, ctrl.Result, error) {
+	data, err := r.kubeconfigBytes(ctx, cluster, mc)
+	if err != nil {
+		return nil, ctrl.Result{RequeueAfter: 5 * time.Second}, err
+	}
+
+	// use the current context in kubeconfig
+	kubeconfig, err := clientcmd.RESTConfigFromKubeConfig(data)
+	if err != nil {
+		return nil, ctrl.Result{}, err
+	}
+
+	return kubeconfig, ctrl.Result{}, nil
+}
+
+// kubeconfigBytes returns the kubeconfig of a remote cluster, either from the referenced Secret
+// or the deprecated inline spec.kubeconfig
+func (r *ClusterReconciler) kubeconfigBytes(ctx context.Context, cluster *clusterv1alpha1.Cluster, mc *config.MeshConfig) ([]byte, error) {
+	ref := cluster.Spec.KubeconfigSecretRef
+	if ref == nil {
+		if cluster.Spec.Kubeconfig == "" {
+			return nil, fmt.Errorf("neither kubeconfigSecretRef nor kubeconfig is set for cluster %q", cluster.Key())
+		}
+
+		return []byte(cluster.Spec.Kubeconfig), nil
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: kubeconfigSecretNamespace(ref, mc), Name: ref.Name}, secret); err != nil {
+		return nil, err
+	}
+
+	data, ok := secret.Data[kubeconfigSecretKey(ref)]
+	if !ok || len(data) == 0 {
+		return nil, fmt.Errorf("key %q is not found in Secret %s/%s", kubeconfigSecretKey(ref), secret.Namespace, secret.Name)
+	}
+
+	return data, nil
+}
+
+// specHash changes if either the spec or the content of the referenced kubeconfig changes,
+// the connector is recreated once it's changed
+func (r *ClusterReconciler) specHash(ctx context.Context, cluster *clusterv1alpha1.Cluster, mc *config.MeshConfig) string {
+	if cluster.Spec.IsInCluster || cluster.Spec.KubeconfigSecretRef == nil {
+		return util.SimpleHash(cluster.Spec)
+	}
+
+	data, err := r.kubeconfigBytes(ctx, cluster, mc)
+	if err != nil {
+		klog.Warningf("Failed to get kubeconfig of cluster %q: %s", cluster.Key(), err)
+	}
+
+	return util.SimpleHash(struct {
+		Spec       clusterv1alpha1.ClusterSpec
+		Kubeconfig string
+	}{
+		Spec:       cluster.Spec,
+		Kubeconfig: util.Hash(data),
+	})
+}
+
+// migrateKubeconfig moves the inline kubeconfig of a remote cluster to a Secret owned by the Cluster,
+// and references it by spec.kubeconfigSecretRef
+func (r *ClusterReconciler) migrateKubeconfig(ctx context.Context, cluster *clusterv1alpha1.Cluster, mc *config.MeshConfig) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("fsm-cluster-kubeconfig-%s", cluster.Name),
+			Namespace: mc.GetMeshNamespace(),
+		},
+	}
+
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Type = corev1.SecretTypeOpaque
+		secret.Data = map[string][]byte{
+			commons.KubeconfigKey: []byte(cluster.Spec.Kubeconfig),
+		}
+
+		return controllerutil.SetControllerReference(cluster, secret, r.Scheme)
+	}); err != nil {
+		klog.Errorf("Failed to store kubeconfig of cluster %q in Secret %s/%s: %s", cluster.Key(), secret.Namespace, secret.Name, err)
+		return err
+	}
+
+	cluster.Spec.Kubeconfig = ""
+	cluster.Spec.KubeconfigSecretRef = &clusterv1alpha1.KubeconfigSecretReference{
+		Name:      secret.Name,
+		Namespace: secret.Namespace,
+		Key:       commons.KubeconfigKey,
+	}
+	if err := r.Update(ctx, cluster); err != nil {
+		klog.Errorf("Failed to reference Secret %s/%s in cluster %q: %s", secret.Namespace, secret.Name, cluster.Key(), err)
+		return err
+	}
+	klog.Infof("Kubeconfig of cluster %q is moved to Secret %s/%s", cluster.Key(), secret.Namespace, secret.Name)
+
+	return nil
+}
+
+// secretToClusters enqueues the Clusters referencing the Secret, so that the connector is recreated once
+// the kubeconfig is rotated
+func (r *ClusterReconciler) secretToClusters(secret client.Object) []reconcile.Request {
+	clusters := &clusterv1alpha1.ClusterList{}
+	if err := r.List(context.TODO(), clusters); err != nil {
+		klog.Errorf("Failed to list Clusters: %s", err)
+		return nil
+	}
+
+	mc := r.configStore.MeshConfig.GetConfig()
+	requests := make([]reconcile.Request, 0)
+	for _, c := range clusters.Items {
+		ref := c.Spec.KubeconfigSecretRef
+		if ref == nil {
+			continue
+		}
+
+		if ref.Name == secret.GetName() && kubeconfigSecretNamespace(ref, mc) == secret.GetNamespace() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: c.Name},
+			})
+		}
+	}
+
+	return requests
+}
+
+func kubeconfigSecretNamespace(ref *clusterv1alpha1.KubeconfigSecretReference, mc *config.MeshConfig) string {
+	if ref.Namespace != "" {
+		return ref.Namespace
+	}
+
+	return mc.GetMeshNamespace()
+}
+
+func kubeconfigSecretKey(ref *clusterv1alpha1.KubeconfigSecretReference) string {
+	if ref.Key != "" {
+		return ref.Key
+	}
+
+	return commons.KubeconfigKey
+}
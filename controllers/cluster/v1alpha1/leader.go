@@ -0,0 +1,60 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package v1alpha1
+
+import (
+	"context"
+	"github.com/flomesh-io/fsm-classic/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// Start processes the events of connectors until the manager stops or loses the leadership. Connectors are owned by
+// the leader, they're created by the reconciliation of Clusters which only runs in the leader, a new leader rebuilds
+// them from the Cluster resources after failover, so that the ServiceImports are never written by two replicas.
+func (r *ClusterReconciler) Start(ctx context.Context) error {
+	klog.Infof("Start processing events of cluster connectors")
+	r.processEvent(r.broker, ctx.Done())
+
+	r.stopConnectors()
+	return nil
+}
+
+// NeedLeaderElection implements LeaderElectionRunnable
+func (r *ClusterReconciler) NeedLeaderElection() bool {
+	return true
+}
+
+// stopConnectors stops all connectors once the replica is not the leader any more
+func (r *ClusterReconciler) stopConnectors() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, bg := range r.backgrounds {
+		klog.V(2).Infof("Stopping connector of cluster %s", key)
+		close(bg.context.StopCh)
+		delete(r.backgrounds, key)
+		metrics.DeleteClusterConnector(key)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package v1alpha1
+
+import (
+	"context"
+	clusterv1alpha1 "github.com/flomesh-io/fsm-classic/apis/cluster/v1alpha1"
+	conn "github.com/flomesh-io/fsm-classic/pkg/cluster"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// clusterFinalizer holds the Cluster until the state of the cluster is torn down from the ClusterSet
+	clusterFinalizer = "cluster.flomesh.io/leave"
+)
+
+func (r *ClusterReconciler) addFinalizer(ctx context.Context, cluster *clusterv1alpha1.Cluster) error {
+	if controllerutil.ContainsFinalizer(cluster, clusterFinalizer) {
+		return nil
+	}
+
+	controllerutil.AddFinalizer(cluster, clusterFinalizer)
+	return r.Update(ctx, cluster)
+}
+
+// leaveClusterSet removes the endpoints of the cluster from the ServiceImports across the ClusterSet, deletes the
+// ServiceImports of the cluster and resets its MeshConfig before the Cluster is deleted
+func (r *ClusterReconciler) leaveClusterSet(ctx context.Context, cluster *clusterv1alpha1.Cluster) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(cluster, clusterFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	key := cluster.Key()
+	if !cluster.Spec.IsInCluster {
+		r.recorder.Eventf(cluster, corev1.EventTypeNormal, "Leaving", "Cluster %s is leaving ClusterSet", key)
+
+		r.removeClusterEndpoints(cluster, key)
+
+		if err := r.resetMemberCluster(cluster, key); err != nil {
+			r.recorder.Eventf(cluster, corev1.EventTypeWarning, "LeaveFailed", "Failed to tear down cluster %s: %s", key, err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	r.destroyConnector(cluster)
+
+	controllerutil.RemoveFinalizer(cluster, clusterFinalizer)
+	if err := r.Update(ctx, cluster); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if !cluster.Spec.IsInCluster {
+		r.recorder.Eventf(cluster, corev1.EventTypeNormal, "Left", "Cluster %s left ClusterSet", key)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// removeClusterEndpoints removes the endpoints of the cluster from the ServiceImports of all other clusters, the
+// ServiceImports without any endpoint left are deleted. A peer which fails is reported by an event and skipped, so
+// that an unreachable peer doesn't block the leaving, its ServiceImports are fixed once it's synced again.
+func (r *ClusterReconciler) removeClusterEndpoints(cluster *clusterv1alpha1.Cluster, key string) {
+	peers := make(map[string]*conn.RemoteConnector)
+	r.mu.Lock()
+	for _, bg := range r.backgrounds {
+		if bg.isInCluster || bg.context.ClusterKey == key {
+			continue
+		}
+		peers[bg.context.ClusterKey] = bg.connector.(*conn.RemoteConnector)
+	}
+	r.mu.Unlock()
+
+	for peer, remoteConnector := range peers {
+		if err := remoteConnector.RemoveClusterEndpoints(key); err != nil {
+			klog.Errorf("[%s] Failed to remove endpoints of cluster %s: %s", peer, key, err)
+			r.recorder.Eventf(cluster, corev1.EventTypeWarning, "LeaveFailed", "Failed to remove endpoints of cluster %s from ServiceImports of cluster %s: %s", key, peer, err)
+		}
+	}
+}
+
+// resetMemberCluster tears down the state written to the member cluster, it's skipped if there's no connector to
+// the cluster, e.g. it's unreachable, so that the deletion of Cluster is not blocked forever
+func (r *ClusterReconciler) resetMemberCluster(cluster *clusterv1alpha1.Cluster, key string) error {
+	r.mu.Lock()
+	bg, exists := r.backgrounds[key]
+	r.mu.Unlock()
+
+	if !exists {
+		klog.Warningf("Connector of cluster %s doesn't exist, the state of cluster is left as is", key)
+		r.recorder.Eventf(cluster, corev1.EventTypeWarning, "Unreachable", "Cluster %s is not connected, ServiceImports and MeshConfig of it are not cleaned up", key)
+		return nil
+	}
+
+	return bg.connector.(*conn.RemoteConnector).LeaveClusterSet()
+}
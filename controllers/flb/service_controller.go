@@ -33,6 +33,7 @@ import (
 	"github.com/flomesh-io/fsm-classic/pkg/config"
 	"github.com/flomesh-io/fsm-classic/pkg/flb"
 	"github.com/flomesh-io/fsm-classic/pkg/kube"
+	"github.com/flomesh-io/fsm-classic/pkg/metrics"
 	"github.com/flomesh-io/fsm-classic/pkg/util"
 	"github.com/ghodss/yaml"
 	"github.com/go-resty/resty/v2"
@@ -69,6 +70,13 @@ const (
 	flbDeleteServiceApiPath = "/api/l-4-lbs/updateservice/delete"
 )
 
+// FLB API operations, they're the label values of metrics
+const (
+	flbOperationLogin  = "login"
+	flbOperationUpdate = "update"
+	flbOperationDelete = "delete"
+)
+
 // FLB annotations
 const (
 	finalizerName        = "servicelb.flomesh.io/flb"
@@ -734,10 +742,13 @@ func (r *ServiceReconciler) invokeFlbApi(namespace string, params map[string]str
 
 	var resp *resty.Response
 	var err error
+	start := time.Now()
 	if del {
 		resp, err = request.Post(flbDeleteServiceApiPath)
+		metrics.ObserveFLBRequest(flbOperationDelete, start, responseStatus(resp, err))
 	} else {
 		resp, err = request.Post(flbUpdateServiceApiPath)
+		metrics.ObserveFLBRequest(flbOperationUpdate, start, responseStatus(resp, err))
 	}
 
 	if err != nil {
@@ -757,13 +768,24 @@ func (r *ServiceReconciler) invokeFlbApi(namespace string, params map[string]str
 	return resp, http.StatusOK, nil
 }
 
+// responseStatus returns the status code of response, it's 0 if no response is received
+func responseStatus(resp *resty.Response, err error) int {
+	if err != nil || resp == nil {
+		return 0
+	}
+
+	return resp.StatusCode()
+}
+
 func (r *ServiceReconciler) loginFLB(namespace string) (string, error) {
 	setting := r.settings[namespace]
+	start := time.Now()
 	resp, err := setting.httpClient.R().
 		SetHeader("Content-Type", "application/json").
 		SetBody(FlbAuthRequest{Identifier: setting.flbUser, Password: setting.flbPassword}).
 		SetResult(&FlbAuthResponse{}).
 		Post(flbAuthApiPath)
+	metrics.ObserveFLBRequest(flbOperationLogin, start, responseStatus(resp, err))
 
 	if err != nil {
 		klog.Errorf("error happened while trying to login FLB, %s", err.Error())
@@ -0,0 +1,253 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	svcexpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceexport/v1alpha1"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	"github.com/flomesh-io/fsm-classic/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metautil "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	mcsv1alpha1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+	"strings"
+	"time"
+)
+
+// ServiceExportReconciler reconciles a multicluster.x-k8s.io ServiceExport object,
+// it's translated to a flomesh.io ServiceExport with the same name, which is then exported
+// to the clusterset by the flomesh.io ServiceExport controller
+type ServiceExportReconciler struct {
+	client.Client
+	K8sAPI                  *kube.K8sAPI
+	Scheme                  *runtime.Scheme
+	Recorder                record.EventRecorder
+	ControlPlaneConfigStore *config.Store
+}
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the ServiceExport closer to the desired state.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.10.0/pkg/reconcile
+func (r *ServiceExportReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	mcsExport := &mcsv1alpha1.ServiceExport{}
+	if err := r.Get(ctx, req.NamespacedName, mcsExport); err != nil {
+		if errors.IsNotFound(err) {
+			// The derived flomesh.io ServiceExport is garbage collected
+			klog.V(3).Info("[MCS] ServiceExport resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		klog.Errorf("Failed to get MCS ServiceExport, %#v", err)
+		return ctrl.Result{}, err
+	}
+
+	if mcsExport.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	svc := &corev1.Service{}
+	if err := r.Get(ctx, req.NamespacedName, svc); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, r.updateStatus(ctx, mcsExport, mcsv1alpha1.ServiceExportValid, corev1.ConditionFalse,
+				"ServiceNotFound", fmt.Sprintf("Service %s not found", req.NamespacedName))
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	// A flomesh.io ServiceExport created by user takes precedence
+	export := &svcexpv1alpha1.ServiceExport{}
+	if err := r.Get(ctx, req.NamespacedName, export); err == nil && !metav1.IsControlledBy(export, mcsExport) {
+		return ctrl.Result{}, r.updateStatus(ctx, mcsExport, mcsv1alpha1.ServiceExportConflict, corev1.ConditionTrue,
+			"ServiceExportExists", fmt.Sprintf("flomesh.io ServiceExport %s already exists", req.NamespacedName))
+	} else if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	protocol := exportProtocol(svc)
+	rules := exportRules(svc, protocol)
+	if len(rules) == 0 {
+		return ctrl.Result{}, r.updateStatus(ctx, mcsExport, mcsv1alpha1.ServiceExportValid, corev1.ConditionFalse,
+			"NoExportablePort", fmt.Sprintf("Service %s has no TCP port", req.NamespacedName))
+	}
+
+	export = &svcexpv1alpha1.ServiceExport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mcsExport.Name,
+			Namespace: mcsExport.Namespace,
+		},
+	}
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, export, func() error {
+		export.Spec.Protocol = protocol
+		export.Spec.Rules = rules
+		export.Spec.PathRewrite = nil
+		if protocol == svcexpv1alpha1.ServiceExportProtocolHTTP {
+			export.Spec.PathRewrite = &svcexpv1alpha1.PathRewrite{
+				From: fmt.Sprintf("^%s/[0-9]+/?", exportPathPrefix(svc)),
+				To:   "/",
+			}
+		}
+		export.Spec.SessionSticky = svc.Spec.SessionAffinity == corev1.ServiceAffinityClientIP
+
+		return controllerutil.SetControllerReference(mcsExport, export, r.Scheme)
+	})
+	if err != nil {
+		klog.Errorf("Failed to translate MCS ServiceExport %s, %s", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	}
+	klog.V(5).Infof("flomesh.io ServiceExport %s is %s", req.NamespacedName, result)
+
+	return ctrl.Result{}, r.syncStatus(ctx, mcsExport, export)
+}
+
+// syncStatus reflects the conditions of flomesh.io ServiceExport to the MCS ServiceExport
+func (r *ServiceExportReconciler) syncStatus(ctx context.Context, mcsExport *mcsv1alpha1.ServiceExport, export *svcexpv1alpha1.ServiceExport) error {
+	valid := metautil.FindStatusCondition(export.Status.Conditions, string(svcexpv1alpha1.ServiceExportValid))
+	if valid == nil {
+		return nil
+	}
+
+	if err := r.updateStatus(ctx, mcsExport, mcsv1alpha1.ServiceExportValid, corev1.ConditionStatus(valid.Status), valid.Reason, valid.Message); err != nil {
+		return err
+	}
+
+	conflict := metautil.FindStatusCondition(export.Status.Conditions, string(svcexpv1alpha1.ServiceExportConflict))
+	if conflict == nil {
+		return r.updateStatus(ctx, mcsExport, mcsv1alpha1.ServiceExportConflict, corev1.ConditionFalse, "NoConflict", "")
+	}
+
+	return r.updateStatus(ctx, mcsExport, mcsv1alpha1.ServiceExportConflict, corev1.ConditionStatus(conflict.Status), conflict.Reason, conflict.Message)
+}
+
+func (r *ServiceExportReconciler) updateStatus(ctx context.Context, mcsExport *mcsv1alpha1.ServiceExport, conditionType mcsv1alpha1.ServiceExportConditionType, status corev1.ConditionStatus, reason, message string) error {
+	condition := mcsv1alpha1.ServiceExportCondition{
+		Type:               conditionType,
+		Status:             status,
+		LastTransitionTime: &metav1.Time{Time: time.Now()},
+		Reason:             pointer.String(reason),
+		Message:            pointer.String(message),
+	}
+
+	found := false
+	for i, c := range mcsExport.Status.Conditions {
+		if c.Type != conditionType {
+			continue
+		}
+
+		found = true
+		if c.Status == status && pointer.StringDeref(c.Reason, "") == reason && pointer.StringDeref(c.Message, "") == message {
+			return nil
+		}
+		if c.Status == status {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+		mcsExport.Status.Conditions[i] = condition
+	}
+	if !found {
+		mcsExport.Status.Conditions = append(mcsExport.Status.Conditions, condition)
+	}
+
+	if err := r.Status().Update(ctx, mcsExport); err != nil {
+		klog.Errorf("Failed to update status of MCS ServiceExport %s/%s, %s", mcsExport.Namespace, mcsExport.Name, err)
+		return err
+	}
+
+	return nil
+}
+
+// exportProtocol returns the protocol the Service is exported with. MCS services are accessed at their own ports,
+// so they're exported with TCP unless every port is declared as HTTP by appProtocol, which is routed by paths
+// through the Ingress controller
+func exportProtocol(svc *corev1.Service) svcexpv1alpha1.ServiceExportProtocol {
+	if len(svc.Spec.Ports) == 0 {
+		return svcexpv1alpha1.ServiceExportProtocolTCP
+	}
+
+	for _, p := range svc.Spec.Ports {
+		if p.AppProtocol == nil || !strings.EqualFold(*p.AppProtocol, "http") {
+			return svcexpv1alpha1.ServiceExportProtocolTCP
+		}
+	}
+
+	return svcexpv1alpha1.ServiceExportProtocolHTTP
+}
+
+// exportRules exposes each port of the Service, on a port allocated on the cluster gateway for TCP or under a
+// dedicated path for HTTP. The ports of other protocols than TCP, e.g. UDP, can't be exported by the gateway.
+func exportRules(svc *corev1.Service, protocol svcexpv1alpha1.ServiceExportProtocol) []svcexpv1alpha1.ServiceExportRule {
+	rules := make([]svcexpv1alpha1.ServiceExportRule, 0)
+	for _, p := range svc.Spec.Ports {
+		if p.Protocol != "" && p.Protocol != corev1.ProtocolTCP {
+			continue
+		}
+
+		if protocol == svcexpv1alpha1.ServiceExportProtocolTCP {
+			rules = append(rules, svcexpv1alpha1.ServiceExportRule{PortNumber: p.Port})
+			continue
+		}
+
+		rules = append(rules, svcexpv1alpha1.ServiceExportRule{
+			PortNumber: p.Port,
+			Path:       fmt.Sprintf("%s/%d", exportPathPrefix(svc), p.Port),
+			PathType:   pathTypePrefix(),
+		})
+	}
+
+	return rules
+}
+
+func exportPathPrefix(svc *corev1.Service) string {
+	return fmt.Sprintf("/%s/%s", svc.Namespace, svc.Name)
+}
+
+func pathTypePrefix() *networkingv1.PathType {
+	pathType := networkingv1.PathTypePrefix
+	return &pathType
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ServiceExportReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("mcs-serviceexport").
+		For(&mcsv1alpha1.ServiceExport{}).
+		Owns(&svcexpv1alpha1.ServiceExport{}).
+		// the Service and the MCS ServiceExport share the same namespace/name
+		Watches(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestForObject{}).
+		Complete(r)
+}
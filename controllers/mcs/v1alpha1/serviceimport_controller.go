@@ -0,0 +1,152 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package v1alpha1
+
+import (
+	"context"
+	svcimpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceimport/v1alpha1"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	"github.com/flomesh-io/fsm-classic/pkg/kube"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	mcsv1alpha1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// ServiceImportReconciler reconciles a flomesh.io ServiceImport object, a multicluster.x-k8s.io
+// ServiceImport with the same name is maintained for the tools built for MCS API
+type ServiceImportReconciler struct {
+	client.Client
+	K8sAPI                  *kube.K8sAPI
+	Scheme                  *runtime.Scheme
+	Recorder                record.EventRecorder
+	ControlPlaneConfigStore *config.Store
+}
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the ServiceImport closer to the desired state.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.10.0/pkg/reconcile
+func (r *ServiceImportReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	svcImport := &svcimpv1alpha1.ServiceImport{}
+	if err := r.Get(ctx, req.NamespacedName, svcImport); err != nil {
+		if errors.IsNotFound(err) {
+			// The MCS ServiceImport is garbage collected
+			klog.V(3).Info("[MCS] ServiceImport resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		klog.Errorf("Failed to get ServiceImport, %#v", err)
+		return ctrl.Result{}, err
+	}
+
+	if svcImport.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	mcsImport := &mcsv1alpha1.ServiceImport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svcImport.Name,
+			Namespace: svcImport.Namespace,
+		},
+	}
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, mcsImport, func() error {
+		mcsImport.Spec.Type = mcsv1alpha1.ServiceImportType(svcImport.Spec.Type)
+		if mcsImport.Spec.Type == "" {
+			mcsImport.Spec.Type = mcsv1alpha1.ClusterSetIP
+		}
+		mcsImport.Spec.Ports = importPorts(svcImport)
+		mcsImport.Spec.IPs = svcImport.Spec.IPs
+		mcsImport.Spec.SessionAffinity = svcImport.Spec.SessionAffinity
+		mcsImport.Spec.SessionAffinityConfig = svcImport.Spec.SessionAffinityConfig
+
+		return controllerutil.SetControllerReference(svcImport, mcsImport, r.Scheme)
+	})
+	if err != nil {
+		klog.Errorf("Failed to translate ServiceImport %s to MCS ServiceImport, %s", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	}
+	klog.V(5).Infof("MCS ServiceImport %s is %s", req.NamespacedName, result)
+
+	clusters := importClusters(svcImport)
+	if reflect.DeepEqual(clusters, mcsImport.Status.Clusters) {
+		return ctrl.Result{}, nil
+	}
+
+	mcsImport.Status.Clusters = clusters
+	if err := r.Status().Update(ctx, mcsImport); err != nil {
+		klog.Errorf("Failed to update status of MCS ServiceImport %s, %s", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+func importPorts(svcImport *svcimpv1alpha1.ServiceImport) []mcsv1alpha1.ServicePort {
+	ports := make([]mcsv1alpha1.ServicePort, 0)
+	for _, p := range svcImport.Spec.Ports {
+		ports = append(ports, mcsv1alpha1.ServicePort{
+			Name:        p.Name,
+			Protocol:    p.Protocol,
+			AppProtocol: p.AppProtocol,
+			Port:        p.Port,
+		})
+	}
+
+	return ports
+}
+
+// importClusters returns the exporting clusters sorted by cluster key
+func importClusters(svcImport *svcimpv1alpha1.ServiceImport) []mcsv1alpha1.ClusterStatus {
+	keys := sets.NewString()
+	for _, p := range svcImport.Spec.Ports {
+		for _, ep := range p.Endpoints {
+			keys.Insert(ep.ClusterKey)
+		}
+	}
+
+	var clusters []mcsv1alpha1.ClusterStatus
+	for _, key := range keys.List() {
+		clusters = append(clusters, mcsv1alpha1.ClusterStatus{Cluster: key})
+	}
+
+	return clusters
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ServiceImportReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("mcs-serviceimport").
+		For(&svcimpv1alpha1.ServiceImport{}).
+		Owns(&mcsv1alpha1.ServiceImport{}).
+		Complete(r)
+}
@@ -30,6 +30,7 @@ import (
 	"fmt"
 	nsigv1alpha1 "github.com/flomesh-io/fsm-classic/apis/namespacedingress/v1alpha1"
 	"github.com/flomesh-io/fsm-classic/pkg/certificate"
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
 	"github.com/flomesh-io/fsm-classic/pkg/config"
 	"github.com/flomesh-io/fsm-classic/pkg/config/utils"
 	"github.com/flomesh-io/fsm-classic/pkg/helm"
@@ -45,6 +46,7 @@ import (
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"time"
 )
 
@@ -103,6 +105,17 @@ func (r *NamespacedIngressReconciler) Reconcile(ctx context.Context, req ctrl.Re
 		return ctrl.Result{}, err
 	}
 
+	if nsig.DeletionTimestamp != nil {
+		return r.deleteCodebases(ctx, nsig, mc)
+	}
+
+	if !controllerutil.ContainsFinalizer(nsig, commons.CodebaseFinalizer) {
+		controllerutil.AddFinalizer(nsig, commons.CodebaseFinalizer)
+		if err := r.Update(ctx, nsig); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	ctrlResult, err := r.deriveCodebases(nsig, mc)
 	if err != nil {
 		return ctrlResult, err
@@ -113,6 +126,12 @@ func (r *NamespacedIngressReconciler) Reconcile(ctx context.Context, req ctrl.Re
 		return ctrlResult, err
 	}
 
+	if len(mc.RepoClientTLSArgs()) > 0 {
+		if err := repo.EnsureClientTLSSecret(r.K8sAPI.Client, mc.GetMeshNamespace(), nsig.Namespace); err != nil {
+			return ctrl.Result{RequeueAfter: 1 * time.Second}, err
+		}
+	}
+
 	releaseName := fmt.Sprintf("namespaced-ingress-%s", nsig.Namespace)
 	if ctrlResult, err = helm.RenderChart(releaseName, nsig, chartSource, mc, r.Client, r.Scheme, resolveValues); err != nil {
 		return ctrlResult, err
@@ -147,6 +166,11 @@ func resolveValues(object metav1.Object, mc *config.MeshConfig) (map[string]inte
 		fmt.Sprintf("fsm.namespace=%s", mc.GetMeshNamespace()),
 	}
 
+	// the ingress loads codebases from https:// repo with the client certificate copied to its namespace
+	if len(mc.RepoClientTLSArgs()) > 0 {
+		overrides = append(overrides, "fsm.repo.backend=pipy", "fsm.repo.schema=https")
+	}
+
 	for _, ov := range overrides {
 		if err := strvals.ParseInto(ov, finalValues); err != nil {
 			return nil, err
@@ -168,6 +192,27 @@ func (r *NamespacedIngressReconciler) deriveCodebases(nsig *nsigv1alpha1.Namespa
 	return ctrl.Result{}, nil
 }
 
+// deleteCodebases removes the codebase of the NamespacedIngress from repo, then releases the finalizer
+func (r *NamespacedIngressReconciler) deleteCodebases(ctx context.Context, nsig *nsigv1alpha1.NamespacedIngress, mc *config.MeshConfig) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(nsig, commons.CodebaseFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	repoClient := repo.NewRepoClient(mc.RepoRootURL())
+	ingressPath := mc.NamespacedIngressCodebasePath(nsig.Namespace)
+	if err := repoClient.DeleteCodebase(ingressPath); err != nil {
+		klog.Errorf("[NSIG] Deleting codebase %q error: %s", ingressPath, err)
+		return ctrl.Result{RequeueAfter: 1 * time.Second}, err
+	}
+
+	controllerutil.RemoveFinalizer(nsig, commons.CodebaseFinalizer)
+	if err := r.Update(ctx, nsig); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
 func (r *NamespacedIngressReconciler) updateConfig(nsig *nsigv1alpha1.NamespacedIngress, mc *config.MeshConfig) (ctrl.Result, error) {
 	if mc.Ingress.Namespaced && nsig.Spec.TLS.Enabled {
 		repoClient := repo.NewRepoClient(mc.RepoRootURL())
@@ -46,6 +46,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 	"strings"
 	"time"
@@ -87,12 +88,23 @@ func (r *ProxyProfileReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, err
 	}
 
+	mc := r.ControlPlaneConfigStore.MeshConfig.GetConfig()
+
+	if pf.DeletionTimestamp != nil {
+		return r.deleteCodebases(ctx, pf, mc)
+	}
+
+	if !controllerutil.ContainsFinalizer(pf, commons.CodebaseFinalizer) {
+		controllerutil.AddFinalizer(pf, commons.CodebaseFinalizer)
+		if err := r.Update(ctx, pf); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	klog.V(3).Infof("Processing ProxyProfile %s with ResourceVersion: %s", pf.Name, pf.ResourceVersion)
 	klog.V(3).Infof("ProxyProfile %q, ConfigMode=%s, RestartPolicy=%s, RestartScope=%s",
 		pf.Name, pf.GetConfigMode(), pf.Spec.RestartPolicy, pf.Spec.RestartScope)
 
-	mc := r.ControlPlaneConfigStore.MeshConfig.GetConfig()
-
 	switch pf.GetConfigMode() {
 	case pfv1alpha1.ProxyConfigModeLocal:
 		return r.reconcileLocalMode(ctx, pf)
@@ -387,6 +399,42 @@ func (r *ProxyProfileReconciler) deriveCodebases(pf *pfv1alpha1.ProxyProfile, mc
 	return ctrl.Result{}, nil
 }
 
+// deleteCodebases removes codebases of the ProxyProfile and its sidecars from repo, then releases the finalizer
+func (r *ProxyProfileReconciler) deleteCodebases(ctx context.Context, pf *pfv1alpha1.ProxyProfile, mc *config.MeshConfig) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(pf, commons.CodebaseFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	repoClient := repo.NewRepoClient(mc.RepoRootURL())
+
+	// sidecar codebases derive ProxyProfile codebase, they're deleted first
+	for _, sidecar := range pf.Spec.Sidecars {
+		sidecarPath := pfhelper.GetSidecarPath(pf.Name, sidecar.Name, mc)
+		klog.V(5).Infof("Deleting codebase of sidecar %q of ProxyProfile %q", sidecar.Name, pf.Name)
+		if err := repoClient.DeleteCodebase(sidecarPath); err != nil {
+			klog.Errorf("Deleting codebase of sidecar %q of ProxyProfile %q error: %s", sidecar.Name, pf.Name, err)
+			return ctrl.Result{RequeueAfter: 3 * time.Second}, err
+		}
+	}
+
+	// any other codebases deriving it, i.e. of sidecars removed from the spec, are deleted along with it
+	pfPath := pfhelper.GetProxyProfilePath(pf.Name, mc)
+	klog.V(5).Infof("Deleting codebase of ProxyProfile %q", pf.Name)
+	if err := repoClient.DeleteCodebase(pfPath); err != nil {
+		klog.Errorf("Deleting codebase of ProxyProfile %q error: %s", pf.Name, err)
+		return ctrl.Result{RequeueAfter: 3 * time.Second}, err
+	}
+
+	delete(hashStore, pf.Name)
+
+	controllerutil.RemoveFinalizer(pf, commons.CodebaseFinalizer)
+	if err := r.Update(ctx, pf); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
 func (r *ProxyProfileReconciler) findInjectedPods(ctx context.Context, pf *pfv1alpha1.ProxyProfile) ([]corev1.Pod, error) {
 	ns := pf.Spec.Namespace
 	if ns == "" {
@@ -41,11 +41,14 @@ import (
 	metautil "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sync"
 	"time"
 )
 
@@ -57,6 +60,11 @@ type ServiceExportReconciler struct {
 	Recorder                record.EventRecorder
 	ControlPlaneConfigStore *config.Store
 	Broker                  *event.Broker
+	portAllocator           *gatewayPortAllocator
+
+	mu sync.Mutex
+	// withdrawing are the exports to be withdrawn as the cluster has left the ClusterSet
+	withdrawing map[types.NamespacedName]bool
 }
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -85,7 +93,7 @@ func (r *ServiceExportReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
 			klog.V(3).Info("[ServiceExport] ServiceExport resource not found. Ignoring since object must be deleted")
-			return ctrl.Result{}, nil
+			return ctrl.Result{}, r.releaseGatewayPorts(ctx, req.NamespacedName)
 		}
 		// Error reading the object - requeue the request.
 		klog.Errorf("Failed to get ServiceExport, %#v", err)
@@ -96,6 +104,20 @@ func (r *ServiceExportReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		export.Status.Conditions = make([]metav1.Condition, 0)
 	}
 
+	managed, err := r.isManagedCluster(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !managed && r.needsWithdrawal(req.NamespacedName, export) {
+		return r.unmanagedCluster(ctx, req, export)
+	}
+	// the intent of withdrawing is stale once the cluster rejoins a ClusterSet
+	if managed {
+		if err := r.setWithdrawing(ctx, export, false); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	svc := &corev1.Service{}
 	if err := r.Get(ctx, req.NamespacedName, svc); err != nil {
 		// the service doesn't exist
@@ -118,6 +140,19 @@ func (r *ServiceExportReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return r.unsupportedServiceType(ctx, req, export)
 	}
 
+	if export.IsTCP() {
+		return r.exportTCP(ctx, req, export)
+	}
+
+	// the export may be switched from TCP
+	if len(export.Status.GatewayPorts) > 0 {
+		if err := r.releaseGatewayPorts(ctx, req.NamespacedName); err != nil {
+			return ctrl.Result{}, err
+		}
+		export.Status.GatewayPorts = nil
+		export.Status.GatewayAddress = ""
+	}
+
 	// Find and compare path from ingress
 	ingList := &networkingv1.IngressList{}
 	if err := r.List(ctx, ingList, client.InNamespace(corev1.NamespaceAll)); err != nil {
@@ -131,7 +166,7 @@ func (r *ServiceExportReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 			}
 			for _, rule := range ing.Spec.Rules {
 				for _, path := range rule.HTTP.Paths {
-					if path.Path == er.Path && string(*path.PathType) == string(*er.PathType) {
+					if path.Path == er.Path && path.PathType != nil && er.PathType != nil && *path.PathType == *er.PathType {
 						return r.pathConflicts(ctx, export, path, ing)
 					}
 				}
@@ -145,7 +180,7 @@ func (r *ServiceExportReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		ctx,
 		client.ObjectKey{
 			Namespace: export.Namespace,
-			Name:      fmt.Sprintf("svcexp-ing-%s", export.Name),
+			Name:      ingressName(export),
 		},
 		ing,
 	); err != nil {
@@ -318,7 +353,7 @@ func newIngress(export *svcexpv1alpha1.ServiceExport) *networkingv1.Ingress {
 	return &networkingv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace:   export.Namespace,
-			Name:        fmt.Sprintf("svcexp-ing-%s", export.Name),
+			Name:        ingressName(export),
 			Annotations: ingressAnnotations(export),
 		},
 		TypeMeta: metav1.TypeMeta{
@@ -340,6 +375,10 @@ func newIngress(export *svcexpv1alpha1.ServiceExport) *networkingv1.Ingress {
 	}
 }
 
+func ingressName(export *svcexpv1alpha1.ServiceExport) string {
+	return fmt.Sprintf("svcexp-ing-%s", export.Name)
+}
+
 func ingressAnnotations(export *svcexpv1alpha1.ServiceExport) map[string]string {
 	annos := make(map[string]string)
 
@@ -402,8 +441,14 @@ func (r *ServiceExportReconciler) successExport(ctx context.Context, req ctrl.Re
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ServiceExportReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.portAllocator = newGatewayPortAllocator()
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&svcexpv1alpha1.ServiceExport{}).
 		Owns(&networkingv1.Ingress{}).
+		Watches(
+			&source.Kind{Type: &corev1.ConfigMap{}},
+			r.meshConfigHandler(),
+		).
 		Complete(r)
 }
@@ -0,0 +1,407 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	svcexpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceexport/v1alpha1"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metautil "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gatewayPortNamePrefix = "tcp-"
+	gatewayServiceSuffix  = "-tcp"
+
+	// gatewayAddressPollInterval is the interval of checking if the gateway Service of LoadBalancer is assigned
+	// an address
+	gatewayAddressPollInterval = 5 * time.Second
+)
+
+// gatewayExposure is how other clusters reach the allocated gateway ports
+type gatewayExposure struct {
+	// nodePorts of the gateway ports, if the gateway is exposed as NodePort
+	nodePorts map[int32]int32
+	// loadBalancer is true if the gateway is exposed as LoadBalancer, the gateway ports are reached by its address
+	// rather than the gateway host of cluster, as it's a Service different from the Ingress Service
+	loadBalancer bool
+	// address of the LoadBalancer, it's empty until it's assigned
+	address string
+}
+
+// gatewayPortAllocator allocates the ports on cluster gateway for the services exported with TCP protocol,
+// the allocations are loaded from the status of existing ServiceExports once, then kept in memory
+type gatewayPortAllocator struct {
+	mu        sync.Mutex
+	synced    bool
+	allocated map[int32]types.NamespacedName
+}
+
+func newGatewayPortAllocator() *gatewayPortAllocator {
+	return &gatewayPortAllocator{
+		allocated: make(map[int32]types.NamespacedName),
+	}
+}
+
+// allocate returns the gateway ports for the ports of Rules, the ports allocated before are kept
+func (a *gatewayPortAllocator) allocate(ctx context.Context, c client.Reader, tcp config.TCP, export *svcexpv1alpha1.ServiceExport) ([]svcexpv1alpha1.GatewayPort, error) {
+	if tcp.MinPort <= 0 || tcp.MaxPort < tcp.MinPort {
+		return nil, fmt.Errorf("invalid port range [%d, %d] for TCP export", tcp.MinPort, tcp.MaxPort)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.synced {
+		if err := a.sync(ctx, c); err != nil {
+			return nil, err
+		}
+		a.synced = true
+	}
+
+	key := client.ObjectKeyFromObject(export)
+	result := make([]svcexpv1alpha1.GatewayPort, 0)
+	inUse := make(map[int32]bool)
+	for _, r := range export.Spec.Rules {
+		if inUse[r.PortNumber] {
+			continue
+		}
+		inUse[r.PortNumber] = true
+
+		port := export.GatewayPortOf(r.PortNumber)
+		if port < tcp.MinPort || port > tcp.MaxPort || a.allocated[port] != key {
+			port = a.next(tcp)
+			if port == 0 {
+				return nil, fmt.Errorf("ports [%d, %d] for TCP export are exhausted", tcp.MinPort, tcp.MaxPort)
+			}
+			a.allocated[port] = key
+			klog.V(3).Infof("Allocated gateway port %d to port %d of ServiceExport %s", port, r.PortNumber, key)
+		}
+
+		result = append(result, svcexpv1alpha1.GatewayPort{PortNumber: r.PortNumber, GatewayPort: port})
+	}
+
+	// release the ports of removed rules
+	for _, p := range export.Status.GatewayPorts {
+		if !inUse[p.PortNumber] && a.allocated[p.GatewayPort] == key {
+			delete(a.allocated, p.GatewayPort)
+		}
+	}
+
+	return result, nil
+}
+
+func (a *gatewayPortAllocator) next(tcp config.TCP) int32 {
+	for port := tcp.MinPort; port <= tcp.MaxPort; port++ {
+		if _, used := a.allocated[port]; !used {
+			return port
+		}
+	}
+
+	return 0
+}
+
+// release frees the gateway ports held by the ServiceExport
+func (a *gatewayPortAllocator) release(key types.NamespacedName) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for port, owner := range a.allocated {
+		if owner == key {
+			delete(a.allocated, port)
+			klog.V(3).Infof("Released gateway port %d of ServiceExport %s", port, key)
+		}
+	}
+}
+
+// ports returns all allocated gateway ports in ascending order
+func (a *gatewayPortAllocator) ports() []int32 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	result := make([]int32, 0)
+	for port := range a.allocated {
+		result = append(result, port)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+
+	return result
+}
+
+func (a *gatewayPortAllocator) sync(ctx context.Context, c client.Reader) error {
+	exports := &svcexpv1alpha1.ServiceExportList{}
+	if err := c.List(ctx, exports); err != nil {
+		return err
+	}
+
+	for _, export := range exports.Items {
+		if !export.IsTCP() {
+			continue
+		}
+
+		for _, p := range export.Status.GatewayPorts {
+			if _, used := a.allocated[p.GatewayPort]; !used {
+				a.allocated[p.GatewayPort] = types.NamespacedName{Namespace: export.Namespace, Name: export.Name}
+			}
+		}
+	}
+
+	return nil
+}
+
+// exportTCP exports the ports of service on the ports allocated on cluster gateway, instead of creating Ingress
+func (r *ServiceExportReconciler) exportTCP(ctx context.Context, req ctrl.Request, export *svcexpv1alpha1.ServiceExport) (ctrl.Result, error) {
+	// the export may be switched from HTTP
+	ing := &networkingv1.Ingress{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: export.Namespace, Name: ingressName(export)}, ing); err == nil {
+		if metav1.IsControlledBy(ing, export) {
+			if err := r.Delete(ctx, ing); err != nil && !errors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+		}
+	} else if !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	mc := r.ControlPlaneConfigStore.MeshConfig.GetConfig()
+	ports, err := r.portAllocator.allocate(ctx, r.Client, mc.Ingress.TCP, export)
+	if err != nil {
+		metautil.SetStatusCondition(&export.Status.Conditions, metav1.Condition{
+			Type:               string(svcexpv1alpha1.ServiceExportValid),
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: export.Generation,
+			LastTransitionTime: metav1.Time{Time: time.Now()},
+			Reason:             "Failed",
+			Message:            fmt.Sprintf("Failed to allocate gateway ports: %s", err),
+		})
+
+		if err := r.Status().Update(ctx, export); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{}, err
+	}
+	exposure, err := r.exposeGatewayPorts(ctx, mc)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	for i := range ports {
+		ports[i].NodePort = exposure.nodePorts[ports[i].GatewayPort]
+	}
+	export.Status.GatewayPorts = ports
+	export.Status.GatewayAddress = exposure.address
+
+	// other clusters can't reach the gateway ports until the LoadBalancer is assigned an address, the export isn't
+	// imported by them as it's not valid yet
+	if exposure.loadBalancer && exposure.address == "" {
+		metautil.SetStatusCondition(&export.Status.Conditions, metav1.Condition{
+			Type:               string(svcexpv1alpha1.ServiceExportValid),
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: export.Generation,
+			LastTransitionTime: metav1.Time{Time: time.Now()},
+			Reason:             "GatewayAddressPending",
+			Message:            "Waiting for the LoadBalancer of gateway ports to be assigned an address",
+		})
+
+		if err := r.Status().Update(ctx, export); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{RequeueAfter: gatewayAddressPollInterval}, nil
+	}
+
+	return r.successExport(ctx, req, export)
+}
+
+// releaseGatewayPorts frees the gateway ports if the ServiceExport is deleted or switched to HTTP
+func (r *ServiceExportReconciler) releaseGatewayPorts(ctx context.Context, key types.NamespacedName) error {
+	r.portAllocator.release(key)
+
+	_, err := r.exposeGatewayPorts(ctx, r.ControlPlaneConfigStore.MeshConfig.GetConfig())
+	return err
+}
+
+// exposeGatewayPorts exposes the allocated gateway ports by a Service next to each Service of cluster Ingress
+// controller, so that they're reachable from other clusters. The Ingress Services are managed by Helm and any change
+// to them is reverted on upgrade, the gateway Services are owned by the manager instead. The node ports of gateway
+// ports are returned if the gateway is exposed as NodePort, and the address of gateway Service if it's exposed as
+// LoadBalancer, as it may differ from the address of Ingress Service
+func (r *ServiceExportReconciler) exposeGatewayPorts(ctx context.Context, mc *config.MeshConfig) (*gatewayExposure, error) {
+	svcList := &corev1.ServiceList{}
+	if err := r.List(
+		ctx,
+		svcList,
+		client.InNamespace(mc.GetMeshNamespace()),
+		client.MatchingLabels{
+			"app.kubernetes.io/component":   "controller",
+			"app.kubernetes.io/instance":    "fsm-ingress-pipy",
+			"ingress.flomesh.io/namespaced": "false",
+		},
+	); err != nil {
+		klog.Errorf("Failed to list Ingress Services, %s", err)
+		return nil, err
+	}
+
+	allocated := r.portAllocator.ports()
+	exposure := &gatewayExposure{nodePorts: make(map[int32]int32)}
+	for i := range svcList.Items {
+		svc, err := r.syncGatewayService(ctx, &svcList.Items[i], allocated)
+		if err != nil {
+			return nil, err
+		}
+
+		if svc == nil {
+			continue
+		}
+		switch svc.Spec.Type {
+		case corev1.ServiceTypeNodePort:
+			for _, p := range svc.Spec.Ports {
+				if _, ok := exposure.nodePorts[p.Port]; !ok && p.NodePort != 0 {
+					exposure.nodePorts[p.Port] = p.NodePort
+				}
+			}
+		case corev1.ServiceTypeLoadBalancer:
+			exposure.loadBalancer = true
+			if exposure.address == "" {
+				exposure.address = loadBalancerAddress(svc)
+			}
+		}
+	}
+
+	return exposure, nil
+}
+
+// loadBalancerAddress returns the IP or hostname assigned to the LoadBalancer Service, empty if it's not assigned yet
+func loadBalancerAddress(svc *corev1.Service) string {
+	for _, ing := range svc.Status.LoadBalancer.Ingress {
+		if ing.IP != "" {
+			return ing.IP
+		}
+		if ing.Hostname != "" {
+			return ing.Hostname
+		}
+	}
+
+	return ""
+}
+
+// syncGatewayService creates or updates the gateway Service of the Ingress Service with the allocated ports, it's
+// deleted if no port is allocated as a Service must have at least one port
+func (r *ServiceExportReconciler) syncGatewayService(ctx context.Context, ingressSvc *corev1.Service, allocated []int32) (*corev1.Service, error) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ingressSvc.Name + gatewayServiceSuffix,
+			Namespace: ingressSvc.Namespace,
+		},
+	}
+
+	if len(allocated) == 0 {
+		if err := r.Delete(ctx, svc); err != nil && !errors.IsNotFound(err) {
+			klog.Errorf("Failed to delete gateway Service %s/%s, %s", svc.Namespace, svc.Name, err)
+			return nil, err
+		}
+
+		return nil, nil
+	}
+
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		if svc.Labels == nil {
+			svc.Labels = make(map[string]string)
+		}
+		svc.Labels["app.kubernetes.io/component"] = "gateway"
+		svc.Labels["app.kubernetes.io/instance"] = "fsm-ingress-pipy"
+
+		// the annotations of Ingress Service are kept, e.g. the key to share the address of LoadBalancer
+		if svc.Annotations == nil {
+			svc.Annotations = make(map[string]string)
+		}
+		for k, v := range ingressSvc.Annotations {
+			if !strings.HasPrefix(k, "meta.helm.sh/") {
+				svc.Annotations[k] = v
+			}
+		}
+
+		svc.Spec.Type = ingressSvc.Spec.Type
+		svc.Spec.Selector = ingressSvc.Spec.Selector
+		svc.Spec.ExternalTrafficPolicy = ingressSvc.Spec.ExternalTrafficPolicy
+		if svc.Spec.Type == corev1.ServiceTypeLoadBalancer {
+			// request the same address as the Ingress, as other clusters reach the gateway by it
+			for _, ing := range ingressSvc.Status.LoadBalancer.Ingress {
+				if ing.IP != "" {
+					svc.Spec.LoadBalancerIP = ing.IP
+					break
+				}
+			}
+		}
+
+		existing := make(map[string]corev1.ServicePort)
+		for _, p := range svc.Spec.Ports {
+			existing[p.Name] = p
+		}
+
+		ports := make([]corev1.ServicePort, 0)
+		for _, port := range allocated {
+			name := fmt.Sprintf("%s%d", gatewayPortNamePrefix, port)
+			p := corev1.ServicePort{
+				Name:       name,
+				Protocol:   corev1.ProtocolTCP,
+				Port:       port,
+				TargetPort: intstr.FromInt(int(port)),
+			}
+			// NodePort is allocated by Kubernetes if the Service is NodePort or LoadBalancer, keep it stable
+			if svc.Spec.Type == corev1.ServiceTypeNodePort || svc.Spec.Type == corev1.ServiceTypeLoadBalancer {
+				p.NodePort = existing[name].NodePort
+			}
+			ports = append(ports, p)
+		}
+		svc.Spec.Ports = ports
+
+		// the gateway Service is removed along with the Ingress Service, e.g. the Ingress is disabled
+		return controllerutil.SetOwnerReference(ingressSvc, svc, r.Scheme)
+	})
+	if err != nil {
+		klog.Errorf("Failed to sync gateway Service %s/%s, %s", svc.Namespace, svc.Name, err)
+		return nil, err
+	}
+
+	klog.V(5).Infof("Gateway Service %s/%s is %s", svc.Namespace, svc.Name, result)
+
+	return svc, nil
+}
@@ -0,0 +1,182 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package v1alpha1
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	svcexpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceexport/v1alpha1"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTCPExport(name string, ports []int32, allocated ...svcexpv1alpha1.GatewayPort) *svcexpv1alpha1.ServiceExport {
+	export := &svcexpv1alpha1.ServiceExport{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec:       svcexpv1alpha1.ServiceExportSpec{Protocol: svcexpv1alpha1.ServiceExportProtocolTCP},
+		Status:     svcexpv1alpha1.ServiceExportStatus{GatewayPorts: allocated},
+	}
+	for _, p := range ports {
+		export.Spec.Rules = append(export.Spec.Rules, svcexpv1alpha1.ServiceExportRule{PortNumber: p})
+	}
+
+	return export
+}
+
+func TestGatewayPortAllocator(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := svcexpv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	tcp := config.TCP{MinPort: 20000, MaxPort: 20002}
+
+	testCases := []struct {
+		name     string
+		tcp      config.TCP
+		existing []*svcexpv1alpha1.ServiceExport
+		exports  []*svcexpv1alpha1.ServiceExport
+		expected [][]svcexpv1alpha1.GatewayPort
+		err      bool
+	}{
+		{
+			name:    "ports are allocated from the lower bound",
+			tcp:     tcp,
+			exports: []*svcexpv1alpha1.ServiceExport{newTCPExport("a", []int32{80, 443}), newTCPExport("b", []int32{3306})},
+			expected: [][]svcexpv1alpha1.GatewayPort{
+				{{PortNumber: 80, GatewayPort: 20000}, {PortNumber: 443, GatewayPort: 20001}},
+				{{PortNumber: 3306, GatewayPort: 20002}},
+			},
+		},
+		{
+			name:     "duplicated rules of a port share the gateway port",
+			tcp:      tcp,
+			exports:  []*svcexpv1alpha1.ServiceExport{newTCPExport("a", []int32{80, 80})},
+			expected: [][]svcexpv1alpha1.GatewayPort{{{PortNumber: 80, GatewayPort: 20000}}},
+		},
+		{
+			name:     "allocated ports are loaded from existing exports and kept",
+			tcp:      tcp,
+			existing: []*svcexpv1alpha1.ServiceExport{newTCPExport("a", []int32{80}, svcexpv1alpha1.GatewayPort{PortNumber: 80, GatewayPort: 20001})},
+			exports: []*svcexpv1alpha1.ServiceExport{
+				newTCPExport("a", []int32{80}, svcexpv1alpha1.GatewayPort{PortNumber: 80, GatewayPort: 20001}),
+				newTCPExport("b", []int32{80}),
+			},
+			expected: [][]svcexpv1alpha1.GatewayPort{
+				{{PortNumber: 80, GatewayPort: 20001}},
+				{{PortNumber: 80, GatewayPort: 20000}},
+			},
+		},
+		{
+			name:     "port held by another export is re-allocated",
+			tcp:      tcp,
+			existing: []*svcexpv1alpha1.ServiceExport{newTCPExport("a", []int32{80}, svcexpv1alpha1.GatewayPort{PortNumber: 80, GatewayPort: 20000})},
+			exports:  []*svcexpv1alpha1.ServiceExport{newTCPExport("b", []int32{80}, svcexpv1alpha1.GatewayPort{PortNumber: 80, GatewayPort: 20000})},
+			expected: [][]svcexpv1alpha1.GatewayPort{{{PortNumber: 80, GatewayPort: 20001}}},
+		},
+		{
+			name:     "port out of range is re-allocated",
+			tcp:      tcp,
+			exports:  []*svcexpv1alpha1.ServiceExport{newTCPExport("a", []int32{80}, svcexpv1alpha1.GatewayPort{PortNumber: 80, GatewayPort: 30000})},
+			expected: [][]svcexpv1alpha1.GatewayPort{{{PortNumber: 80, GatewayPort: 20000}}},
+		},
+		{
+			name:     "ports are exhausted",
+			tcp:      config.TCP{MinPort: 20000, MaxPort: 20000},
+			exports:  []*svcexpv1alpha1.ServiceExport{newTCPExport("a", []int32{80}), newTCPExport("b", []int32{80})},
+			expected: [][]svcexpv1alpha1.GatewayPort{{{PortNumber: 80, GatewayPort: 20000}}},
+			err:      true,
+		},
+		{
+			name:    "invalid port range",
+			tcp:     config.TCP{MinPort: 20001, MaxPort: 20000},
+			exports: []*svcexpv1alpha1.ServiceExport{newTCPExport("a", []int32{80})},
+			err:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		builder := fake.NewClientBuilder().WithScheme(scheme)
+		for _, export := range tc.existing {
+			builder = builder.WithObjects(export)
+		}
+		c := builder.Build()
+
+		a := newGatewayPortAllocator()
+		var got [][]svcexpv1alpha1.GatewayPort
+		var err error
+		for _, export := range tc.exports {
+			var ports []svcexpv1alpha1.GatewayPort
+			if ports, err = a.allocate(context.TODO(), c, tc.tcp, export); err != nil {
+				break
+			}
+			got = append(got, ports)
+		}
+
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected error %t, got %v", tc.name, tc.err, err)
+		}
+		if len(got) != 0 || len(tc.expected) != 0 {
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+			}
+		}
+	}
+}
+
+func TestGatewayPortAllocatorRelease(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := svcexpv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	tcp := config.TCP{MinPort: 20000, MaxPort: 20010}
+
+	a := newGatewayPortAllocator()
+	ports, err := a.allocate(context.TODO(), c, tcp, newTCPExport("a", []int32{80, 443}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.allocate(context.TODO(), c, tcp, newTCPExport("b", []int32{80})); err != nil {
+		t.Fatal(err)
+	}
+
+	// the port of removed rule is released
+	if _, err := a.allocate(context.TODO(), c, tcp, newTCPExport("a", []int32{80}, ports...)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int32{20000, 20002}; !reflect.DeepEqual(a.ports(), expected) {
+		t.Errorf("expected allocated ports %v, got %v", expected, a.ports())
+	}
+
+	a.release(types.NamespacedName{Namespace: "default", Name: "b"})
+	if expected := []int32{20000}; !reflect.DeepEqual(a.ports(), expected) {
+		t.Errorf("expected allocated ports %v after release, got %v", expected, a.ports())
+	}
+}
@@ -0,0 +1,224 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	svcexpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceexport/v1alpha1"
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metautil "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlevent "sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"time"
+)
+
+const (
+	// notManagedReason of the Valid condition marks the export withdrawn after the cluster left the ClusterSet
+	notManagedReason = "NotManaged"
+)
+
+// isManagedCluster reads MeshConfig from the cache of manager rather than the config store, so that it's consistent
+// with the MeshConfig change which triggers the reconciliation
+func (r *ServiceExportReconciler) isManagedCluster(ctx context.Context) (bool, error) {
+	mc := r.ControlPlaneConfigStore.MeshConfig.GetConfig()
+
+	cm := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: mc.GetMeshNamespace(), Name: commons.MeshConfigName}, cm); err != nil {
+		return false, err
+	}
+
+	cfg, err := config.ParseMeshConfig(cm)
+	if err != nil {
+		return false, err
+	}
+
+	return cfg.IsManaged, nil
+}
+
+// needsWithdrawal returns true if the export was exported in the ClusterSet which the cluster has left, or it has been
+// withdrawn already. Exports of a standalone cluster which has never joined a ClusterSet are processed as usual.
+func (r *ServiceExportReconciler) needsWithdrawal(key types.NamespacedName, export *svcexpv1alpha1.ServiceExport) bool {
+	if valid := metautil.FindStatusCondition(export.Status.Conditions, string(svcexpv1alpha1.ServiceExportValid)); valid != nil && valid.Reason == notManagedReason {
+		return true
+	}
+
+	if _, ok := export.Annotations[commons.MultiClustersServiceExportWithdrawing]; ok {
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.withdrawing[key]
+}
+
+// unmanagedCluster withdraws the export once the cluster leaves the ClusterSet, the derived Ingress and gateway ports
+// are released and the conditions are reset, it's exported again after the cluster rejoins
+func (r *ServiceExportReconciler) unmanagedCluster(ctx context.Context, req ctrl.Request, export *svcexpv1alpha1.ServiceExport) (ctrl.Result, error) {
+	ing := &networkingv1.Ingress{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: export.Namespace, Name: ingressName(export)}, ing); err == nil {
+		if metav1.IsControlledBy(ing, export) {
+			if err := r.Delete(ctx, ing); err != nil && !errors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+		}
+	} else if !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	if len(export.Status.GatewayPorts) > 0 {
+		if err := r.releaseGatewayPorts(ctx, req.NamespacedName); err != nil {
+			return ctrl.Result{}, err
+		}
+		export.Status.GatewayPorts = nil
+		export.Status.GatewayAddress = ""
+	}
+
+	metautil.RemoveStatusCondition(&export.Status.Conditions, string(svcexpv1alpha1.ServiceExportConflict))
+	metautil.SetStatusCondition(&export.Status.Conditions, metav1.Condition{
+		Type:               string(svcexpv1alpha1.ServiceExportValid),
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: export.Generation,
+		LastTransitionTime: metav1.Time{Time: time.Now()},
+		Reason:             notManagedReason,
+		Message:            "Cluster doesn't join any ClusterSet, Service is not exported.",
+	})
+
+	if err := r.Status().Update(ctx, export); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// the condition records the withdrawal from now on
+	if err := r.setWithdrawing(ctx, export, false); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.mu.Lock()
+	delete(r.withdrawing, req.NamespacedName)
+	r.mu.Unlock()
+
+	return ctrl.Result{}, nil
+}
+
+// setWithdrawing records the intent of withdrawing the export in its annotation, so that it's withdrawn even if the
+// manager restarts before reconciling it, as the transition of MeshConfig is not visible any more then
+func (r *ServiceExportReconciler) setWithdrawing(ctx context.Context, export *svcexpv1alpha1.ServiceExport, withdrawing bool) error {
+	if _, ok := export.Annotations[commons.MultiClustersServiceExportWithdrawing]; ok == withdrawing {
+		return nil
+	}
+
+	// null removes the annotation by merge patch
+	var value interface{}
+	if withdrawing {
+		value = "true"
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{commons.MultiClustersServiceExportWithdrawing: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := r.Patch(ctx, export, client.RawPatch(types.MergePatchType, patch)); err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("Failed to mark ServiceExport %s/%s withdrawing=%t: %s", export.Namespace, export.Name, withdrawing, err)
+		return err
+	}
+
+	return nil
+}
+
+// meshConfigHandler enqueues all ServiceExports once the cluster joins or leaves the ClusterSet, so that they're
+// exported again or withdrawn. The exports existing when the cluster leaves are recorded to be withdrawn, both in
+// memory and by annotation, as the transition is not visible any more once MeshConfig is reset.
+func (r *ServiceExportReconciler) meshConfigHandler() handler.EventHandler {
+	return handler.Funcs{
+		UpdateFunc: func(e ctrlevent.UpdateEvent, q workqueue.RateLimitingInterface) {
+			mc := r.ControlPlaneConfigStore.MeshConfig.GetConfig()
+			if e.ObjectNew.GetNamespace() != mc.GetMeshNamespace() || e.ObjectNew.GetName() != commons.MeshConfigName {
+				return
+			}
+
+			oldManaged, newManaged := isManagedMeshConfig(e.ObjectOld), isManagedMeshConfig(e.ObjectNew)
+			if oldManaged == newManaged {
+				return
+			}
+
+			exports := &svcexpv1alpha1.ServiceExportList{}
+			if err := r.List(context.TODO(), exports); err != nil {
+				klog.Errorf("Failed to list ServiceExports: %s", err)
+				return
+			}
+
+			r.mu.Lock()
+			if r.withdrawing == nil {
+				r.withdrawing = make(map[types.NamespacedName]bool)
+			}
+			for _, export := range exports.Items {
+				key := types.NamespacedName{Namespace: export.Namespace, Name: export.Name}
+				if newManaged {
+					delete(r.withdrawing, key)
+				} else {
+					r.withdrawing[key] = true
+				}
+			}
+			r.mu.Unlock()
+
+			// the intent in memory takes effect even if the annotation fails to be persisted
+			for i := range exports.Items {
+				export := &exports.Items[i]
+				_ = r.setWithdrawing(context.TODO(), export, !newManaged)
+				q.Add(reconcile.Request{NamespacedName: client.ObjectKeyFromObject(export)})
+			}
+		},
+	}
+}
+
+func isManagedMeshConfig(obj client.Object) bool {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return false
+	}
+
+	cfg, err := config.ParseMeshConfig(cm)
+	if err != nil {
+		klog.Errorf("Failed to parse MeshConfig: %s", err)
+		return false
+	}
+
+	return cfg.IsManaged
+}
@@ -0,0 +1,70 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+
+	svcexpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceexport/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestWithdrawingSurvivesRestart(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := svcexpv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	export := newTCPExport("a", []int32{80})
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(export).Build()
+	key := client.ObjectKeyFromObject(export)
+
+	r := &ServiceExportReconciler{Client: c}
+	if err := r.setWithdrawing(context.TODO(), export, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new reconciler has nothing in memory, e.g. the manager restarts before reconciling the export
+	restarted := &ServiceExportReconciler{Client: c}
+	got := &svcexpv1alpha1.ServiceExport{}
+	if err := c.Get(context.TODO(), key, got); err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.needsWithdrawal(key, got) {
+		t.Errorf("expected ServiceExport %s to be withdrawn after restart", key)
+	}
+
+	if err := restarted.setWithdrawing(context.TODO(), got, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(context.TODO(), key, got); err != nil {
+		t.Fatal(err)
+	}
+	if restarted.needsWithdrawal(key, got) {
+		t.Errorf("expected ServiceExport %s not to be withdrawn once the mark is removed, annotations %v", key, got.Annotations)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	svcimpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceimport/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"net/netip"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"sync"
+)
+
+// clusterSetIPAllocator allocates ClusterSetIPs of ServiceImports from the configured CIDR, the allocations
+// are loaded from existing ServiceImports once, then kept in memory as the cached ServiceImports may not
+// reflect the latest allocations yet
+type clusterSetIPAllocator struct {
+	mu        sync.Mutex
+	synced    bool
+	allocated map[netip.Addr]types.NamespacedName
+}
+
+func newClusterSetIPAllocator() *clusterSetIPAllocator {
+	return &clusterSetIPAllocator{
+		allocated: make(map[netip.Addr]types.NamespacedName),
+	}
+}
+
+// allocate returns the ClusterSetIP of the ServiceImport, a new one is allocated if it doesn't have one in the CIDR
+func (a *clusterSetIPAllocator) allocate(ctx context.Context, c client.Reader, cidr string, svcImport *svcimpv1alpha1.ServiceImport) (string, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", fmt.Errorf("invalid ClusterSetIP CIDR %q: %w", cidr, err)
+	}
+	prefix = prefix.Masked()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.synced {
+		if err := a.sync(ctx, c, prefix); err != nil {
+			return "", err
+		}
+		a.synced = true
+	}
+
+	key := types.NamespacedName{Namespace: svcImport.Namespace, Name: svcImport.Name}
+	for _, ip := range svcImport.Spec.IPs {
+		if addr, err := netip.ParseAddr(ip); err == nil && prefix.Contains(addr) && a.allocated[addr] == key {
+			return addr.String(), nil
+		}
+	}
+
+	// the network address is reserved, so is the broadcast address of IPv4
+	for addr := prefix.Addr().Next(); prefix.Contains(addr); addr = addr.Next() {
+		if addr.Is4() && !prefix.Contains(addr.Next()) {
+			break
+		}
+		if _, used := a.allocated[addr]; used {
+			continue
+		}
+
+		a.allocated[addr] = key
+		klog.V(3).Infof("Allocated ClusterSetIP %s to ServiceImport %s", addr, key)
+		return addr.String(), nil
+	}
+
+	return "", fmt.Errorf("ClusterSetIP CIDR %s is exhausted", prefix)
+}
+
+// markUsed records an address which is taken outside the ServiceImports, e.g. by a Service of the cluster, it's
+// never allocated again
+func (a *clusterSetIPAllocator) markUsed(ip string) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.allocated[addr] = types.NamespacedName{}
+	klog.V(3).Infof("ClusterSetIP %s is used by the cluster, skipped", addr)
+}
+
+// isIPAllocatedError tells if the ClusterIP provided for a Service is already allocated by Kubernetes
+func isIPAllocatedError(err error) bool {
+	return errors.IsInvalid(err) && strings.Contains(err.Error(), "provided IP is already allocated")
+}
+
+// release frees the ClusterSetIPs held by the ServiceImport
+func (a *clusterSetIPAllocator) release(key types.NamespacedName) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for addr, owner := range a.allocated {
+		if owner == key {
+			delete(a.allocated, addr)
+			klog.V(3).Infof("Released ClusterSetIP %s of ServiceImport %s", addr, key)
+		}
+	}
+}
+
+// sync records the ClusterSetIPs of existing ServiceImports, the first holder wins if an IP is claimed by many
+func (a *clusterSetIPAllocator) sync(ctx context.Context, c client.Reader, prefix netip.Prefix) error {
+	imports := &svcimpv1alpha1.ServiceImportList{}
+	if err := c.List(ctx, imports); err != nil {
+		return err
+	}
+
+	for _, imp := range imports.Items {
+		if imp.Spec.Type == svcimpv1alpha1.Headless {
+			continue
+		}
+
+		for _, ip := range imp.Spec.IPs {
+			addr, err := netip.ParseAddr(ip)
+			if err != nil || !prefix.Contains(addr) {
+				continue
+			}
+			if _, used := a.allocated[addr]; !used {
+				a.allocated[addr] = types.NamespacedName{Namespace: imp.Namespace, Name: imp.Name}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+
+	svcimpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceimport/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newServiceImport(name string, ips ...string) *svcimpv1alpha1.ServiceImport {
+	return &svcimpv1alpha1.ServiceImport{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec:       svcimpv1alpha1.ServiceImportSpec{Type: svcimpv1alpha1.ClusterSetIP, IPs: ips},
+	}
+}
+
+func TestClusterSetIPAllocator(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := svcimpv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		cidr     string
+		existing []*svcimpv1alpha1.ServiceImport
+		used     []string
+		imports  []*svcimpv1alpha1.ServiceImport
+		expected []string
+		err      bool
+	}{
+		{
+			name:     "network address is skipped",
+			cidr:     "10.10.0.0/24",
+			imports:  []*svcimpv1alpha1.ServiceImport{newServiceImport("a"), newServiceImport("b")},
+			expected: []string{"10.10.0.1", "10.10.0.2"},
+		},
+		{
+			name:     "CIDR is masked",
+			cidr:     "10.10.0.8/29",
+			imports:  []*svcimpv1alpha1.ServiceImport{newServiceImport("a")},
+			expected: []string{"10.10.0.9"},
+		},
+		{
+			name:     "allocated IP is kept",
+			cidr:     "10.10.0.0/24",
+			existing: []*svcimpv1alpha1.ServiceImport{newServiceImport("a", "10.10.0.7")},
+			imports:  []*svcimpv1alpha1.ServiceImport{newServiceImport("a", "10.10.0.7"), newServiceImport("b")},
+			expected: []string{"10.10.0.7", "10.10.0.1"},
+		},
+		{
+			name:     "IP held by another ServiceImport is re-allocated",
+			cidr:     "10.10.0.0/24",
+			existing: []*svcimpv1alpha1.ServiceImport{newServiceImport("a", "10.10.0.1")},
+			imports:  []*svcimpv1alpha1.ServiceImport{newServiceImport("b", "10.10.0.1")},
+			expected: []string{"10.10.0.2"},
+		},
+		{
+			name:     "IP out of CIDR is re-allocated",
+			cidr:     "10.10.0.0/24",
+			imports:  []*svcimpv1alpha1.ServiceImport{newServiceImport("a", "10.20.0.1")},
+			expected: []string{"10.10.0.1"},
+		},
+		{
+			name:     "IP used in the cluster is skipped",
+			cidr:     "10.10.0.0/24",
+			used:     []string{"10.10.0.1"},
+			imports:  []*svcimpv1alpha1.ServiceImport{newServiceImport("a", "10.10.0.1")},
+			expected: []string{"10.10.0.2"},
+		},
+		{
+			name:     "broadcast address of IPv4 is not allocated",
+			cidr:     "10.10.0.0/30",
+			imports:  []*svcimpv1alpha1.ServiceImport{newServiceImport("a"), newServiceImport("b"), newServiceImport("c")},
+			expected: []string{"10.10.0.1", "10.10.0.2"},
+			err:      true,
+		},
+		{
+			name:     "IPv6 CIDR",
+			cidr:     "fd00::/126",
+			imports:  []*svcimpv1alpha1.ServiceImport{newServiceImport("a"), newServiceImport("b"), newServiceImport("c")},
+			expected: []string{"fd00::1", "fd00::2", "fd00::3"},
+		},
+		{
+			name:    "invalid CIDR",
+			cidr:    "10.10.0.0",
+			imports: []*svcimpv1alpha1.ServiceImport{newServiceImport("a")},
+			err:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		builder := fake.NewClientBuilder().WithScheme(scheme)
+		for _, imp := range tc.existing {
+			builder = builder.WithObjects(imp)
+		}
+		c := builder.Build()
+
+		a := newClusterSetIPAllocator()
+		for _, ip := range tc.used {
+			a.markUsed(ip)
+		}
+
+		var got []string
+		var err error
+		for _, imp := range tc.imports {
+			var ip string
+			if ip, err = a.allocate(context.TODO(), c, tc.cidr, imp); err != nil {
+				break
+			}
+			got = append(got, ip)
+		}
+
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected error %t, got %v", tc.name, tc.err, err)
+		}
+		if len(got) != len(tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.expected[i] {
+				t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+				break
+			}
+		}
+	}
+}
+
+func TestClusterSetIPAllocatorRelease(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := svcimpv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	a := newClusterSetIPAllocator()
+	ip, err := a.allocate(context.TODO(), c, "10.10.0.0/30", newServiceImport("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.markUsed("10.10.0.2")
+
+	if _, err := a.allocate(context.TODO(), c, "10.10.0.0/30", newServiceImport("b")); err == nil {
+		t.Errorf("expected the CIDR to be exhausted")
+	}
+
+	a.release(types.NamespacedName{Namespace: "default", Name: "a"})
+	got, err := a.allocate(context.TODO(), c, "10.10.0.0/30", newServiceImport("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != ip {
+		t.Errorf("expected released IP %s to be allocated again, got %s", ip, got)
+	}
+}
@@ -28,15 +28,20 @@ import (
 	"context"
 	_ "embed"
 	svcimpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceimport/v1alpha1"
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
 	"github.com/flomesh-io/fsm-classic/pkg/config"
 	"github.com/flomesh-io/fsm-classic/pkg/kube"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
+	"net"
+	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 // ServiceImportReconciler reconciles a ServiceImport object
@@ -46,31 +51,30 @@ type ServiceImportReconciler struct {
 	Scheme                  *runtime.Scheme
 	Recorder                record.EventRecorder
 	ControlPlaneConfigStore *config.Store
+	ipAllocator             *clusterSetIPAllocator
 }
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the ServiceImport closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the ServiceImport object against the actual ServiceImport state, and then
-// perform operations to make the ServiceImport state reflect the state specified by
-// the user.
+// For each ServiceImport, a selector-less Service and the Endpoints pointing to the
+// gateways of remote clusters are derived, so that plain kubernetes clients can reach
+// the imported service without sidecar.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.10.0/pkg/reconcile
 func (r *ServiceImportReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	//mc := r.ControlPlaneConfigStore.MeshConfig.GetConfig()
-
-	ServiceImport := &svcimpv1alpha1.ServiceImport{}
+	svcImport := &svcimpv1alpha1.ServiceImport{}
 	if err := r.Get(
 		ctx,
 		client.ObjectKey{Name: req.Name, Namespace: req.Namespace},
-		ServiceImport,
+		svcImport,
 	); err != nil {
 		if errors.IsNotFound(err) {
 			// Request object not found, could have been deleted after reconcile request.
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
 			klog.V(3).Info("[ServiceImport] ServiceImport resource not found. Ignoring since object must be deleted")
+			r.ipAllocator.release(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
@@ -78,11 +82,244 @@ func (r *ServiceImportReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{}, err
 	}
 
+	if svcImport.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	mc := r.ControlPlaneConfigStore.MeshConfig.GetConfig()
+	cidr := mc.ClusterSetDNS.ClusterSetIPCIDR
+	if cidr != "" && svcImport.Spec.Type != svcimpv1alpha1.Headless {
+		if err := r.allocateClusterSetIP(ctx, cidr, svcImport); err != nil {
+			klog.Errorf("Failed to allocate ClusterSetIP for ServiceImport %s/%s, %s", svcImport.Namespace, svcImport.Name, err)
+			r.Recorder.Eventf(svcImport, corev1.EventTypeWarning, "AllocateClusterSetIPFailed", "Failed to allocate ClusterSetIP: %s", err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	svc, err := r.deriveService(ctx, svcImport, cidr != "")
+	if err != nil {
+		if cidr != "" && len(svcImport.Spec.IPs) > 0 && isIPAllocatedError(err) {
+			// the ClusterSetIP is taken by a Service of the cluster, move on to the next one
+			klog.Warningf("ClusterSetIP %s of ServiceImport %s/%s is already allocated in the cluster, re-allocating", svcImport.Spec.IPs[0], svcImport.Namespace, svcImport.Name)
+			r.ipAllocator.markUsed(svcImport.Spec.IPs[0])
+			return ctrl.Result{Requeue: true}, nil
+		}
+
+		klog.Errorf("Failed to derive Service for ServiceImport %s/%s, %s", svcImport.Namespace, svcImport.Name, err)
+		r.Recorder.Eventf(svcImport, corev1.EventTypeWarning, "DeriveServiceFailed", "Failed to derive Service: %s", err)
+		return ctrl.Result{}, err
+	}
+
+	if err := r.deriveEndpoints(ctx, svcImport); err != nil {
+		klog.Errorf("Failed to derive Endpoints for ServiceImport %s/%s, %s", svcImport.Namespace, svcImport.Name, err)
+		r.Recorder.Eventf(svcImport, corev1.EventTypeWarning, "DeriveEndpointsFailed", "Failed to derive Endpoints: %s", err)
+		return ctrl.Result{}, err
+	}
+
+	// ClusterSetIPs are allocated from the CIDR rather than by Kubernetes
+	if cidr != "" {
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.updateServiceImportIPs(ctx, svcImport, svc); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	return ctrl.Result{}, nil
 }
 
+func (r *ServiceImportReconciler) allocateClusterSetIP(ctx context.Context, cidr string, svcImport *svcimpv1alpha1.ServiceImport) error {
+	ip, err := r.ipAllocator.allocate(ctx, r.Client, cidr, svcImport)
+	if err != nil {
+		return err
+	}
+
+	ips := []string{ip}
+	if reflect.DeepEqual(ips, svcImport.Spec.IPs) {
+		return nil
+	}
+
+	svcImport.Spec.IPs = ips
+	return r.Update(ctx, svcImport)
+}
+
+func (r *ServiceImportReconciler) deriveService(ctx context.Context, svcImport *svcimpv1alpha1.ServiceImport, useClusterSetIP bool) (*corev1.Service, error) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svcImport.DerivedServiceName(),
+			Namespace: svcImport.Namespace,
+		},
+	}
+
+	// ClusterIP is immutable, the derived Service is recreated to take the ClusterSetIP
+	clusterSetIP := ""
+	if useClusterSetIP && svcImport.Spec.Type != svcimpv1alpha1.Headless && len(svcImport.Spec.IPs) > 0 {
+		clusterSetIP = svcImport.Spec.IPs[0]
+		if err := r.Get(ctx, client.ObjectKeyFromObject(svc), svc); err == nil && svc.Spec.ClusterIP != clusterSetIP {
+			klog.V(3).Infof("Recreating derived Service %s/%s with ClusterSetIP %s", svc.Namespace, svc.Name, clusterSetIP)
+			if err := r.Delete(ctx, svc); err != nil && !errors.IsNotFound(err) {
+				return nil, err
+			}
+			svc = &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      svcImport.DerivedServiceName(),
+					Namespace: svcImport.Namespace,
+				},
+			}
+		}
+	}
+
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		if svc.Labels == nil {
+			svc.Labels = make(map[string]string)
+		}
+		svc.Labels[commons.MultiClustersServiceImportName] = svcImport.Name
+
+		// ClusterIP is immutable once allocated, Headless can only be set on creation
+		if svc.CreationTimestamp.IsZero() {
+			switch {
+			case svcImport.Spec.Type == svcimpv1alpha1.Headless:
+				svc.Spec.ClusterIP = corev1.ClusterIPNone
+			case clusterSetIP != "":
+				svc.Spec.ClusterIP = clusterSetIP
+			}
+		}
+		svc.Spec.Type = corev1.ServiceTypeClusterIP
+		svc.Spec.Selector = nil
+		svc.Spec.Ports = servicePorts(svcImport)
+		svc.Spec.SessionAffinity = svcImport.Spec.SessionAffinity
+		svc.Spec.SessionAffinityConfig = svcImport.Spec.SessionAffinityConfig
+		if svc.Spec.SessionAffinity == "" {
+			svc.Spec.SessionAffinity = corev1.ServiceAffinityNone
+		}
+
+		return controllerutil.SetControllerReference(svcImport, svc, r.Scheme)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	klog.V(5).Infof("Derived Service %s/%s is %s", svc.Namespace, svc.Name, result)
+
+	return svc, nil
+}
+
+func (r *ServiceImportReconciler) deriveEndpoints(ctx context.Context, svcImport *svcimpv1alpha1.ServiceImport) error {
+	ep := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svcImport.DerivedServiceName(),
+			Namespace: svcImport.Namespace,
+		},
+	}
+
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, ep, func() error {
+		if ep.Labels == nil {
+			ep.Labels = make(map[string]string)
+		}
+		ep.Labels[commons.MultiClustersServiceImportName] = svcImport.Name
+		ep.Subsets = endpointSubsets(svcImport)
+
+		return controllerutil.SetControllerReference(svcImport, ep, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+
+	klog.V(5).Infof("Derived Endpoints %s/%s is %s", ep.Namespace, ep.Name, result)
+
+	return nil
+}
+
+// updateServiceImportIPs records the allocated ClusterIP of derived Service in ServiceImport
+func (r *ServiceImportReconciler) updateServiceImportIPs(ctx context.Context, svcImport *svcimpv1alpha1.ServiceImport, svc *corev1.Service) error {
+	if svcImport.Spec.Type == svcimpv1alpha1.Headless {
+		return nil
+	}
+
+	ips := svc.Spec.ClusterIPs
+	if len(ips) == 0 && svc.Spec.ClusterIP != "" {
+		ips = []string{svc.Spec.ClusterIP}
+	}
+	if len(ips) == 0 || reflect.DeepEqual(ips, svcImport.Spec.IPs) {
+		return nil
+	}
+
+	svcImport.Spec.IPs = ips
+	if err := r.Update(ctx, svcImport); err != nil {
+		klog.Errorf("Failed to update IPs of ServiceImport %s/%s, %s", svcImport.Namespace, svcImport.Name, err)
+		return err
+	}
+
+	return nil
+}
+
+func servicePorts(svcImport *svcimpv1alpha1.ServiceImport) []corev1.ServicePort {
+	ports := make([]corev1.ServicePort, 0)
+	for _, p := range svcImport.Spec.Ports {
+		ports = append(ports, corev1.ServicePort{
+			Name:        p.Name,
+			Protocol:    p.Protocol,
+			AppProtocol: p.AppProtocol,
+			Port:        p.Port,
+		})
+	}
+
+	return ports
+}
+
+// endpointSubsets builds one subset for each remote endpoint, as the gateways of
+// remote clusters listen on different ports for the same service port
+func endpointSubsets(svcImport *svcimpv1alpha1.ServiceImport) []corev1.EndpointSubset {
+	subsets := make([]corev1.EndpointSubset, 0)
+	for _, p := range svcImport.Spec.Ports {
+		for _, ep := range p.Endpoints {
+			ip := endpointIP(ep.Target)
+			if ip == "" {
+				klog.Warningf("Endpoint %s of ServiceImport %s/%s has no valid IP, ignored", ep.Target.Host, svcImport.Namespace, svcImport.Name)
+				continue
+			}
+
+			subsets = append(subsets, corev1.EndpointSubset{
+				Addresses: []corev1.EndpointAddress{{IP: ip}},
+				Ports: []corev1.EndpointPort{{
+					Name:        p.Name,
+					Port:        ep.Target.Port,
+					Protocol:    p.Protocol,
+					AppProtocol: p.AppProtocol,
+				}},
+			})
+		}
+	}
+
+	return subsets
+}
+
+func endpointIP(target svcimpv1alpha1.Target) string {
+	if net.ParseIP(target.IP) != nil {
+		return target.IP
+	}
+
+	if target.Host == "" {
+		return ""
+	}
+
+	if net.ParseIP(target.Host) != nil {
+		return target.Host
+	}
+
+	ips, err := net.LookupIP(target.Host)
+	if err != nil || len(ips) == 0 {
+		klog.Warningf("Failed to resolve host %s, %v", target.Host, err)
+		return ""
+	}
+
+	return ips[0].String()
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *ServiceImportReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.ipAllocator = newClusterSetIPAllocator()
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&svcimpv1alpha1.ServiceImport{}).
 		Owns(&corev1.Service{}).
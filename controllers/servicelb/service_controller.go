@@ -108,6 +108,12 @@ func (r *ServiceReconciler) deployDaemonSet(ctx context.Context, svc *corev1.Ser
 		return r.deleteDaemonSet(ctx, svc)
 	}
 
+	if !hasIPv4Family(svc) {
+		klog.Warningf("ServiceLB doesn't support IPv6 single-stack service %s/%s", svc.Namespace, svc.Name)
+		r.Recorder.Eventf(svc, corev1.EventTypeWarning, "UnsupportedIPFamily", "ServiceLB doesn't support IPv6 single-stack Service")
+		return r.deleteDaemonSet(ctx, svc)
+	}
+
 	ds, err := r.newDaemonSet(ctx, svc, mc)
 	if err != nil {
 		return err
@@ -224,7 +230,7 @@ func (r *ServiceReconciler) newDaemonSet(ctx context.Context, svc *corev1.Servic
 				},
 				{
 					Name:  "DEST_IPS",
-					Value: strings.Join(svc.Spec.ClusterIPs, " "),
+					Value: strings.Join(ipv4ClusterIPs(svc), " "),
 				},
 			},
 			SecurityContext: &corev1.SecurityContext{
@@ -282,7 +288,7 @@ func (r *ServiceReconciler) newDaemonSet(ctx context.Context, svc *corev1.Servic
 }
 
 func (r *ServiceReconciler) updateService(ctx context.Context, svc *corev1.Service, mc *config.MeshConfig) error {
-	if !mc.ServiceLB.Enabled || svc.DeletionTimestamp != nil || svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+	if !mc.ServiceLB.Enabled || svc.DeletionTimestamp != nil || svc.Spec.Type != corev1.ServiceTypeLoadBalancer || !hasIPv4Family(svc) {
 		return r.removeFinalizer(ctx, svc)
 	}
 
@@ -399,28 +405,54 @@ func (r *ServiceReconciler) podIPs(ctx context.Context, pods []corev1.Pod, svc *
 	return ips, nil
 }
 
+// filterByIPFamily returns the IPv4 addresses of the Service, klipper-lb sets up iptables rules only, so ServiceLB
+// exposes the IPv4 family of dual-stack Services and doesn't support IPv6 single-stack Services
 func filterByIPFamily(ips []string, svc *corev1.Service) ([]string, error) {
-	var ipFamilyPolicy corev1.IPFamilyPolicyType
-	var ipv4Addresses []string
+	if !hasIPv4Family(svc) {
+		return nil, fmt.Errorf("ServiceLB doesn't support IPv6 single-stack service %s/%s", svc.Namespace, svc.Name)
+	}
 
+	var ipv4Addresses []string
 	for _, ip := range ips {
 		if net.IsIPv4String(ip) {
 			ipv4Addresses = append(ipv4Addresses, ip)
 		}
 	}
 
-	if svc.Spec.IPFamilyPolicy != nil {
-		ipFamilyPolicy = *svc.Spec.IPFamilyPolicy
+	return ipv4Addresses, nil
+}
+
+// hasIPv4Family returns true if the Service has an IPv4 ClusterIP, services created before dual-stack was enabled
+// have no ipFamilies
+func hasIPv4Family(svc *corev1.Service) bool {
+	if len(svc.Spec.IPFamilies) == 0 {
+		return net.IsIPv4String(svc.Spec.ClusterIP)
+	}
+
+	for _, family := range svc.Spec.IPFamilies {
+		if family == corev1.IPv4Protocol {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ipv4ClusterIPs returns the IPv4 ClusterIPs of the Service, which klipper-lb forwards the traffic to
+func ipv4ClusterIPs(svc *corev1.Service) []string {
+	clusterIPs := svc.Spec.ClusterIPs
+	if len(clusterIPs) == 0 {
+		clusterIPs = []string{svc.Spec.ClusterIP}
 	}
 
-	switch ipFamilyPolicy {
-	case corev1.IPFamilyPolicySingleStack:
-		if svc.Spec.IPFamilies[0] == corev1.IPv4Protocol {
-			return ipv4Addresses, nil
+	var result []string
+	for _, ip := range clusterIPs {
+		if net.IsIPv4String(ip) {
+			result = append(result, ip)
 		}
 	}
 
-	return nil, fmt.Errorf("unhandled ipFamilyPolicy")
+	return result
 }
 
 func (r *ServiceReconciler) addFinalizer(ctx context.Context, svc *corev1.Service) error {
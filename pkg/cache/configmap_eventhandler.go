@@ -25,22 +25,65 @@
 package cache
 
 import (
+	"context"
+	"fmt"
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
+	"time"
 )
 
+const (
+	// restorePinHoldTimeout is how long the in-flight pushes are waited for once codebases are pinned
+	restorePinHoldTimeout = 2 * time.Minute
+)
+
+// ConfigMap events are of the restore pin only, see newLocalCache
+
 func (c *LocalCache) OnConfigMapAdd(cm *corev1.ConfigMap) {
-	klog.Error("implement me")
+	c.acknowledgeRestorePin(cm)
 }
 
 func (c *LocalCache) OnConfigMapUpdate(oldCm, cm *corev1.ConfigMap) {
-	klog.Error("implement me")
+	c.acknowledgeRestorePin(cm)
 }
 
 func (c *LocalCache) OnConfigMapDelete(cm *corev1.ConfigMap) {
-	klog.Error("implement me")
+	klog.Infof("Codebases are unpinned from the restored snapshot, resuming pushes")
+	c.Sync()
 }
 
 func (c *LocalCache) OnConfigMapSynced() {
-	klog.Error("implement me")
+	klog.V(5).Infof("Restore pin is synced")
+}
+
+// acknowledgeRestorePin marks the pin as held once no push is in flight, the snapshot is restored after that.
+// The pushers see the pin from now on, so a push in flight stops before its next commit.
+func (c *LocalCache) acknowledgeRestorePin(cm *corev1.ConfigMap) {
+	restoredAt := cm.Data[commons.RepoRestorePinRestoredAtKey]
+	if restoredAt == "" || cm.Annotations[commons.RepoRestorePinHeldAnnotation] == restoredAt {
+		return
+	}
+
+	go func() {
+		if err := wait.PollImmediate(time.Second, restorePinHoldTimeout, func() (bool, error) {
+			return c.servicePusher.Idle() && c.ingressPusher.Idle(), nil
+		}); err != nil {
+			klog.Errorf("Pushes are still in flight %s after codebases are pinned: %s", restorePinHoldTimeout, err)
+			return
+		}
+
+		patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, commons.RepoRestorePinHeldAnnotation, restoredAt)
+		if _, err := c.k8sAPI.Client.CoreV1().
+			ConfigMaps(cm.Namespace).
+			Patch(context.TODO(), cm.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+			klog.Errorf("Failed to mark the restore pin %s/%s as held: %s", cm.Namespace, cm.Name, err)
+			return
+		}
+
+		klog.Infof("Pushes are held for the snapshot restored at %s", restoredAt)
+	}()
 }
@@ -33,13 +33,17 @@ type Controllers interface {
 }
 
 type LocalControllers struct {
-	Service        *controller.ServiceController
-	Endpoints      *controller.EndpointsController
-	Ingressv1      *controller.Ingressv1Controller
-	IngressClassv1 *controller.IngressClassv1Controller
-	ServiceImport  *controller.ServiceImportController
-	Secret         *controller.SecretController
-	GatewayApi     *GatewayApiControllers
+	Service             *controller.ServiceController
+	Endpoints           *controller.EndpointsController
+	EndpointSlice       *controller.EndpointSliceController
+	Ingressv1           *controller.Ingressv1Controller
+	IngressClassv1      *controller.IngressClassv1Controller
+	ServiceImport       *controller.ServiceImportController
+	GlobalTrafficPolicy *controller.GlobalTrafficPolicyController
+	ServiceExport       *controller.ServiceExportController
+	Secret              *controller.SecretController
+	RestorePin          *controller.ConfigMapController
+	GatewayApi          *GatewayApiControllers
 }
 
 var _ Controllers = &LocalControllers{}
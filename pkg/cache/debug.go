@@ -0,0 +1,245 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cache
+
+import (
+	"sync"
+)
+
+var (
+	activeLocalCache   *LocalCache
+	activeLocalCacheMu sync.RWMutex
+)
+
+// RoutesFilter filters the dumped routing state, empty fields match everything
+type RoutesFilter struct {
+	// Namespace of the services, endpoints, service imports and backends of ingress routes
+	Namespace string
+	// Host of ingress routes
+	Host string
+}
+
+// RoutesDump is the routing state computed by LocalCache
+type RoutesDump struct {
+	Services              map[string]ServiceDump    `json:"services"`
+	Endpoints             map[string][]EndpointDump `json:"endpoints"`
+	Ingresses             map[string]IngressDump    `json:"ingresses"`
+	ServiceImports        map[string]ServiceDump    `json:"serviceImports"`
+	MultiClusterEndpoints map[string][]EndpointDump `json:"multiClusterEndpoints"`
+	Versions              VersionsDump              `json:"versions"`
+}
+
+type ServiceDump struct {
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+type EndpointDump struct {
+	Address  string `json:"address"`
+	NodeName string `json:"nodeName,omitempty"`
+	Zone     string `json:"zone,omitempty"`
+	Cluster  string `json:"cluster,omitempty"`
+}
+
+// IngressDump is the view of an ingress route, certificates and keys are intentionally left out
+type IngressDump struct {
+	Host           string   `json:"host"`
+	Path           string   `json:"path"`
+	Backend        string   `json:"backend"`
+	Rewrite        []string `json:"rewrite,omitempty"`
+	SessionSticky  bool     `json:"sessionSticky"`
+	LBType         string   `json:"lbType,omitempty"`
+	IsTLS          bool     `json:"isTLS"`
+	IsWildcardHost bool     `json:"isWildcardHost"`
+	VerifyClient   bool     `json:"verifyClient"`
+	Protocol       string   `json:"protocol,omitempty"`
+}
+
+// VersionsDump contains the hashes of the latest desired routes and the ones pushed to repo successfully
+type VersionsDump struct {
+	ServiceRoutes       string `json:"serviceRoutes"`
+	IngressRoutes       string `json:"ingressRoutes"`
+	PushedServiceRoutes string `json:"pushedServiceRoutes"`
+	PushedIngressRoutes string `json:"pushedIngressRoutes"`
+	PushError           string `json:"pushError,omitempty"`
+	// Pinned is true while the codebases are pinned to a restored snapshot and routes are not pushed
+	Pinned bool `json:"pinned"`
+}
+
+func setActiveLocalCache(c *LocalCache) {
+	activeLocalCacheMu.Lock()
+	defer activeLocalCacheMu.Unlock()
+
+	activeLocalCache = c
+}
+
+func clearActiveLocalCache(c *LocalCache) {
+	activeLocalCacheMu.Lock()
+	defer activeLocalCacheMu.Unlock()
+
+	if activeLocalCache == c {
+		activeLocalCache = nil
+	}
+}
+
+// ActivePushError returns the last push error of the running LocalCache, running is false if there's no running one
+func ActivePushError() (running bool, err error) {
+	activeLocalCacheMu.RLock()
+	c := activeLocalCache
+	activeLocalCacheMu.RUnlock()
+
+	if c == nil {
+		return false, nil
+	}
+
+	return true, c.PushError()
+}
+
+// ActiveRoutesSynced returns true if the running LocalCache has pushed both service and ingress routes to the repo,
+// it returns false if there's no running one
+func ActiveRoutesSynced() bool {
+	activeLocalCacheMu.RLock()
+	c := activeLocalCache
+	activeLocalCacheMu.RUnlock()
+
+	if c == nil {
+		return false
+	}
+
+	return c.servicePusher.HasSynced() && c.ingressPusher.HasSynced()
+}
+
+// DumpActiveRoutes dumps the routing state of the running LocalCache, it returns false if there's no running one
+func DumpActiveRoutes(filter RoutesFilter) (*RoutesDump, bool) {
+	activeLocalCacheMu.RLock()
+	c := activeLocalCache
+	activeLocalCacheMu.RUnlock()
+
+	if c == nil {
+		return nil, false
+	}
+
+	return c.DumpRoutes(filter), true
+}
+
+// DumpRoutes returns a snapshot of the maps and versions of the cache
+func (c *LocalCache) DumpRoutes(filter RoutesFilter) *RoutesDump {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dump := &RoutesDump{
+		Services:              make(map[string]ServiceDump),
+		Endpoints:             make(map[string][]EndpointDump),
+		Ingresses:             make(map[string]IngressDump),
+		ServiceImports:        make(map[string]ServiceDump),
+		MultiClusterEndpoints: make(map[string][]EndpointDump),
+		Versions: VersionsDump{
+			ServiceRoutes:       c.serviceRoutesVersion,
+			IngressRoutes:       c.ingressRoutesVersion,
+			PushedServiceRoutes: c.servicePusher.PushedHash(),
+			PushedIngressRoutes: c.ingressPusher.PushedHash(),
+			Pinned:              c.pinned,
+		},
+	}
+	if err := c.PushError(); err != nil {
+		dump.Versions.PushError = err.Error()
+	}
+
+	for svcName, svc := range c.serviceMap {
+		if filter.matchNamespace(svcName) {
+			dump.Services[svcName.String()] = serviceDump(svc)
+		}
+	}
+
+	for svcName, eps := range c.endpointsMap {
+		if filter.matchNamespace(svcName) {
+			dump.Endpoints[svcName.String()] = endpointsDump(eps)
+		}
+	}
+
+	for svcName, svc := range c.serviceImportMap {
+		if filter.matchNamespace(svcName) {
+			dump.ServiceImports[svcName.String()] = serviceDump(svc)
+		}
+	}
+
+	for svcName, eps := range c.multiClusterEndpointsMap {
+		if filter.matchNamespace(svcName) {
+			dump.MultiClusterEndpoints[svcName.String()] = endpointsDump(eps)
+		}
+	}
+
+	for key, r := range c.ingressMap {
+		if !filter.matchNamespace(r.Backend()) || (filter.Host != "" && filter.Host != r.Host()) {
+			continue
+		}
+
+		dump.Ingresses[key.String()] = IngressDump{
+			Host:           r.Host(),
+			Path:           r.Path(),
+			Backend:        r.Backend().String(),
+			Rewrite:        r.Rewrite(),
+			SessionSticky:  r.SessionSticky(),
+			LBType:         string(r.LBType()),
+			IsTLS:          r.IsTLS(),
+			IsWildcardHost: r.IsWildcardHost(),
+			VerifyClient:   r.VerifyClient(),
+			Protocol:       r.Protocol(),
+		}
+	}
+
+	return dump
+}
+
+func (f RoutesFilter) matchNamespace(svcName ServicePortName) bool {
+	return f.Namespace == "" || f.Namespace == svcName.Namespace
+}
+
+func serviceDump(svc ServicePort) ServiceDump {
+	return ServiceDump{
+		Address:  svc.Address(),
+		Port:     svc.Port(),
+		Protocol: string(svc.Protocol()),
+	}
+}
+
+func endpointsDump(eps []Endpoint) []EndpointDump {
+	result := make([]EndpointDump, 0, len(eps))
+	for _, ep := range eps {
+		epDump := EndpointDump{
+			Address:  ep.String(),
+			NodeName: ep.NodeName(),
+			Cluster:  ep.ClusterInfo(),
+		}
+		if info, ok := ep.(*BaseEndpointInfo); ok {
+			epDump.Zone = info.ZoneName()
+		}
+
+		result = append(result, epDump)
+	}
+
+	return result
+}
@@ -27,13 +27,16 @@ package cache
 import (
 	"github.com/flomesh-io/fsm-classic/pkg/cache/controller"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/events"
 	"k8s.io/klog/v2"
 	utilcache "k8s.io/kubernetes/pkg/proxy/util"
 	utilnet "k8s.io/utils/net"
+	"k8s.io/utils/pointer"
 	"net"
 	"reflect"
+	"sort"
 	"strconv"
 	"sync"
 )
@@ -43,6 +46,13 @@ type BaseEndpointInfo struct {
 	Nodename string
 	Hostname string
 	Cluster  string
+	Zone     string
+
+	// Ready indicates whether this endpoint is ready to receive traffic,
+	// Serving and Terminating mirror the EndpointSlice conditions of the same name.
+	Ready       bool
+	Serving     bool
+	Terminating bool
 }
 
 var _ Endpoint = &BaseEndpointInfo{}
@@ -71,15 +81,34 @@ func (info *BaseEndpointInfo) ClusterInfo() string {
 	return info.Cluster
 }
 
+func (info *BaseEndpointInfo) ZoneName() string {
+	return info.Zone
+}
+
+func (info *BaseEndpointInfo) IsReady() bool {
+	return info.Ready
+}
+
+func (info *BaseEndpointInfo) IsServing() bool {
+	return info.Serving
+}
+
+func (info *BaseEndpointInfo) IsTerminating() bool {
+	return info.Terminating
+}
+
 func (info *BaseEndpointInfo) Equal(other Endpoint) bool {
 	return info.String() == other.String()
 }
 
-func newBaseEndpointInfo(IP string, port int, nodename string, hostname string) *BaseEndpointInfo {
+func newBaseEndpointInfo(IP string, port int, nodename string, hostname string, ready, serving, terminating bool) *BaseEndpointInfo {
 	return &BaseEndpointInfo{
-		Endpoint: net.JoinHostPort(IP, strconv.Itoa(port)),
-		Nodename: nodename,
-		Hostname: hostname,
+		Endpoint:    net.JoinHostPort(IP, strconv.Itoa(port)),
+		Nodename:    nodename,
+		Hostname:    hostname,
+		Ready:       ready,
+		Serving:     serving,
+		Terminating: terminating,
 	}
 }
 
@@ -91,6 +120,9 @@ type EndpointChangeTracker struct {
 	enrichEndpointInfo enrichEndpointFunc
 	recorder           events.EventRecorder
 	controllers        *controller.LocalControllers
+	// endpointSliceCache holds all EndpointSlices of a service, keyed by service and then by slice name,
+	// it's only used when the tracker is fed by EndpointSlices
+	endpointSliceCache map[types.NamespacedName]map[string]*discoveryv1.EndpointSlice
 }
 
 func NewEndpointChangeTracker(enrichEndpointInfo enrichEndpointFunc, recorder events.EventRecorder, controllers *controller.LocalControllers) *EndpointChangeTracker {
@@ -99,6 +131,7 @@ func NewEndpointChangeTracker(enrichEndpointInfo enrichEndpointFunc, recorder ev
 		enrichEndpointInfo: enrichEndpointInfo,
 		recorder:           recorder,
 		controllers:        controllers,
+		endpointSliceCache: make(map[types.NamespacedName]map[string]*discoveryv1.EndpointSlice),
 	}
 }
 
@@ -136,6 +169,60 @@ func (ect *EndpointChangeTracker) Update(previous, current *corev1.Endpoints) bo
 	return len(ect.items) > 0
 }
 
+// EndpointSliceUpdate updates the given service's endpoints change map based on the <previous, current> endpoint slices pair.
+// All slices of a service are merged before being converted, so the result is the same as if they were a single Endpoints.
+func (ect *EndpointChangeTracker) EndpointSliceUpdate(endpointSlice *discoveryv1.EndpointSlice, removeSlice bool) bool {
+	if endpointSlice == nil {
+		return false
+	}
+
+	serviceName, ok := endpointSlice.Labels[discoveryv1.LabelServiceName]
+	if !ok || serviceName == "" {
+		klog.V(5).Infof("EndpointSlice %s/%s is not owned by any service, ignoring", endpointSlice.Namespace, endpointSlice.Name)
+		return false
+	}
+
+	namespacedName := types.NamespacedName{Namespace: endpointSlice.Namespace, Name: serviceName}
+
+	ect.lock.Lock()
+	defer ect.lock.Unlock()
+
+	change, exists := ect.items[namespacedName]
+	if !exists {
+		change = &endpointsChange{}
+		change.previous = ect.endpointSlicesToEndpointsMap(namespacedName)
+		ect.items[namespacedName] = change
+	}
+
+	slices, exists := ect.endpointSliceCache[namespacedName]
+	if removeSlice {
+		if exists {
+			delete(slices, endpointSlice.Name)
+			if len(slices) == 0 {
+				delete(ect.endpointSliceCache, namespacedName)
+			}
+		}
+	} else {
+		if !exists {
+			slices = make(map[string]*discoveryv1.EndpointSlice)
+			ect.endpointSliceCache[namespacedName] = slices
+		}
+		slices[endpointSlice.Name] = endpointSlice
+	}
+
+	change.current = ect.endpointSlicesToEndpointsMap(namespacedName)
+
+	if reflect.DeepEqual(change.previous, change.current) {
+		delete(ect.items, namespacedName)
+	} else {
+		for spn, eps := range change.current {
+			klog.V(2).Infof("Service port %s updated: %d endpoints", spn, len(eps))
+		}
+	}
+
+	return len(ect.items) > 0
+}
+
 func (ect *EndpointChangeTracker) checkoutChanges() []*endpointsChange {
 	ect.lock.Lock()
 	defer ect.lock.Unlock()
@@ -164,7 +251,7 @@ func (ect *EndpointChangeTracker) endpointsToEndpointsMap(endpoints *corev1.Endp
 		return nil
 	}
 
-	endpointsMap := make(EndpointsMap)
+	builder := newEndpointsMapBuilder(ect.enrichEndpointInfo)
 	for i := range endpoints.Subsets {
 		ss := &endpoints.Subsets[i]
 		for i := range ss.Ports {
@@ -185,23 +272,175 @@ func (ect *EndpointChangeTracker) endpointsToEndpointsMap(endpoints *corev1.Endp
 					continue
 				}
 
-				// ONLY supports IPv4
-				if !utilnet.IsIPv4String(addr.IP) {
+				if !utilnet.IsIPv4String(addr.IP) && !utilnet.IsIPv6String(addr.IP) {
+					klog.Warningf("ignoring invalid endpoint IP %q of port %s", addr.IP, port.Name)
 					continue
 				}
 
 				klog.V(5).Infof("Address = %#v", addr)
 
-				baseEndpointInfo := newBaseEndpointInfo(addr.IP, int(port.Port), nodename(addr), addr.Hostname)
-				if ect.enrichEndpointInfo != nil {
-					endpointsMap[svcPortName] = append(endpointsMap[svcPortName], ect.enrichEndpointInfo(baseEndpointInfo))
-				} else {
-					endpointsMap[svcPortName] = append(endpointsMap[svcPortName], baseEndpointInfo)
+				// Endpoints has no terminating state, addresses in Addresses are always ready
+				builder.add(svcPortName, newBaseEndpointInfo(addr.IP, int(port.Port), nodename(addr), addr.Hostname, true, true, false))
+			}
+		}
+	}
+
+	return builder.build()
+}
+
+// endpointSlicesToEndpointsMap converts all cached EndpointSlices of the service to EndpointsMap,
+// it must be called with the lock held
+func (ect *EndpointChangeTracker) endpointSlicesToEndpointsMap(namespacedName types.NamespacedName) EndpointsMap {
+	slices, exists := ect.endpointSliceCache[namespacedName]
+	if !exists || len(slices) == 0 {
+		return nil
+	}
+
+	// a dual-stack service has slices of both families for the same pods, only the primary family is used,
+	// or every pod would be listed twice
+	addressType := ect.primaryAddressType(namespacedName, slices)
+
+	builder := newEndpointsMapBuilder(ect.enrichEndpointInfo)
+	for _, slice := range slices {
+		// Both IPv4 and IPv6 are supported, FQDN is not
+		if slice.AddressType != addressType {
+			continue
+		}
+
+		for i := range slice.Ports {
+			port := &slice.Ports[i]
+			if port.Port == nil || *port.Port == 0 {
+				klog.Warningf("ignoring invalid endpoint port %s of EndpointSlice %s/%s", pointer.StringDeref(port.Name, ""), slice.Namespace, slice.Name)
+				continue
+			}
+			protocol := corev1.ProtocolTCP
+			if port.Protocol != nil {
+				protocol = *port.Protocol
+			}
+			svcPortName := ServicePortName{
+				NamespacedName: namespacedName,
+				Port:           pointer.StringDeref(port.Name, ""),
+				Protocol:       protocol,
+			}
+
+			for i := range slice.Endpoints {
+				ep := &slice.Endpoints[i]
+				if len(ep.Addresses) == 0 {
+					klog.Warningf("ignoring invalid endpoint port %s with empty host", svcPortName.Port)
+					continue
 				}
+
+				// As per the EndpointSlice API, a nil ready/serving condition should be interpreted as "true",
+				// and a nil terminating condition as "false"
+				ready := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+				serving := ready
+				if ep.Conditions.Serving != nil {
+					serving = *ep.Conditions.Serving
+				}
+				terminating := ep.Conditions.Terminating != nil && *ep.Conditions.Terminating
+
+				// Only the first address is used, the others are considered as duplicates by kubernetes
+				info := newBaseEndpointInfo(
+					ep.Addresses[0],
+					int(*port.Port),
+					pointer.StringDeref(ep.NodeName, ""),
+					pointer.StringDeref(ep.Hostname, ""),
+					ready,
+					serving,
+					terminating,
+				)
+				info.Zone = pointer.StringDeref(ep.Zone, "")
+				builder.add(svcPortName, info)
 			}
+		}
+	}
+
+	return builder.build()
+}
+
+// primaryAddressType returns the address type of the primary IP family of the service, IPv4 is preferred if the
+// service is not found in cache yet
+func (ect *EndpointChangeTracker) primaryAddressType(namespacedName types.NamespacedName, slices map[string]*discoveryv1.EndpointSlice) discoveryv1.AddressType {
+	if ect.controllers != nil && ect.controllers.Service != nil {
+		svc, err := ect.controllers.Service.Lister.Services(namespacedName.Namespace).Get(namespacedName.Name)
+		if err == nil && len(svc.Spec.IPFamilies) > 0 {
+			return discoveryv1.AddressType(svc.Spec.IPFamilies[0])
+		}
+	}
+
+	for _, slice := range slices {
+		if slice.AddressType == discoveryv1.AddressTypeIPv4 {
+			return discoveryv1.AddressTypeIPv4
+		}
+	}
+
+	return discoveryv1.AddressTypeIPv6
+}
+
+// endpointsMapBuilder collects endpoints of each service port and picks the usable ones:
+// ready endpoints are preferred, if there's no ready endpoint at all, falls back to the ones
+// which are still serving while terminating, so that in-flight traffic can be drained gracefully.
+type endpointsMapBuilder struct {
+	enrichEndpointInfo enrichEndpointFunc
+	ready              map[ServicePortName][]*BaseEndpointInfo
+	terminating        map[ServicePortName][]*BaseEndpointInfo
+	seen               map[ServicePortName]map[string]bool
+}
+
+func newEndpointsMapBuilder(enrichEndpointInfo enrichEndpointFunc) *endpointsMapBuilder {
+	return &endpointsMapBuilder{
+		enrichEndpointInfo: enrichEndpointInfo,
+		ready:              make(map[ServicePortName][]*BaseEndpointInfo),
+		terminating:        make(map[ServicePortName][]*BaseEndpointInfo),
+		seen:               make(map[ServicePortName]map[string]bool),
+	}
+}
+
+func (b *endpointsMapBuilder) add(svcPortName ServicePortName, info *BaseEndpointInfo) {
+	if _, ok := b.seen[svcPortName]; !ok {
+		b.seen[svcPortName] = make(map[string]bool)
+	}
+
+	// The same endpoint may appear in multiple slices during slice re-balancing
+	if b.seen[svcPortName][info.String()] {
+		return
+	}
 
-			klog.V(3).Infof("Setting endpoints for %q to %#v", svcPortName, formatEndpointsList(endpointsMap[svcPortName]))
+	switch {
+	case info.Ready:
+		b.ready[svcPortName] = append(b.ready[svcPortName], info)
+	case info.Serving && info.Terminating:
+		b.terminating[svcPortName] = append(b.terminating[svcPortName], info)
+	default:
+		return
+	}
+
+	b.seen[svcPortName][info.String()] = true
+}
+
+func (b *endpointsMapBuilder) build() EndpointsMap {
+	endpointsMap := make(EndpointsMap)
+
+	for svcPortName := range b.seen {
+		candidates := b.ready[svcPortName]
+		if len(candidates) == 0 {
+			candidates = b.terminating[svcPortName]
+		}
+
+		// Sort them to make the result stable, as the order of slices is not deterministic
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].String() < candidates[j].String()
+		})
+
+		for _, info := range candidates {
+			if b.enrichEndpointInfo != nil {
+				endpointsMap[svcPortName] = append(endpointsMap[svcPortName], b.enrichEndpointInfo(info))
+			} else {
+				endpointsMap[svcPortName] = append(endpointsMap[svcPortName], info)
+			}
 		}
+
+		klog.V(3).Infof("Setting endpoints for %q to %#v", svcPortName, formatEndpointsList(endpointsMap[svcPortName]))
 	}
 
 	return endpointsMap
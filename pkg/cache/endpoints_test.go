@@ -0,0 +1,99 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cache
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+)
+
+func TestEndpointSlicesOfOneFamily(t *testing.T) {
+	svcName := types.NamespacedName{Namespace: "default", Name: "httpbin"}
+	slice := func(name string, addressType discoveryv1.AddressType, addresses ...string) *discoveryv1.EndpointSlice {
+		s := &discoveryv1.EndpointSlice{
+			ObjectMeta:  metav1.ObjectMeta{Namespace: svcName.Namespace, Name: name},
+			AddressType: addressType,
+			Ports:       []discoveryv1.EndpointPort{{Name: pointer.String("http"), Port: pointer.Int32(8080)}},
+		}
+		for _, addr := range addresses {
+			s.Endpoints = append(s.Endpoints, discoveryv1.Endpoint{Addresses: []string{addr}})
+		}
+		return s
+	}
+
+	testCases := []struct {
+		name     string
+		slices   []*discoveryv1.EndpointSlice
+		expected []string
+	}{
+		{
+			name:     "IPv4 single-stack",
+			slices:   []*discoveryv1.EndpointSlice{slice("v4", discoveryv1.AddressTypeIPv4, "10.0.0.1", "10.0.0.2")},
+			expected: []string{"10.0.0.1:8080", "10.0.0.2:8080"},
+		},
+		{
+			name:     "IPv6 single-stack",
+			slices:   []*discoveryv1.EndpointSlice{slice("v6", discoveryv1.AddressTypeIPv6, "fd00::1")},
+			expected: []string{"[fd00::1]:8080"},
+		},
+		{
+			name: "dual-stack lists each pod once",
+			slices: []*discoveryv1.EndpointSlice{
+				slice("v6", discoveryv1.AddressTypeIPv6, "fd00::1", "fd00::2"),
+				slice("v4", discoveryv1.AddressTypeIPv4, "10.0.0.1", "10.0.0.2"),
+			},
+			expected: []string{"10.0.0.1:8080", "10.0.0.2:8080"},
+		},
+		{
+			name:   "FQDN is not supported",
+			slices: []*discoveryv1.EndpointSlice{slice("fqdn", discoveryv1.AddressTypeFQDN, "httpbin.local")},
+		},
+	}
+
+	for _, tc := range testCases {
+		ect := NewEndpointChangeTracker(nil, nil, nil)
+		ect.endpointSliceCache[svcName] = make(map[string]*discoveryv1.EndpointSlice)
+		for _, s := range tc.slices {
+			ect.endpointSliceCache[svcName][s.Name] = s
+		}
+
+		var got []string
+		for _, ep := range ect.endpointSlicesToEndpointsMap(svcName)[ServicePortName{NamespacedName: svcName, Port: "http", Protocol: corev1.ProtocolTCP}] {
+			got = append(got, ep.String())
+		}
+		sort.Strings(got)
+
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cache
+
+import (
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/klog/v2"
+)
+
+func (c *LocalCache) OnEndpointSliceAdd(endpointSlice *discoveryv1.EndpointSlice) {
+	if c.endpointsChanges.EndpointSliceUpdate(endpointSlice, false) && c.isInitialized() {
+		klog.V(5).Infof("Detects endpoint slice change, syncing...")
+		c.Sync()
+	}
+}
+
+func (c *LocalCache) OnEndpointSliceUpdate(_, newEndpointSlice *discoveryv1.EndpointSlice) {
+	if c.endpointsChanges.EndpointSliceUpdate(newEndpointSlice, false) && c.isInitialized() {
+		klog.V(5).Infof("Detects endpoint slice change, syncing...")
+		c.Sync()
+	}
+}
+
+func (c *LocalCache) OnEndpointSliceDelete(endpointSlice *discoveryv1.EndpointSlice) {
+	if c.endpointsChanges.EndpointSliceUpdate(endpointSlice, true) && c.isInitialized() {
+		klog.V(5).Infof("Detects endpoint slice change, syncing...")
+		c.Sync()
+	}
+}
+
+func (c *LocalCache) OnEndpointSlicesSynced() {
+	c.OnEndpointsSynced()
+}
@@ -0,0 +1,246 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cache
+
+import (
+	"context"
+	gtpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/globaltrafficpolicy/v1alpha1"
+	routepkg "github.com/flomesh-io/fsm-classic/pkg/route"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sort"
+)
+
+const (
+	// defaultClusterWeight is the weight of a cluster which has no weight specified in an ActiveActive policy,
+	// weights are relative to each other and a cluster of weight 0 is excluded
+	defaultClusterWeight = 100
+	// maxWeightedAddresses limits the number of addresses of a service in registry.json, which are repeated by
+	// weights of the targets
+	maxWeightedAddresses = 100
+)
+
+// globalTrafficPolicyStatuses are the effective policies calculated in a sync, keyed by the policy
+type globalTrafficPolicyStatuses map[types.NamespacedName]*gtpv1alpha1.GlobalTrafficPolicyStatus
+
+// globalTrafficPolicyOf returns the GlobalTrafficPolicy applied to the ServiceImport of svcName,
+// the policy has the same namespace and name as the ServiceImport
+func (c *LocalCache) globalTrafficPolicyOf(svcName ServicePortName) *gtpv1alpha1.GlobalTrafficPolicy {
+	if c.controllers.GlobalTrafficPolicy == nil {
+		return nil
+	}
+
+	policy, err := c.controllers.GlobalTrafficPolicy.Lister.
+		GlobalTrafficPolicies(svcName.Namespace).
+		Get(svcName.Name)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("Failed to get GlobalTrafficPolicy %s: %s", svcName.NamespacedName, err)
+		}
+		return nil
+	}
+
+	return policy
+}
+
+// applyGlobalTrafficPolicy selects the endpoints of a service among local and remote clusters according to the policy,
+// it returns the route targets and the clusters receiving traffic with their effective weights
+func (c *LocalCache) applyGlobalTrafficPolicy(policy *gtpv1alpha1.GlobalTrafficPolicy, local []Endpoint, remote []Endpoint) ([]routepkg.Target, []gtpv1alpha1.TrafficTarget) {
+	localKey := c.connectorConfig.Key()
+	clusters := map[string][]Endpoint{localKey: local}
+	for _, ep := range remote {
+		clusters[ep.ClusterInfo()] = append(clusters[ep.ClusterInfo()], ep)
+	}
+
+	var selected []string
+	weighted := false
+
+	switch policy.Spec.LbType {
+	case gtpv1alpha1.LocalityLbType:
+		// sticky to exact one cluster, it's the local cluster if no target is specified
+		key := localKey
+		if len(policy.Spec.Targets) > 0 {
+			key = policy.Spec.Targets[0].ClusterKey
+		}
+		selected = []string{key}
+	case gtpv1alpha1.FailOverLbType:
+		// the remote clusters take over the traffic only if there's no local endpoint
+		if len(local) > 0 {
+			selected = []string{localKey}
+		} else {
+			for _, t := range policy.Spec.Targets {
+				selected = append(selected, t.ClusterKey)
+			}
+		}
+	case gtpv1alpha1.ActiveActiveLbType:
+		// spread across local cluster and the targets, or all clusters exporting the service if no target is specified
+		weighted = true
+		selected = []string{localKey}
+		if len(policy.Spec.Targets) > 0 {
+			for _, t := range policy.Spec.Targets {
+				if t.ClusterKey != localKey {
+					selected = append(selected, t.ClusterKey)
+				}
+			}
+		} else {
+			for key := range clusters {
+				if key != localKey {
+					selected = append(selected, key)
+				}
+			}
+			sort.Strings(selected[1:])
+		}
+	default:
+		klog.Warningf("Unsupported LbType %q of GlobalTrafficPolicy %s/%s", policy.Spec.LbType, policy.Namespace, policy.Name)
+		return nil, nil
+	}
+
+	targets := make([]routepkg.Target, 0)
+	effective := make([]gtpv1alpha1.TrafficTarget, 0)
+	for _, key := range selected {
+		endpoints := clusters[key]
+		if len(endpoints) == 0 {
+			continue
+		}
+
+		tt := gtpv1alpha1.TrafficTarget{ClusterKey: key}
+		epWeight := 0
+		if weighted {
+			weight := clusterWeight(policy, key)
+			if weight <= 0 {
+				continue
+			}
+			tt.Weight = &weight
+			// the weight is of the cluster, it's shared by the endpoints of the cluster
+			epWeight = weight * 100 / len(endpoints)
+			if epWeight == 0 {
+				epWeight = 1
+			}
+		}
+		effective = append(effective, tt)
+
+		for _, ep := range endpoints {
+			targets = append(targets, routepkg.Target{
+				Address: ep.String(),
+				Tags:    endpointTags(ep, key == localKey),
+				Weight:  epWeight,
+			})
+		}
+	}
+
+	return targets, effective
+}
+
+func clusterWeight(policy *gtpv1alpha1.GlobalTrafficPolicy, key string) int {
+	for _, t := range policy.Spec.Targets {
+		if t.ClusterKey == key && t.Weight != nil {
+			return *t.Weight
+		}
+	}
+
+	return defaultClusterWeight
+}
+
+func endpointTags(ep Endpoint, local bool) map[string]string {
+	if local {
+		return map[string]string{
+			"Node": ep.NodeName(),
+			"Host": ep.HostName(),
+		}
+	}
+
+	return map[string]string{
+		"Cluster": ep.ClusterInfo(),
+	}
+}
+
+// add merges the effective targets of a service port into the status of the policy
+func (s globalTrafficPolicyStatuses) add(policy *gtpv1alpha1.GlobalTrafficPolicy, effective []gtpv1alpha1.TrafficTarget) {
+	key := types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}
+	status, ok := s[key]
+	if !ok {
+		status = &gtpv1alpha1.GlobalTrafficPolicyStatus{
+			ObservedGeneration: policy.Generation,
+			LbType:             policy.Spec.LbType,
+		}
+		s[key] = status
+	}
+
+	for _, t := range effective {
+		found := false
+		for _, existing := range status.Targets {
+			if existing.ClusterKey == t.ClusterKey {
+				found = true
+				break
+			}
+		}
+		if !found {
+			status.Targets = append(status.Targets, t)
+		}
+	}
+}
+
+// updateGlobalTrafficPolicyStatus reports the effective policies, the status of a policy which is not applied to
+// any ServiceImport only has the LbType
+func (c *LocalCache) updateGlobalTrafficPolicyStatus(statuses globalTrafficPolicyStatuses) {
+	if c.controllers.GlobalTrafficPolicy == nil {
+		return
+	}
+
+	policies, err := c.controllers.GlobalTrafficPolicy.Lister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list GlobalTrafficPolicies: %s", err)
+		return
+	}
+
+	for _, policy := range policies {
+		status, ok := statuses[types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}]
+		if !ok {
+			status = &gtpv1alpha1.GlobalTrafficPolicyStatus{
+				ObservedGeneration: policy.Generation,
+				LbType:             policy.Spec.LbType,
+			}
+		}
+		sort.Slice(status.Targets, func(i, j int) bool {
+			return status.Targets[i].ClusterKey < status.Targets[j].ClusterKey
+		})
+
+		if equality.Semantic.DeepEqual(policy.Status, *status) {
+			continue
+		}
+
+		p := policy.DeepCopy()
+		p.Status = *status
+		if _, err := c.k8sAPI.FlomeshClient.GlobaltrafficpolicyV1alpha1().
+			GlobalTrafficPolicies(p.Namespace).
+			UpdateStatus(context.TODO(), p, metav1.UpdateOptions{}); err != nil {
+			klog.Errorf("Failed to update status of GlobalTrafficPolicy %s/%s: %s", p.Namespace, p.Name, err)
+		}
+	}
+}
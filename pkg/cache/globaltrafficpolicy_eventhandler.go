@@ -0,0 +1,57 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cache
+
+import (
+	"github.com/flomesh-io/fsm-classic/apis/globaltrafficpolicy/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/klog/v2"
+)
+
+func (c *LocalCache) OnGlobalTrafficPolicyAdd(policy *v1alpha1.GlobalTrafficPolicy) {
+	c.OnGlobalTrafficPolicyUpdate(nil, policy)
+}
+
+func (c *LocalCache) OnGlobalTrafficPolicyUpdate(oldPolicy, policy *v1alpha1.GlobalTrafficPolicy) {
+	// status updates made by the cache itself don't change the routes
+	if oldPolicy != nil && policy != nil && equality.Semantic.DeepEqual(oldPolicy.Spec, policy.Spec) {
+		return
+	}
+
+	if c.isInitialized() {
+		klog.V(5).Infof("Detects GlobalTrafficPolicy change, syncing...")
+		c.Sync()
+	}
+}
+
+func (c *LocalCache) OnGlobalTrafficPolicyDelete(policy *v1alpha1.GlobalTrafficPolicy) {
+	c.OnGlobalTrafficPolicyUpdate(policy, nil)
+}
+
+func (c *LocalCache) OnGlobalTrafficPolicySynced() {
+	if c.isInitialized() {
+		c.Sync()
+	}
+}
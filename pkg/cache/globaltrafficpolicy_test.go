@@ -0,0 +1,216 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cache
+
+import (
+	"reflect"
+	"testing"
+
+	gtpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/globaltrafficpolicy/v1alpha1"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	routepkg "github.com/flomesh-io/fsm-classic/pkg/route"
+)
+
+func TestAddresses(t *testing.T) {
+	testCases := []struct {
+		name     string
+		targets  []routepkg.Target
+		expected []string
+	}{
+		{
+			name:     "unweighted targets are listed once",
+			targets:  []routepkg.Target{{Address: "a"}, {Address: "b"}},
+			expected: []string{"a", "b"},
+		},
+		{
+			name:     "weights are reduced by the greatest common divisor",
+			targets:  []routepkg.Target{{Address: "a", Weight: 300}, {Address: "b", Weight: 100}},
+			expected: []string{"a", "b", "a", "a"},
+		},
+		{
+			name:     "equal weights are listed once",
+			targets:  []routepkg.Target{{Address: "a", Weight: 5000}, {Address: "b", Weight: 5000}},
+			expected: []string{"a", "b"},
+		},
+	}
+
+	for _, tc := range testCases {
+		got := addresses(routepkg.ServiceRouteEntry{Targets: tc.targets})
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestTargetRepeatsAreCapped(t *testing.T) {
+	// 3 local endpoints share the weight 100 of the local cluster, 1 remote endpoint has the weight 100 of its cluster
+	targets := []routepkg.Target{
+		{Address: "l1", Weight: 3333},
+		{Address: "l2", Weight: 3333},
+		{Address: "l3", Weight: 3333},
+		{Address: "r1", Weight: 10000},
+	}
+
+	repeats := targetRepeats(targets)
+	total := 0
+	for _, r := range repeats {
+		total += r
+	}
+	if total > maxWeightedAddresses+len(targets) {
+		t.Errorf("expected about %d addresses at most, got %d", maxWeightedAddresses, total)
+	}
+
+	local := repeats[0] + repeats[1] + repeats[2]
+	if diff := local - repeats[3]; diff < -2 || diff > 2 {
+		t.Errorf("expected the local and remote clusters to receive the same share, got %v", repeats)
+	}
+}
+
+func TestApplyGlobalTrafficPolicy(t *testing.T) {
+	connectorConfig, err := config.NewConnectorConfig("default", "default", "default", "local", "", 0, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &LocalCache{connectorConfig: connectorConfig}
+	localKey := connectorConfig.Key()
+
+	local := []Endpoint{&BaseEndpointInfo{Endpoint: "10.0.0.1:80"}}
+	remote := []Endpoint{
+		&BaseEndpointInfo{Endpoint: "192.168.0.1:80", Cluster: "c1"},
+		&BaseEndpointInfo{Endpoint: "192.168.0.2:80", Cluster: "c2"},
+	}
+	weight := func(w int) *int { return &w }
+
+	testCases := []struct {
+		name      string
+		spec      gtpv1alpha1.GlobalTrafficPolicySpec
+		local     []Endpoint
+		addresses []string
+		effective []gtpv1alpha1.TrafficTarget
+	}{
+		{
+			name:      "Locality sticks to the local cluster without targets",
+			spec:      gtpv1alpha1.GlobalTrafficPolicySpec{LbType: gtpv1alpha1.LocalityLbType},
+			local:     local,
+			addresses: []string{"10.0.0.1:80"},
+			effective: []gtpv1alpha1.TrafficTarget{{ClusterKey: localKey}},
+		},
+		{
+			name: "Locality sticks to the first target",
+			spec: gtpv1alpha1.GlobalTrafficPolicySpec{
+				LbType:  gtpv1alpha1.LocalityLbType,
+				Targets: []gtpv1alpha1.TrafficTarget{{ClusterKey: "c2"}, {ClusterKey: "c1"}},
+			},
+			local:     local,
+			addresses: []string{"192.168.0.2:80"},
+			effective: []gtpv1alpha1.TrafficTarget{{ClusterKey: "c2"}},
+		},
+		{
+			name: "FailOver keeps the traffic in the local cluster if it has endpoints",
+			spec: gtpv1alpha1.GlobalTrafficPolicySpec{
+				LbType:  gtpv1alpha1.FailOverLbType,
+				Targets: []gtpv1alpha1.TrafficTarget{{ClusterKey: "c1"}},
+			},
+			local:     local,
+			addresses: []string{"10.0.0.1:80"},
+			effective: []gtpv1alpha1.TrafficTarget{{ClusterKey: localKey}},
+		},
+		{
+			name: "FailOver moves the traffic to the targets without local endpoints",
+			spec: gtpv1alpha1.GlobalTrafficPolicySpec{
+				LbType:  gtpv1alpha1.FailOverLbType,
+				Targets: []gtpv1alpha1.TrafficTarget{{ClusterKey: "c1"}, {ClusterKey: "c3"}},
+			},
+			addresses: []string{"192.168.0.1:80"},
+			effective: []gtpv1alpha1.TrafficTarget{{ClusterKey: "c1"}},
+		},
+		{
+			name:      "ActiveActive spreads across all clusters without targets",
+			spec:      gtpv1alpha1.GlobalTrafficPolicySpec{LbType: gtpv1alpha1.ActiveActiveLbType},
+			local:     local,
+			addresses: []string{"10.0.0.1:80", "192.168.0.1:80", "192.168.0.2:80"},
+			effective: []gtpv1alpha1.TrafficTarget{
+				{ClusterKey: localKey, Weight: weight(defaultClusterWeight)},
+				{ClusterKey: "c1", Weight: weight(defaultClusterWeight)},
+				{ClusterKey: "c2", Weight: weight(defaultClusterWeight)},
+			},
+		},
+		{
+			name: "ActiveActive spreads across the local cluster and the targets with their weights",
+			spec: gtpv1alpha1.GlobalTrafficPolicySpec{
+				LbType:  gtpv1alpha1.ActiveActiveLbType,
+				Targets: []gtpv1alpha1.TrafficTarget{{ClusterKey: "c2", Weight: weight(30)}},
+			},
+			local:     local,
+			addresses: []string{"10.0.0.1:80", "192.168.0.2:80"},
+			effective: []gtpv1alpha1.TrafficTarget{
+				{ClusterKey: localKey, Weight: weight(defaultClusterWeight)},
+				{ClusterKey: "c2", Weight: weight(30)},
+			},
+		},
+		{
+			name: "ActiveActive excludes the clusters of weight 0",
+			spec: gtpv1alpha1.GlobalTrafficPolicySpec{
+				LbType: gtpv1alpha1.ActiveActiveLbType,
+				Targets: []gtpv1alpha1.TrafficTarget{
+					{ClusterKey: localKey, Weight: weight(0)},
+					{ClusterKey: "c1", Weight: weight(0)},
+					{ClusterKey: "c2"},
+				},
+			},
+			local:     local,
+			addresses: []string{"192.168.0.2:80"},
+			effective: []gtpv1alpha1.TrafficTarget{
+				{ClusterKey: "c2", Weight: weight(defaultClusterWeight)},
+			},
+		},
+		{
+			name:      "unsupported LbType selects nothing",
+			spec:      gtpv1alpha1.GlobalTrafficPolicySpec{LbType: "RoundRobin"},
+			local:     local,
+			addresses: nil,
+			effective: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		policy := &gtpv1alpha1.GlobalTrafficPolicy{Spec: tc.spec}
+		targets, effective := c.applyGlobalTrafficPolicy(policy, tc.local, remote)
+
+		var addresses []string
+		for _, target := range targets {
+			addresses = append(addresses, target.Address)
+		}
+		if !reflect.DeepEqual(addresses, tc.addresses) {
+			t.Errorf("%s: expected addresses %v, got %v", tc.name, tc.addresses, addresses)
+		}
+		if len(effective) == 0 && len(tc.effective) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(effective, tc.effective) {
+			t.Errorf("%s: expected effective targets %v, got %v", tc.name, tc.effective, effective)
+		}
+	}
+}
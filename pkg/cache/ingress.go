@@ -46,6 +46,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type BaseIngressInfo struct {
@@ -170,6 +171,8 @@ func (irk *RouteKey) String() string {
 type ingressChange struct {
 	previous IngressMap
 	current  IngressMap
+	// since is the time when the change is tracked first
+	since time.Time
 }
 
 type IngressChangeTracker struct {
@@ -254,7 +257,7 @@ func (ict *IngressChangeTracker) Update(previous, current *networkingv1.Ingress)
 
 	change, exists := ict.items[namespacedName]
 	if !exists {
-		change = &ingressChange{}
+		change = &ingressChange{since: time.Now()}
 		change.previous = ict.ingressToIngressMap(previous)
 		ict.items[namespacedName] = change
 	}
@@ -388,6 +391,21 @@ func (ict *IngressChangeTracker) findService(namespace string, service *networki
 	return svc.(*corev1.Service), nil
 }
 
+// Pending returns the number of pending changes and the time when the oldest one is tracked
+func (ict *IngressChangeTracker) Pending() (int, time.Time) {
+	ict.lock.Lock()
+	defer ict.lock.Unlock()
+
+	oldest := time.Time{}
+	for _, change := range ict.items {
+		if oldest.IsZero() || change.since.Before(oldest) {
+			oldest = change.since
+		}
+	}
+
+	return len(ict.items), oldest
+}
+
 func (ict *IngressChangeTracker) checkoutChanges() []*ingressChange {
 	ict.lock.Lock()
 	defer ict.lock.Unlock()
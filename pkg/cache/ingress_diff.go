@@ -0,0 +1,84 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cache
+
+import (
+	"fmt"
+	routepkg "github.com/flomesh-io/fsm-classic/pkg/route"
+	"reflect"
+	"sort"
+)
+
+// ingressRoutesDiff is the difference of ingress routes between two IngressData, routes are identified by host+path
+type ingressRoutesDiff struct {
+	Added    []string
+	Removed  []string
+	Modified []string
+}
+
+func diffIngressData(old, new routepkg.IngressData) ingressRoutesDiff {
+	oldRoutes := ingressRoutesByKey(old)
+	newRoutes := ingressRoutesByKey(new)
+
+	diff := ingressRoutesDiff{}
+	for key, r := range newRoutes {
+		oldRoute, ok := oldRoutes[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, key)
+		case !reflect.DeepEqual(oldRoute, r):
+			diff.Modified = append(diff.Modified, key)
+		}
+	}
+
+	for key := range oldRoutes {
+		if _, ok := newRoutes[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Modified)
+
+	return diff
+}
+
+func ingressRoutesByKey(data routepkg.IngressData) map[string]routepkg.IngressRouteSpec {
+	routes := make(map[string]routepkg.IngressRouteSpec)
+	for _, r := range data.Routes {
+		routes[routerKey(r)] = r
+	}
+
+	return routes
+}
+
+func (d ingressRoutesDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+func (d ingressRoutesDiff) String() string {
+	return fmt.Sprintf("%d added, %d removed, %d modified", len(d.Added), len(d.Removed), len(d.Modified))
+}
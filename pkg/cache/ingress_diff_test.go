@@ -0,0 +1,86 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cache
+
+import (
+	"reflect"
+	"testing"
+
+	routepkg "github.com/flomesh-io/fsm-classic/pkg/route"
+)
+
+func TestDiffIngressData(t *testing.T) {
+	route := func(host, path string, sticky bool) routepkg.IngressRouteSpec {
+		return routepkg.IngressRouteSpec{
+			RouterSpec:   routepkg.RouterSpec{Host: host, Path: path},
+			BalancerSpec: routepkg.BalancerSpec{Sticky: sticky},
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		old      []routepkg.IngressRouteSpec
+		new      []routepkg.IngressRouteSpec
+		expected ingressRoutesDiff
+	}{
+		{
+			name:     "no change",
+			old:      []routepkg.IngressRouteSpec{route("a.com", "/", false)},
+			new:      []routepkg.IngressRouteSpec{route("a.com", "/", false)},
+			expected: ingressRoutesDiff{},
+		},
+		{
+			name:     "routes are added to empty data",
+			new:      []routepkg.IngressRouteSpec{route("b.com", "/", false), route("a.com", "/api", false)},
+			expected: ingressRoutesDiff{Added: []string{"a.com/api", "b.com/"}},
+		},
+		{
+			name:     "routes are removed",
+			old:      []routepkg.IngressRouteSpec{route("a.com", "/", false), route("b.com", "/", false)},
+			new:      []routepkg.IngressRouteSpec{route("a.com", "/", false)},
+			expected: ingressRoutesDiff{Removed: []string{"b.com/"}},
+		},
+		{
+			name: "routes of same host and path are modified",
+			old:  []routepkg.IngressRouteSpec{route("a.com", "/", false), route("c.com", "/", false)},
+			new:  []routepkg.IngressRouteSpec{route("a.com", "/", true), route("b.com", "/", false)},
+			expected: ingressRoutesDiff{
+				Added:    []string{"b.com/"},
+				Removed:  []string{"c.com/"},
+				Modified: []string{"a.com/"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		diff := diffIngressData(routepkg.IngressData{Routes: tc.old}, routepkg.IngressData{Routes: tc.new})
+		if !reflect.DeepEqual(diff, tc.expected) {
+			t.Errorf("%s: expected %#v, got %#v", tc.name, tc.expected, diff)
+		}
+		if diff.IsEmpty() != tc.expected.IsEmpty() {
+			t.Errorf("%s: expected IsEmpty %t, got %t", tc.name, tc.expected.IsEmpty(), diff.IsEmpty())
+		}
+	}
+}
@@ -31,22 +31,32 @@ import (
 	"github.com/flomesh-io/fsm-classic/pkg/cache/controller"
 	"github.com/flomesh-io/fsm-classic/pkg/certificate"
 	conn "github.com/flomesh-io/fsm-classic/pkg/cluster/context"
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
 	"github.com/flomesh-io/fsm-classic/pkg/config"
 	cachectrl "github.com/flomesh-io/fsm-classic/pkg/controller"
 	"github.com/flomesh-io/fsm-classic/pkg/event"
 	fsminformers "github.com/flomesh-io/fsm-classic/pkg/generated/informers/externalversions"
 	ingresspipy "github.com/flomesh-io/fsm-classic/pkg/ingress"
 	"github.com/flomesh-io/fsm-classic/pkg/kube"
+	"github.com/flomesh-io/fsm-classic/pkg/metrics"
 	"github.com/flomesh-io/fsm-classic/pkg/repo"
 	routepkg "github.com/flomesh-io/fsm-classic/pkg/route"
+	"github.com/flomesh-io/fsm-classic/pkg/tracing"
 	"github.com/flomesh-io/fsm-classic/pkg/util"
+	"github.com/flomesh-io/fsm-classic/pkg/version"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/events"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/util/async"
+	"math"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -82,13 +92,22 @@ type LocalCache struct {
 	initialized          int32
 
 	syncRunner *async.BoundedFrequencyRunner
+	runnerMu   sync.RWMutex
 	repoClient *repo.PipyRepoClient
 
 	controllers *controller.LocalControllers
 	broadcaster events.EventBroadcaster
 
+	// hashes of the latest desired routes which have been handed over to the pushers
 	ingressRoutesVersion string
 	serviceRoutesVersion string
+	// the latest desired ingress routes, it's the base for diffing the ingress routes of next sync
+	ingressData routepkg.IngressData
+
+	servicePusher *codebasePusher
+	ingressPusher *codebasePusher
+	// pinned is true while the codebases are pinned to a restored snapshot, routes are not pushed until it's resumed
+	pinned bool
 }
 
 func newLocalCache(ctx context.Context, api *kube.K8sAPI, clusterCfg *config.Store, broker *event.Broker, certMgr certificate.Manager, resyncPeriod time.Duration) *LocalCache {
@@ -112,6 +131,8 @@ func newLocalCache(ctx context.Context, api *kube.K8sAPI, clusterCfg *config.Sto
 		broker:                   broker,
 		certMgr:                  certMgr,
 	}
+	c.servicePusher = newCodebasePusher(metrics.RouteTypeService, c.repoClient, c.onPushResult(metrics.RouteTypeService), c.repoPinned)
+	c.ingressPusher = newCodebasePusher(metrics.RouteTypeIngress, c.repoClient, c.onPushResult(metrics.RouteTypeIngress), c.repoPinned)
 
 	informerFactory := informers.NewSharedInformerFactoryWithOptions(api.Client, resyncPeriod)
 	serviceController := cachectrl.NewServiceControllerWithEventHandler(
@@ -119,11 +140,24 @@ func newLocalCache(ctx context.Context, api *kube.K8sAPI, clusterCfg *config.Sto
 		resyncPeriod,
 		c,
 	)
-	endpointsController := cachectrl.NewEndpointsControllerWithEventHandler(
-		informerFactory.Core().V1().Endpoints(),
-		resyncPeriod,
-		c,
-	)
+	// Prefer EndpointSlices, falls back to Endpoints if the cluster doesn't support it
+	var endpointsController *cachectrl.EndpointsController
+	var endpointSliceController *cachectrl.EndpointSliceController
+	if version.IsEndpointSliceEnabled(api) {
+		klog.V(3).Infof("EndpointSlice is enabled, watching EndpointSlices")
+		endpointSliceController = cachectrl.NewEndpointSliceControllerWithEventHandler(
+			informerFactory.Discovery().V1().EndpointSlices(),
+			resyncPeriod,
+			c,
+		)
+	} else {
+		klog.V(3).Infof("EndpointSlice is not enabled, watching Endpoints")
+		endpointsController = cachectrl.NewEndpointsControllerWithEventHandler(
+			informerFactory.Core().V1().Endpoints(),
+			resyncPeriod,
+			c,
+		)
+	}
 	ingressClassV1Controller := cachectrl.NewIngressClassv1ControllerWithEventHandler(
 		informerFactory.Networking().V1().IngressClasses(),
 		resyncPeriod,
@@ -140,20 +174,51 @@ func newLocalCache(ctx context.Context, api *kube.K8sAPI, clusterCfg *config.Sto
 		nil,
 	)
 
+	// only the restore pin is watched, it's read by every sync and push, pushes are acknowledged to be held once it's
+	// created
+	pinInformerFactory := informers.NewSharedInformerFactoryWithOptions(
+		api.Client,
+		resyncPeriod,
+		informers.WithNamespace(mc.GetMeshNamespace()),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", commons.RepoRestorePinConfigMapName).String()
+		}),
+	)
+	restorePinController := cachectrl.NewConfigMapControllerWithEventHandler(
+		pinInformerFactory.Core().V1().ConfigMaps(),
+		resyncPeriod,
+		c,
+		func(obj interface{}) bool { return true },
+	)
+
 	fsmInformerFactory := fsminformers.NewSharedInformerFactoryWithOptions(api.FlomeshClient, resyncPeriod)
 	serviceImportController := cachectrl.NewServiceImportControllerWithEventHandler(
 		fsmInformerFactory.Serviceimport().V1alpha1().ServiceImports(),
 		resyncPeriod,
 		c,
 	)
+	globalTrafficPolicyController := cachectrl.NewGlobalTrafficPolicyControllerWithEventHandler(
+		fsmInformerFactory.Globaltrafficpolicy().V1alpha1().GlobalTrafficPolicies(),
+		resyncPeriod,
+		c,
+	)
+	serviceExportController := cachectrl.NewServiceExportControllerWithEventHandler(
+		fsmInformerFactory.Serviceexport().V1alpha1().ServiceExports(),
+		resyncPeriod,
+		c,
+	)
 
 	c.controllers = &controller.LocalControllers{
-		Service:        serviceController,
-		Endpoints:      endpointsController,
-		Ingressv1:      ingressV1Controller,
-		IngressClassv1: ingressClassV1Controller,
-		ServiceImport:  serviceImportController,
-		Secret:         secretController,
+		Service:             serviceController,
+		Endpoints:           endpointsController,
+		EndpointSlice:       endpointSliceController,
+		Ingressv1:           ingressV1Controller,
+		IngressClassv1:      ingressClassV1Controller,
+		ServiceImport:       serviceImportController,
+		GlobalTrafficPolicy: globalTrafficPolicyController,
+		ServiceExport:       serviceExportController,
+		Secret:              secretController,
+		RestorePin:          restorePinController,
 	}
 
 	c.serviceChanges = NewServiceChangeTracker(enrichServiceInfo, recorder, c.controllers, c.k8sAPI)
@@ -161,15 +226,31 @@ func newLocalCache(ctx context.Context, api *kube.K8sAPI, clusterCfg *config.Sto
 	c.endpointsChanges = NewEndpointChangeTracker(nil, recorder, c.controllers)
 	c.ingressChanges = NewIngressChangeTracker(api, c.controllers, recorder, certMgr)
 
-	// FIXME: make it configurable
-	minSyncPeriod := 5 * time.Second
-	syncPeriod := 30 * time.Second
-	burstSyncs := 5
-	c.syncRunner = async.NewBoundedFrequencyRunner("sync-runner-local", c.syncRoutes, minSyncPeriod, syncPeriod, burstSyncs)
+	c.syncRunner = c.newSyncRunner(mc)
 
 	return c
 }
 
+func (c *LocalCache) newSyncRunner(mc *config.MeshConfig) *async.BoundedFrequencyRunner {
+	klog.V(3).Infof("Creating sync runner, minSyncPeriod=%s, syncPeriod=%s, burstSyncs=%d", mc.MinSyncPeriod(), mc.SyncPeriod(), mc.BurstSyncs())
+
+	return async.NewBoundedFrequencyRunner("sync-runner-local", c.syncRoutes, mc.MinSyncPeriod(), mc.SyncPeriod(), mc.BurstSyncs())
+}
+
+func (c *LocalCache) getSyncRunner() *async.BoundedFrequencyRunner {
+	c.runnerMu.RLock()
+	defer c.runnerMu.RUnlock()
+
+	return c.syncRunner
+}
+
+func (c *LocalCache) setSyncRunner(runner *async.BoundedFrequencyRunner) {
+	c.runnerMu.Lock()
+	defer c.runnerMu.Unlock()
+
+	c.syncRunner = runner
+}
+
 func (c *LocalCache) GetControllers() controller.Controllers {
 	return c.controllers
 }
@@ -195,18 +276,85 @@ func (c *LocalCache) isInitialized() bool {
 }
 
 func (c *LocalCache) Sync() {
-	c.syncRunner.Run()
+	c.getSyncRunner().Run()
 }
 
 // SyncLoop runs periodic work.  This is expected to run as a goroutine or as the main loop of the app.  It does not return.
+// Once the cache config in MeshConfig is changed, the runner is replaced by a new one with the new timing.
 func (c *LocalCache) SyncLoop(stopCh <-chan struct{}) {
-	c.syncRunner.Loop(stopCh)
+	go c.servicePusher.Run(stopCh)
+	go c.ingressPusher.Run(stopCh)
+
+	setActiveLocalCache(c)
+	defer clearActiveLocalCache(c)
+
+	msgBus := c.broker.GetMessageBus()
+	cacheConfigCh := msgBus.Sub(string(event.CacheConfigUpdated))
+	defer c.broker.Unsub(msgBus, cacheConfigCh)
+
+	for {
+		runner := c.getSyncRunner()
+		runnerStopCh := make(chan struct{})
+		go runner.Loop(runnerStopCh)
+
+		select {
+		case <-stopCh:
+			close(runnerStopCh)
+			return
+		case msg, ok := <-cacheConfigCh:
+			close(runnerStopCh)
+			if !ok {
+				return
+			}
+
+			e, ok := msg.(event.Message)
+			if !ok {
+				klog.Errorf("Received unexpected message %T on channel, expected Message", msg)
+				continue
+			}
+
+			mc, ok := e.NewObj.(*config.MeshConfig)
+			if !ok {
+				klog.Errorf("Received unexpected object %T, expected *config.MeshConfig", e.NewObj)
+				continue
+			}
+
+			c.setSyncRunner(c.newSyncRunner(mc))
+			// Sync immediately, the pending request of old runner may be lost
+			c.Sync()
+		}
+	}
 }
 
 func (c *LocalCache) syncRoutes() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	start := time.Now()
+	defer func() {
+		metrics.CacheSyncDuration.Observe(time.Since(start).Seconds())
+		metrics.CacheSyncsTotal.Inc()
+	}()
+
+	// The trace of sync starts when the oldest pending ingress change is tracked, so that the time spent in waiting
+	// for sync is visible
+	pendingIngresses, pendingSince := c.ingressChanges.Pending()
+	traceStart := start
+	if pendingIngresses > 0 && pendingSince.Before(start) {
+		traceStart = pendingSince
+	}
+	ctx, span := tracing.Tracer().Start(context.Background(), "cache.SyncRoutes",
+		trace.WithTimestamp(traceStart),
+		trace.WithAttributes(attribute.Int("ingress.changes", pendingIngresses)),
+	)
+	defer span.End()
+
+	if pendingIngresses > 0 {
+		_, pendingSpan := tracing.Tracer().Start(ctx, "cache.PendingChanges", trace.WithTimestamp(traceStart))
+		pendingSpan.End(trace.WithTimestamp(start))
+	}
+
+	_, applySpan := tracing.Tracer().Start(ctx, "cache.ApplyChanges")
 	c.serviceMap.Update(c.serviceChanges)
 	klog.V(5).Infof("Service Map: %#v", c.serviceMap)
 
@@ -221,58 +369,160 @@ func (c *LocalCache) syncRoutes() {
 
 	c.ingressMap.Update(c.ingressChanges)
 	klog.V(5).Infof("Ingress Map: %#v", c.ingressMap)
+	applySpan.End()
 
 	klog.V(3).InfoS("Start syncing rules ...")
 
 	mc := c.clusterCfg.MeshConfig.GetConfig()
 
-	serviceRoutes := c.buildServiceRoutes()
+	// the desired routes are handed over to the pushers again once the restored snapshot is unpinned, they're held by
+	// the pushers while it's pinned
+	pinned := c.repoPinned(ctx)
+	if c.pinned && !pinned {
+		klog.Infof("Codebases are unpinned from the restored snapshot, pushing all routes")
+		c.serviceRoutesVersion = ""
+		c.ingressRoutesVersion = ""
+	}
+	c.pinned = pinned
+
+	_, buildSpan := tracing.Tracer().Start(ctx, "cache.BuildServiceRoutes")
+	serviceRoutes, policyStatuses := c.buildServiceRoutes()
+	buildSpan.SetAttributes(attribute.Int("routes", len(serviceRoutes.Routes)))
+	buildSpan.End()
+	c.updateGlobalTrafficPolicyStatus(policyStatuses)
 	klog.V(5).Infof("Service Routes:\n %#v", serviceRoutes)
+	observeServiceRoutes(mc.GetDefaultServicesPath(), serviceRoutes)
 
 	exists := c.repoClient.CodebaseExists(mc.GetDefaultServicesPath())
 	if !exists {
 		c.serviceRoutesVersion = fmt.Sprintf("%d", time.Now().UnixMilli())
+		c.servicePusher.Reset()
 	}
 	if c.serviceRoutesVersion != serviceRoutes.Hash && exists {
 		klog.V(5).Infof("Service Routes changed, old hash=%q, new hash=%q", c.serviceRoutesVersion, serviceRoutes.Hash)
 		batches := serviceBatches(serviceRoutes, mc)
 		if batches != nil {
-			go func() {
-				if err := c.repoClient.Batch(batches); err != nil {
-					klog.Errorf("Sync service routes to repo failed: %s", err)
-					return
-				}
-
-				klog.V(5).Infof("Updating service routes version ...")
-				c.serviceRoutesVersion = serviceRoutes.Hash
-			}()
+			c.servicePusher.Push(ctx, serviceRoutes.Hash, batches)
 		}
+		c.serviceRoutesVersion = serviceRoutes.Hash
 
 		// If services changed, try to fully rebuild the ingress map
 		c.refreshIngress()
 	}
 
+	_, buildSpan = tracing.Tracer().Start(ctx, "cache.BuildIngressRoutes")
 	ingressRoutes := c.buildIngressConfig()
+	buildSpan.SetAttributes(attribute.Int("routes", len(ingressRoutes.Routes)))
+	buildSpan.End()
 	klog.V(5).Infof("Ingress Routes:\n %#v", ingressRoutes)
+	observeIngressRoutes(mc.GetDefaultIngressPath(), ingressRoutes)
 	exists = c.repoClient.CodebaseExists(mc.GetDefaultIngressPath())
 	if !exists {
 		c.ingressRoutesVersion = fmt.Sprintf("%d", time.Now().UnixMilli())
+		c.ingressData = routepkg.IngressData{}
+		c.ingressPusher.Reset()
 	}
 	if c.ingressRoutesVersion != ingressRoutes.Hash && exists {
 		klog.V(5).Infof("Ingress Routes changed, old hash=%q, new hash=%q", c.ingressRoutesVersion, ingressRoutes.Hash)
+		diff := diffIngressData(c.ingressData, ingressRoutes)
+		klog.V(5).Infof("Ingress Routes diff: %s, added=%v, removed=%v, modified=%v", diff, diff.Added, diff.Removed, diff.Modified)
+		if !diff.IsEmpty() {
+			c.recorder.Eventf(managerPodRef(), nil, corev1.EventTypeNormal, "RoutesChanged", "Sync", "Ingress routes changed: %s", diff)
+		}
+		c.ingressData = ingressRoutes
 		batches := c.ingressBatches(ingressRoutes, mc)
 		if batches != nil {
-			go func() {
-				if err := c.repoClient.Batch(batches); err != nil {
-					klog.Errorf("Sync ingress routes to repo failed: %s", err)
-					return
-				}
+			c.ingressPusher.Push(ctx, ingressRoutes.Hash, batches)
+		}
+		c.ingressRoutesVersion = ingressRoutes.Hash
+	}
+}
+
+func observeServiceRoutes(codebase string, serviceRoutes routepkg.ServiceRoute) {
+	endpoints := 0
+	for _, route := range serviceRoutes.Routes {
+		endpoints += len(route.Targets)
+	}
+
+	metrics.CacheRoutes.WithLabelValues(codebase, metrics.RouteTypeService).Set(float64(len(serviceRoutes.Routes)))
+	metrics.CacheEndpoints.WithLabelValues(codebase, metrics.RouteTypeService).Set(float64(endpoints))
+}
 
-				klog.V(5).Infof("Updating ingress routes version ...")
-				c.ingressRoutesVersion = ingressRoutes.Hash
-			}()
+func observeIngressRoutes(codebase string, ingressRoutes routepkg.IngressData) {
+	endpoints := 0
+	for _, route := range ingressRoutes.Routes {
+		if route.Upstream != nil {
+			endpoints += len(route.Upstream.Endpoints)
 		}
 	}
+
+	metrics.CacheRoutes.WithLabelValues(codebase, metrics.RouteTypeIngress).Set(float64(len(ingressRoutes.Routes)))
+	metrics.CacheEndpoints.WithLabelValues(codebase, metrics.RouteTypeIngress).Set(float64(endpoints))
+}
+
+// onPushResult records an event on the manager pod if the push failed or recovered from failure
+func (c *LocalCache) onPushResult(routeType string) pushResultFunc {
+	var failed int32
+
+	return func(hash string, err error) {
+		ref := managerPodRef()
+
+		if err != nil {
+			atomic.StoreInt32(&failed, 1)
+			c.recorder.Eventf(ref, nil, corev1.EventTypeWarning, "PushFailed", "Push", "Failed to push %s routes(hash=%s) to repo: %s", routeType, hash, err)
+			return
+		}
+
+		if atomic.CompareAndSwapInt32(&failed, 1, 0) {
+			c.recorder.Eventf(ref, nil, corev1.EventTypeNormal, "Pushed", "Push", "Pushed %s routes(hash=%s) to repo", routeType, hash)
+		}
+	}
+}
+
+// managerPodRef returns the reference of the pod which the manager is running in, it's the regarding object of cache events
+func managerPodRef() *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  config.GetFsmPodNamespace(),
+		Name:       config.GetFsmPodName(),
+	}
+}
+
+// repoPinned returns true if the codebases are pinned to a restored snapshot by the marker ConfigMap, pushes are held
+// until an operator resumes them by deleting it. It's true until the marker is synced or if it can't be read, so that
+// the restored snapshot is never overwritten by mistake.
+func (c *LocalCache) repoPinned(_ context.Context) bool {
+	pin := c.controllers.RestorePin
+	if !pin.HasSynced() {
+		klog.V(3).Infof("Restore pin isn't synced yet, holding pushes")
+		return true
+	}
+
+	mc := c.clusterCfg.MeshConfig.GetConfig()
+	_, err := pin.Lister.ConfigMaps(mc.GetMeshNamespace()).Get(commons.RepoRestorePinConfigMapName)
+	switch {
+	case err == nil:
+		return true
+	case apierrors.IsNotFound(err):
+		return false
+	default:
+		klog.Warningf("Failed to check whether codebases are pinned to a restored snapshot, holding pushes: %s", err)
+		return true
+	}
+}
+
+// PushError returns the error of the last push of service or ingress routes, nil if both succeeded
+func (c *LocalCache) PushError() error {
+	if err := c.servicePusher.LastError(); err != nil {
+		return fmt.Errorf("push service routes: %w", err)
+	}
+
+	if err := c.ingressPusher.LastError(); err != nil {
+		return fmt.Errorf("push ingress routes: %w", err)
+	}
+
+	return nil
 }
 
 func (c *LocalCache) refreshIngress() {
@@ -332,35 +582,129 @@ func (c *LocalCache) buildIngressConfig() routepkg.IngressData {
 			},
 		}
 
-		for _, e := range c.endpointsMap[svcName] {
-			ep, ok := e.(*BaseEndpointInfo)
+		ir.Upstream.Endpoints = c.upstreamEndpoints(svcName)
+
+		ir.Topology = c.topologySpec(svcName)
+
+		if len(ir.Upstream.Endpoints) > 0 {
+			ingressConfig.Routes = append(ingressConfig.Routes, ir)
+		}
+	}
+
+	ingressConfig.TCPRoutes = c.buildTCPRoutes()
+	ingressConfig.Hash = util.SimpleHash(ingressConfig)
+
+	return ingressConfig
+}
+
+// buildTCPRoutes routes the gateway ports allocated for the ServiceExports with TCP protocol to the services
+func (c *LocalCache) buildTCPRoutes() []routepkg.TCPRouteSpec {
+	routes := make([]routepkg.TCPRouteSpec, 0)
+
+	exports, err := c.controllers.ServiceExport.Lister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list ServiceExports: %s", err)
+		return routes
+	}
+
+	for _, export := range exports {
+		if !export.IsTCP() {
+			continue
+		}
+
+		for _, p := range export.Status.GatewayPorts {
+			svcName, ok := c.servicePortNameOf(export.Namespace, export.Name, p.PortNumber)
 			if !ok {
-				klog.ErrorS(nil, "Failed to cast BaseEndpointInfo", "endpoint", e.String())
+				klog.V(5).Infof("Port %d of Service %s/%s is not found", p.PortNumber, export.Namespace, export.Name)
 				continue
 			}
 
-			epIP := ep.IP()
-			epPort, err := ep.Port()
-			// Error parsing this endpoint has been logged. Skip to next endpoint.
-			if epIP == "" || err != nil {
-				continue
+			route := routepkg.TCPRouteSpec{
+				Port:    p.GatewayPort,
+				Service: svcName.String(),
+				BalancerSpec: routepkg.BalancerSpec{
+					Sticky:   export.Spec.SessionSticky,
+					Balancer: tcpBalancer(export.Spec.LoadBalancer),
+					Upstream: &routepkg.UpstreamSpec{
+						Protocol:  "TCP",
+						Endpoints: c.upstreamEndpoints(svcName),
+					},
+					Topology: c.topologySpec(svcName),
+				},
 			}
-			entry := routepkg.UpstreamEndpoint{
-				IP:   epIP,
-				Port: epPort,
-				//Protocol: protocol,
+
+			if len(route.Upstream.Endpoints) > 0 {
+				routes = append(routes, route)
 			}
-			ir.Upstream.Endpoints = append(ir.Upstream.Endpoints, entry)
 		}
+	}
 
-		if len(ir.Upstream.Endpoints) > 0 {
-			ingressConfig.Routes = append(ingressConfig.Routes, ir)
+	return routes
+}
+
+func (c *LocalCache) servicePortNameOf(namespace, name string, port int32) (ServicePortName, bool) {
+	for svcName, svc := range c.serviceMap {
+		if svcName.Namespace == namespace && svcName.Name == name && svc.Port() == int(port) {
+			return svcName, true
 		}
 	}
 
-	ingressConfig.Hash = util.SimpleHash(ingressConfig)
+	return ServicePortName{}, false
+}
 
-	return ingressConfig
+func (c *LocalCache) upstreamEndpoints(svcName ServicePortName) []routepkg.UpstreamEndpoint {
+	endpoints := make([]routepkg.UpstreamEndpoint, 0)
+	for _, e := range c.endpointsMap[svcName] {
+		ep, ok := e.(*BaseEndpointInfo)
+		if !ok {
+			klog.ErrorS(nil, "Failed to cast BaseEndpointInfo", "endpoint", e.String())
+			continue
+		}
+
+		epIP := ep.IP()
+		epPort, err := ep.Port()
+		// Error parsing this endpoint has been logged. Skip to next endpoint.
+		if epIP == "" || err != nil {
+			continue
+		}
+
+		endpoints = append(endpoints, routepkg.UpstreamEndpoint{
+			IP:   epIP,
+			Port: epPort,
+			Zone: ep.ZoneName(),
+		})
+	}
+
+	return endpoints
+}
+
+// tcpBalancer converts the LoadBalancer type of ServiceExport to the algorithm of balancer
+func tcpBalancer(lb routepkg.AlgoBalancer) routepkg.AlgoBalancer {
+	switch lb {
+	case "HashingLoadBalancer", routepkg.HashingLoadBalancer:
+		return routepkg.HashingLoadBalancer
+	case "LeastWorkLoadBalancer", routepkg.LeastWorkLoadBalancer:
+		return routepkg.LeastWorkLoadBalancer
+	default:
+		return routepkg.RoundRobinLoadBalancer
+	}
+}
+
+func (c *LocalCache) topologySpec(svcName ServicePortName) *routepkg.TopologySpec {
+	svc, exists := c.serviceMap[svcName]
+	if !exists {
+		return nil
+	}
+
+	svcInfo, ok := svc.(*serviceInfo)
+	if !ok || !svcInfo.TopologyAware() {
+		return nil
+	}
+
+	return &routepkg.TopologySpec{
+		Enabled:           true,
+		MinLocalEndpoints: svcInfo.MinLocalEndpoints(),
+	}
 }
 
 func (c *LocalCache) ingressBatches(ingressData routepkg.IngressData, mc *config.MeshConfig) []repo.Batch {
@@ -376,8 +720,16 @@ func (c *LocalCache) ingressBatches(ingressData routepkg.IngressData, mc *config
 	// Generate certificates.json
 	certificates := routepkg.TLSConfig{Certificates: map[string]routepkg.TLSSpec{}}
 
+	// Generate tcp-router.json
+	tcpRouter := routepkg.TCPRouterConfig{Routes: map[string]string{}}
+
 	trustedCAMap := make(map[string]bool, 0)
 
+	for _, r := range ingressData.TCPRoutes {
+		tcpRouter.Routes[fmt.Sprintf("%d", r.Port)] = r.Service
+		balancer.Services[r.Service] = r.BalancerSpec
+	}
+
 	for _, r := range ingressData.Routes {
 		// router
 		router.Routes[routerKey(r)] = r.RouterSpec
@@ -409,6 +761,7 @@ func (c *LocalCache) ingressBatches(ingressData routepkg.IngressData, mc *config
 		TLSConfig:      certificates,
 		RouterConfig:   router,
 		BalancerConfig: balancer,
+		TCPRouter:      tcpRouter,
 	}
 
 	batch.Items = append(batch.Items, ingressBatchItems(ingressConfig)...)
@@ -429,11 +782,12 @@ func getTrustedCAs(caMap map[string]bool) []string {
 	return trustedCAs
 }
 
-func (c *LocalCache) buildServiceRoutes() routepkg.ServiceRoute {
+func (c *LocalCache) buildServiceRoutes() (routepkg.ServiceRoute, globalTrafficPolicyStatuses) {
 	// Build  rules for each service.
 	serviceRoutes := routepkg.ServiceRoute{
 		Routes: []routepkg.ServiceRouteEntry{},
 	}
+	policyStatuses := make(globalTrafficPolicyStatuses)
 
 	svcNames := mapset.NewSet[ServicePortName]()
 	for svcName := range c.serviceMap {
@@ -444,6 +798,13 @@ func (c *LocalCache) buildServiceRoutes() routepkg.ServiceRoute {
 	}
 
 	for _, svcName := range svcNames.ToSlice() {
+		// The GlobalTrafficPolicy decides how the traffic is distributed among local and remote endpoints
+		// of an imported service
+		if route, ok := c.buildGlobalTrafficPolicyRoute(svcName, policyStatuses); ok {
+			serviceRoutes.Routes = append(serviceRoutes.Routes, route)
+			continue
+		}
+
 		svc, exists := c.serviceMap[svcName]
 		if exists {
 			svcInfo, ok := svc.(*serviceInfo)
@@ -505,7 +866,40 @@ func (c *LocalCache) buildServiceRoutes() routepkg.ServiceRoute {
 	}
 	serviceRoutes.Hash = util.SimpleHash(serviceRoutes)
 
-	return serviceRoutes
+	return serviceRoutes, policyStatuses
+}
+
+func (c *LocalCache) buildGlobalTrafficPolicyRoute(svcName ServicePortName, policyStatuses globalTrafficPolicyStatuses) (routepkg.ServiceRouteEntry, bool) {
+	svcImp, exists := c.serviceImportMap[svcName]
+	if !exists {
+		return routepkg.ServiceRouteEntry{}, false
+	}
+	svcImpInfo, ok := svcImp.(*serviceImportInfo)
+	if !ok {
+		return routepkg.ServiceRouteEntry{}, false
+	}
+	policy := c.globalTrafficPolicyOf(svcName)
+	if policy == nil {
+		return routepkg.ServiceRouteEntry{}, false
+	}
+
+	// Only in-cluster ClusterIP services have local endpoints
+	var local []Endpoint
+	if svc, exists := c.serviceMap[svcName]; exists {
+		if svcInfo, ok := svc.(*serviceInfo); ok && svcInfo.Type == corev1.ServiceTypeClusterIP {
+			local = c.endpointsMap[svcName]
+		}
+	}
+
+	targets, effective := c.applyGlobalTrafficPolicy(policy, local, c.multiClusterEndpointsMap[svcName])
+	policyStatuses.add(policy, effective)
+
+	return routepkg.ServiceRouteEntry{
+		Name:      svcImpInfo.svcName.Name,
+		Namespace: svcImpInfo.svcName.Namespace,
+		Targets:   targets,
+		PortName:  svcImpInfo.portName,
+	}, true
 }
 
 func serviceBatches(serviceRoutes routepkg.ServiceRoute, mc *config.MeshConfig) []repo.Batch {
@@ -542,12 +936,32 @@ func routerKey(r routepkg.IngressRouteSpec) string {
 	return fmt.Sprintf("%s%s", r.Host, r.Path)
 }
 
+// ingressBatchItems splits the ingress config into router.json, balancer.json, certificates.json and tcp-router.json,
+// so that only the changed files need to be uploaded
 func ingressBatchItems(ingressConfig routepkg.IngressConfig) []repo.BatchItem {
 	return []repo.BatchItem{
 		{
 			Path:     "/config",
-			Filename: "ingress.json",
-			Content:  ingressConfig,
+			Filename: "router.json",
+			Content:  ingressConfig.RouterConfig,
+		},
+		{
+			Path:     "/config",
+			Filename: "balancer.json",
+			Content:  ingressConfig.BalancerConfig,
+		},
+		{
+			Path:     "/config",
+			Filename: "certificates.json",
+			Content: routepkg.CertificateConfig{
+				TrustedCAs: ingressConfig.TrustedCAs,
+				TLSConfig:  ingressConfig.TLSConfig,
+			},
+		},
+		{
+			Path:     "/config",
+			Filename: "tcp-router.json",
+			Content:  ingressConfig.TCPRouter,
 		},
 	}
 }
@@ -556,11 +970,71 @@ func servicePortName(route routepkg.ServiceRouteEntry) string {
 	return fmt.Sprintf("%s/%s%s", route.Namespace, route.Name, fmtPortName(route.PortName))
 }
 
+// addresses returns the addresses of the targets of route, if the targets are weighted by a GlobalTrafficPolicy, each
+// address is repeated in proportion to its weight, so that sidecars balancing across the list in round-robin honor
+// the weights
 func addresses(route routepkg.ServiceRouteEntry) []string {
+	repeats := targetRepeats(route.Targets)
+
 	result := make([]string, 0)
-	for _, target := range route.Targets {
-		result = append(result, target.Address)
+	// addresses are interleaved, so that the traffic is spread smoothly
+	for round := 0; ; round++ {
+		added := false
+		for i, target := range route.Targets {
+			if round < repeats[i] {
+				result = append(result, target.Address)
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
 	}
 
 	return result
 }
+
+// targetRepeats returns how many times each target is repeated, it's 1 for each target if they're not weighted,
+// otherwise the weights are reduced by their greatest common divisor, and scaled down if there'd be more than
+// maxWeightedAddresses addresses
+func targetRepeats(targets []routepkg.Target) []int {
+	repeats := make([]int, len(targets))
+	divisor, total := 0, 0
+	for i, target := range targets {
+		repeats[i] = 1
+		if target.Weight > 0 {
+			repeats[i] = target.Weight
+		}
+		divisor = gcd(divisor, repeats[i])
+		total += repeats[i]
+	}
+	if divisor <= 1 && total <= maxWeightedAddresses {
+		return repeats
+	}
+
+	sum := 0
+	for i := range repeats {
+		repeats[i] /= divisor
+		sum += repeats[i]
+	}
+	if sum <= maxWeightedAddresses {
+		return repeats
+	}
+
+	for i := range repeats {
+		repeats[i] = int(math.Round(float64(repeats[i]) * maxWeightedAddresses / float64(sum)))
+		if repeats[i] == 0 {
+			repeats[i] = 1
+		}
+	}
+
+	return repeats
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+
+	return a
+}
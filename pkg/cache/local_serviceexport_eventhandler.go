@@ -0,0 +1,69 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cache
+
+import (
+	svcexpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceexport/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/klog/v2"
+)
+
+// The local cache watches ServiceExports for the ones with TCP protocol, which are routed by the Ingress
+// on the allocated gateway ports
+
+func (c *LocalCache) OnServiceExportAdd(export *svcexpv1alpha1.ServiceExport) {
+	c.OnServiceExportUpdate(nil, export)
+}
+
+func (c *LocalCache) OnServiceExportUpdate(oldExport, export *svcexpv1alpha1.ServiceExport) {
+	if !isTCPExport(oldExport) && !isTCPExport(export) {
+		return
+	}
+
+	if oldExport != nil && export != nil &&
+		equality.Semantic.DeepEqual(oldExport.Spec, export.Spec) &&
+		equality.Semantic.DeepEqual(oldExport.Status.GatewayPorts, export.Status.GatewayPorts) {
+		return
+	}
+
+	if c.isInitialized() {
+		klog.V(5).Infof("Detects TCP ServiceExport change, syncing...")
+		c.Sync()
+	}
+}
+
+func (c *LocalCache) OnServiceExportDelete(export *svcexpv1alpha1.ServiceExport) {
+	c.OnServiceExportUpdate(export, nil)
+}
+
+func (c *LocalCache) OnServiceExportSynced() {
+	if c.isInitialized() {
+		c.Sync()
+	}
+}
+
+func isTCPExport(export *svcexpv1alpha1.ServiceExport) bool {
+	return export != nil && export.IsTCP()
+}
@@ -0,0 +1,414 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/flomesh-io/fsm-classic/pkg/metrics"
+	"github.com/flomesh-io/fsm-classic/pkg/repo"
+	"github.com/flomesh-io/fsm-classic/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	pushRetryInterval = 5 * time.Second
+)
+
+// errPushesPaused fails the push which is in flight once pushes are paused, it's held before the next commit
+var errPushesPaused = errors.New("pushes are paused")
+
+type pushRequest struct {
+	hash    string
+	batches []repo.Batch
+	// spanContext is the span of the sync which requests the push, the push is traced as its child
+	spanContext trace.SpanContext
+	// versions of the codebases after the batches are pushed
+	versions map[string]int64
+}
+
+// pushResultFunc is called after each push with the hash of the pushed routes and the error if any
+type pushResultFunc func(hash string, err error)
+
+// pausedFunc returns true if pushes must be held, e.g. the codebases are pinned to a restored snapshot
+type pausedFunc func(ctx context.Context) bool
+
+// codebasePusher pushes routes to a codebase of the repo one by one in order, requests are coalesced,
+// only the latest one is pushed if there're several requests while a push is in progress.
+type codebasePusher struct {
+	name       string
+	repoClient *repo.PipyRepoClient
+	onResult   pushResultFunc
+	paused     pausedFunc
+
+	mu         sync.Mutex
+	pending    *pushRequest
+	inflight   *pushRequest
+	pushedHash string
+	lastErr    error
+	lastPushAt time.Time
+	// synced is true once a push has succeeded, it's never reset
+	synced bool
+	// contents of the files which have been pushed successfully, keyed by full path
+	pushedContents map[string]interface{}
+	// versions of the codebases after the last successful push, keyed by basepath. If the version of a codebase
+	// has been moved by other writers since then, pushedContents can't be trusted any more.
+	pushedVersions map[string]int64
+
+	signal chan struct{}
+}
+
+func newCodebasePusher(name string, repoClient *repo.PipyRepoClient, onResult pushResultFunc, paused pausedFunc) *codebasePusher {
+	return &codebasePusher{
+		name:       name,
+		repoClient: repoClient,
+		onResult:   onResult,
+		paused:     paused,
+		signal:     make(chan struct{}, 1),
+	}
+}
+
+// Push requests to push the batches, it replaces any pending request and returns immediately.
+// It's a no-op if the routes of the same hash have been pushed or are being pushed.
+func (p *codebasePusher) Push(ctx context.Context, hash string, batches []repo.Batch) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending != nil && p.pending.hash == hash {
+		return
+	}
+	if p.pending == nil && p.inflight != nil && p.inflight.hash == hash {
+		return
+	}
+	if p.pending == nil && p.inflight == nil && p.pushedHash == hash && p.lastErr == nil {
+		return
+	}
+
+	if p.pending != nil {
+		klog.V(5).Infof("[%s] Pending push of hash %q is superseded by %q", p.name, p.pending.hash, hash)
+	}
+	p.pending = &pushRequest{hash: hash, batches: batches, spanContext: trace.SpanContextFromContext(ctx)}
+
+	select {
+	case p.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Reset forgets what have been pushed, so that the next request is always pushed with all files
+func (p *codebasePusher) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pushedHash = ""
+	p.pushedContents = nil
+	p.pushedVersions = nil
+}
+
+// Invalidate forgets the pushed contents but keeps the pushed hash, so that the current routes are not pushed again,
+// while the next change is pushed with all files. It's used when files of the codebase are replaced by others.
+func (p *codebasePusher) Invalidate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pushedContents = nil
+	p.pushedVersions = nil
+}
+
+// HasSynced returns true if routes have ever been pushed to the repo successfully
+func (p *codebasePusher) HasSynced() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.synced
+}
+
+// PushedHash returns the hash of routes which have been pushed to the repo successfully
+func (p *codebasePusher) PushedHash() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.pushedHash
+}
+
+// Idle returns true if no push is in flight
+func (p *codebasePusher) Idle() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.inflight == nil
+}
+
+// LastError returns the error of last push, nil if it succeeded or nothing has been pushed yet
+func (p *codebasePusher) LastError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.lastErr
+}
+
+// Run processes push requests until stopCh is closed
+func (p *codebasePusher) Run(stopCh <-chan struct{}) {
+	// cancels the in-flight push once stopped
+	ctx, cancel := wait.ContextForChannel(stopCh)
+	defer cancel()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-p.signal:
+		}
+
+		req := p.checkout()
+		if req == nil {
+			continue
+		}
+
+		if p.paused != nil && p.paused(ctx) {
+			p.hold(req)
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(pushRetryInterval):
+				p.trigger()
+			}
+			continue
+		}
+
+		err := p.push(ctx, req)
+
+		// paused while pushing, some of the batches may have been committed
+		if errors.Is(err, errPushesPaused) {
+			p.hold(req)
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(pushRetryInterval):
+				p.trigger()
+			}
+			continue
+		}
+
+		if retry := p.complete(req, err); retry {
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(pushRetryInterval):
+				p.trigger()
+			}
+		}
+	}
+}
+
+func (p *codebasePusher) push(ctx context.Context, req *pushRequest) error {
+	ctx, span := tracing.Tracer().Start(
+		trace.ContextWithSpanContext(ctx, req.spanContext),
+		"cache.Push",
+		trace.WithAttributes(attribute.String("route.type", p.name), attribute.String("route.hash", req.hash)),
+	)
+
+	p.verifyVersions(ctx, req.batches)
+
+	var err error
+	if batches := p.changedBatches(req.batches); len(batches) > 0 {
+		span.SetAttributes(attribute.Int("repo.batches", len(batches)))
+		start := time.Now()
+		err = p.repoClient.BatchIf(ctx, batches, p.notPaused)
+		metrics.ObserveRepoPush(p.name, start, err)
+	} else {
+		klog.V(5).Infof("[%s] No file is changed for hash %q, skip pushing", p.name, req.hash)
+	}
+	if err == nil {
+		req.versions = p.codebaseVersions(ctx, req.batches)
+	}
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+// notPaused is the precondition of each commit, so that a push in flight never overwrites the codebases once pushes
+// are paused, e.g. by the retries of a conflicting commit after a snapshot is restored
+func (p *codebasePusher) notPaused(ctx context.Context) error {
+	if p.paused != nil && p.paused(ctx) {
+		return errPushesPaused
+	}
+
+	return nil
+}
+
+// verifyVersions forgets the pushed contents if any codebase has been committed by other writers since the last
+// successful push, e.g. by another replica or an operator, as the files in repo may differ from what were pushed.
+func (p *codebasePusher) verifyVersions(ctx context.Context, batches []repo.Batch) {
+	p.mu.Lock()
+	pushed := p.pushedVersions
+	p.mu.Unlock()
+
+	if pushed == nil {
+		return
+	}
+
+	for basepath, current := range p.codebaseVersions(ctx, batches) {
+		if version, ok := pushed[basepath]; !ok || version != current {
+			klog.Warningf("[%s] Version of codebase %q is moved from %d to %d unexpectedly, pushing all files", p.name, basepath, version, current)
+			p.Invalidate()
+			return
+		}
+	}
+}
+
+// codebaseVersions returns the current versions of the codebases of batches, codebases whose version can't be read
+// are absent, so that they are treated as changed next time. A commit by another writer between the push and
+// reading the version is not detected.
+func (p *codebasePusher) codebaseVersions(ctx context.Context, batches []repo.Batch) map[string]int64 {
+	versions := make(map[string]int64)
+	for _, batch := range batches {
+		version, err := p.repoClient.CodebaseVersion(ctx, batch.Basepath)
+		if err != nil {
+			klog.Warningf("[%s] Failed to get version of codebase %q: %s", p.name, batch.Basepath, err)
+			continue
+		}
+		versions[batch.Basepath] = version
+	}
+
+	return versions
+}
+
+func (p *codebasePusher) checkout() *pushRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	req := p.pending
+	p.pending = nil
+	p.inflight = req
+
+	return req
+}
+
+// hold puts the request back unless there's a newer one, and forgets what have been pushed, as the files in repo
+// are replaced while pushes are paused, the request is pushed with all files once pushes are resumed
+func (p *codebasePusher) hold(req *pushRequest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	klog.V(3).Infof("[%s] Pushes are paused, holding routes of hash %q", p.name, req.hash)
+	p.inflight = nil
+	if p.pending == nil {
+		p.pending = req
+	}
+	p.pushedHash = ""
+	p.pushedContents = nil
+	p.pushedVersions = nil
+}
+
+// complete records the result of the push, it returns true if the request needs to be retried
+func (p *codebasePusher) complete(req *pushRequest, err error) bool {
+	p.mu.Lock()
+	p.inflight = nil
+	p.lastErr = err
+	p.lastPushAt = time.Now()
+	retry := false
+	if err != nil {
+		klog.Errorf("[%s] Push routes of hash %q to repo failed: %s", p.name, req.hash, err)
+		// Retries only if there's no newer request
+		if p.pending == nil {
+			p.pending = req
+			retry = true
+		}
+	} else {
+		klog.V(5).Infof("[%s] Pushed routes of hash %q to repo", p.name, req.hash)
+		p.pushedHash = req.hash
+		p.pushedContents = batchContents(req.batches)
+		p.pushedVersions = req.versions
+		p.synced = true
+	}
+	p.mu.Unlock()
+
+	if p.onResult != nil {
+		p.onResult(req.hash, err)
+	}
+
+	return retry
+}
+
+// changedBatches returns the batches with only the files whose content differs from the pushed one
+func (p *codebasePusher) changedBatches(batches []repo.Batch) []repo.Batch {
+	p.mu.Lock()
+	pushed := p.pushedContents
+	p.mu.Unlock()
+
+	if pushed == nil {
+		return batches
+	}
+
+	result := make([]repo.Batch, 0)
+	for _, batch := range batches {
+		changed := repo.Batch{Basepath: batch.Basepath, Items: []repo.BatchItem{}}
+		for _, item := range batch.Items {
+			content, ok := pushed[batchItemPath(batch, item)]
+			if ok && reflect.DeepEqual(content, item.Content) {
+				continue
+			}
+
+			klog.V(5).Infof("[%s] %q is changed", p.name, batchItemPath(batch, item))
+			changed.Items = append(changed.Items, item)
+		}
+
+		if len(changed.Items) > 0 {
+			result = append(result, changed)
+		}
+	}
+
+	return result
+}
+
+func batchContents(batches []repo.Batch) map[string]interface{} {
+	contents := make(map[string]interface{})
+	for _, batch := range batches {
+		for _, item := range batch.Items {
+			contents[batchItemPath(batch, item)] = item.Content
+		}
+	}
+
+	return contents
+}
+
+func batchItemPath(batch repo.Batch, item repo.BatchItem) string {
+	return fmt.Sprintf("%s%s/%s", batch.Basepath, item.Path, item.Filename)
+}
+
+func (p *codebasePusher) trigger() {
+	select {
+	case p.signal <- struct{}{}:
+	default:
+	}
+}
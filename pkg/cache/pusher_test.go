@@ -0,0 +1,281 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/flomesh-io/fsm-classic/pkg/repo"
+)
+
+// recordingBackend records the paths of files upserted
+type recordingBackend struct {
+	*repo.MemoryBackend
+	upserted []string
+}
+
+func (b *recordingBackend) UpsertFile(ctx context.Context, path string, content interface{}) error {
+	b.upserted = append(b.upserted, path)
+	return b.MemoryBackend.UpsertFile(ctx, path, content)
+}
+
+func (b *recordingBackend) takeUpserted() []string {
+	result := b.upserted
+	b.upserted = nil
+	sort.Strings(result)
+
+	return result
+}
+
+func testBatches(contents map[string]string) []repo.Batch {
+	batch := repo.Batch{Basepath: "/base"}
+	for filename, content := range contents {
+		batch.Items = append(batch.Items, repo.BatchItem{Path: "/config", Filename: filename, Content: content})
+	}
+
+	return []repo.Batch{batch}
+}
+
+func TestPusherCoalescesRequests(t *testing.T) {
+	pushFailed := fmt.Errorf("push failed")
+
+	testCases := []struct {
+		name    string
+		run     func(p *codebasePusher) bool
+		pending string
+		retry   bool
+	}{
+		{
+			name: "latest request supersedes the pending one",
+			run: func(p *codebasePusher) bool {
+				p.Push(context.TODO(), "h1", nil)
+				p.Push(context.TODO(), "h2", nil)
+				return false
+			},
+			pending: "h2",
+		},
+		{
+			name: "request of the in-flight hash is dropped",
+			run: func(p *codebasePusher) bool {
+				p.Push(context.TODO(), "h1", nil)
+				p.checkout()
+				p.Push(context.TODO(), "h1", nil)
+				return false
+			},
+		},
+		{
+			name: "request of a new hash is queued while pushing",
+			run: func(p *codebasePusher) bool {
+				p.Push(context.TODO(), "h1", nil)
+				p.checkout()
+				p.Push(context.TODO(), "h2", nil)
+				return false
+			},
+			pending: "h2",
+		},
+		{
+			name: "request of the pushed hash is dropped",
+			run: func(p *codebasePusher) bool {
+				p.Push(context.TODO(), "h1", nil)
+				p.complete(p.checkout(), nil)
+				p.Push(context.TODO(), "h1", nil)
+				return false
+			},
+		},
+		{
+			name: "failed push is retried if there's no newer request",
+			run: func(p *codebasePusher) bool {
+				p.Push(context.TODO(), "h1", nil)
+				return p.complete(p.checkout(), pushFailed)
+			},
+			pending: "h1",
+			retry:   true,
+		},
+		{
+			name: "failed push is dropped for the newer request",
+			run: func(p *codebasePusher) bool {
+				p.Push(context.TODO(), "h1", nil)
+				req := p.checkout()
+				p.Push(context.TODO(), "h2", nil)
+				return p.complete(req, pushFailed)
+			},
+			pending: "h2",
+		},
+		{
+			name: "request of the pushed hash is queued while another one is pushing",
+			run: func(p *codebasePusher) bool {
+				p.Push(context.TODO(), "h1", nil)
+				p.complete(p.checkout(), nil)
+				p.Push(context.TODO(), "h2", nil)
+				p.checkout()
+				p.Push(context.TODO(), "h1", nil)
+				return false
+			},
+			pending: "h1",
+		},
+	}
+
+	for _, tc := range testCases {
+		p := &codebasePusher{name: "test", signal: make(chan struct{}, 1)}
+		retry := tc.run(p)
+
+		pending := ""
+		if p.pending != nil {
+			pending = p.pending.hash
+		}
+		if pending != tc.pending {
+			t.Errorf("%s: expected pending %q, got %q", tc.name, tc.pending, pending)
+		}
+		if retry != tc.retry {
+			t.Errorf("%s: expected retry %t, got %t", tc.name, tc.retry, retry)
+		}
+	}
+}
+
+func TestPusherSkipsUnchangedFiles(t *testing.T) {
+	backend := &recordingBackend{MemoryBackend: repo.NewMemoryBackend()}
+	p := newCodebasePusher("test", repo.NewRepoClientWithBackend(backend), nil, nil)
+
+	testCases := []struct {
+		name     string
+		contents map[string]string
+		external bool
+		expected []string
+	}{
+		{
+			name:     "first push uploads all files",
+			contents: map[string]string{"a.json": "a1", "b.json": "b1"},
+			expected: []string{"/base/config/a.json", "/base/config/b.json"},
+		},
+		{
+			name:     "only changed file is uploaded",
+			contents: map[string]string{"a.json": "a2", "b.json": "b1"},
+			expected: []string{"/base/config/a.json"},
+		},
+		{
+			name:     "nothing is uploaded if unchanged",
+			contents: map[string]string{"a.json": "a2", "b.json": "b1"},
+			expected: nil,
+		},
+		{
+			name:     "all files are uploaded once the codebase is committed by others",
+			contents: map[string]string{"a.json": "a2", "b.json": "b1"},
+			external: true,
+			expected: []string{"/base/config/a.json", "/base/config/b.json"},
+		},
+		{
+			name:     "unchanged files are skipped again after the full push",
+			contents: map[string]string{"a.json": "a2", "b.json": "b2"},
+			expected: []string{"/base/config/b.json"},
+		},
+	}
+
+	for _, tc := range testCases {
+		if tc.external {
+			version, _ := p.repoClient.CodebaseVersion(context.TODO(), "/base")
+			if err := backend.CommitCodebase(context.TODO(), "/base", version+1); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		req := &pushRequest{hash: tc.name, batches: testBatches(tc.contents)}
+		err := p.push(context.TODO(), req)
+		p.complete(req, err)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", tc.name, err)
+		}
+
+		upserted := backend.takeUpserted()
+		if len(upserted) != len(tc.expected) {
+			t.Errorf("%s: expected %v to be uploaded, got %v", tc.name, tc.expected, upserted)
+			continue
+		}
+		for i := range upserted {
+			if upserted[i] != tc.expected[i] {
+				t.Errorf("%s: expected %v to be uploaded, got %v", tc.name, tc.expected, upserted)
+				break
+			}
+		}
+	}
+}
+
+func TestPusherHoldsWhilePaused(t *testing.T) {
+	backend := &recordingBackend{MemoryBackend: repo.NewMemoryBackend()}
+	p := newCodebasePusher("test", repo.NewRepoClientWithBackend(backend), nil, nil)
+
+	contents := map[string]string{"a.json": "a1", "b.json": "b1"}
+	req := &pushRequest{hash: "h1", batches: testBatches(contents)}
+	p.complete(req, p.push(context.TODO(), req))
+	backend.takeUpserted()
+
+	// a newer request is held while paused, e.g. the codebase is pinned to a restored snapshot
+	p.Push(context.TODO(), "h2", testBatches(map[string]string{"a.json": "a2", "b.json": "b1"}))
+	p.hold(p.checkout())
+	if p.PushedHash() != "" {
+		t.Errorf("expected pushed hash to be forgotten while paused, got %q", p.PushedHash())
+	}
+
+	// the routes of pushed hash must not be dropped, as the files in repo have been replaced
+	p.Push(context.TODO(), "h1", testBatches(contents))
+	req = p.checkout()
+	if req == nil || req.hash != "h1" {
+		t.Fatalf("expected the request of hash h1 to be pending, got %v", req)
+	}
+
+	if err := p.push(context.TODO(), req); err != nil {
+		t.Fatal(err)
+	}
+	p.complete(req, nil)
+
+	expected := []string{"/base/config/a.json", "/base/config/b.json"}
+	if upserted := backend.takeUpserted(); len(upserted) != len(expected) || upserted[0] != expected[0] || upserted[1] != expected[1] {
+		t.Errorf("expected %v to be uploaded once resumed, got %v", expected, upserted)
+	}
+}
+
+func TestPusherStopsCommitsOncePaused(t *testing.T) {
+	backend := &recordingBackend{MemoryBackend: repo.NewMemoryBackend()}
+	paused := false
+	p := newCodebasePusher("test", repo.NewRepoClientWithBackend(backend), nil, func(context.Context) bool { return paused })
+
+	req := &pushRequest{hash: "h1", batches: testBatches(map[string]string{"a.json": "a1"})}
+	p.complete(req, p.push(context.TODO(), req))
+	committed, _ := p.repoClient.CodebaseVersion(context.TODO(), "/base")
+
+	// paused while pushing, e.g. a snapshot is restored
+	paused = true
+	req = &pushRequest{hash: "h2", batches: testBatches(map[string]string{"a.json": "a2"})}
+	if err := p.push(context.TODO(), req); !errors.Is(err, errPushesPaused) {
+		t.Fatalf("expected push to fail with %q, got %v", errPushesPaused, err)
+	}
+
+	if version, _ := p.repoClient.CodebaseVersion(context.TODO(), "/base"); version != committed {
+		t.Errorf("expected codebase not to be committed once paused, got version %d, want %d", version, committed)
+	}
+}
@@ -27,6 +27,8 @@ package cache
 import (
 	"fmt"
 	"github.com/flomesh-io/fsm-classic/pkg/cache/controller"
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
+	ingresspipy "github.com/flomesh-io/fsm-classic/pkg/ingress"
 	"github.com/flomesh-io/fsm-classic/pkg/kube"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -36,8 +38,10 @@ import (
 	"k8s.io/client-go/tools/events"
 	"k8s.io/klog/v2"
 	utilcache "k8s.io/kubernetes/pkg/proxy/util"
+	utilnet "k8s.io/utils/net"
 	"net"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -52,7 +56,7 @@ type BaseServiceInfo struct {
 var _ ServicePort = &BaseServiceInfo{}
 
 func (info *BaseServiceInfo) String() string {
-	return fmt.Sprintf("%s:%d/%s", info.address, info.port, info.protocol)
+	return fmt.Sprintf("%s/%s", net.JoinHostPort(info.address, strconv.Itoa(info.port)), info.protocol)
 }
 
 func (info *BaseServiceInfo) Address() string {
@@ -87,16 +91,18 @@ type ServiceMap map[ServicePortName]ServicePort
 
 type serviceInfo struct {
 	*BaseServiceInfo
-	svcName types.NamespacedName
-	Type    corev1.ServiceType
+	svcName           types.NamespacedName
+	Type              corev1.ServiceType
+	topologyAware     bool
+	minLocalEndpoints int
 }
 
 func (sct *ServiceChangeTracker) newBaseServiceInfo(port *corev1.ServicePort, service *corev1.Service) *BaseServiceInfo {
 	klog.V(5).Infof("Service %s/%s, Type: %q, Port %s", service.Namespace, service.Name, service.Spec.Type, port.String())
 	switch service.Spec.Type {
 	case corev1.ServiceTypeClusterIP:
-		// ONLY supports IPv4 for now
-		clusterIP := utilcache.GetClusterIPByFamily(corev1.IPv4Protocol, service)
+		// For dual-stack service, the primary ClusterIP is used
+		clusterIP := primaryClusterIP(service)
 		info := &BaseServiceInfo{
 			//address:  netutils.ParseIPSloppy(clusterIP),
 			address:  clusterIP,
@@ -130,7 +136,7 @@ func (sct *ServiceChangeTracker) newBaseServiceInfo(port *corev1.ServicePort, se
 		}
 
 		info := &BaseServiceInfo{
-			address:  fmt.Sprintf("%s:%d", service.Spec.ExternalName, port.TargetPort.IntValue()),
+			address:  net.JoinHostPort(service.Spec.ExternalName, strconv.Itoa(port.TargetPort.IntValue())),
 			port:     int(port.Port),
 			portName: port.Name,
 			protocol: port.Protocol,
@@ -200,7 +206,7 @@ func (sct *ServiceChangeTracker) serviceToServiceMap(service *corev1.Service) Se
 		return nil
 	}
 
-	clusterIP := utilcache.GetClusterIPByFamily(corev1.IPv4Protocol, service)
+	clusterIP := primaryClusterIP(service)
 	if clusterIP == "" {
 		return nil
 	}
@@ -224,11 +230,32 @@ func (sct *ServiceChangeTracker) serviceToServiceMap(service *corev1.Service) Se
 	return serviceMap
 }
 
+// primaryClusterIP returns the ClusterIP of the primary IP family of the service,
+// it's empty if the service is headless or doesn't have a valid ClusterIP
+func primaryClusterIP(service *corev1.Service) string {
+	if len(service.Spec.IPFamilies) > 0 {
+		return utilcache.GetClusterIPByFamily(service.Spec.IPFamilies[0], service)
+	}
+
+	// IPFamilies is not set, it's a service created before dual-stack was enabled
+	if utilnet.IsIPv4String(service.Spec.ClusterIP) {
+		return utilcache.GetClusterIPByFamily(corev1.IPv4Protocol, service)
+	}
+
+	return utilcache.GetClusterIPByFamily(corev1.IPv6Protocol, service)
+}
+
 func (sct *ServiceChangeTracker) shouldSkipService(svc *corev1.Service) bool {
 	if svc == nil {
 		return true
 	}
 
+	// Services derived from ServiceImports are routed by the ServiceImport itself
+	if svc.Labels[commons.MultiClustersServiceImportName] != "" {
+		klog.V(5).Infof("Service %s/%s is ignored as it's derived from ServiceImport", svc.Namespace, svc.Name)
+		return true
+	}
+
 	// Checks if ServiceImport with the same name exists
 	// If true, the Service and ServiceImport are aggregated
 	//if exists := sct.serviceImportExists(svc); exists {
@@ -319,5 +346,41 @@ func enrichServiceInfo(port *corev1.ServicePort, service *corev1.Service, baseIn
 	info.svcName = svcName
 	info.Type = service.Spec.Type
 
+	if service.Annotations == nil {
+		return info
+	}
+
+	// enrich topology aware routing
+	topologyAware := service.Annotations[ingresspipy.PipyIngressAnnotationTopologyAwareRouting]
+	switch strings.ToLower(topologyAware) {
+	case "yes", "true", "1", "on":
+		info.topologyAware = true
+	case "no", "false", "0", "off", "":
+		info.topologyAware = false
+	default:
+		klog.Warningf("Invalid value %q of annotation pipy.ingress.kubernetes.io/topology-aware-routing of Service %s/%s, setting topology aware routing to false", topologyAware, service.Namespace, service.Name)
+		info.topologyAware = false
+	}
+
+	minLocalEndpoints := service.Annotations[ingresspipy.PipyIngressAnnotationTopologyMinLocalEndpoints]
+	if minLocalEndpoints == "" {
+		minLocalEndpoints = "1"
+	}
+	count, err := strconv.Atoi(minLocalEndpoints)
+	if err == nil && count > 0 {
+		info.minLocalEndpoints = count
+	} else {
+		klog.Warningf("Invalid value %q of annotation pipy.ingress.kubernetes.io/topology-min-local-endpoints of Service %s/%s, setting min local endpoints to 1", minLocalEndpoints, service.Namespace, service.Name)
+		info.minLocalEndpoints = 1
+	}
+
 	return info
 }
+
+func (info *serviceInfo) TopologyAware() bool {
+	return info.topologyAware
+}
+
+func (info *serviceInfo) MinLocalEndpoints() int {
+	return info.minLocalEndpoints
+}
@@ -34,8 +34,9 @@ import (
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/tools/events"
 	"k8s.io/klog/v2"
-	utilcache "k8s.io/kubernetes/pkg/proxy/util"
+	"net"
 	"reflect"
+	"strconv"
 	"sync"
 )
 
@@ -49,7 +50,7 @@ type BaseServiceImportInfo struct {
 var _ ServicePort = &BaseServiceImportInfo{}
 
 func (info *BaseServiceImportInfo) String() string {
-	return fmt.Sprintf("%s:%d/%s", info.address, info.port, info.protocol)
+	return fmt.Sprintf("%s/%s", net.JoinHostPort(info.address, strconv.Itoa(info.port)), info.protocol)
 }
 
 func (info *BaseServiceImportInfo) Address() string {
@@ -105,8 +106,8 @@ func (sct *ServiceImportChangeTracker) newBaseServiceInfo(port *svcimpv1alpha1.S
 	clusterIP := ""
 	svc, exists := sct.serviceExists(svcImp)
 	if exists {
-		// ONLY supports IPv4 for now, uses Service ClusterIP, if a Service with same name exists
-		clusterIP = utilcache.GetClusterIPByFamily(corev1.IPv4Protocol, svc)
+		// uses primary Service ClusterIP, if a Service with same name exists
+		clusterIP = primaryClusterIP(svc)
 	}
 
 	info := &BaseServiceInfo{
@@ -305,7 +306,7 @@ func (sct *ServiceImportChangeTracker) endpointsToEndpointsMap(svcImp *svcimpv1a
 
 func newMultiClusterEndpointInfo(ep *svcimpv1alpha1.Endpoint, target svcimpv1alpha1.Target) *BaseEndpointInfo {
 	return &BaseEndpointInfo{
-		Endpoint: fmt.Sprintf("%s:%d%s", target.Host, target.Port, target.Path),
+		Endpoint: fmt.Sprintf("%s%s", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))), target.Path),
 		Cluster:  ep.ClusterKey,
 	}
 }
@@ -49,16 +49,25 @@ func NewConfig(k8sApi *kube.K8sAPI, mc *config.MeshConfig) *Config {
 }
 
 func (c *Config) GetCertificateManager() (certificate.Manager, error) {
-	switch certificate.CertificateManagerType(c.mc.Certificate.Manager) {
+	managerType := certificate.CertificateManagerType(c.mc.Certificate.Manager)
+
+	var mgr certificate.Manager
+	var err error
+	switch managerType {
 	case certificate.Manual:
-		return c.getManualCertificateManager()
+		mgr, err = c.getManualCertificateManager()
 	case certificate.Archon:
-		return c.getArchonCertificateManager()
+		mgr, err = c.getArchonCertificateManager()
 	case certificate.CertManager:
-		return c.getCertManagerCertificateManager()
+		mgr, err = c.getCertManagerCertificateManager()
 	default:
 		return nil, fmt.Errorf("%q is not a valid certificate manager", c.mc.Certificate.Manager)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	return certificate.NewInstrumentedManager(mgr, managerType), nil
 }
 
 func (c *Config) getArchonCertificateManager() (certificate.Manager, error) {
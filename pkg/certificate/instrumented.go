@@ -0,0 +1,70 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package certificate
+
+import (
+	"github.com/flomesh-io/fsm-classic/pkg/metrics"
+	"time"
+)
+
+// instrumentedManager records the issued certificates and their expiration of the wrapped Manager
+type instrumentedManager struct {
+	Manager
+	managerType CertificateManagerType
+}
+
+// NewInstrumentedManager wraps the Manager, so that certificates issued by it are exposed as metrics
+func NewInstrumentedManager(mgr Manager, managerType CertificateManagerType) Manager {
+	m := &instrumentedManager{
+		Manager:     mgr,
+		managerType: managerType,
+	}
+
+	// records the expiration of root CA
+	_, _ = m.GetRootCertificate()
+
+	return m
+}
+
+func (m *instrumentedManager) IssueCertificate(cn string, validityPeriod time.Duration, dnsNames []string) (*Certificate, error) {
+	cert, err := m.Manager.IssueCertificate(cn, validityPeriod, dnsNames)
+
+	expiration := time.Time{}
+	if cert != nil {
+		expiration = cert.Expiration
+	}
+	metrics.ObserveCertificateIssued(string(m.managerType), cn, expiration, err)
+
+	return cert, err
+}
+
+func (m *instrumentedManager) GetRootCertificate() (*Certificate, error) {
+	cert, err := m.Manager.GetRootCertificate()
+	if err == nil && cert != nil {
+		metrics.SetCertificateExpiration(string(m.managerType), cert.CommonName, cert.Expiration)
+	}
+
+	return cert, err
+}
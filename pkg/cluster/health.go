@@ -0,0 +1,107 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	conn "github.com/flomesh-io/fsm-classic/pkg/cluster/context"
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
+	"github.com/flomesh-io/fsm-classic/pkg/event"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	// HealthProbeInterval is the interval of the heartbeat of remote clusters
+	HealthProbeInterval = 15 * time.Second
+	healthProbeTimeout  = 5 * time.Second
+
+	versionLabel = "app.kubernetes.io/version"
+)
+
+// heartbeat probes the health of the remote cluster periodically and publishes the result
+func (c *RemoteConnector) heartbeat(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(HealthProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		c.broker.Enqueue(
+			event.Message{
+				Kind:   event.ClusterHealthProbed,
+				OldObj: nil,
+				NewObj: c.Probe(),
+			},
+		)
+
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// Probe checks the reachability of the API server and the gateway, and the version of FSM in the remote cluster
+func (c *RemoteConnector) Probe() *event.ClusterHealthEvent {
+	ctx := c.context.(*conn.ConnectorContext)
+	connectorCfg := ctx.ConnectorConfig
+	health := &event.ClusterHealthEvent{
+		Geo:       connectorCfg,
+		ProbeTime: time.Now(),
+	}
+
+	probeCtx, cancel := context.WithTimeout(context.Background(), healthProbeTimeout)
+	defer cancel()
+
+	if _, err := c.k8sAPI.Client.Discovery().RESTClient().Get().AbsPath("/healthz").Do(probeCtx).Raw(); err != nil {
+		klog.Warningf("[%s] API server is unreachable: %s", connectorCfg.Key(), err)
+		health.APIServerError = err.Error()
+	} else {
+		mc := c.clusterCfg.MeshConfig.GetConfig()
+		deployment, err := c.k8sAPI.Client.AppsV1().
+			Deployments(mc.GetMeshNamespace()).
+			Get(probeCtx, commons.ManagerDeploymentName, metav1.GetOptions{})
+		if err != nil {
+			klog.Warningf("[%s] Failed to get FSM version: %s", connectorCfg.Key(), err)
+		} else {
+			health.FsmVersion = deployment.Labels[versionLabel]
+		}
+	}
+
+	addr := net.JoinHostPort(connectorCfg.GatewayHost(), strconv.Itoa(int(connectorCfg.GatewayPort())))
+	gwConn, err := net.DialTimeout("tcp", addr, healthProbeTimeout)
+	if err != nil {
+		klog.Warningf("[%s] Gateway %s is unreachable: %s", connectorCfg.Key(), addr, err)
+		health.GatewayError = fmt.Sprintf("gateway %s is unreachable: %s", addr, err)
+	} else {
+		_ = gwConn.Close()
+	}
+
+	return health
+}
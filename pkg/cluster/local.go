@@ -53,28 +53,36 @@ func (c *LocalConnector) Run(stopCh <-chan struct{}) error {
 	klog.V(3).Infof("Registering event handlers ......")
 	controllers := c.cache.GetControllers().(*controller.LocalControllers)
 
+	// Either Endpoints or EndpointSlice controller is enabled, depends on the k8s version
+	endpointsController, endpointsInformer, endpointsSynced := endpointsControllerOf(controllers)
+
 	go controllers.Service.Run(stopCh)
-	go controllers.Endpoints.Run(stopCh)
+	go endpointsController(stopCh)
 	go controllers.IngressClassv1.Run(stopCh)
 	go controllers.Ingressv1.Run(stopCh)
 	go controllers.ServiceImport.Run(stopCh)
+	go controllers.GlobalTrafficPolicy.Run(stopCh)
+	go controllers.ServiceExport.Run(stopCh)
 	go controllers.Secret.Run(stopCh)
+	go controllers.RestorePin.Run(stopCh)
 
 	// start the informers manually
 	klog.V(3).Infof("Starting informers(svc, ep & ingress class) ......")
 	go controllers.Service.Informer.Run(stopCh)
-	go controllers.Endpoints.Informer.Run(stopCh)
+	go endpointsInformer(stopCh)
 	go controllers.Secret.Informer.Run(stopCh)
+	go controllers.RestorePin.Informer.Run(stopCh)
 	go controllers.IngressClassv1.Informer.Run(stopCh)
 
 	klog.V(3).Infof("Waiting for caches to be synced ......")
 	// Ingress depends on service & enpoints, they must be synced first
 	if !k8scache.WaitForCacheSync(stopCh,
-		controllers.Endpoints.HasSynced,
+		endpointsSynced,
 		controllers.Service.HasSynced,
 		controllers.Secret.HasSynced,
+		controllers.RestorePin.HasSynced,
 	) {
-		runtime.HandleError(fmt.Errorf("timed out waiting for services, endpoints & secrets caches to sync"))
+		runtime.HandleError(fmt.Errorf("timed out waiting for services, endpoints, secrets & restore pin caches to sync"))
 	}
 
 	// Ingress also depends on IngressClass, but it'c not needed to have relation with svc & ep
@@ -89,6 +97,20 @@ func (c *LocalConnector) Run(stopCh <-chan struct{}) error {
 		runtime.HandleError(fmt.Errorf("timed out waiting for ServiceExport to sync"))
 	}
 
+	// GlobalTrafficPolicy decides how the traffic of ServiceImport is distributed
+	klog.V(3).Infof("Starting GlobalTrafficPolicy informer ......")
+	go controllers.GlobalTrafficPolicy.Informer.Run(stopCh)
+	if !k8scache.WaitForCacheSync(stopCh, controllers.GlobalTrafficPolicy.HasSynced) {
+		runtime.HandleError(fmt.Errorf("timed out waiting for GlobalTrafficPolicy to sync"))
+	}
+
+	// ServiceExports with TCP protocol are exported on the ports of Ingress
+	klog.V(3).Infof("Starting ServiceExport informer ......")
+	go controllers.ServiceExport.Informer.Run(stopCh)
+	if !k8scache.WaitForCacheSync(stopCh, controllers.ServiceExport.HasSynced) {
+		runtime.HandleError(fmt.Errorf("timed out waiting for ServiceExport to sync"))
+	}
+
 	// Sleep for a while, so that there's enough time for processing
 	klog.V(5).Infof("Sleep for a while ......")
 	time.Sleep(1 * time.Second)
@@ -106,6 +128,14 @@ func (c *LocalConnector) Run(stopCh <-chan struct{}) error {
 	return <-errCh
 }
 
+func endpointsControllerOf(controllers *controller.LocalControllers) (func(<-chan struct{}), func(<-chan struct{}), k8scache.InformerSynced) {
+	if controllers.EndpointSlice != nil {
+		return controllers.EndpointSlice.Run, controllers.EndpointSlice.Informer.Run, controllers.EndpointSlice.HasSynced
+	}
+
+	return controllers.Endpoints.Run, controllers.Endpoints.Informer.Run, controllers.Endpoints.HasSynced
+}
+
 func (c *LocalConnector) ensureCodebaseDerivatives() error {
 	mc := c.clusterCfg.MeshConfig.GetConfig()
 	repoClient := repo.NewRepoClient(mc.RepoRootURL())
@@ -31,6 +31,7 @@ import (
 	svcimpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceimport/v1alpha1"
 	"github.com/flomesh-io/fsm-classic/pkg/cache/controller"
 	conn "github.com/flomesh-io/fsm-classic/pkg/cluster/context"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
 	"github.com/flomesh-io/fsm-classic/pkg/event"
 	retry "github.com/sethvargo/go-retry"
 	corev1 "k8s.io/api/core/v1"
@@ -83,6 +84,9 @@ func (c *RemoteConnector) Run(stopCh <-chan struct{}) error {
 		go c.processEvent(c.broker, stopCh)
 	}
 
+	// start the heartbeat
+	go c.heartbeat(stopCh)
+
 	// start the cache runner
 	go c.cache.SyncLoop(stopCh)
 
@@ -258,7 +262,64 @@ func (c *RemoteConnector) ServiceImportExists(svcExp *svcexpv1alpha1.ServiceExpo
 	return true
 }
 
-func (c *RemoteConnector) ValidateServiceExport(svcExp *svcexpv1alpha1.ServiceExport, service *corev1.Service) error {
+// GetServiceExport returns the ServiceExport of same namespace/name in the cluster, nil if the Service is not
+// exported in the cluster
+func (c *RemoteConnector) GetServiceExport(svcExp *svcexpv1alpha1.ServiceExport) (*svcexpv1alpha1.ServiceExport, error) {
+	ctx := c.context.(*conn.ConnectorContext)
+
+	exp, err := c.k8sAPI.FlomeshClient.ServiceexportV1alpha1().
+		ServiceExports(svcExp.Namespace).
+		Get(context.TODO(), svcExp.Name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			klog.V(5).Infof("[%s] ServiceExport %s/%s doesn't exist", ctx.ClusterKey, svcExp.Namespace, svcExp.Name)
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("[%s] Failed to get ServiceExport %s/%s: %s", ctx.ClusterKey, svcExp.Namespace, svcExp.Name, err)
+	}
+
+	return exp, nil
+}
+
+// GetService returns the Service which the ServiceExport exports in the cluster, nil if it doesn't exist
+func (c *RemoteConnector) GetService(svcExp *svcexpv1alpha1.ServiceExport) (*corev1.Service, error) {
+	ctx := c.context.(*conn.ConnectorContext)
+
+	svc, err := c.k8sAPI.Client.CoreV1().
+		Services(svcExp.Namespace).
+		Get(context.TODO(), svcExp.Name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			klog.V(5).Infof("[%s] Service %s/%s doesn't exist", ctx.ClusterKey, svcExp.Namespace, svcExp.Name)
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("[%s] Failed to get Service %s/%s: %s", ctx.ClusterKey, svcExp.Namespace, svcExp.Name, err)
+	}
+
+	return svc, nil
+}
+
+// ClearServiceExportConflict removes the Conflict condition of a rejected ServiceExport, so that it takes part in
+// the cluster set again once it's re-evaluated
+func (c *RemoteConnector) ClearServiceExportConflict(svcExp *svcexpv1alpha1.ServiceExport) (*svcexpv1alpha1.ServiceExport, error) {
+	ctx := c.context.(*conn.ConnectorContext)
+
+	exp := svcExp.DeepCopy()
+	metautil.RemoveStatusCondition(&exp.Status.Conditions, string(svcexpv1alpha1.ServiceExportConflict))
+
+	exp, err := c.k8sAPI.FlomeshClient.ServiceexportV1alpha1().
+		ServiceExports(exp.Namespace).
+		UpdateStatus(context.TODO(), exp, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("[%s] Failed to clear Conflict status of ServiceExport %s/%s: %s", ctx.ClusterKey, svcExp.Namespace, svcExp.Name, err)
+	}
+
+	return exp, nil
+}
+
+func (c *RemoteConnector) ValidateServiceExport(svcExp *svcexpv1alpha1.ServiceExport, service *corev1.Service, policy config.ServiceExportConflictPolicy) error {
 	ctx := c.context.(*conn.ConnectorContext)
 	clusterKey := ctx.ClusterKey
 	localSvc, err := c.k8sAPI.Client.CoreV1().
@@ -278,6 +339,10 @@ func (c *RemoteConnector) ValidateServiceExport(svcExp *svcexpv1alpha1.ServiceEx
 		return fmt.Errorf("[%s] service type doesn't match: %s vs %s", clusterKey, service.Spec.Type, localSvc.Spec.Type)
 	}
 
+	if policy == config.MergeCompatiblePorts {
+		return compatiblePorts(clusterKey, service.Spec.Ports, localSvc.Spec.Ports)
+	}
+
 	if !reflect.DeepEqual(service.Spec.Ports, localSvc.Spec.Ports) {
 		return fmt.Errorf("[%s] spec.ports conflict, please check service spec", clusterKey)
 	}
@@ -285,6 +350,24 @@ func (c *RemoteConnector) ValidateServiceExport(svcExp *svcexpv1alpha1.ServiceEx
 	return nil
 }
 
+// compatiblePorts checks if the ports can be merged into one ServiceImport, ports with same number must have same
+// name and protocol, ports only exist on one side are merged
+func compatiblePorts(clusterKey string, ports, localPorts []corev1.ServicePort) error {
+	for _, p := range ports {
+		for _, lp := range localPorts {
+			if p.Port != lp.Port {
+				continue
+			}
+
+			if p.Name != lp.Name || p.Protocol != lp.Protocol {
+				return fmt.Errorf("[%s] port %d conflicts: %s/%s vs %s/%s", clusterKey, p.Port, p.Name, p.Protocol, lp.Name, lp.Protocol)
+			}
+		}
+	}
+
+	return nil
+}
+
 func (c *RemoteConnector) upsertServiceImport(export *event.ServiceExportEvent) error {
 	ctx := c.context.(*conn.ConnectorContext)
 	exportClusterKey := export.ClusterKey()
@@ -307,7 +390,11 @@ func (c *RemoteConnector) upsertServiceImport(export *event.ServiceExportEvent)
 		if len(p.Endpoints) == 0 {
 			for _, r := range svcExp.Spec.Rules {
 				if r.PortNumber == p.Port {
-					ep := newEndpoint(export, r, export.Geo.GatewayHost(), export.Geo.GatewayIP(), export.Geo.GatewayPort())
+					ep, ok := gatewayEndpoint(export, r)
+					if !ok {
+						klog.V(5).Infof("[%s] gateway port of port %d is not allocated yet", ctx.ClusterKey, p.Port)
+						continue
+					}
 					klog.V(5).Infof("[%s] processing port %d, ep=%#v", ctx.ClusterKey, p.Port, ep)
 					endpoints = append(endpoints, ep)
 				}
@@ -323,7 +410,11 @@ func (c *RemoteConnector) upsertServiceImport(export *event.ServiceExportEvent)
 					}
 
 					// insert/update
-					epMap[exportClusterKey] = newEndpoint(export, r, export.Geo.GatewayHost(), export.Geo.GatewayIP(), export.Geo.GatewayPort())
+					if ep, ok := gatewayEndpoint(export, r); ok {
+						epMap[exportClusterKey] = ep
+					} else {
+						delete(epMap, exportClusterKey)
+					}
 				}
 			}
 
@@ -336,6 +427,13 @@ func (c *RemoteConnector) upsertServiceImport(export *event.ServiceExportEvent)
 		imp.Spec.Ports[idx].Endpoints = endpoints
 		klog.V(5).Infof("[%s] len of endpoints of port %d is %d", ctx.ClusterKey, p.Port, len(imp.Spec.Ports[idx].Endpoints))
 	}
+	// ports of compatible exports are merged, add the ports which are not imported yet
+	for _, p := range c.newServiceImport(export).Spec.Ports {
+		if !hasPort(imp.Spec.Ports, p.Port) {
+			klog.V(5).Infof("[%s] merging port %d into ServiceImport %s/%s", ctx.ClusterKey, p.Port, svcExp.Namespace, svcExp.Name)
+			imp.Spec.Ports = append(imp.Spec.Ports, p)
+		}
+	}
 	imp.Spec.ServiceAccountName = svcExp.Spec.ServiceAccountName
 	klog.V(5).Infof("[%s] After merging, ServiceImport %s/%s: %#v", ctx.ClusterKey, svcExp.Namespace, svcExp.Name, imp)
 
@@ -412,14 +510,17 @@ func (c *RemoteConnector) newServiceImport(export *event.ServiceExportEvent) *sv
 	for _, r := range svcExp.Spec.Rules {
 		for _, p := range service.Spec.Ports {
 			if r.PortNumber == p.Port {
+				endpoints := make([]svcimpv1alpha1.Endpoint, 0)
+				if ep, ok := gatewayEndpoint(export, r); ok {
+					endpoints = append(endpoints, ep)
+				}
+
 				ports = append(ports, svcimpv1alpha1.ServicePort{
 					Name:        p.Name,
 					Port:        p.Port,
 					Protocol:    p.Protocol,
 					AppProtocol: p.AppProtocol,
-					Endpoints: []svcimpv1alpha1.Endpoint{
-						newEndpoint(export, r, export.Geo.GatewayHost(), export.Geo.GatewayIP(), export.Geo.GatewayPort()),
-					},
+					Endpoints:   endpoints,
 				})
 			}
 		}
@@ -442,6 +543,46 @@ func (c *RemoteConnector) newServiceImport(export *event.ServiceExportEvent) *sv
 	}
 }
 
+func hasPort(ports []svcimpv1alpha1.ServicePort, port int32) bool {
+	for _, p := range ports {
+		if p.Port == port {
+			return true
+		}
+	}
+
+	return false
+}
+
+// gatewayEndpoint returns the endpoint through which the port of rule is reached on the gateway of exporting cluster,
+// the service exported with TCP protocol is reached on the gateway port allocated for it rather than by path, and by
+// the address of the gateway Service if it's a LoadBalancer. It returns false if the gateway port is not allocated
+// yet, or the export isn't valid, e.g. the LoadBalancer is not assigned an address yet
+func gatewayEndpoint(export *event.ServiceExportEvent, r svcexpv1alpha1.ServiceExportRule) (svcimpv1alpha1.Endpoint, bool) {
+	svcExp := export.ServiceExport
+	if !svcExp.IsTCP() {
+		return newEndpoint(export, r, export.Geo.GatewayHost(), export.Geo.GatewayIP(), export.Geo.GatewayPort()), true
+	}
+
+	port := svcExp.ExposedPortOf(r.PortNumber)
+	if port == 0 || !metautil.IsStatusConditionTrue(svcExp.Status.Conditions, string(svcexpv1alpha1.ServiceExportValid)) {
+		return svcimpv1alpha1.Endpoint{}, false
+	}
+
+	r.Path = ""
+	if address := svcExp.Status.GatewayAddress; address != "" {
+		ip := net.ParseIP(address)
+		ep := newEndpoint(export, r, address, ip, port)
+		if ip == nil {
+			// the LoadBalancer has a hostname, it's resolved by the importing cluster
+			ep.Target.IP = ""
+		}
+
+		return ep, true
+	}
+
+	return newEndpoint(export, r, export.Geo.GatewayHost(), export.Geo.GatewayIP(), port), true
+}
+
 func newEndpoint(export *event.ServiceExportEvent, r svcexpv1alpha1.ServiceExportRule, host string, ip net.IP, port int32) svcimpv1alpha1.Endpoint {
 	return svcimpv1alpha1.Endpoint{
 		ClusterKey: export.ClusterKey(),
@@ -484,26 +625,22 @@ func (c *RemoteConnector) deleteServiceImport(export *event.ServiceExportEvent)
 		return nil
 	}
 
-	// update service import, remove the export entry
+	// update service import, remove the export entry, the ports merged from other clusters are kept
 	ports := make([]svcimpv1alpha1.ServicePort, 0)
-	for _, r := range svcExp.Spec.Rules {
-		for _, p := range imp.Spec.Ports {
-			if r.PortNumber == p.Port {
-				endpoints := make([]svcimpv1alpha1.Endpoint, 0)
-				for _, ep := range p.Endpoints {
-					if ep.ClusterKey == exportClusterKey {
-						continue
-					} else {
-						endpoints = append(endpoints, *ep.DeepCopy())
-					}
-				}
-
-				if len(endpoints) > 0 {
-					p.Endpoints = endpoints
-					ports = append(ports, *p.DeepCopy())
-				}
+	for _, p := range imp.Spec.Ports {
+		endpoints := make([]svcimpv1alpha1.Endpoint, 0)
+		for _, ep := range p.Endpoints {
+			if ep.ClusterKey == exportClusterKey {
+				continue
+			} else {
+				endpoints = append(endpoints, *ep.DeepCopy())
 			}
 		}
+
+		if len(endpoints) > 0 {
+			p.Endpoints = endpoints
+			ports = append(ports, *p.DeepCopy())
+		}
 	}
 
 	if len(ports) > 0 {
@@ -528,39 +665,148 @@ func (c *RemoteConnector) deleteServiceImport(export *event.ServiceExportEvent)
 	return nil
 }
 
+// RemoveClusterEndpoints removes the endpoints of the cluster from all ServiceImports, so that no traffic is routed
+// to the cluster, the ServiceImport is deleted if there's no endpoint left
+func (c *RemoteConnector) RemoveClusterEndpoints(clusterKey string) error {
+	ctx := c.context.(*conn.ConnectorContext)
+
+	imports, err := c.k8sAPI.FlomeshClient.ServiceimportV1alpha1().
+		ServiceImports(corev1.NamespaceAll).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("[%s] Failed to list ServiceImports: %s", ctx.ClusterKey, err)
+		return err
+	}
+
+	for _, imp := range imports.Items {
+		imp := imp
+		if imp.DeletionTimestamp != nil {
+			continue
+		}
+
+		changed := false
+		ports := make([]svcimpv1alpha1.ServicePort, 0)
+		for _, p := range imp.Spec.Ports {
+			endpoints := make([]svcimpv1alpha1.Endpoint, 0)
+			for _, ep := range p.Endpoints {
+				if ep.ClusterKey == clusterKey {
+					changed = true
+					continue
+				}
+				endpoints = append(endpoints, *ep.DeepCopy())
+			}
+
+			if len(endpoints) > 0 {
+				p.Endpoints = endpoints
+				ports = append(ports, *p.DeepCopy())
+			}
+		}
+
+		if !changed {
+			continue
+		}
+
+		if len(ports) > 0 {
+			imp.Spec.Ports = ports
+			if _, err := c.k8sAPI.FlomeshClient.ServiceimportV1alpha1().
+				ServiceImports(imp.Namespace).
+				Update(context.TODO(), &imp, metav1.UpdateOptions{}); err != nil {
+				klog.Errorf("[%s] Failed to update ServiceImport %s/%s: %s", ctx.ClusterKey, imp.Namespace, imp.Name, err)
+				return err
+			}
+		} else {
+			if err := c.k8sAPI.FlomeshClient.ServiceimportV1alpha1().
+				ServiceImports(imp.Namespace).
+				Delete(context.TODO(), imp.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				klog.Errorf("[%s] Failed to delete ServiceImport %s/%s: %s", ctx.ClusterKey, imp.Namespace, imp.Name, err)
+				return err
+			}
+		}
+		klog.V(5).Infof("[%s] Endpoints of cluster %s are removed from ServiceImport %s/%s", ctx.ClusterKey, clusterKey, imp.Namespace, imp.Name)
+	}
+
+	return nil
+}
+
 func (c *RemoteConnector) rejectServiceExport(svcExportEvt *event.ServiceExportEvent) error {
 	ctx := c.context.(*conn.ConnectorContext)
 	export := svcExportEvt.ServiceExport
 	//reason := svcExportEvt.Data["reason"]
 	reason := svcExportEvt.Error
 
-	if ctx.ClusterKey == svcExportEvt.ClusterKey() {
-		exp, err := c.k8sAPI.FlomeshClient.ServiceexportV1alpha1().
-			ServiceExports(export.Namespace).
-			Get(context.TODO(), export.Name, metav1.GetOptions{})
-		if err != nil {
-			klog.Errorf("[%s] Failed to get ServiceExport %s/%s: %s", ctx.ClusterKey, export.Namespace, export.Name, err)
-			return err
-		}
+	if ctx.ClusterKey != svcExportEvt.ClusterKey() {
+		// the rejected export may have been accepted before, e.g. it loses to an older export, no traffic should be
+		// routed to it any more
+		return c.deleteServiceImport(svcExportEvt)
+	}
+
+	exp, err := c.k8sAPI.FlomeshClient.ServiceexportV1alpha1().
+		ServiceExports(export.Namespace).
+		Get(context.TODO(), export.Name, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("[%s] Failed to get ServiceExport %s/%s: %s", ctx.ClusterKey, export.Namespace, export.Name, err)
+		return err
+	}
+
+	c.cache.GetRecorder().Eventf(exp, nil, corev1.EventTypeWarning, "Rejected", "ServiceExport %s/%s is invalid, %s", exp.Namespace, exp.Name, reason)
+
+	metautil.SetStatusCondition(&exp.Status.Conditions, metav1.Condition{
+		Type:               string(svcexpv1alpha1.ServiceExportConflict),
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: exp.Generation,
+		LastTransitionTime: metav1.Time{Time: time.Now()},
+		Reason:             "Conflict",
+		Message:            fmt.Sprintf("ServiceExport %s/%s conflicts, %s", exp.Namespace, exp.Name, reason),
+	})
+
+	if _, err := c.k8sAPI.FlomeshClient.ServiceexportV1alpha1().
+		ServiceExports(export.Namespace).
+		UpdateStatus(context.TODO(), exp, metav1.UpdateOptions{}); err != nil {
+		klog.Errorf("[%s] Failed to update status of ServiceExport %s/%s: %s", ctx.ClusterKey, exp.Namespace, exp.Name, err)
+		return err
+	}
 
-		c.cache.GetRecorder().Eventf(exp, nil, corev1.EventTypeWarning, "Rejected", "ServiceExport %s/%s is invalid, %s", exp.Namespace, exp.Name, reason)
-
-		metautil.SetStatusCondition(&exp.Status.Conditions, metav1.Condition{
-			Type:               string(svcexpv1alpha1.ServiceExportConflict),
-			Status:             metav1.ConditionTrue,
-			ObservedGeneration: exp.Generation,
-			LastTransitionTime: metav1.Time{Time: time.Now()},
-			Reason:             "Conflict",
-			Message:            fmt.Sprintf("ServiceExport %s/%s conflicts, %s", exp.Namespace, exp.Name, reason),
-		})
-
-		if _, err := c.k8sAPI.FlomeshClient.ServiceexportV1alpha1().
-			ServiceExports(export.Namespace).
-			UpdateStatus(context.TODO(), exp, metav1.UpdateOptions{}); err != nil {
-			klog.Errorf("[%s] Failed to update status of ServiceExport %s/%s: %s", ctx.ClusterKey, exp.Namespace, exp.Name, err)
+	return nil
+}
+
+// LeaveClusterSet tears down the state written to the cluster since it joined the ClusterSet, the ServiceImports are
+// deleted and the MeshConfig is reset, so that the cluster can join a ClusterSet again
+func (c *RemoteConnector) LeaveClusterSet() error {
+	ctx := c.context.(*conn.ConnectorContext)
+	connectorCfg := ctx.ConnectorConfig
+
+	imports, err := c.k8sAPI.FlomeshClient.ServiceimportV1alpha1().
+		ServiceImports(corev1.NamespaceAll).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("[%s] Failed to list ServiceImports: %s", ctx.ClusterKey, err)
+		return err
+	}
+
+	for _, imp := range imports.Items {
+		if err := c.k8sAPI.FlomeshClient.ServiceimportV1alpha1().
+			ServiceImports(imp.Namespace).
+			Delete(context.TODO(), imp.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			klog.Errorf("[%s] Failed to delete ServiceImport %s/%s: %s", ctx.ClusterKey, imp.Namespace, imp.Name, err)
 			return err
 		}
+		klog.V(5).Infof("[%s] ServiceImport %s/%s is deleted", ctx.ClusterKey, imp.Namespace, imp.Name)
+	}
+
+	mcClient := c.clusterCfg.MeshConfig
+	mc := mcClient.GetConfig()
+	if !mc.IsManaged || mc.Cluster.ControlPlaneUID != connectorCfg.ControlPlaneUID() {
+		klog.Warningf("[%s] Cluster is not managed by this control plane, MeshConfig is left as is", ctx.ClusterKey)
+		return nil
+	}
+
+	mc.IsManaged = false
+	mc.Cluster.ControlPlaneUID = ""
+	if _, err := mcClient.UpdateConfig(mc); err != nil {
+		klog.Errorf("[%s] Failed to reset MeshConfig: %s", ctx.ClusterKey, err)
+		return err
 	}
 
+	klog.Infof("[%s] Left ClusterSet", ctx.ClusterKey)
 	return nil
 }
@@ -36,6 +36,8 @@ const (
 	RootCAPrivateKeyName          = "ca.key"
 	TLSCertName                   = "tls.crt"
 	TLSPrivateKeyName             = "tls.key"
+	RepoTokenKey                  = "token"
+	KubeconfigKey                 = "kubeconfig"
 	WebhookServerServingCertsPath = "/tmp/k8s-webhook-server/serving-certs"
 	DefaultCAValidityPeriod       = 24 * 365 * 10 * time.Hour
 	DefaultCACommonName           = "flomesh.io"
@@ -48,6 +50,35 @@ const (
 	DefaultPipyRepoPath           = "/repo"
 	DefaultPipyRepoApiPath        = "/api/v1/repo"
 	DefaultPipyFileApiPath        = "/api/v1/repo-files"
+	DefaultRepoServePort          = 6070
+	DefaultMinSyncPeriod          = 5 * time.Second
+	DefaultSyncPeriod             = 30 * time.Second
+	DefaultBurstSyncs             = 5
+
+	// RepoManagerCertValidityPeriod is the validity of the client certificate of manager to repo, it's renewed in memory
+	RepoManagerCertValidityPeriod = 24 * time.Hour
+	// RepoCertValidityPeriod is the validity of the certificates of repo and pods kept in Secrets
+	RepoCertValidityPeriod = 90 * 24 * time.Hour
+	// RepoServerTLSSecretName is the Secret of the certificate of repo server, it's mounted to the repo
+	RepoServerTLSSecretName = "fsm-repo-server-tls"
+	// RepoWriterCASecretName is the Secret of the CA which issues the client certificate of manager to repo with mTLS,
+	// the repo trusts only it
+	RepoWriterCASecretName = "fsm-repo-writer-ca"
+	// RepoWriterCAValidityPeriod is the validity of the repo writer CA
+	RepoWriterCAValidityPeriod = 10 * 365 * 24 * time.Hour
+	// RepoClientTLSSecretName is the Secret of the CA and client certificate of pods which load codebases from repo,
+	// it's copied to the namespaces of sidecars
+	RepoClientTLSSecretName  = "fsm-repo-client-tls"
+	RepoClientTLSSecretLabel = "flomesh.io/repo-client-tls"
+	RepoClientTLSMountPath   = "/etc/fsm/repo-tls"
+	// RepoRestorePinConfigMapName is the marker ConfigMap which pins the codebases to a restored snapshot, routes
+	// aren't pushed to repo until it's deleted
+	RepoRestorePinConfigMapName = "fsm-repo-restore-pin"
+	// RepoRestorePinRestoredAtKey is the key of the pin which records when the snapshot is restored
+	RepoRestorePinRestoredAtKey = "restoredAt"
+	// RepoRestorePinHeldAnnotation is set to the restoredAt of the pin by the manager which pushes routes, once its
+	// pushes are held and none is in flight, codebases are restored after that
+	RepoRestorePinHeldAnnotation = AnnotationPrefix + "/repo-restore-pin-held"
 
 	// Proxy CRD
 
@@ -101,6 +132,8 @@ const (
 	MatchedProxyProfile               = AnnotationPrefix + "/proxy-profile"
 	ConfigHashAnnotation              = AnnotationPrefix + "/config-hash"
 	SpecHashAnnotation                = AnnotationPrefix + "/spec-hash"
+	CodebaseFinalizer                 = AnnotationPrefix + "/codebase"
+	RepoSnapshotLabel                 = AnnotationPrefix + "/repo-snapshot"
 	ProxySpecHashAnnotation           = AnnotationPrefix + "/proxy-hash"
 	ProxyProfileLastUpdated           = AnnotationPrefix + "/last-updated"
 	ProxyProfileLastUpdatedTimeFormat = "20060102-150405.0000"
@@ -146,6 +179,10 @@ const (
 	MultiClustersPrefix            = "multicluster.flomesh.io"
 	MultiClustersServiceExportHash = MultiClustersPrefix + "/export-hash"
 	MultiClustersConnectorMode     = MultiClustersPrefix + "/connector-mode"
+	MultiClustersServiceImportName = MultiClustersPrefix + "/service-import-name"
+	// MultiClustersServiceExportWithdrawing marks the ServiceExport to be withdrawn as the cluster has left the
+	// ClusterSet, it's removed once the export is withdrawn or the cluster rejoins
+	MultiClustersServiceExportWithdrawing = MultiClustersPrefix + "/withdrawing"
 	//MultiClustersExported          = MultiClustersPrefix + "/export"
 	//MultiClustersExportedName      = MultiClustersPrefix + "/export-name"
 
@@ -155,6 +192,15 @@ const (
 
 	ClusterTpl = "{{ .Region }}/{{ .Zone }}/{{ .Group }}/{{ .Cluster }}"
 
+	// DefaultClusterSetDomain is the DNS domain of imported services
+	DefaultClusterSetDomain = "clusterset.local"
+	// DefaultClusterSetDNSPort is the port ClusterSet DNS server listens on
+	DefaultClusterSetDNSPort = 5353
+	// DefaultCoreDNSNamespace is the namespace of the ConfigMap of CoreDNS Corefile
+	DefaultCoreDNSNamespace = "kube-system"
+	// DefaultCoreDNSConfigMapName is the name of the ConfigMap of CoreDNS Corefile
+	DefaultCoreDNSConfigMapName = "coredns"
+
 	// FLB constants
 
 	FlbPrefix                   = "flb.flomesh.io"
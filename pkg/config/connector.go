@@ -29,7 +29,6 @@ import (
 	"github.com/flomesh-io/fsm-classic/pkg/commons"
 	"github.com/flomesh-io/fsm-classic/pkg/util"
 	"k8s.io/apimachinery/pkg/util/validation"
-	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/klog/v2"
 	"net"
 )
@@ -76,42 +75,36 @@ func NewConnectorConfig(
 	}
 
 	if !inCluster {
-		isDNSName := false
-		if ipErrs := validation.IsValidIPv4Address(field.NewPath(""), gatewayHost); len(ipErrs) > 0 {
-			// Not IPv4 address
-			klog.Warningf("%q is NOT a valid IPv4 address: %v", gatewayHost, ipErrs)
+		// Both IPv4 and IPv6 addresses are supported
+		gwIP := net.ParseIP(gatewayHost)
+		if gwIP == nil {
+			// Not IP address
+			klog.Warningf("%q is NOT a valid IP address", gatewayHost)
 			if dnsErrs := validation.IsDNS1123Subdomain(gatewayHost); len(dnsErrs) > 0 {
 				// Not valid DNS domain name
 				return nil, fmt.Errorf("invalid DNS name or IP %q: %v", gatewayHost, dnsErrs)
-			} else {
-				// is DNS name
-				isDNSName = true
 			}
-		}
 
-		var gwIPv4 net.IP
-		if isDNSName {
-			ipAddr, err := net.ResolveIPAddr("ip4", gatewayHost)
+			// is DNS name
+			ipAddr, err := net.ResolveIPAddr("ip", gatewayHost)
 			if err != nil {
 				return nil, fmt.Errorf("%q cannot be resolved to IP, %s", gatewayHost, err)
 			}
 			klog.Infof("%q is resolved to IP: %s", gatewayHost, ipAddr.IP)
-			gwIPv4 = ipAddr.IP.To4()
-		} else {
-			gwIPv4 = net.ParseIP(gatewayHost).To4()
+			gwIP = ipAddr.IP
 		}
 
-		if gwIPv4 == nil {
-			return nil, fmt.Errorf("%q cannot be resolved to a IPv4 address", gatewayHost)
+		if gwIP == nil {
+			return nil, fmt.Errorf("%q cannot be resolved to a IP address", gatewayHost)
 		}
 
-		if gwIPv4 != nil && (gwIPv4.IsLoopback() || gwIPv4.IsUnspecified()) {
+		if gwIP.IsLoopback() || gwIP.IsUnspecified() {
 			return nil, fmt.Errorf("gateway Host %s is resolved to Loopback IP or Unspecified", gatewayHost)
 		}
 
 		c.gatewayHost = gatewayHost
 		c.gatewayPort = gatewayPort
-		c.gatewayIP = gwIPv4
+		c.gatewayIP = gwIP
 	}
 
 	return c, nil
@@ -0,0 +1,76 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package listener
+
+import (
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	lcfg "github.com/flomesh-io/fsm-classic/pkg/config/listener/config"
+	"github.com/flomesh-io/fsm-classic/pkg/event"
+	"k8s.io/klog/v2"
+)
+
+type cacheConfigChangeListener struct {
+	listenerCfg *lcfg.ListenerConfig
+}
+
+func NewCacheConfigListener(cfg *lcfg.ListenerConfig) config.MeshConfigChangeListener {
+	return &cacheConfigChangeListener{
+		listenerCfg: cfg,
+	}
+}
+
+func (l cacheConfigChangeListener) OnConfigCreate(cfg *config.MeshConfig) {
+	// TODO: implement it if needed
+}
+
+func (l cacheConfigChangeListener) OnConfigUpdate(oldCfg, cfg *config.MeshConfig) {
+	if !isCacheConfigChanged(oldCfg, cfg) {
+		return
+	}
+
+	klog.Infof("Cache config changed, minSyncPeriod=%s, syncPeriod=%s, burstSyncs=%d", cfg.MinSyncPeriod(), cfg.SyncPeriod(), cfg.BurstSyncs())
+
+	if l.listenerCfg.Broker == nil {
+		klog.Warningf("Broker is not set, the new cache config takes effect after restart")
+		return
+	}
+
+	// The caches live in the cluster connectors, notify them through the broker
+	l.listenerCfg.Broker.Enqueue(event.Message{
+		Kind:   event.CacheConfigUpdated,
+		OldObj: oldCfg,
+		NewObj: cfg,
+	})
+}
+
+func isCacheConfigChanged(oldCfg, cfg *config.MeshConfig) bool {
+	return oldCfg.MinSyncPeriod() != cfg.MinSyncPeriod() ||
+		oldCfg.SyncPeriod() != cfg.SyncPeriod() ||
+		oldCfg.BurstSyncs() != cfg.BurstSyncs()
+}
+
+func (l cacheConfigChangeListener) OnConfigDelete(cfg *config.MeshConfig) {
+	// TODO: implement it if needed
+}
@@ -27,6 +27,7 @@ package config
 import (
 	"github.com/flomesh-io/fsm-classic/pkg/certificate"
 	"github.com/flomesh-io/fsm-classic/pkg/config"
+	"github.com/flomesh-io/fsm-classic/pkg/event"
 	"github.com/flomesh-io/fsm-classic/pkg/kube"
 	"github.com/flomesh-io/fsm-classic/pkg/repo"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -38,4 +39,5 @@ type ListenerConfig struct {
 	ConfigStore        *config.Store
 	CertificateManager certificate.Manager
 	RepoClient         *repo.PipyRepoClient
+	Broker             *event.Broker
 }
@@ -0,0 +1,78 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package listener
+
+import (
+	"context"
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
+	"github.com/flomesh-io/fsm-classic/pkg/config"
+	lcfg "github.com/flomesh-io/fsm-classic/pkg/config/listener/config"
+	"github.com/flomesh-io/fsm-classic/pkg/tracing"
+	"k8s.io/klog/v2"
+)
+
+type tracingConfigChangeListener struct {
+	listenerCfg *lcfg.ListenerConfig
+}
+
+func NewTracingConfigListener(cfg *lcfg.ListenerConfig) config.MeshConfigChangeListener {
+	return &tracingConfigChangeListener{
+		listenerCfg: cfg,
+	}
+}
+
+func (l tracingConfigChangeListener) OnConfigCreate(cfg *config.MeshConfig) {
+	// TODO: implement it if needed
+}
+
+func (l tracingConfigChangeListener) OnConfigUpdate(oldCfg, cfg *config.MeshConfig) {
+	if !isTracingConfigChanged(oldCfg, cfg) {
+		return
+	}
+
+	klog.Infof("Tracing config changed, enabled=%t, endpoint=%s", cfg.Tracing.Enabled, cfg.Tracing.Endpoint)
+	if err := ConfigureTracing(cfg); err != nil {
+		klog.Errorf("Failed to update Tracing config, the previous one is kept: %s", err)
+	}
+}
+
+func isTracingConfigChanged(oldCfg, cfg *config.MeshConfig) bool {
+	return oldCfg.Tracing != cfg.Tracing
+}
+
+func (l tracingConfigChangeListener) OnConfigDelete(cfg *config.MeshConfig) {
+	// TODO: implement it if needed
+}
+
+// ConfigureTracing installs the OTLP exporter of the manager with the Tracing config of MeshConfig, or disables
+// tracing if it's not enabled
+func ConfigureTracing(cfg *config.MeshConfig) error {
+	return tracing.Reconfigure(context.TODO(), cfg.Tracing.Enabled, tracing.Options{
+		ServiceName: commons.ManagerDeploymentName,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRate:  cfg.Tracing.SampleRate,
+	})
+}
@@ -41,6 +41,9 @@ import (
 	v1 "k8s.io/client-go/listers/core/v1"
 	k8scache "k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -49,23 +52,51 @@ var (
 )
 
 type MeshConfig struct {
-	IsManaged     bool        `json:"isManaged"`
-	Repo          Repo        `json:"repo"`
-	Images        Images      `json:"images"`
-	Webhook       Webhook     `json:"webhook"`
-	Ingress       Ingress     `json:"ingress"`
-	GatewayApi    GatewayApi  `json:"gatewayApi"`
-	Certificate   Certificate `json:"certificate"`
-	Cluster       Cluster     `json:"cluster"`
-	ServiceLB     ServiceLB   `json:"serviceLB"`
-	Logging       Logging     `json:"logging"`
-	FLB           FLB         `json:"flb"`
-	MeshNamespace string      `json:"-"`
+	IsManaged     bool          `json:"isManaged"`
+	Repo          Repo          `json:"repo"`
+	Images        Images        `json:"images"`
+	Webhook       Webhook       `json:"webhook"`
+	Ingress       Ingress       `json:"ingress"`
+	GatewayApi    GatewayApi    `json:"gatewayApi"`
+	MCSApi        MCSApi        `json:"mcsApi"`
+	ClusterSetDNS ClusterSetDNS `json:"clusterSetDNS"`
+	Certificate   Certificate   `json:"certificate"`
+	Cluster       Cluster       `json:"cluster"`
+	ServiceLB     ServiceLB     `json:"serviceLB"`
+	Logging       Logging       `json:"logging"`
+	Tracing       Tracing       `json:"tracing"`
+	FLB           FLB           `json:"flb"`
+	Cache         Cache         `json:"cache"`
+	MeshNamespace string        `json:"-"`
 }
 
 type Repo struct {
-	RootURL                  string `json:"rootURL" validate:"required,url"`
-	RecoverIntervalInSeconds uint32 `json:"recoverIntervalInSeconds" validate:"gte=1,lte=3600"`
+	// RootURL of the repo, the scheme selects the backend: http(s):// for Pipy repo, file:// for a local directory
+	// of the manager, configmap://<namespace> for ConfigMaps of the namespace, mem:// for an in-memory repo used in tests
+	RootURL string `json:"rootURL" validate:"required,url"`
+	// ServeURL is the URL of the manager which serves the codebases to sidecars and ingress if the backend
+	// isn't Pipy repo or Pipy repo requires mTLS, the manager Service of mesh namespace is used if it's empty
+	ServeURL                 string   `json:"serveURL" validate:"omitempty,url"`
+	RecoverIntervalInSeconds uint32   `json:"recoverIntervalInSeconds" validate:"gte=1,lte=3600"`
+	TLS                      RepoTLS  `json:"tls"`
+	Auth                     RepoAuth `json:"auth"`
+}
+
+type RepoTLS struct {
+	// CASecretName is the Secret with key ca.crt to verify the certificate of https:// repo, the CA of FSM CA bundle
+	// is used if it's empty
+	CASecretName      string `json:"caSecretName"`
+	CASecretNamespace string `json:"caSecretNamespace"`
+	// MTLS presents a client certificate issued by the repo writer CA of manager to the repo, sidecars and ingress
+	// load codebases from the manager with client certificates issued by the FSM certificate manager
+	MTLS               bool `json:"mTLS"`
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+}
+
+type RepoAuth struct {
+	// TokenSecretName is the Secret with key token, it's sent to the repo as the bearer token if it's not empty
+	TokenSecretName      string `json:"tokenSecretName"`
+	TokenSecretNamespace string `json:"tokenSecretNamespace"`
 }
 
 type Images struct {
@@ -84,6 +115,7 @@ type Ingress struct {
 	Namespaced bool `json:"namespaced"`
 	HTTP       HTTP `json:"http"`
 	TLS        TLS  `json:"tls"`
+	TCP        TCP  `json:"tcp"`
 }
 
 type HTTP struct {
@@ -102,6 +134,12 @@ type TLS struct {
 	SSLPassthrough SSLPassthrough `json:"sslPassthrough"`
 }
 
+// TCP is the range of ports allocated on the cluster gateway for the services exported with TCP protocol
+type TCP struct {
+	MinPort int32 `json:"minPort" validate:"gte=0,lte=65535"`
+	MaxPort int32 `json:"maxPort" validate:"gte=0,lte=65535"`
+}
+
 type SSLPassthrough struct {
 	Enabled      bool  `json:"enabled"`
 	UpstreamPort int32 `json:"upstreamPort" validate:"gte=1,lte=65535"`
@@ -111,6 +149,34 @@ type GatewayApi struct {
 	Enabled bool `json:"enabled"`
 }
 
+// MCSApi enables the compatibility with Kubernetes Multi-Cluster Services API(multicluster.x-k8s.io)
+type MCSApi struct {
+	Enabled bool `json:"enabled"`
+}
+
+// ClusterSetDNS serves the DNS records of imported services, in form of <svc>.<ns>.svc.<domain>
+type ClusterSetDNS struct {
+	Enabled bool   `json:"enabled"`
+	Domain  string `json:"domain"`
+	Port    int32  `json:"port" validate:"gte=0,lte=65535"`
+	// ClusterSetIPCIDR is the range ClusterSetIPs of ServiceImports are allocated from, it should be a sub range of
+	// the service CIDR of cluster as ClusterSetIPs are assigned to the derived Services. If it's empty, the ClusterIPs
+	// allocated by Kubernetes are used.
+	ClusterSetIPCIDR string `json:"clusterSetIPCIDR" validate:"omitempty,cidr"`
+	// Service is the Service of manager in mesh namespace, CoreDNS forwards the queries of domain to its ClusterIP.
+	// It's fsm-manager if empty.
+	Service string  `json:"service"`
+	CoreDNS CoreDNS `json:"coreDNS"`
+}
+
+// CoreDNS is where the Corefile of CoreDNS is stored, the server block forwarding the ClusterSet domain to manager
+// is added into the Corefile if Patch is true
+type CoreDNS struct {
+	Patch     bool   `json:"patch"`
+	Namespace string `json:"namespace"`
+	ConfigMap string `json:"configMap"`
+}
+
 type Cluster struct {
 	UID             string `json:"uid"`
 	Region          string `json:"region"`
@@ -118,7 +184,26 @@ type Cluster struct {
 	Group           string `json:"group"`
 	Name            string `json:"name" validate:"required"`
 	ControlPlaneUID string `json:"controlPlaneUID"`
-}
+	// StrictKubeconfig rejects Clusters with inline kubeconfig, the kubeconfig must be stored in a Secret
+	StrictKubeconfig bool `json:"strictKubeconfig"`
+	// ServiceExportConflictPolicy decides which export wins if the exports of same Service conflict in the ClusterSet,
+	// it's FirstWins if empty
+	ServiceExportConflictPolicy ServiceExportConflictPolicy `json:"serviceExportConflictPolicy" validate:"omitempty,oneof=FirstWins OldestWins MergeCompatiblePorts"`
+}
+
+// ServiceExportConflictPolicy is the policy to resolve the conflict of ServiceExports of same Service across clusters
+type ServiceExportConflictPolicy string
+
+const (
+	// FirstWins accepts the export which is exported first in the ClusterSet, later conflicting exports are rejected
+	FirstWins ServiceExportConflictPolicy = "FirstWins"
+	// OldestWins accepts the export with the oldest creationTimestamp, the newer conflicting exports are rejected
+	// even if they were accepted before
+	OldestWins ServiceExportConflictPolicy = "OldestWins"
+	// MergeCompatiblePorts accepts exports whose ports are compatible, ports are merged into the ServiceImport. Ports
+	// with same number must have same name and protocol, otherwise the later export is rejected as FirstWins
+	MergeCompatiblePorts ServiceExportConflictPolicy = "MergeCompatiblePorts"
+)
 
 type ServiceLB struct {
 	Enabled bool `json:"enabled"`
@@ -130,6 +215,12 @@ type FLB struct {
 	SecretName string `json:"secretName" validate:"required"`
 }
 
+type Cache struct {
+	MinSyncPeriodInSeconds uint32 `json:"minSyncPeriodInSeconds" validate:"omitempty,gte=1,lte=300"`
+	SyncPeriodInSeconds    uint32 `json:"syncPeriodInSeconds" validate:"omitempty,gte=1,lte=3600"`
+	BurstSyncs             uint32 `json:"burstSyncs" validate:"omitempty,gte=1,lte=100"`
+}
+
 type Certificate struct {
 	Manager           string `json:"manager" validate:"required"`
 	CaBundleName      string `json:"caBundleName" validate:"required"`
@@ -141,6 +232,14 @@ type Logging struct {
 	SecretName string `json:"secretName" validate:"required"`
 }
 
+type Tracing struct {
+	Enabled bool `json:"enabled"`
+	// Endpoint is the host:port of the OTLP gRPC collector
+	Endpoint   string  `json:"endpoint" validate:"required_if=Enabled true"`
+	Insecure   bool    `json:"insecure"`
+	SampleRate float64 `json:"sampleRate" validate:"gte=0,lte=1"`
+}
+
 type MeshConfigClient struct {
 	k8sApi   *kube.K8sAPI
 	cmLister v1.ConfigMapNamespaceLister
@@ -203,12 +302,145 @@ func (o *MeshConfig) ServiceLbImage() string {
 	return fmt.Sprintf("%s/%s", o.Images.Repository, o.Images.KlipperLbImage)
 }
 
+// ClusterSetDomain is the domain of ClusterSet DNS records, without trailing dot
+func (o *MeshConfig) ClusterSetDomain() string {
+	if o.ClusterSetDNS.Domain == "" {
+		return commons.DefaultClusterSetDomain
+	}
+
+	return strings.TrimSuffix(o.ClusterSetDNS.Domain, ".")
+}
+
+// ServiceExportConflictPolicy is the policy to resolve the conflict of ServiceExports, FirstWins by default
+func (o *MeshConfig) ServiceExportConflictPolicy() ServiceExportConflictPolicy {
+	if o.Cluster.ServiceExportConflictPolicy == "" {
+		return FirstWins
+	}
+
+	return o.Cluster.ServiceExportConflictPolicy
+}
+
+// MinSyncPeriod is the minimum interval between two syncs of the cache
+func (o *MeshConfig) MinSyncPeriod() time.Duration {
+	if o.Cache.MinSyncPeriodInSeconds == 0 {
+		return commons.DefaultMinSyncPeriod
+	}
+
+	return time.Duration(o.Cache.MinSyncPeriodInSeconds) * time.Second
+}
+
+// SyncPeriod is the maximum interval between two syncs of the cache, even if nothing changed
+func (o *MeshConfig) SyncPeriod() time.Duration {
+	if o.Cache.SyncPeriodInSeconds == 0 {
+		return commons.DefaultSyncPeriod
+	}
+
+	return time.Duration(o.Cache.SyncPeriodInSeconds) * time.Second
+}
+
+// BurstSyncs is the number of syncs allowed to run back-to-back before MinSyncPeriod kicks in
+func (o *MeshConfig) BurstSyncs() int {
+	if o.Cache.BurstSyncs == 0 {
+		return commons.DefaultBurstSyncs
+	}
+
+	return int(o.Cache.BurstSyncs)
+}
+
 func (o *MeshConfig) RepoRootURL() string {
 	return o.Repo.RootURL
 }
 
+// RepoBaseURL is the URL which sidecars and ingress load codebases from
 func (o *MeshConfig) RepoBaseURL() string {
-	return fmt.Sprintf("%s%s", o.Repo.RootURL, commons.DefaultPipyRepoPath)
+	if !o.IsRepoServedByManager() {
+		return fmt.Sprintf("%s%s", o.Repo.RootURL, commons.DefaultPipyRepoPath)
+	}
+
+	return fmt.Sprintf("%s%s", strings.TrimSuffix(o.RepoServeURL(), "/"), commons.DefaultPipyRepoPath)
+}
+
+// IsRepoServedByManager returns true if sidecars and ingress load codebases from the manager instead of the repo.
+// Codebases of the backends other than Pipy repo are always served by the manager. With mTLS, Pipy repo only trusts
+// the client certificate of manager which writes codebases, the others load codebases from the read-only listener
+// of manager, so that their certificates can't be used to write codebases.
+func (o *MeshConfig) IsRepoServedByManager() bool {
+	return !o.IsPipyRepo() || (o.IsRepoTLSEnabled() && o.Repo.TLS.MTLS)
+}
+
+// RepoServeURL is the URL of the manager which serves codebases if IsRepoServedByManager is true, it's https:// if
+// Pipy repo requires mTLS
+func (o *MeshConfig) RepoServeURL() string {
+	if o.Repo.ServeURL != "" {
+		return o.Repo.ServeURL
+	}
+
+	scheme := "http"
+	if o.IsPipyRepo() && o.IsRepoTLSEnabled() {
+		scheme = "https"
+	}
+
+	return fmt.Sprintf("%s://%s.%s.svc:%d", scheme, commons.ManagerDeploymentName, o.GetMeshNamespace(), commons.DefaultRepoServePort)
+}
+
+// IsPipyRepo returns true if the backend of repo is Pipy repo, it's selected by http:// and https:// of root URL
+func (o *MeshConfig) IsPipyRepo() bool {
+	u, err := url.Parse(o.Repo.RootURL)
+	return err == nil && (strings.EqualFold(u.Scheme, "http") || strings.EqualFold(u.Scheme, "https"))
+}
+
+// RepoServePort is the port which the manager serves codebases on if the backend isn't Pipy repo
+func (o *MeshConfig) RepoServePort() int32 {
+	u, err := url.Parse(o.RepoServeURL())
+	if err != nil || u.Port() == "" {
+		return commons.DefaultRepoServePort
+	}
+
+	port, err := strconv.ParseInt(u.Port(), 10, 32)
+	if err != nil {
+		return commons.DefaultRepoServePort
+	}
+
+	return int32(port)
+}
+
+func (o *MeshConfig) IsRepoTLSEnabled() bool {
+	u, err := url.Parse(o.Repo.RootURL)
+	return err == nil && strings.EqualFold(u.Scheme, "https")
+}
+
+// RepoClientTLSArgs are the arguments of Pipy to load codebases from the https:// repo, or from the manager with mTLS,
+// with the CA and client certificate mounted at commons.RepoClientTLSMountPath, it's empty if TLS of repo isn't enabled
+func (o *MeshConfig) RepoClientTLSArgs() []string {
+	if !o.IsPipyRepo() || !o.IsRepoTLSEnabled() {
+		return nil
+	}
+
+	args := []string{fmt.Sprintf("--tls-trusted=%s/%s", commons.RepoClientTLSMountPath, commons.RootCACertName)}
+	if o.Repo.TLS.MTLS {
+		args = append(args,
+			fmt.Sprintf("--tls-cert=%s/%s", commons.RepoClientTLSMountPath, commons.TLSCertName),
+			fmt.Sprintf("--tls-key=%s/%s", commons.RepoClientTLSMountPath, commons.TLSPrivateKeyName),
+		)
+	}
+
+	return args
+}
+
+func (o *MeshConfig) GetRepoCASecretNamespace() string {
+	if o.Repo.TLS.CASecretNamespace != "" {
+		return o.Repo.TLS.CASecretNamespace
+	}
+
+	return o.GetMeshNamespace()
+}
+
+func (o *MeshConfig) GetRepoTokenSecretNamespace() string {
+	if o.Repo.Auth.TokenSecretNamespace != "" {
+		return o.Repo.Auth.TokenSecretNamespace
+	}
+
+	return o.GetMeshNamespace()
 }
 
 func (o *MeshConfig) IngressCodebasePath() string {
@@ -30,16 +30,17 @@ import (
 	"github.com/kelseyhightower/envconfig"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
+	"sync"
 )
 
 var (
 	meshMetadata             FsmMetadata
+	meshMetadataOnce         sync.Once
 	DefaultWatchedConfigMaps = sets.Set[string]{}
 )
 
 func init() {
 	DefaultWatchedConfigMaps.Insert(commons.MeshConfigName)
-	meshMetadata = getFsmMetadata()
 }
 
 type Store struct {
@@ -71,14 +72,23 @@ func getFsmMetadata() FsmMetadata {
 	return metadata
 }
 
+// fsmMetadata loads the metadata from environment on first use, so that packages depend on it can be unit tested
+func fsmMetadata() FsmMetadata {
+	meshMetadataOnce.Do(func() {
+		meshMetadata = getFsmMetadata()
+	})
+
+	return meshMetadata
+}
+
 func GetFsmPodName() string {
-	return meshMetadata.PodName
+	return fsmMetadata().PodName
 }
 
 func GetFsmPodNamespace() string {
-	return meshMetadata.PodNamespace
+	return fsmMetadata().PodNamespace
 }
 
 func GetFsmNamespace() string {
-	return meshMetadata.FsmNamespace
+	return fsmMetadata().FsmNamespace
 }
@@ -25,6 +25,7 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"github.com/flomesh-io/fsm-classic/pkg/repo"
 	"k8s.io/klog/v2"
@@ -54,7 +55,7 @@ func updateMainJson(basepath string, repoClient *repo.PipyRepoClient, newJson st
 		},
 	}
 
-	if err := repoClient.Batch([]repo.Batch{batch}); err != nil {
+	if err := repoClient.Batch(context.TODO(), []repo.Batch{batch}); err != nil {
 		klog.Errorf("Failed to update %q: %s", getPathOfMainJson(basepath), err)
 		return err
 	}
@@ -0,0 +1,158 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package controller
+
+import (
+	"fmt"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	discoveryinformers "k8s.io/client-go/informers/discovery/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"time"
+)
+
+type EndpointSliceHandler interface {
+	OnEndpointSliceAdd(endpointSlice *discoveryv1.EndpointSlice)
+	OnEndpointSliceUpdate(oldEndpointSlice, newEndpointSlice *discoveryv1.EndpointSlice)
+	OnEndpointSliceDelete(endpointSlice *discoveryv1.EndpointSlice)
+	OnEndpointSlicesSynced()
+}
+
+type EndpointSliceController struct {
+	Informer     cache.SharedIndexInformer
+	Store        EndpointSliceStore
+	HasSynced    cache.InformerSynced
+	Lister       discoverylisters.EndpointSliceLister
+	eventHandler EndpointSliceHandler
+}
+
+type EndpointSliceStore struct {
+	cache.Store
+}
+
+func (l *EndpointSliceStore) ByKey(key string) (*discoveryv1.EndpointSlice, error) {
+	s, exists, err := l.GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("no object matching key %q in local store", key)
+	}
+	return s.(*discoveryv1.EndpointSlice), nil
+}
+
+func NewEndpointSliceControllerWithEventHandler(endpointSliceInformer discoveryinformers.EndpointSliceInformer, resyncPeriod time.Duration, handler EndpointSliceHandler) *EndpointSliceController {
+	informer := endpointSliceInformer.Informer()
+
+	result := &EndpointSliceController{
+		HasSynced: informer.HasSynced,
+		Informer:  informer,
+		Lister:    endpointSliceInformer.Lister(),
+		Store: EndpointSliceStore{
+			Store: informer.GetStore(),
+		},
+	}
+
+	informer.AddEventHandlerWithResyncPeriod(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    result.handleAddEndpointSlice,
+			UpdateFunc: result.handleUpdateEndpointSlice,
+			DeleteFunc: result.handleDeleteEndpointSlice,
+		},
+		resyncPeriod,
+	)
+
+	if handler != nil {
+		result.eventHandler = handler
+	}
+
+	return result
+}
+
+func (c *EndpointSliceController) Run(stopCh <-chan struct{}) {
+	klog.InfoS("Starting endpoint slice config controller")
+
+	if !cache.WaitForNamedCacheSync("endpoint slice config", stopCh, c.HasSynced) {
+		return
+	}
+
+	if c.eventHandler != nil {
+		klog.V(3).Info("Calling handler.OnEndpointSlicesSynced()")
+		c.eventHandler.OnEndpointSlicesSynced()
+	}
+}
+
+func (c *EndpointSliceController) handleAddEndpointSlice(obj interface{}) {
+	endpointSlice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		runtime.HandleError(fmt.Errorf("unexpected object type: %v", obj))
+		return
+	}
+
+	if c.eventHandler != nil {
+		klog.V(4).Info("Calling handler.OnEndpointSliceAdd")
+		c.eventHandler.OnEndpointSliceAdd(endpointSlice)
+	}
+}
+
+func (c *EndpointSliceController) handleUpdateEndpointSlice(oldObj, newObj interface{}) {
+	oldEndpointSlice, ok := oldObj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		runtime.HandleError(fmt.Errorf("unexpected object type: %v", oldObj))
+		return
+	}
+	newEndpointSlice, ok := newObj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		runtime.HandleError(fmt.Errorf("unexpected object type: %v", newObj))
+		return
+	}
+
+	if c.eventHandler != nil {
+		klog.V(4).Info("Calling handler.OnEndpointSliceUpdate")
+		c.eventHandler.OnEndpointSliceUpdate(oldEndpointSlice, newEndpointSlice)
+	}
+}
+
+func (c *EndpointSliceController) handleDeleteEndpointSlice(obj interface{}) {
+	endpointSlice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			runtime.HandleError(fmt.Errorf("unexpected object type: %v", obj))
+			return
+		}
+		if endpointSlice, ok = tombstone.Obj.(*discoveryv1.EndpointSlice); !ok {
+			runtime.HandleError(fmt.Errorf("unexpected object type: %v", obj))
+			return
+		}
+	}
+
+	if c.eventHandler != nil {
+		klog.V(4).Info("Calling handler.OnEndpointSliceDelete")
+		c.eventHandler.OnEndpointSliceDelete(endpointSlice)
+	}
+}
@@ -0,0 +1,158 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package controller
+
+import (
+	"fmt"
+	gtpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/globaltrafficpolicy/v1alpha1"
+	gtpv1alpha1informers "github.com/flomesh-io/fsm-classic/pkg/generated/informers/externalversions/globaltrafficpolicy/v1alpha1"
+	gtpv1alpha1lister "github.com/flomesh-io/fsm-classic/pkg/generated/listers/globaltrafficpolicy/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"time"
+)
+
+type GlobalTrafficPolicyHandler interface {
+	OnGlobalTrafficPolicyAdd(globalTrafficPolicy *gtpv1alpha1.GlobalTrafficPolicy)
+	OnGlobalTrafficPolicyUpdate(oldGlobalTrafficPolicy, globalTrafficPolicy *gtpv1alpha1.GlobalTrafficPolicy)
+	OnGlobalTrafficPolicyDelete(globalTrafficPolicy *gtpv1alpha1.GlobalTrafficPolicy)
+	OnGlobalTrafficPolicySynced()
+}
+
+type GlobalTrafficPolicyController struct {
+	Informer     cache.SharedIndexInformer
+	Store        GlobalTrafficPolicyStore
+	HasSynced    cache.InformerSynced
+	Lister       gtpv1alpha1lister.GlobalTrafficPolicyLister
+	eventHandler GlobalTrafficPolicyHandler
+}
+
+type GlobalTrafficPolicyStore struct {
+	cache.Store
+}
+
+func (l *GlobalTrafficPolicyStore) ByKey(key string) (*gtpv1alpha1.GlobalTrafficPolicy, error) {
+	s, exists, err := l.GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("no object matching key %q in local store", key)
+	}
+	return s.(*gtpv1alpha1.GlobalTrafficPolicy), nil
+}
+
+func NewGlobalTrafficPolicyControllerWithEventHandler(globalTrafficPolicyInformer gtpv1alpha1informers.GlobalTrafficPolicyInformer, resyncPeriod time.Duration, handler GlobalTrafficPolicyHandler) *GlobalTrafficPolicyController {
+	informer := globalTrafficPolicyInformer.Informer()
+
+	result := &GlobalTrafficPolicyController{
+		HasSynced: informer.HasSynced,
+		Informer:  informer,
+		Lister:    globalTrafficPolicyInformer.Lister(),
+		Store: GlobalTrafficPolicyStore{
+			Store: informer.GetStore(),
+		},
+	}
+
+	informer.AddEventHandlerWithResyncPeriod(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    result.handleAddGlobalTrafficPolicy,
+			UpdateFunc: result.handleUpdateGlobalTrafficPolicy,
+			DeleteFunc: result.handleDeleteGlobalTrafficPolicy,
+		},
+		resyncPeriod,
+	)
+
+	if handler != nil {
+		result.eventHandler = handler
+	}
+
+	return result
+}
+
+func (c *GlobalTrafficPolicyController) Run(stopCh <-chan struct{}) {
+	klog.InfoS("Starting GlobalTrafficPolicy config controller")
+
+	if !cache.WaitForNamedCacheSync("GlobalTrafficPolicy config", stopCh, c.HasSynced) {
+		return
+	}
+
+	if c.eventHandler != nil {
+		klog.V(3).Info("Calling handler.OnGlobalTrafficPolicySynced()")
+		c.eventHandler.OnGlobalTrafficPolicySynced()
+	}
+}
+
+func (c *GlobalTrafficPolicyController) handleAddGlobalTrafficPolicy(obj interface{}) {
+	globalTrafficPolicy, ok := obj.(*gtpv1alpha1.GlobalTrafficPolicy)
+	if !ok {
+		runtime.HandleError(fmt.Errorf("unexpected object type: %v", obj))
+		return
+	}
+
+	if c.eventHandler != nil {
+		klog.V(4).Info("Calling handler.OnGlobalTrafficPolicyAdd")
+		c.eventHandler.OnGlobalTrafficPolicyAdd(globalTrafficPolicy)
+	}
+}
+
+func (c *GlobalTrafficPolicyController) handleUpdateGlobalTrafficPolicy(oldObj, newObj interface{}) {
+	oldGlobalTrafficPolicy, ok := oldObj.(*gtpv1alpha1.GlobalTrafficPolicy)
+	if !ok {
+		runtime.HandleError(fmt.Errorf("unexpected object type: %v", oldObj))
+		return
+	}
+	globalTrafficPolicy, ok := newObj.(*gtpv1alpha1.GlobalTrafficPolicy)
+	if !ok {
+		runtime.HandleError(fmt.Errorf("unexpected object type: %v", newObj))
+		return
+	}
+
+	if c.eventHandler != nil {
+		klog.V(4).Info("Calling handler.OnGlobalTrafficPolicyUpdate")
+		c.eventHandler.OnGlobalTrafficPolicyUpdate(oldGlobalTrafficPolicy, globalTrafficPolicy)
+	}
+}
+
+func (c *GlobalTrafficPolicyController) handleDeleteGlobalTrafficPolicy(obj interface{}) {
+	globalTrafficPolicy, ok := obj.(*gtpv1alpha1.GlobalTrafficPolicy)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			runtime.HandleError(fmt.Errorf("unexpected object type: %v", obj))
+			return
+		}
+		if globalTrafficPolicy, ok = tombstone.Obj.(*gtpv1alpha1.GlobalTrafficPolicy); !ok {
+			runtime.HandleError(fmt.Errorf("unexpected object type: %v", obj))
+			return
+		}
+	}
+
+	if c.eventHandler != nil {
+		klog.V(4).Info("Calling handler.OnGlobalTrafficPolicyDelete")
+		c.eventHandler.OnGlobalTrafficPolicyDelete(globalTrafficPolicy)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package dns
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"strings"
+	"time"
+)
+
+const (
+	corefileKey = "Corefile"
+
+	// the server block is delimited by the markers, so that it's replaced instead of being appended again
+	corefileBeginMarker = "# BEGIN fsm clusterset dns"
+	corefileEndMarker   = "# END fsm clusterset dns"
+
+	// the Corefile is checked periodically, as it may be reset by upgrades of CoreDNS addon
+	corefilePatchInterval = time.Minute
+)
+
+// CorefilePatcher adds a server block forwarding the queries of ClusterSet domain to the manager Service into the
+// Corefile of CoreDNS. CoreDNS picks up the change if the reload plugin is enabled, which is the default of kubeadm.
+type CorefilePatcher struct {
+	client    kubernetes.Interface
+	domain    string
+	port      int32
+	service   types.NamespacedName
+	configMap types.NamespacedName
+}
+
+func NewCorefilePatcher(client kubernetes.Interface, domain string, port int32, service, configMap types.NamespacedName) *CorefilePatcher {
+	return &CorefilePatcher{
+		client:    client,
+		domain:    strings.TrimSuffix(domain, "."),
+		port:      port,
+		service:   service,
+		configMap: configMap,
+	}
+}
+
+// Start implements manager.Runnable, it keeps the server block in the Corefile until the context is done
+func (p *CorefilePatcher) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := p.patch(ctx); err != nil {
+			klog.Errorf("Failed to forward ClusterSet domain %s in Corefile of ConfigMap %s, %s", p.domain, p.configMap, err)
+		}
+	}, corefilePatchInterval)
+
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, only the leader updates the Corefile
+func (p *CorefilePatcher) NeedLeaderElection() bool {
+	return true
+}
+
+func (p *CorefilePatcher) patch(ctx context.Context) error {
+	svc, err := p.client.CoreV1().Services(p.service.Namespace).Get(ctx, p.service.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		return fmt.Errorf("service %s has no ClusterIP", p.service)
+	}
+
+	cm, err := p.client.CoreV1().ConfigMaps(p.configMap.Namespace).Get(ctx, p.configMap.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	corefile, ok := cm.Data[corefileKey]
+	if !ok {
+		return fmt.Errorf("ConfigMap %s has no %s", p.configMap, corefileKey)
+	}
+
+	patched := withServerBlock(corefile, serverBlock(p.domain, svc.Spec.ClusterIP, p.port))
+	if patched == corefile {
+		return nil
+	}
+
+	cm.Data[corefileKey] = patched
+	if _, err := p.client.CoreV1().ConfigMaps(p.configMap.Namespace).Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	klog.Infof("ClusterSet domain %s is forwarded to %s:%d in Corefile of ConfigMap %s", p.domain, svc.Spec.ClusterIP, p.port, p.configMap)
+
+	return nil
+}
+
+func serverBlock(domain, ip string, port int32) string {
+	return fmt.Sprintf("%s\n%s:53 {\n    errors\n    cache %d\n    forward . %s:%d\n}\n%s\n",
+		corefileBeginMarker, domain, recordTTL, ip, port, corefileEndMarker)
+}
+
+// withServerBlock replaces the server block between the markers in the Corefile, or appends it if there's none
+func withServerBlock(corefile, block string) string {
+	begin := strings.Index(corefile, corefileBeginMarker)
+	end := strings.Index(corefile, corefileEndMarker)
+	if begin >= 0 && end > begin {
+		end += len(corefileEndMarker)
+		if end < len(corefile) && corefile[end] == '\n' {
+			end++
+		}
+
+		return corefile[:begin] + block + corefile[end:]
+	}
+
+	if corefile != "" && !strings.HasSuffix(corefile, "\n") {
+		corefile += "\n"
+	}
+
+	return corefile + block
+}
@@ -0,0 +1,49 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package dns
+
+import (
+	"testing"
+)
+
+func TestWithServerBlock(t *testing.T) {
+	block := serverBlock("clusterset.local", "10.96.0.20", 5353)
+	corefile := ".:53 {\n    forward . /etc/resolv.conf\n    reload\n}"
+
+	patched := withServerBlock(corefile, block)
+	expected := corefile + "\n" + block
+	if patched != expected {
+		t.Fatalf("expected the server block to be appended, got:\n%s", patched)
+	}
+
+	if again := withServerBlock(patched, block); again != patched {
+		t.Errorf("expected the Corefile to be unchanged if it has the server block, got:\n%s", again)
+	}
+
+	moved := serverBlock("clusterset.local", "10.96.0.30", 5353)
+	if replaced := withServerBlock(patched, moved); replaced != corefile+"\n"+moved {
+		t.Errorf("expected the server block to be replaced, got:\n%s", replaced)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package dns
+
+import (
+	"context"
+	"fmt"
+	svcimpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceimport/v1alpha1"
+	miekgdns "github.com/miekg/dns"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+const (
+	// recordTTL is kept short, as the endpoints of imported services change with the health of remote clusters
+	recordTTL = 5
+)
+
+// Server answers the DNS queries of imported services in form of <svc>.<ns>.svc.<domain>, the ClusterSetIP
+// is returned for ClusterSetIP ServiceImport and the IPs of endpoints are returned for Headless ServiceImport.
+type Server struct {
+	client client.Reader
+	domain string
+	addr   string
+}
+
+func NewServer(client client.Reader, domain string, port int32) *Server {
+	return &Server{
+		client: client,
+		domain: strings.ToLower(miekgdns.Fqdn(domain)),
+		addr:   fmt.Sprintf(":%d", port),
+	}
+}
+
+// Start implements manager.Runnable, it serves both UDP and TCP until the context is done
+func (s *Server) Start(ctx context.Context) error {
+	mux := miekgdns.NewServeMux()
+	mux.HandleFunc(s.domain, s.serveDNS)
+
+	servers := []*miekgdns.Server{
+		{Addr: s.addr, Net: "udp", Handler: mux},
+		{Addr: s.addr, Net: "tcp", Handler: mux},
+	}
+
+	errCh := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *miekgdns.Server) {
+			klog.Infof("Starting ClusterSet DNS server for domain %s on %s/%s", s.domain, server.Net, server.Addr)
+			if err := server.ListenAndServe(); err != nil {
+				errCh <- fmt.Errorf("ClusterSet DNS server on %s/%s: %w", server.Net, server.Addr, err)
+			}
+		}(server)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errCh:
+		klog.Error(err)
+	}
+
+	for _, server := range servers {
+		_ = server.Shutdown()
+	}
+
+	return err
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, all replicas serve DNS queries
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *Server) serveDNS(w miekgdns.ResponseWriter, req *miekgdns.Msg) {
+	msg := new(miekgdns.Msg)
+	msg.SetReply(req)
+	msg.Authoritative = true
+
+	for _, q := range req.Question {
+		answers, found := s.resolve(q)
+		if !found {
+			msg.SetRcode(req, miekgdns.RcodeNameError)
+			continue
+		}
+		msg.Answer = append(msg.Answer, answers...)
+	}
+
+	if err := w.WriteMsg(msg); err != nil {
+		klog.Errorf("Failed to write DNS response, %s", err)
+	}
+}
+
+func (s *Server) resolve(q miekgdns.Question) ([]miekgdns.RR, bool) {
+	name, namespace, ok := s.parse(q.Name)
+	if !ok {
+		return nil, false
+	}
+
+	svcImport := &svcimpv1alpha1.ServiceImport{}
+	if err := s.client.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: name}, svcImport); err != nil {
+		klog.V(5).Infof("ServiceImport %s/%s is not resolved, %s", namespace, name, err)
+		return nil, false
+	}
+
+	answers := make([]miekgdns.RR, 0)
+	for _, ip := range addresses(svcImport) {
+		hdr := miekgdns.RR_Header{Name: q.Name, Class: miekgdns.ClassINET, Ttl: recordTTL}
+		switch {
+		case q.Qtype == miekgdns.TypeA && ip.To4() != nil:
+			hdr.Rrtype = miekgdns.TypeA
+			answers = append(answers, &miekgdns.A{Hdr: hdr, A: ip.To4()})
+		case q.Qtype == miekgdns.TypeAAAA && ip.To4() == nil:
+			hdr.Rrtype = miekgdns.TypeAAAA
+			answers = append(answers, &miekgdns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+
+	return answers, true
+}
+
+// parse extracts the name and namespace of ServiceImport from <svc>.<ns>.svc.<domain>
+func (s *Server) parse(qname string) (string, string, bool) {
+	qname = strings.ToLower(miekgdns.Fqdn(qname))
+	if !miekgdns.IsSubDomain(s.domain, qname) {
+		return "", "", false
+	}
+
+	labels := miekgdns.SplitDomainName(strings.TrimSuffix(qname, s.domain))
+	if len(labels) != 3 || labels[2] != "svc" {
+		return "", "", false
+	}
+
+	return labels[0], labels[1], true
+}
+
+func addresses(svcImport *svcimpv1alpha1.ServiceImport) []net.IP {
+	ips := sets.NewString()
+	if svcImport.Spec.Type == svcimpv1alpha1.Headless {
+		for _, p := range svcImport.Spec.Ports {
+			for _, ep := range p.Endpoints {
+				if net.ParseIP(ep.Target.IP) != nil {
+					ips.Insert(ep.Target.IP)
+				}
+			}
+		}
+	} else {
+		ips.Insert(svcImport.Spec.IPs...)
+	}
+
+	result := make([]net.IP, 0)
+	for _, ip := range ips.List() {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			result = append(result, parsed)
+		}
+	}
+
+	return result
+}
@@ -28,6 +28,7 @@ import (
 	svcexpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceexport/v1alpha1"
 	"github.com/flomesh-io/fsm-classic/pkg/config"
 	corev1 "k8s.io/api/core/v1"
+	"time"
 )
 
 type EventType string
@@ -37,6 +38,8 @@ const (
 	ServiceExportDeleted  EventType = "service.export.deleted"
 	ServiceExportAccepted EventType = "service.export.accepted"
 	ServiceExportRejected EventType = "service.export.rejected"
+	CacheConfigUpdated    EventType = "mesh.config.cache.updated"
+	ClusterHealthProbed   EventType = "cluster.health.probed"
 )
 
 type Message struct {
@@ -64,6 +67,27 @@ func (e *ServiceExportEvent) ClusterKey() string {
 	return e.Geo.Key()
 }
 
+// ClusterHealthEvent is the result of a health probe of a remote cluster
+type ClusterHealthEvent struct {
+	Geo       *config.ConnectorConfig
+	ProbeTime time.Time
+	// APIServerError is empty if the API server of the cluster is reachable
+	APIServerError string
+	// GatewayError is empty if the gateway of the cluster is reachable
+	GatewayError string
+	// FsmVersion is the version of FSM installed in the cluster, it's empty if it's unknown
+	FsmVersion string
+}
+
+func (e *ClusterHealthEvent) ClusterKey() string {
+	return e.Geo.Key()
+}
+
+// IsReady returns true if both the API server and the gateway are reachable
+func (e *ClusterHealthEvent) IsReady() bool {
+	return e.APIServerError == "" && e.GatewayError == ""
+}
+
 //func NewServiceExportMessage(eventType EventType, geo *config.ConnectorConfig, serviceExport *svcexpv1alpha1.ServiceExport, svc *corev1.Service, data map[string]interface{}) *Message {
 //	obj := ServiceExportEvent{Geo: geo, ServiceExport: serviceExport, Service: svc, Data: data}
 //
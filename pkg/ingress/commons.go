@@ -54,4 +54,8 @@ const (
 	PipyIngressAnnotationTLSVerifyDepth     = PipyIngressAnnotationPrefix + "/tls-verify-depth"
 	PipyIngressAnnotationTLSTrustedCASecret = PipyIngressAnnotationPrefix + "/tls-trusted-ca-secret"
 	PipyIngressAnnotationBackendProtocol    = PipyIngressAnnotationPrefix + "/upstream-protocol"
+
+	// Annotations of Service, control how the ingress balancer selects endpoints of the service
+	PipyIngressAnnotationTopologyAwareRouting      = PipyIngressAnnotationPrefix + "/topology-aware-routing"
+	PipyIngressAnnotationTopologyMinLocalEndpoints = PipyIngressAnnotationPrefix + "/topology-min-local-endpoints"
 )
@@ -32,6 +32,7 @@ import (
 	pfhelper "github.com/flomesh-io/fsm-classic/apis/proxyprofile/v1alpha1/helper"
 	"github.com/flomesh-io/fsm-classic/pkg/commons"
 	"github.com/flomesh-io/fsm-classic/pkg/config"
+	"github.com/flomesh-io/fsm-classic/pkg/repo"
 	"github.com/flomesh-io/fsm-classic/pkg/util"
 	"github.com/ghodss/yaml"
 	corev1 "k8s.io/api/core/v1"
@@ -40,6 +41,10 @@ import (
 	"strings"
 )
 
+const (
+	repoClientTLSVolumeName = "repo-tls"
+)
+
 //go:embed tpl/default-env.yaml
 var defaultEnvBytes []byte
 
@@ -79,6 +84,13 @@ func (pi *ProxyInjector) toSidecarTemplate(pod *corev1.Pod, pf *pfv1alpha1.Proxy
 			template.InitContainers,
 			pi.defaultRemoteConfigModeInitContainer(pf, mc),
 		)
+
+		if len(mc.RepoClientTLSArgs()) > 0 {
+			if err := repo.EnsureClientTLSSecret(pi.K8sAPI.Client, mc.GetMeshNamespace(), pod.Namespace); err != nil {
+				return nil, err
+			}
+			template.Volumes = append(template.Volumes, repoClientTLSVolume())
+		}
 	default:
 		// do nothing
 	}
@@ -144,6 +156,11 @@ func (pi *ProxyInjector) sidecar(sidecar pfv1alpha1.Sidecar, pf *pfv1alpha1.Prox
 	// This's a fix for issue of current entrypoint of Docker image
 	if len(sidecar.Command) == 0 && len(sidecar.Args) == 0 {
 		c.Command = []string{"/bin/sh", "-c", fmt.Sprintf("pipy $(%s)", commons.PipyProxyConfigFileEnvName)}
+
+		// certificates to load codebase from https:// repo
+		if tlsArgs := mc.RepoClientTLSArgs(); pf.GetConfigMode() == pfv1alpha1.ProxyConfigModeRemote && len(tlsArgs) > 0 {
+			c.Command[2] = fmt.Sprintf("pipy %s $(%s)", strings.Join(tlsArgs, " "), commons.PipyProxyConfigFileEnvName)
+		}
 	}
 
 	volumeMount := corev1.VolumeMount{
@@ -152,6 +169,14 @@ func (pi *ProxyInjector) sidecar(sidecar pfv1alpha1.Sidecar, pf *pfv1alpha1.Prox
 	}
 	c.VolumeMounts = append(c.VolumeMounts, volumeMount)
 
+	if pf.GetConfigMode() == pfv1alpha1.ProxyConfigModeRemote && len(mc.RepoClientTLSArgs()) > 0 {
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
+			Name:      repoClientTLSVolumeName,
+			MountPath: commons.RepoClientTLSMountPath,
+			ReadOnly:  true,
+		})
+	}
+
 	c.Resources = sidecar.Resources
 
 	return c
@@ -238,6 +263,17 @@ func emptyDir() corev1.Volume {
 	return emptyDirVolume
 }
 
+func repoClientTLSVolume() corev1.Volume {
+	return corev1.Volume{
+		Name: repoClientTLSVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: commons.RepoClientTLSSecretName,
+			},
+		},
+	}
+}
+
 func (pi *ProxyInjector) defaultRemoteConfigModeInitContainer(pf *pfv1alpha1.ProxyProfile, mc *config.MeshConfig) corev1.Container {
 	c := corev1.Container{}
 	c.Name = "proxy-init"
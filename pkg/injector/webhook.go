@@ -33,6 +33,7 @@ import (
 	"github.com/flomesh-io/fsm-classic/pkg/commons"
 	"github.com/flomesh-io/fsm-classic/pkg/config"
 	"github.com/flomesh-io/fsm-classic/pkg/kube"
+	"github.com/flomesh-io/fsm-classic/pkg/metrics"
 	admissionregv1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -87,7 +88,11 @@ type ProxyInjector struct {
 	K8sAPI      *kube.K8sAPI
 }
 
-func (pi *ProxyInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
+func (pi *ProxyInjector) Handle(ctx context.Context, req admission.Request) (resp admission.Response) {
+	defer func() {
+		metrics.ObserveAdmission(metrics.WebhookInjector, req, resp)
+	}()
+
 	pod := &corev1.Pod{}
 
 	if err := pi.decoder.Decode(req, pod); err != nil {
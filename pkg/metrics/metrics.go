@@ -0,0 +1,417 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strconv"
+	"time"
+)
+
+const (
+	namespace = "fsm"
+
+	cacheSubsystem       = "cache"
+	repoSubsystem        = "repo"
+	webhookSubsystem     = "webhook"
+	flbSubsystem         = "flb"
+	certificateSubsystem = "certificate"
+	clusterSubsystem     = "cluster"
+)
+
+// Label names, they're shared by all metrics so that the same dimension is always labelled the same way
+const (
+	labelType       = "type"
+	labelCodebase   = "codebase"
+	labelMethod     = "method"
+	labelEndpoint   = "endpoint"
+	labelStatus     = "status"
+	labelResult     = "result"
+	labelWebhook    = "webhook"
+	labelKind       = "kind"
+	labelOperation  = "operation"
+	labelManager    = "manager"
+	labelCommonName = "common_name"
+	labelCluster    = "cluster"
+)
+
+const (
+	// RouteTypeService is the label value of service routes
+	RouteTypeService = "service"
+	// RouteTypeIngress is the label value of ingress routes
+	RouteTypeIngress = "ingress"
+
+	// ResultSuccess and ResultFailure are the label values of operation results
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+
+	// StatusError is the label value of status if no response is received
+	StatusError = "error"
+
+	// RepoEndpointCodebases and RepoEndpointFiles are the label values of Pipy repo API endpoints
+	RepoEndpointCodebases = "codebases"
+	RepoEndpointFiles     = "files"
+
+	// WebhookMutating, WebhookValidating and WebhookInjector are the label values of webhooks
+	WebhookMutating   = "mutating"
+	WebhookValidating = "validating"
+	WebhookInjector   = "injector"
+
+	// AdmissionAllowed, AdmissionDenied and AdmissionErrored are the label values of admission decisions
+	AdmissionAllowed = "allowed"
+	AdmissionDenied  = "denied"
+	AdmissionErrored = "errored"
+
+	// ClusterTypeLocal and ClusterTypeRemote are the label values of cluster connectors
+	ClusterTypeLocal  = "local"
+	ClusterTypeRemote = "remote"
+)
+
+var (
+	// CacheSyncDuration is the time taken to build routes from the cache
+	CacheSyncDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: cacheSubsystem,
+			Name:      "sync_duration_seconds",
+			Help:      "Time taken to compute routes from the cache in seconds",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+		},
+	)
+
+	// CacheSyncsTotal is the total number of syncs
+	CacheSyncsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: cacheSubsystem,
+			Name:      "syncs_total",
+			Help:      "Total number of cache syncs",
+		},
+	)
+
+	// CacheRoutes is the number of routes of last sync, by codebase and route type
+	CacheRoutes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: cacheSubsystem,
+			Name:      "routes",
+			Help:      "Number of routes computed by the last cache sync",
+		},
+		[]string{labelCodebase, labelType},
+	)
+
+	// CacheEndpoints is the number of endpoints of last sync, by codebase and route type
+	CacheEndpoints = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: cacheSubsystem,
+			Name:      "endpoints",
+			Help:      "Number of endpoints computed by the last cache sync",
+		},
+		[]string{labelCodebase, labelType},
+	)
+
+	// RepoPushDuration is the time taken to push routes to the repo, by route type
+	RepoPushDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: repoSubsystem,
+			Name:      "push_duration_seconds",
+			Help:      "Time taken to push routes to the repo in seconds",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		},
+		[]string{labelType},
+	)
+
+	// RepoPushFailuresTotal is the total number of failed pushes, by route type
+	RepoPushFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: repoSubsystem,
+			Name:      "push_failures_total",
+			Help:      "Total number of failed pushes of routes to the repo",
+		},
+		[]string{labelType},
+	)
+
+	// RepoRequestDuration is the latency of requests to the Pipy repo API, by method and endpoint
+	RepoRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: repoSubsystem,
+			Name:      "request_duration_seconds",
+			Help:      "Latency of requests to the repo API in seconds",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		},
+		[]string{labelMethod, labelEndpoint},
+	)
+
+	// RepoRequestsTotal is the total number of requests to the Pipy repo API, by method, endpoint and status
+	RepoRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: repoSubsystem,
+			Name:      "requests_total",
+			Help:      "Total number of requests to the repo API",
+		},
+		[]string{labelMethod, labelEndpoint, labelStatus},
+	)
+
+	// RepoRequestErrorsTotal is the total number of failed requests to the Pipy repo API, by method, endpoint and
+	// status, the status is error if no response is received
+	RepoRequestErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: repoSubsystem,
+			Name:      "request_errors_total",
+			Help:      "Total number of failed requests to the repo API",
+		},
+		[]string{labelMethod, labelEndpoint, labelStatus},
+	)
+
+	// RepoBatchesTotal is the total number of batches, by codebase and result
+	RepoBatchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: repoSubsystem,
+			Name:      "batches_total",
+			Help:      "Total number of batches uploaded to the repo",
+		},
+		[]string{labelCodebase, labelResult},
+	)
+
+	// RepoCommitsTotal is the total number of commits, by codebase and result
+	RepoCommitsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: repoSubsystem,
+			Name:      "commits_total",
+			Help:      "Total number of codebase commits to the repo",
+		},
+		[]string{labelCodebase, labelResult},
+	)
+
+	// WebhookAdmissionsTotal is the total number of admission decisions, by webhook, kind, operation and result
+	WebhookAdmissionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: webhookSubsystem,
+			Name:      "admissions_total",
+			Help:      "Total number of admission decisions made by webhooks",
+		},
+		[]string{labelWebhook, labelKind, labelOperation, labelResult},
+	)
+
+	// FLBRequestDuration is the latency of requests to the FLB API, by operation
+	FLBRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: flbSubsystem,
+			Name:      "request_duration_seconds",
+			Help:      "Latency of requests to the FLB API in seconds",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		},
+		[]string{labelOperation},
+	)
+
+	// FLBRequestsTotal is the total number of requests to the FLB API, by operation and status
+	FLBRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: flbSubsystem,
+			Name:      "requests_total",
+			Help:      "Total number of requests to the FLB API",
+		},
+		[]string{labelOperation, labelStatus},
+	)
+
+	// FLBRequestFailuresTotal is the total number of failed requests to the FLB API, by operation
+	FLBRequestFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: flbSubsystem,
+			Name:      "request_failures_total",
+			Help:      "Total number of failed requests to the FLB API",
+		},
+		[]string{labelOperation},
+	)
+
+	// CertificatesIssuedTotal is the total number of certificates issued, by certificate manager and result
+	CertificatesIssuedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: certificateSubsystem,
+			Name:      "issued_total",
+			Help:      "Total number of certificates issued",
+		},
+		[]string{labelManager, labelResult},
+	)
+
+	// CertificateExpirationTimestamp is the expiration time of certificates, by certificate manager and common name
+	CertificateExpirationTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: certificateSubsystem,
+			Name:      "expiration_timestamp_seconds",
+			Help:      "Expiration time of certificates in unix seconds",
+		},
+		[]string{labelManager, labelCommonName},
+	)
+
+	// ClusterConnectorUp is 1 if the connector of cluster is running, by cluster and cluster type
+	ClusterConnectorUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: clusterSubsystem,
+			Name:      "connector_up",
+			Help:      "Whether the connector of cluster is running",
+		},
+		[]string{labelCluster, labelType},
+	)
+)
+
+func init() {
+	// Registers to the controller-runtime registry, so that they're exposed by the metrics endpoint of manager
+	metrics.Registry.MustRegister(
+		CacheSyncDuration,
+		CacheSyncsTotal,
+		CacheRoutes,
+		CacheEndpoints,
+		RepoPushDuration,
+		RepoPushFailuresTotal,
+		RepoRequestDuration,
+		RepoRequestsTotal,
+		RepoRequestErrorsTotal,
+		RepoBatchesTotal,
+		RepoCommitsTotal,
+		WebhookAdmissionsTotal,
+		FLBRequestDuration,
+		FLBRequestsTotal,
+		FLBRequestFailuresTotal,
+		CertificatesIssuedTotal,
+		CertificateExpirationTimestamp,
+		ClusterConnectorUp,
+	)
+}
+
+// ObserveRepoPush records the duration and result of a push to the repo
+func ObserveRepoPush(routeType string, start time.Time, err error) {
+	RepoPushDuration.WithLabelValues(routeType).Observe(time.Since(start).Seconds())
+	if err != nil {
+		RepoPushFailuresTotal.WithLabelValues(routeType).Inc()
+	}
+}
+
+// ObserveRepoRequest records a request to the repo API, statusCode is 0 if no response is received
+func ObserveRepoRequest(method, endpoint string, statusCode int, duration time.Duration) {
+	status := statusLabel(statusCode)
+	RepoRequestDuration.WithLabelValues(method, endpoint).Observe(duration.Seconds())
+	RepoRequestsTotal.WithLabelValues(method, endpoint, status).Inc()
+	if statusCode == 0 || statusCode >= http.StatusBadRequest {
+		RepoRequestErrorsTotal.WithLabelValues(method, endpoint, status).Inc()
+	}
+}
+
+// ObserveRepoBatch records the result of a batch uploaded to the codebase
+func ObserveRepoBatch(codebase string, err error) {
+	RepoBatchesTotal.WithLabelValues(codebase, resultLabel(err)).Inc()
+}
+
+// ObserveRepoCommit records the result of a commit of the codebase
+func ObserveRepoCommit(codebase string, err error) {
+	RepoCommitsTotal.WithLabelValues(codebase, resultLabel(err)).Inc()
+}
+
+// ObserveAdmission records the decision of an admission request made by the webhook
+func ObserveAdmission(webhook string, req admission.Request, resp admission.Response) {
+	WebhookAdmissionsTotal.WithLabelValues(webhook, req.Kind.Kind, string(req.Operation), admissionResult(resp.AdmissionResponse)).Inc()
+}
+
+// ObserveFLBRequest records a request to the FLB API, statusCode is 0 if no response is received
+func ObserveFLBRequest(operation string, start time.Time, statusCode int) {
+	FLBRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	FLBRequestsTotal.WithLabelValues(operation, statusLabel(statusCode)).Inc()
+	if statusCode != http.StatusOK {
+		FLBRequestFailuresTotal.WithLabelValues(operation).Inc()
+	}
+}
+
+// ObserveCertificateIssued records the result of issuing a certificate and its expiration
+func ObserveCertificateIssued(manager, commonName string, expiration time.Time, err error) {
+	CertificatesIssuedTotal.WithLabelValues(manager, resultLabel(err)).Inc()
+	if err == nil {
+		SetCertificateExpiration(manager, commonName, expiration)
+	}
+}
+
+// SetCertificateExpiration records the expiration of a certificate
+func SetCertificateExpiration(manager, commonName string, expiration time.Time) {
+	CertificateExpirationTimestamp.WithLabelValues(manager, commonName).Set(float64(expiration.Unix()))
+}
+
+// SetClusterConnectorUp records whether the connector of cluster is running
+func SetClusterConnectorUp(cluster, clusterType string, up bool) {
+	value := 0.0
+	if up {
+		value = 1.0
+	}
+	ClusterConnectorUp.WithLabelValues(cluster, clusterType).Set(value)
+}
+
+// DeleteClusterConnector removes the status of the connector once the cluster is gone
+func DeleteClusterConnector(cluster string) {
+	ClusterConnectorUp.DeletePartialMatch(prometheus.Labels{labelCluster: cluster})
+}
+
+func statusLabel(statusCode int) string {
+	if statusCode == 0 {
+		return StatusError
+	}
+
+	return strconv.Itoa(statusCode)
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return ResultFailure
+	}
+
+	return ResultSuccess
+}
+
+func admissionResult(resp admissionv1.AdmissionResponse) string {
+	switch {
+	case resp.Allowed:
+		return AdmissionAllowed
+	case resp.Result != nil && (resp.Result.Code == http.StatusBadRequest || resp.Result.Code >= http.StatusInternalServerError):
+		return AdmissionErrored
+	default:
+		return AdmissionDenied
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// FileBackendScheme is the scheme of repo root URL for the filesystem backend, i.e. file:///var/lib/fsm/repo
+	FileBackendScheme = "file"
+	// MemoryBackendScheme is the scheme of repo root URL for the in-memory backend, i.e. mem://
+	MemoryBackendScheme = "mem"
+	// ConfigMapBackendScheme is the scheme of repo root URL for the ConfigMap backend, the host is the namespace of
+	// ConfigMaps, i.e. configmap://flomesh
+	ConfigMapBackendScheme = "configmap"
+)
+
+// Backend is the storage of codebases, PipyRepoClient implements batching, retrying and conflict detection on top of it.
+// All errors returned by a Backend should be typed as RepoError.
+type Backend interface {
+	// GetCodebase returns the codebase, a NotFound error is returned if it doesn't exist.
+	// Files of the codebase are its own files, the ones inherited from bases are not included.
+	GetCodebase(ctx context.Context, path string) (*Codebase, error)
+	// CreateCodebase creates an empty codebase with version 1
+	CreateCodebase(ctx context.Context, path string) (*Codebase, error)
+	// DeriveCodebase creates a codebase with version 1 which inherits files from the base
+	DeriveCodebase(ctx context.Context, path, base string) (*Codebase, error)
+	// CommitCodebase publishes the changes of codebase with the new version. It should fail with a Conflict error if
+	// version is not newer than the current one, the precondition makes the commit of concurrent writers atomic.
+	CommitCodebase(ctx context.Context, path string, version int64) error
+	// DeleteCodebase deletes the codebase, it's a no-op if it doesn't exist
+	DeleteCodebase(ctx context.Context, path string) error
+	// ListCodebases returns paths of all codebases
+	ListCodebases(ctx context.Context) ([]string, error)
+	// GetFile returns the content of file, path is the full path including the codebase path
+	GetFile(ctx context.Context, path string) (string, error)
+	// UpsertFile creates or updates the file, path is the full path including the codebase path
+	UpsertFile(ctx context.Context, path string, content interface{}) error
+	// DeleteFile deletes the file, it's a no-op if it doesn't exist
+	DeleteFile(ctx context.Context, path string) error
+}
+
+// stagingBackend is implemented by the backends which stage changes of a codebase in the process until it's
+// committed. The staged changes are shared by all writers of the codebase, so PipyRepoClient serializes them and
+// discards the staged changes of a failed write, they never leak into the next commit.
+type stagingBackend interface {
+	Backend
+	// DiscardStaged drops the uncommitted changes of the codebase
+	DiscardStaged(ctx context.Context, path string) error
+}
+
+// NewBackend creates the backend by the scheme of repo root URL, file:// for the filesystem backend,
+// configmap:// for the ConfigMap backend, mem:// for the in-memory backend and all others for the Pipy repo HTTP backend.
+func NewBackend(repoRootUrl string) (Backend, error) {
+	u, err := url.Parse(repoRootUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case FileBackendScheme:
+		if u.Path == "" {
+			return nil, fmt.Errorf("root directory of file backend is required in %q", repoRootUrl)
+		}
+		return NewFileBackend(u.Path), nil
+	case ConfigMapBackendScheme:
+		if u.Host == "" {
+			return nil, fmt.Errorf("namespace of ConfigMap backend is required in %q", repoRootUrl)
+		}
+		return newConfigMapBackend(u.Host)
+	case MemoryBackendScheme:
+		return NewMemoryBackend(), nil
+	default:
+		return NewHTTPBackend(repoRootUrl, defaultTransport()), nil
+	}
+}
+
+// contentBytes serializes file content, strings and bytes are kept as is, others are encoded as JSON
+func contentBytes(content interface{}) ([]byte, error) {
+	switch c := content.(type) {
+	case string:
+		return []byte(c), nil
+	case []byte:
+		return c, nil
+	default:
+		return json.MarshalIndent(c, "", "  ")
+	}
+}
@@ -0,0 +1,281 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package repo
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testBackends(t *testing.T) map[string]Backend {
+	return map[string]Backend{
+		"memory":    NewMemoryBackend(),
+		"file":      NewFileBackend(t.TempDir()),
+		"configmap": NewConfigMapBackend(fake.NewSimpleClientset(), "flomesh"),
+	}
+}
+
+func TestBackendCodebases(t *testing.T) {
+	ctx := context.TODO()
+
+	for name, backend := range testBackends(t) {
+		steps := []struct {
+			name string
+			run  func() error
+			// expectedErr is the reason of the expected error, empty if no error is expected
+			expectedErr ErrorReason
+		}{
+			{"create base", func() error { _, err := backend.CreateCodebase(ctx, "/base"); return err }, ""},
+			{"create existing base", func() error { _, err := backend.CreateCodebase(ctx, "/base"); return err }, ErrorReasonConflict},
+			{"derive from missing base", func() error { _, err := backend.DeriveCodebase(ctx, "/derived", "/missing"); return err }, ErrorReasonNotFound},
+			{"upsert main.js", func() error { return backend.UpsertFile(ctx, "/base/main.js", "base") }, ""},
+			{"upsert config", func() error { return backend.UpsertFile(ctx, "/base/config/main.json", map[string]string{"a": "b"}) }, ""},
+			{"upsert to missing codebase", func() error { return backend.UpsertFile(ctx, "/missing/main.js", "") }, ErrorReasonNotFound},
+			{"commit base", func() error { return backend.CommitCodebase(ctx, "/base", 2) }, ""},
+			{"commit stale version", func() error { return backend.CommitCodebase(ctx, "/base", 2) }, ErrorReasonConflict},
+			{"derive from base", func() error { _, err := backend.DeriveCodebase(ctx, "/base/derived", "/base"); return err }, ""},
+			{"override in derived", func() error { return backend.UpsertFile(ctx, "/base/derived/main.js", "derived") }, ""},
+			{"commit derived", func() error { return backend.CommitCodebase(ctx, "/base/derived", 2) }, ""},
+			{"delete config of base", func() error { return backend.DeleteFile(ctx, "/base/config/main.json") }, ""},
+			{"delete missing file", func() error { return backend.DeleteFile(ctx, "/base/missing.js") }, ""},
+			{"commit base again", func() error { return backend.CommitCodebase(ctx, "/base", 3) }, ""},
+		}
+
+		for _, step := range steps {
+			err := step.run()
+			switch {
+			case step.expectedErr == "" && err != nil:
+				t.Fatalf("[%s] %s: unexpected error %s", name, step.name, err)
+			case step.expectedErr != "" && ReasonForError(err) != step.expectedErr:
+				t.Fatalf("[%s] %s: expected error of reason %s, got %v", name, step.name, step.expectedErr, err)
+			}
+		}
+
+		files := []struct {
+			path     string
+			expected string
+			notFound bool
+		}{
+			{path: "/base/main.js", expected: "base"},
+			{path: "/base/derived/main.js", expected: "derived"},
+			{path: "/base/config/main.json", notFound: true},
+			{path: "/base/derived/config/main.json", notFound: true},
+		}
+		for _, f := range files {
+			content, err := backend.GetFile(ctx, f.path)
+			switch {
+			case f.notFound && !IsNotFound(err):
+				t.Errorf("[%s] expected %s not found, got %q, %v", name, f.path, content, err)
+			case !f.notFound && (err != nil || content != f.expected):
+				t.Errorf("[%s] expected %s to be %q, got %q, %v", name, f.path, f.expected, content, err)
+			}
+		}
+
+		codebase, err := backend.GetCodebase(ctx, "/base")
+		if err != nil {
+			t.Fatalf("[%s] unexpected error %s", name, err)
+		}
+		if codebase.Version != 3 || !reflect.DeepEqual(codebase.Files, []string{"/main.js"}) ||
+			!reflect.DeepEqual(codebase.Derived, []string{"/base/derived"}) {
+			t.Errorf("[%s] unexpected codebase %#v", name, codebase)
+		}
+
+		codebases, err := backend.ListCodebases(ctx)
+		if err != nil || !reflect.DeepEqual(codebases, []string{"/base", "/base/derived"}) {
+			t.Errorf("[%s] unexpected codebases %v, %v", name, codebases, err)
+		}
+
+		if err := backend.DeleteCodebase(ctx, "/base/derived"); err != nil {
+			t.Errorf("[%s] unexpected error %s", name, err)
+		}
+		if _, err := backend.GetCodebase(ctx, "/base/derived"); !IsNotFound(err) {
+			t.Errorf("[%s] expected deleted codebase not found, got %v", name, err)
+		}
+	}
+}
+
+func TestBackendStagedChanges(t *testing.T) {
+	ctx := context.TODO()
+
+	backends := testBackends(t)
+	// changes of the memory backend take effect immediately
+	delete(backends, "memory")
+
+	for name, backend := range backends {
+		if _, err := backend.CreateCodebase(ctx, "/base"); err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.UpsertFile(ctx, "/base/main.js", "v1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := backend.GetFile(ctx, "/base/main.js"); !IsNotFound(err) {
+			t.Errorf("[%s] expected uncommitted file not found, got %v", name, err)
+		}
+
+		if err := backend.CommitCodebase(ctx, "/base", 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.UpsertFile(ctx, "/base/main.js", "v2"); err != nil {
+			t.Fatal(err)
+		}
+		if content, _ := backend.GetFile(ctx, "/base/main.js"); content != "v1" {
+			t.Errorf("[%s] expected committed content v1, got %q", name, content)
+		}
+
+		if err := backend.CommitCodebase(ctx, "/base", 3); err != nil {
+			t.Fatal(err)
+		}
+		if content, _ := backend.GetFile(ctx, "/base/main.js"); content != "v2" {
+			t.Errorf("[%s] expected committed content v2, got %q", name, content)
+		}
+	}
+}
+
+func TestConfigMapBackendSizeLimit(t *testing.T) {
+	ctx := context.TODO()
+	backend := NewConfigMapBackend(fake.NewSimpleClientset(), "flomesh")
+
+	if _, err := backend.CreateCodebase(ctx, "/base"); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.UpsertFile(ctx, "/base/large.json", make([]byte, configMapMaxSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.CommitCodebase(ctx, "/base", 2); err == nil {
+		t.Errorf("expected commit of codebase larger than ConfigMap limit to fail")
+	}
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.TODO()
+	backend := NewMemoryBackend()
+	if _, err := backend.CreateCodebase(ctx, "/base"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.DeriveCodebase(ctx, "/base/derived", "/base"); err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{"/base/main.js": "base", "/base/derived/config.json": "{}"} {
+		if err := backend.UpsertFile(ctx, path, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := httptest.NewServer(NewServer(backend, 0))
+	defer server.Close()
+
+	testCases := []struct {
+		path         string
+		expectedCode int
+		expectedBody string
+		expectedETag string
+	}{
+		{"/base/derived/", http.StatusOK, "/config.json\n/main.js", "1-1"},
+		{"/base/", http.StatusOK, "/main.js", "1"},
+		{"/base/derived/main.js", http.StatusOK, "base", ""},
+		{"/base/derived/missing.js", http.StatusNotFound, "", ""},
+		{"/missing/", http.StatusNotFound, "", ""},
+	}
+
+	for _, tc := range testCases {
+		resp, err := http.Get(server.URL + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.expectedCode {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.expectedCode, resp.StatusCode)
+			continue
+		}
+		if tc.expectedCode == http.StatusOK && string(body) != tc.expectedBody {
+			t.Errorf("%s: expected body %q, got %q", tc.path, tc.expectedBody, body)
+		}
+		if etag := resp.Header.Get("ETag"); etag != tc.expectedETag {
+			t.Errorf("%s: expected ETag %q, got %q", tc.path, tc.expectedETag, etag)
+		}
+	}
+}
+
+func TestServerForwardsReadsToPipyRepo(t *testing.T) {
+	var requests []string
+	pipyRepo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Header().Set("ETag", "2")
+		_, _ = w.Write([]byte("/main.js"))
+	}))
+	defer pipyRepo.Close()
+
+	server := httptest.NewServer(NewServer(NewHTTPBackendWithOptions(pipyRepo.URL, defaultTransport(), ClientOptions{}), 0))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/base/ingress/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "/main.js" || resp.Header.Get("ETag") != "2" {
+		t.Errorf("expected codebase of Pipy repo, got status %d, body %q, ETag %q", resp.StatusCode, body, resp.Header.Get("ETag"))
+	}
+
+	resp, err = http.Post(server.URL+"/base/ingress/main.js", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected writes to be rejected, got status %d", resp.StatusCode)
+	}
+
+	if !reflect.DeepEqual(requests, []string{"GET /repo/base/ingress/"}) {
+		t.Errorf("expected only the read to be forwarded to Pipy repo, got %v", requests)
+	}
+}
+
+func TestFailedBatchDiscardsStagedChanges(t *testing.T) {
+	ctx := context.TODO()
+	client := NewRepoClientWithBackend(NewConfigMapBackend(fake.NewSimpleClientset(), "flomesh"))
+
+	large := Batch{Basepath: "/base", Items: []BatchItem{{Filename: "large.json", Content: make([]byte, configMapMaxSize)}}}
+	if err := client.Batch(ctx, []Batch{large}); err == nil {
+		t.Fatalf("expected batch larger than ConfigMap limit to fail")
+	}
+
+	small := Batch{Basepath: "/base", Items: []BatchItem{{Filename: "main.js", Content: "main"}}}
+	if err := client.Batch(ctx, []Batch{small}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetFile("/base/large.json"); !IsNotFound(err) {
+		t.Errorf("expected file of the failed batch not committed, got %v", err)
+	}
+}
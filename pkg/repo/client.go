@@ -21,13 +21,17 @@
  * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
  * SOFTWARE.
  */
-
 package repo
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
-	"github.com/flomesh-io/fsm-classic/pkg/commons"
-	"github.com/go-resty/resty/v2"
+	"github.com/flomesh-io/fsm-classic/pkg/metrics"
+	"github.com/flomesh-io/fsm-classic/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 	"net/http"
 	"strings"
@@ -35,259 +39,314 @@ import (
 	"time"
 )
 
-type PipyRepoClient struct {
-	baseUrl          string
-	defaultTransport *http.Transport
-	httpClient       *resty.Client
-	mu               sync.Mutex
-}
-
-func NewRepoClient(repoRootUrl string) *PipyRepoClient {
-	return NewRepoClientWithTransport(
-		repoRootUrl,
-		&http.Transport{
-			DisableKeepAlives:  false,
-			MaxIdleConns:       10,
-			IdleConnTimeout:    60 * time.Second,
-			DisableCompression: false,
-		})
-}
-
-func NewRepoClientWithTransport(repoRootUrl string, transport *http.Transport) *PipyRepoClient {
-	return newRepoClientWithRepoRootUrlAndTransport(
-		repoRootUrl,
-		transport,
-	)
-}
-
-func newRepoClientWithRepoRootUrlAndTransport(repoRootUrl string, transport *http.Transport) *PipyRepoClient {
-	repo := &PipyRepoClient{
-		baseUrl:          repoRootUrl,
-		defaultTransport: transport,
+var (
+	// batchBackoff is the backoff of retrying a batch, it retries for about 1 minute in total
+	batchBackoff = wait.Backoff{
+		Duration: 500 * time.Millisecond,
+		Factor:   2.0,
+		Jitter:   0.1,
+		Steps:    7,
+		Cap:      30 * time.Second,
 	}
+)
 
-	repo.httpClient = resty.New().
-		SetTransport(repo.defaultTransport).
-		SetScheme(commons.DefaultHttpSchema).
-		SetAllowGetMethodPayload(true).
-		SetBaseURL(repo.baseUrl).
-		SetTimeout(5 * time.Second).
-		SetDebug(true).
-		EnableTrace()
-
-	return repo
-}
-
-func (p *PipyRepoClient) codebaseExists(path string) (bool, *Codebase) {
-	resp, err := p.httpClient.R().
-		SetResult(&Codebase{}).
-		Get(fullRepoApiPath(path))
+var (
+	// localBackends are the backends of the file, ConfigMap and memory schemes keyed by repo root URL, they're shared
+	// by all clients of the process, so that staged changes and the Kubernetes client are never built per client.
+	localBackends   = make(map[string]Backend)
+	localBackendsMu sync.Mutex
 
-	if err == nil {
-		switch resp.StatusCode() {
-		case http.StatusNotFound:
-			return false, nil
-		case http.StatusOK:
-			return true, resp.Result().(*Codebase)
-		}
-	}
+	// codebaseLocks serializes writes of a codebase to the staging backends, keyed by codebase path
+	codebaseLocks   = make(map[string]*sync.Mutex)
+	codebaseLocksMu sync.Mutex
+)
 
-	klog.Errorf("error happened while getting path %q, %#v", path, err)
-	return false, nil
+type PipyRepoClient struct {
+	backend Backend
 }
 
-func (p *PipyRepoClient) get(path string) (*Codebase, error) {
-	resp, err := p.httpClient.R().
-		SetResult(&Codebase{}).
-		Get(fullRepoApiPath(path))
-
+// NewRepoClient creates the client with the backend selected by the scheme of repo root URL, see NewBackend.
+// Clients of the same URL share the backend unless it's the Pipy repo HTTP backend.
+// If the URL is invalid, it falls back to the Pipy repo HTTP backend.
+func NewRepoClient(repoRootUrl string) *PipyRepoClient {
+	backend, err := localBackend(repoRootUrl)
 	if err != nil {
-		klog.Errorf("Failed to get path %q, error: %s", path, err.Error())
-		return nil, err
+		klog.Errorf("Failed to create repo backend for %q, falls back to HTTP backend: %s", repoRootUrl, err)
+		backend = NewHTTPBackend(repoRootUrl, defaultTransport())
 	}
 
-	return resp.Result().(*Codebase), nil
+	return NewRepoClientWithBackend(backend)
 }
 
-func (p *PipyRepoClient) createCodebase(path string) (*Codebase, error) {
-	resp, err := p.httpClient.R().
-		SetHeader("Content-Type", "application/json").
-		SetBody(Codebase{Version: 1}).
-		Post(fullRepoApiPath(path))
-
-	if err != nil {
-		klog.Errorf("failed to create codebase %q, error: %s", path, err.Error())
-		return nil, err
-	}
+// localBackend returns the shared backend of the URL, the Pipy repo HTTP backend is created every time
+func localBackend(repoRootUrl string) (Backend, error) {
+	localBackendsMu.Lock()
+	defer localBackendsMu.Unlock()
 
-	if resp.IsError() {
-		return nil, fmt.Errorf("failed to create codebase %q, reason: %s", path, resp.Status())
+	if backend, ok := localBackends[repoRootUrl]; ok {
+		return backend, nil
 	}
 
-	codebase, err := p.get(path)
+	backend, err := NewBackend(repoRootUrl)
 	if err != nil {
 		return nil, err
 	}
+	if _, ok := backend.(*HTTPBackend); !ok {
+		localBackends[repoRootUrl] = backend
+	}
 
-	return codebase, nil
+	return backend, nil
 }
 
-func (p *PipyRepoClient) deriveCodebase(path, base string) (*Codebase, error) {
-	exists, _ := p.codebaseExists(base)
-	if !exists {
-		return nil, fmt.Errorf("parent %q of codebase %q doesn't exists", base, path)
+// lockCodebase locks writes of the codebase, the returned func unlocks it
+func lockCodebase(path string) func() {
+	codebaseLocksMu.Lock()
+	mu, ok := codebaseLocks[path]
+	if !ok {
+		mu = &sync.Mutex{}
+		codebaseLocks[path] = mu
 	}
+	codebaseLocksMu.Unlock()
 
-	resp, err := p.httpClient.R().
-		SetHeader("Content-Type", "application/json").
-		SetBody(Codebase{Version: 1, Base: base}).
-		Post(fullRepoApiPath(path))
+	mu.Lock()
+	return mu.Unlock
+}
 
-	if err != nil {
-		klog.Errorf("Failed to derive codebase codebase: path: %q, base: %q, error: %s", path, base, err.Error())
-		return nil, err
+func NewRepoClientWithTransport(repoRootUrl string, transport *http.Transport) *PipyRepoClient {
+	return NewRepoClientWithBackend(NewHTTPBackend(repoRootUrl, transport))
+}
+
+func NewRepoClientWithBackend(backend Backend) *PipyRepoClient {
+	return &PipyRepoClient{
+		backend: backend,
 	}
+}
 
-	switch resp.StatusCode() {
-	case http.StatusOK, http.StatusCreated:
-		klog.V(5).Infof("Status code is %d, stands for success.", resp.StatusCode())
-	default:
-		klog.Errorf("Response contains error: %#v", resp.Status())
-		return nil, fmt.Errorf("failed to derive codebase codebase: path: %q, base: %q, reason: %s", path, base, resp.Status())
+// NewServer creates the server which serves the codebases of the backend of client to Pipy, it serves over TLS if
+// tlsConfig isn't nil
+func (p *PipyRepoClient) NewServer(port int32, tlsConfig *tls.Config) *Server {
+	return NewTLSServer(p.backend, port, tlsConfig)
+}
+
+func (p *PipyRepoClient) codebaseExists(path string) (bool, *Codebase) {
+	codebase, err := p.backend.GetCodebase(context.TODO(), path)
+	if err == nil {
+		return true, codebase
 	}
 
-	klog.V(5).Infof("Getting info of codebase %q", path)
-	codebase, err := p.get(path)
-	if err != nil {
-		klog.V(5).Infof("Failed to get info of codebase %q", path)
-		return nil, err
+	if !IsNotFound(err) {
+		klog.Errorf("error happened while getting path %q, %s", path, err)
 	}
 
-	klog.V(5).Infof("Successfully derived codebase: %#v", codebase)
-	return codebase, nil
+	return false, nil
 }
 
 func (p *PipyRepoClient) GetFile(path string) (string, error) {
-	resp, err := p.httpClient.R().
-		Get(fullFileApiPath(path))
-
+	result, err := p.backend.GetFile(context.TODO(), path)
 	if err != nil {
 		klog.Errorf("Failed to get path %q, error: %s", path, err.Error())
 		return "", err
 	}
 
-	result := string(resp.Body())
 	klog.V(5).Infof("Content of %q:\n\n\n%s\n\n\n", path, result)
 
 	return result, nil
 }
 
-func (p *PipyRepoClient) upsertFile(path string, content interface{}) error {
-	// FIXME: temp solution, refine it later
-	contentType := "text/plain"
-	if strings.HasSuffix(path, ".json") {
-		contentType = "application/json"
-	}
-
-	resp, err := p.httpClient.R().
-		SetHeader("Content-Type", contentType).
-		SetBody(content).
-		Post(fullFileApiPath(path))
-
+func (p *PipyRepoClient) deleteCodebase(ctx context.Context, path string) error {
+	codebase, err := p.backend.GetCodebase(ctx, path)
 	if err != nil {
-		klog.Errorf("error happened while trying to upsert %q to repo, %s", path, err.Error())
+		if IsNotFound(err) {
+			klog.V(5).Infof("Codebase %q doesn't exist, skip deleting", path)
+			return nil
+		}
+
 		return err
 	}
 
-	if resp.IsSuccess() {
-		return nil
+	// A codebase cannot be removed while other codebases derive it, delete the derived ones first
+	for _, derived := range codebase.Derived {
+		if err := p.deleteCodebase(ctx, derived); err != nil {
+			return err
+		}
 	}
 
-	errstr := "repo server responsed with error HTTP code: %d, error: %s"
-	klog.Errorf(errstr, resp.StatusCode(), resp.Status())
-	return fmt.Errorf(errstr, resp.StatusCode(), resp.Status())
-}
+	if err := p.backend.DeleteCodebase(ctx, path); err != nil {
+		klog.Errorf("error happened while trying to delete codebase %q, %s", path, err.Error())
+		return err
+	}
 
-func (p *PipyRepoClient) delete(path string) {
-	// DELETE, as pipy repo doesn't support deletion yet, this's not implemented
-	panic("implement me")
+	klog.V(5).Infof("Codebase %q is deleted", path)
+	return nil
 }
 
-// Commit the codebase, version is the current vesion of the codebase, it will be increased by 1 when committing
-func (p *PipyRepoClient) commit(path string, version int64) error {
-	resp, err := p.httpClient.R().
-		SetHeader("Content-Type", "application/json").
-		SetBody(Codebase{Version: version + 1}).
-		SetResult(&Codebase{}).
-		Patch(fullRepoApiPath(path))
-
+// Commit the codebase, version is the current vesion of the codebase, it will be increased by 1 when committing.
+// If the version of codebase has been changed by another writer since it's read, a Conflict error is returned.
+// Only the memory, file and ConfigMap backends make it a real compare-and-swap, it's get-then-commit with the Pipy repo
+// HTTP backend, so callers must not rely on it to serialize concurrent writers of the same codebase.
+func (p *PipyRepoClient) commit(ctx context.Context, path string, version int64) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "repo.Commit",
+		trace.WithAttributes(attribute.String("repo.codebase", path), attribute.Int64("repo.version", version+1)),
+	)
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
+	// The memory, file and ConfigMap backends reject a commit whose version is not newer, so only one of the concurrent
+	// writers read the same version can commit. Pipy repo has no such precondition, the check below narrows the window but two
+	// writers may still both pass it and both commit. In that case, and whenever a commit is rejected, the files
+	// uploaded by the losing writer are left in the codebase as they cannot be reverted, the losing writer retries
+	// with all files of its batch, so the codebase converges to the complete files of the last successful batch.
+	current, err := p.backend.GetCodebase(ctx, path)
 	if err != nil {
 		return err
 	}
 
-	if resp.IsSuccess() {
-		return nil
+	if current.Version != version {
+		return newRepoError(ErrorReasonConflict, path, "failed to commit codebase",
+			fmt.Errorf("version is changed from %d to %d", version, current.Version))
 	}
 
-	err = fmt.Errorf("failed to commit codebase %q, reason: %s", path, resp.Status())
-	klog.Error(err)
+	err = p.backend.CommitCodebase(ctx, path, version+1)
+	metrics.ObserveRepoCommit(path, err)
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
 
-	return err
+	return nil
 }
 
-// TODO: handle concurrent updating
+// Batch uploads files of each batch to the codebase and commits it. Once the commit conflicts with another writer or
+// the repo is unavailable, the batch is retried from fresh state with backoff until it succeeds or ctx is done.
+func (p *PipyRepoClient) Batch(ctx context.Context, batches []Batch) error {
+	return p.BatchIf(ctx, batches, nil)
+}
 
-func (p *PipyRepoClient) Batch(batches []Batch) error {
+// BatchIf is Batch with the precondition checked before each commit, including the retried ones. Once the
+// precondition returns an error, the batches fail with it without retrying, the precondition is ignored if it's nil.
+func (p *PipyRepoClient) BatchIf(ctx context.Context, batches []Batch, precondition func(ctx context.Context) error) error {
 	if len(batches) == 0 {
 		return nil
 	}
 
 	for _, batch := range batches {
-		// 1. batch.Basepath, if not exists, create it
-		klog.V(5).Infof("batch.Basepath = %q", batch.Basepath)
-		var version = int64(-1)
-		exists, codebase := p.codebaseExists(batch.Basepath)
-		if exists {
-			// just get the version of codebase
-			version = codebase.Version
-		} else {
-			klog.V(5).Infof("%q doesn't exist in repo", batch.Basepath)
-			result, err := p.createCodebase(batch.Basepath)
-			if err != nil {
-				klog.Errorf("Not able to create the codebase %q, reason: %s", batch.Basepath, err.Error())
-				return err
-			}
-
-			klog.V(5).Infof("Result = %#v", result)
-
-			version = result.Version
+		batch := batch
+		batchCtx, span := tracing.Tracer().Start(ctx, "repo.Batch",
+			trace.WithAttributes(attribute.String("repo.codebase", batch.Basepath), attribute.Int("repo.files", len(batch.Items))),
+		)
+		err := p.retry(batchCtx, batch.Basepath, func() error {
+			return p.write(batchCtx, batch.Basepath, func() error {
+				return p.batch(batchCtx, batch, precondition)
+			})
+		})
+		metrics.ObserveRepoBatch(batch.Basepath, err)
+		tracing.EndSpan(span, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// retry runs fn with backoff while it fails because of conflicts or the repo is unavailable
+func (p *PipyRepoClient) retry(ctx context.Context, path string, fn func() error) error {
+	var lastErr error
+	err := wait.ExponentialBackoffWithContext(ctx, batchBackoff, func() (bool, error) {
+		lastErr = fn()
+		switch {
+		case lastErr == nil:
+			return true, nil
+		case IsConflict(lastErr), IsUnavailable(lastErr):
+			klog.Warningf("Updating %q failed, retrying: %s", path, lastErr)
+			return false, nil
+		default:
+			return false, lastErr
+		}
+	})
+
+	if err == wait.ErrWaitTimeout {
+		return lastErr
+	}
+
+	return err
+}
+
+// write runs fn which stages and commits changes of the codebase. With a staging backend, writes of the codebase
+// in the process are serialized and the staged changes are discarded if fn fails.
+func (p *PipyRepoClient) write(ctx context.Context, path string, fn func() error) error {
+	backend, ok := p.backend.(stagingBackend)
+	if !ok {
+		return fn()
+	}
+
+	unlock := lockCodebase(path)
+	defer unlock()
+
+	err := fn()
+	if err != nil {
+		if derr := backend.DiscardStaged(ctx, path); derr != nil {
+			klog.Warningf("Failed to discard staged changes of codebase %q: %s", path, derr)
+		}
+	}
+
+	return err
+}
+
+func (p *PipyRepoClient) batch(ctx context.Context, batch Batch, precondition func(ctx context.Context) error) error {
+	// 1. batch.Basepath, if not exists, create it
+	klog.V(5).Infof("batch.Basepath = %q", batch.Basepath)
+	codebase, err := p.backend.GetCodebase(ctx, batch.Basepath)
+	switch {
+	case err == nil:
+		klog.V(5).Infof("Version of %q is %d", batch.Basepath, codebase.Version)
+	case IsNotFound(err):
+		klog.V(5).Infof("%q doesn't exist in repo", batch.Basepath)
+		codebase, err = p.backend.CreateCodebase(ctx, batch.Basepath)
+		if err != nil {
+			klog.Errorf("Not able to create the codebase %q, reason: %s", batch.Basepath, err.Error())
+			return err
 		}
 
-		// 2. upload each json to repo
-		for _, item := range batch.Items {
-			fullpath := fmt.Sprintf("%s%s/%s", batch.Basepath, item.Path, item.Filename)
-			klog.V(5).Infof("Creating/updating config %q", fullpath)
-			klog.V(5).Infof("Content: %#v", item.Content)
-			err := p.upsertFile(fullpath, item.Content)
-			if err != nil {
-				klog.Errorf("Upsert %q error, reason: %s", fullpath, err.Error())
-				return err
-			}
+		klog.V(5).Infof("Result = %#v", codebase)
+	default:
+		return err
+	}
+
+	// 2. upload each json to repo
+	for _, item := range batch.Items {
+		fullpath := fmt.Sprintf("%s%s/%s", batch.Basepath, item.Path, item.Filename)
+		klog.V(5).Infof("Creating/updating config %q", fullpath)
+		klog.V(5).Infof("Content: %#v", item.Content)
+		err := p.backend.UpsertFile(ctx, fullpath, item.Content)
+		if err != nil {
+			klog.Errorf("Upsert %q error, reason: %s", fullpath, err.Error())
+			return err
 		}
+	}
 
-		// 3. commit the repo, so that changes can take effect
-		klog.V(5).Infof("Committing batch.Basepath = %q", batch.Basepath)
-		// NOT a valid version, ignore committing
-		if version == -1 {
-			err := fmt.Errorf("%d is not a valid version", version)
-			klog.Error(err)
+	// 3. record the trace of the change, so that it can be correlated with reloads of Pipy
+	if traceID := tracing.TraceID(ctx); traceID != "" {
+		metadata := CommitMetadata{TraceID: traceID, Version: codebase.Version + 1, Timestamp: time.Now().UTC()}
+		if err := p.backend.UpsertFile(ctx, batch.Basepath+CommitMetadataFile, metadata); err != nil {
+			klog.Errorf("Upsert commit metadata of %q error, reason: %s", batch.Basepath, err.Error())
 			return err
 		}
-		if err := p.commit(batch.Basepath, version); err != nil {
-			klog.Errorf("Error happened while committing the codebase %q, error: %s", batch.Basepath, err.Error())
+	}
+
+	// 4. commit the repo, so that changes can take effect
+	if precondition != nil {
+		if err := precondition(ctx); err != nil {
+			klog.V(3).Infof("Precondition of committing %q isn't met: %s", batch.Basepath, err)
 			return err
 		}
 	}
+	klog.V(5).Infof("Committing batch.Basepath = %q", batch.Basepath)
+	if err := p.commit(ctx, batch.Basepath, codebase.Version); err != nil {
+		klog.Errorf("Error happened while committing the codebase %q, error: %s", batch.Basepath, err.Error())
+		return err
+	}
 
 	return nil
 }
@@ -301,7 +360,7 @@ func (p *PipyRepoClient) DeriveCodebase(path, base string) (bool, error) {
 		return false, nil
 	} else {
 		klog.V(5).Infof("Codebase %q doesn't exist, deriving ...", path)
-		result, err := p.deriveCodebase(path, base)
+		result, err := p.backend.DeriveCodebase(context.TODO(), path, base)
 		if err != nil {
 			klog.Errorf("Deriving codebase %q error: %#v", path, err)
 			return false, err
@@ -309,7 +368,7 @@ func (p *PipyRepoClient) DeriveCodebase(path, base string) (bool, error) {
 		klog.V(5).Infof("Successfully derived codebase %q", path)
 
 		klog.V(5).Infof("Committing the changes of codebase %q", path)
-		if err = p.commit(path, result.Version); err != nil {
+		if err = p.commit(context.TODO(), path, result.Version); err != nil {
 			klog.Errorf("Committing codebase %q error: %#v", path, err)
 			return false, err
 		}
@@ -320,13 +379,22 @@ func (p *PipyRepoClient) DeriveCodebase(path, base string) (bool, error) {
 }
 
 func (p *PipyRepoClient) IsRepoUp() bool {
-	_, err := p.get("/")
+	_, err := p.backend.ListCodebases(context.TODO())
 
-	if err != nil {
-		return false
-	}
+	return err == nil
+}
 
-	return true
+// CodebaseVersion returns the committed version of the codebase, it's 0 if the codebase doesn't exist
+func (p *PipyRepoClient) CodebaseVersion(ctx context.Context, path string) (int64, error) {
+	codebase, err := p.backend.GetCodebase(ctx, path)
+	switch {
+	case err == nil:
+		return codebase.Version, nil
+	case IsNotFound(err):
+		return 0, nil
+	default:
+		return 0, err
+	}
 }
 
 func (p *PipyRepoClient) CodebaseExists(path string) bool {
@@ -335,10 +403,29 @@ func (p *PipyRepoClient) CodebaseExists(path string) bool {
 	return exists
 }
 
-func fullRepoApiPath(path string) string {
-	return fmt.Sprintf("%s%s", commons.DefaultPipyRepoApiPath, path)
+// DeleteFile deletes the file from the codebase, the change takes effect after the codebase is committed
+func (p *PipyRepoClient) DeleteFile(path string) error {
+	return p.backend.DeleteFile(context.TODO(), path)
+}
+
+// DeleteCodebase deletes the codebase and all codebases derived from it, it's a no-op if it doesn't exist
+func (p *PipyRepoClient) DeleteCodebase(path string) error {
+	return p.deleteCodebase(context.TODO(), path)
 }
 
-func fullFileApiPath(path string) string {
-	return fmt.Sprintf("%s%s", commons.DefaultPipyFileApiPath, path)
+// ListCodebases returns paths of all codebases which start with the prefix
+func (p *PipyRepoClient) ListCodebases(prefix string) ([]string, error) {
+	codebases, err := p.backend.ListCodebases(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0)
+	for _, path := range codebases {
+		if strings.HasPrefix(path, prefix) {
+			result = append(result, path)
+		}
+	}
+
+	return result, nil
 }
@@ -0,0 +1,161 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package repo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// flakyBackend fails the first failures commits with err
+type flakyBackend struct {
+	*MemoryBackend
+	failures int
+	err      error
+	commits  int
+}
+
+func (b *flakyBackend) CommitCodebase(ctx context.Context, path string, version int64) error {
+	b.commits++
+	if b.commits <= b.failures {
+		return b.err
+	}
+
+	return b.MemoryBackend.CommitCodebase(ctx, path, version)
+}
+
+func withFastBackoff(t *testing.T) {
+	old := batchBackoff
+	batchBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1.0, Steps: 3}
+	t.Cleanup(func() {
+		batchBackoff = old
+	})
+}
+
+func TestErrorForStatus(t *testing.T) {
+	testCases := []struct {
+		statusCode int
+		expected   ErrorReason
+	}{
+		{http.StatusNotFound, ErrorReasonNotFound},
+		{http.StatusConflict, ErrorReasonConflict},
+		{http.StatusPreconditionFailed, ErrorReasonConflict},
+		{http.StatusInternalServerError, ErrorReasonUnavailable},
+		{http.StatusServiceUnavailable, ErrorReasonUnavailable},
+		{http.StatusTooManyRequests, ErrorReasonUnavailable},
+		{http.StatusBadRequest, ErrorReasonUnknown},
+		{http.StatusUnauthorized, ErrorReasonUnknown},
+	}
+
+	for _, tc := range testCases {
+		err := errorForStatus(tc.statusCode, http.StatusText(tc.statusCode), "/base", "test")
+		if err.Reason != tc.expected {
+			t.Errorf("status %d: expected reason %s, got %s", tc.statusCode, tc.expected, err.Reason)
+		}
+	}
+}
+
+func TestReasonForError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected ErrorReason
+	}{
+		{"repo error", newRepoError(ErrorReasonConflict, "/base", "test", nil), ErrorReasonConflict},
+		{"wrapped repo error", fmt.Errorf("wrapped: %w", newRepoError(ErrorReasonNotFound, "/base", "test", nil)), ErrorReasonNotFound},
+		{"plain error", fmt.Errorf("plain"), ErrorReasonUnknown},
+		{"nil", nil, ErrorReasonUnknown},
+	}
+
+	for _, tc := range testCases {
+		if reason := ReasonForError(tc.err); reason != tc.expected {
+			t.Errorf("%s: expected reason %s, got %s", tc.name, tc.expected, reason)
+		}
+	}
+}
+
+func TestBatchRetry(t *testing.T) {
+	withFastBackoff(t)
+
+	testCases := []struct {
+		name            string
+		failures        int
+		err             error
+		expectedErr     ErrorReason
+		expectedCommits int
+	}{
+		{"no failure", 0, nil, "", 1},
+		{"conflict is retried", 2, newRepoError(ErrorReasonConflict, "/base", "test", nil), "", 3},
+		{"unavailable is retried", 1, newRepoError(ErrorReasonUnavailable, "/base", "test", nil), "", 2},
+		{"unknown is not retried", 1, newRepoError(ErrorReasonUnknown, "/base", "test", nil), ErrorReasonUnknown, 1},
+		{"gives up after backoff", 10, newRepoError(ErrorReasonUnavailable, "/base", "test", nil), ErrorReasonUnavailable, 3},
+	}
+
+	for _, tc := range testCases {
+		backend := &flakyBackend{MemoryBackend: NewMemoryBackend(), failures: tc.failures, err: tc.err}
+		client := NewRepoClientWithBackend(backend)
+
+		err := client.Batch(context.TODO(), []Batch{
+			{Basepath: "/base", Items: []BatchItem{{Path: "/config", Filename: "main.json", Content: "{}"}}},
+		})
+
+		switch {
+		case tc.expectedErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %s", tc.name, err)
+		case tc.expectedErr != "" && ReasonForError(err) != tc.expectedErr:
+			t.Errorf("%s: expected error of reason %s, got %v", tc.name, tc.expectedErr, err)
+		}
+		if backend.commits != tc.expectedCommits {
+			t.Errorf("%s: expected %d commits, got %d", tc.name, tc.expectedCommits, backend.commits)
+		}
+	}
+}
+
+func TestCommitConflict(t *testing.T) {
+	backend := NewMemoryBackend()
+	client := NewRepoClientWithBackend(backend)
+	if _, err := backend.CreateCodebase(context.TODO(), "/base"); err != nil {
+		t.Fatal(err)
+	}
+
+	// both writers read version 1, the first one commits version 2
+	if err := client.commit(context.TODO(), "/base", 1); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if err := client.commit(context.TODO(), "/base", 1); !IsConflict(err) {
+		t.Errorf("expected Conflict error, got %v", err)
+	}
+
+	// the backend rejects the stale version even if the check of client is passed
+	if err := backend.CommitCodebase(context.TODO(), "/base", 2); !IsConflict(err) {
+		t.Errorf("expected Conflict error from backend, got %v", err)
+	}
+}
@@ -0,0 +1,417 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package repo
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+const (
+	configMapCodebaseLabel             = "repo.flomesh.io/codebase"
+	configMapCodebasePathAnnotation    = "repo.flomesh.io/path"
+	configMapCodebaseBaseAnnotation    = "repo.flomesh.io/base"
+	configMapCodebaseVersionAnnotation = "repo.flomesh.io/version"
+	// configMapMaxSize is the limit of the size of a ConfigMap enforced by the API server
+	configMapMaxSize = 1024 * 1024
+)
+
+// ConfigMapBackend keeps each codebase in a ConfigMap of the namespace, files are the data of the ConfigMap keyed by
+// the base64 encoded path. Changes are staged in memory of the writer and written to the ConfigMap on commit with
+// the resourceVersion as precondition, so that concurrent commits of the same codebase never overwrite each other.
+// Files inherited from bases are not copied, they are resolved when the codebase is read.
+type ConfigMapBackend struct {
+	client    kubernetes.Interface
+	namespace string
+
+	mu sync.Mutex
+	// staged changes of codebases, keyed by codebase path
+	staged map[string]*configMapStagedChanges
+}
+
+type configMapStagedChanges struct {
+	upserted map[string][]byte
+	deleted  map[string]bool
+}
+
+var _ stagingBackend = &ConfigMapBackend{}
+
+func NewConfigMapBackend(client kubernetes.Interface, namespace string) *ConfigMapBackend {
+	return &ConfigMapBackend{
+		client:    client,
+		namespace: namespace,
+		staged:    make(map[string]*configMapStagedChanges),
+	}
+}
+
+func (b *ConfigMapBackend) GetCodebase(ctx context.Context, path string) (*Codebase, error) {
+	cm, err := b.get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	codebases, err := b.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	codebase := configMapCodebase(cm)
+	for _, other := range codebases {
+		if other.Annotations[configMapCodebaseBaseAnnotation] == path {
+			codebase.Derived = append(codebase.Derived, other.Annotations[configMapCodebasePathAnnotation])
+		}
+	}
+	sort.Strings(codebase.Derived)
+
+	return codebase, nil
+}
+
+func (b *ConfigMapBackend) CreateCodebase(ctx context.Context, path string) (*Codebase, error) {
+	return b.DeriveCodebase(ctx, path, "")
+}
+
+func (b *ConfigMapBackend) DeriveCodebase(ctx context.Context, path, base string) (*Codebase, error) {
+	if base != "" {
+		if _, err := b.get(ctx, base); err != nil {
+			return nil, err
+		}
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      configMapName(path),
+			Namespace: b.namespace,
+			Labels:    map[string]string{configMapCodebaseLabel: "true"},
+			Annotations: map[string]string{
+				configMapCodebasePathAnnotation:    path,
+				configMapCodebaseBaseAnnotation:    base,
+				configMapCodebaseVersionAnnotation: "1",
+			},
+		},
+		Data: map[string]string{},
+	}
+
+	cm, err := b.client.CoreV1().ConfigMaps(b.namespace).Create(ctx, cm, metav1.CreateOptions{})
+	if err != nil {
+		return nil, apiError(path, "failed to create codebase", err)
+	}
+
+	return configMapCodebase(cm), nil
+}
+
+func (b *ConfigMapBackend) CommitCodebase(ctx context.Context, path string, version int64) error {
+	cm, err := b.get(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	if current := configMapVersion(cm); version <= current {
+		return newRepoError(ErrorReasonConflict, path, "failed to commit codebase",
+			fmt.Errorf("version %d is not newer than %d", version, current))
+	}
+
+	b.mu.Lock()
+	changes := b.staged[path]
+	b.mu.Unlock()
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	if changes != nil {
+		for file := range changes.deleted {
+			delete(cm.Data, configMapKey(file))
+		}
+		for file, content := range changes.upserted {
+			cm.Data[configMapKey(file)] = string(content)
+		}
+	}
+	cm.Annotations[configMapCodebaseVersionAnnotation] = strconv.FormatInt(version, 10)
+
+	if size := configMapSize(cm); size > configMapMaxSize {
+		return newRepoError(ErrorReasonUnknown, path, "failed to commit codebase",
+			fmt.Errorf("size of codebase %d exceeds the limit %d of ConfigMap", size, configMapMaxSize))
+	}
+
+	// the resourceVersion of cm is the precondition, the update fails if the codebase is committed by others
+	if _, err := b.client.CoreV1().ConfigMaps(b.namespace).Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return apiError(path, "failed to commit codebase", err)
+	}
+
+	b.mu.Lock()
+	if b.staged[path] == changes {
+		delete(b.staged, path)
+	}
+	b.mu.Unlock()
+
+	return nil
+}
+
+// DiscardStaged implements stagingBackend
+func (b *ConfigMapBackend) DiscardStaged(_ context.Context, path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.staged, path)
+
+	return nil
+}
+
+func (b *ConfigMapBackend) DeleteCodebase(ctx context.Context, path string) error {
+	b.mu.Lock()
+	delete(b.staged, path)
+	b.mu.Unlock()
+
+	err := b.client.CoreV1().ConfigMaps(b.namespace).Delete(ctx, configMapName(path), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return apiError(path, "failed to delete codebase", err)
+	}
+
+	return nil
+}
+
+func (b *ConfigMapBackend) ListCodebases(ctx context.Context) ([]string, error) {
+	codebases, err := b.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(codebases))
+	for _, cm := range codebases {
+		result = append(result, cm.Annotations[configMapCodebasePathAnnotation])
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// GetFile returns the committed content of the file, the file is looked up in the bases if the codebase doesn't have it
+func (b *ConfigMapBackend) GetFile(ctx context.Context, path string) (string, error) {
+	codebase, file, err := b.locate(ctx, path)
+	if err != nil {
+		return "", err
+	}
+
+	for codebase != "" {
+		cm, err := b.get(ctx, codebase)
+		if err != nil {
+			return "", err
+		}
+
+		if content, ok := cm.Data[configMapKey(file)]; ok {
+			return content, nil
+		}
+		codebase = cm.Annotations[configMapCodebaseBaseAnnotation]
+	}
+
+	return "", newRepoError(ErrorReasonNotFound, path, "failed to get file", nil)
+}
+
+func (b *ConfigMapBackend) UpsertFile(ctx context.Context, path string, content interface{}) error {
+	codebase, file, err := b.locate(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	data, err := contentBytes(content)
+	if err != nil {
+		return newRepoError(ErrorReasonUnknown, path, "failed to upsert file", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	changes := b.stagedChanges(codebase)
+	changes.upserted[file] = data
+	delete(changes.deleted, file)
+
+	return nil
+}
+
+func (b *ConfigMapBackend) DeleteFile(ctx context.Context, path string) error {
+	codebase, file, err := b.locate(ctx, path)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	changes := b.stagedChanges(codebase)
+	delete(changes.upserted, file)
+	changes.deleted[file] = true
+
+	return nil
+}
+
+func (b *ConfigMapBackend) stagedChanges(codebase string) *configMapStagedChanges {
+	changes, ok := b.staged[codebase]
+	if !ok {
+		changes = &configMapStagedChanges{upserted: map[string][]byte{}, deleted: map[string]bool{}}
+		b.staged[codebase] = changes
+	}
+
+	return changes
+}
+
+// locate finds the codebase which the file belongs to, it's the one with the longest matched path
+func (b *ConfigMapBackend) locate(ctx context.Context, path string) (string, string, error) {
+	codebases, err := b.ListCodebases(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	codebase := ""
+	for _, c := range codebases {
+		if strings.HasPrefix(path, c+"/") && len(c) > len(codebase) {
+			codebase = c
+		}
+	}
+
+	if codebase == "" {
+		return "", "", newRepoError(ErrorReasonNotFound, path, "failed to locate file", fmt.Errorf("no codebase contains it"))
+	}
+
+	return codebase, strings.TrimPrefix(path, codebase), nil
+}
+
+func (b *ConfigMapBackend) get(ctx context.Context, path string) (*corev1.ConfigMap, error) {
+	cm, err := b.client.CoreV1().ConfigMaps(b.namespace).Get(ctx, configMapName(path), metav1.GetOptions{})
+	if err != nil {
+		return nil, apiError(path, "failed to get codebase", err)
+	}
+
+	if cm.Labels[configMapCodebaseLabel] != "true" || cm.Annotations[configMapCodebasePathAnnotation] != path {
+		return nil, newRepoError(ErrorReasonNotFound, path, "failed to get codebase",
+			fmt.Errorf("ConfigMap %s/%s is not the codebase", cm.Namespace, cm.Name))
+	}
+
+	return cm, nil
+}
+
+func (b *ConfigMapBackend) list(ctx context.Context) ([]corev1.ConfigMap, error) {
+	cms, err := b.client.CoreV1().ConfigMaps(b.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: configMapCodebaseLabel + "=true",
+	})
+	if err != nil {
+		return nil, apiError("/", "failed to list codebases", err)
+	}
+
+	return cms.Items, nil
+}
+
+func configMapCodebase(cm *corev1.ConfigMap) *Codebase {
+	codebase := &Codebase{
+		Version: configMapVersion(cm),
+		Path:    cm.Annotations[configMapCodebasePathAnnotation],
+		Base:    cm.Annotations[configMapCodebaseBaseAnnotation],
+	}
+	for key := range cm.Data {
+		if file, err := base64.RawURLEncoding.DecodeString(key); err == nil {
+			codebase.Files = append(codebase.Files, string(file))
+		}
+	}
+	sort.Strings(codebase.Files)
+
+	return codebase
+}
+
+func configMapVersion(cm *corev1.ConfigMap) int64 {
+	version, err := strconv.ParseInt(cm.Annotations[configMapCodebaseVersionAnnotation], 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return version
+}
+
+func configMapSize(cm *corev1.ConfigMap) int {
+	size := 0
+	for key, value := range cm.Data {
+		size += len(key) + len(value)
+	}
+
+	return size
+}
+
+// configMapName is derived from the hash of path, as the path of codebase isn't a valid name of ConfigMap
+func configMapName(path string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(path))
+
+	return fmt.Sprintf("fsm-repo-%x", h.Sum64())
+}
+
+// configMapKey encodes the path of file as the key of ConfigMap data, which allows only alphanumerics, '-', '_' and '.'
+func configMapKey(file string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(file))
+}
+
+// apiError maps the error of Kubernetes API to RepoError
+func apiError(path, message string, err error) *RepoError {
+	var reason ErrorReason
+	switch {
+	case apierrors.IsNotFound(err):
+		reason = ErrorReasonNotFound
+	case apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		reason = ErrorReasonConflict
+	case apierrors.IsServerTimeout(err), apierrors.IsTimeout(err), apierrors.IsTooManyRequests(err),
+		apierrors.IsServiceUnavailable(err), apierrors.IsInternalError(err):
+		reason = ErrorReasonUnavailable
+	default:
+		reason = ErrorReasonUnknown
+	}
+
+	return newRepoError(reason, path, message, err)
+}
+
+// newConfigMapBackend creates the backend with the client of the cluster which the process runs in
+func newConfigMapBackend(namespace string) (*ConfigMapBackend, error) {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConfigMapBackend(client, namespace), nil
+}
@@ -0,0 +1,111 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrorReason is the category of errors returned by PipyRepoClient
+type ErrorReason string
+
+const (
+	// ErrorReasonNotFound means the codebase or file doesn't exist
+	ErrorReasonNotFound ErrorReason = "NotFound"
+	// ErrorReasonConflict means the codebase has been changed by another writer
+	ErrorReasonConflict ErrorReason = "Conflict"
+	// ErrorReasonUnavailable means the repo cannot be reached or it fails to handle the request
+	ErrorReasonUnavailable ErrorReason = "Unavailable"
+	// ErrorReasonUnknown is for all other errors
+	ErrorReasonUnknown ErrorReason = "Unknown"
+)
+
+// RepoError is the typed error of operations against the repo
+type RepoError struct {
+	Reason  ErrorReason
+	Path    string
+	Message string
+	Err     error
+}
+
+func (e *RepoError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s %q: %s", e.Reason, e.Message, e.Path, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %s %q", e.Reason, e.Message, e.Path)
+}
+
+func (e *RepoError) Unwrap() error {
+	return e.Err
+}
+
+func newRepoError(reason ErrorReason, path, message string, err error) *RepoError {
+	return &RepoError{Reason: reason, Path: path, Message: message, Err: err}
+}
+
+// errorForStatus maps the HTTP status code of repo response to RepoError
+func errorForStatus(statusCode int, status, path, message string) *RepoError {
+	var reason ErrorReason
+	switch {
+	case statusCode == http.StatusNotFound:
+		reason = ErrorReasonNotFound
+	case statusCode == http.StatusConflict, statusCode == http.StatusPreconditionFailed:
+		reason = ErrorReasonConflict
+	case statusCode >= http.StatusInternalServerError, statusCode == http.StatusTooManyRequests:
+		reason = ErrorReasonUnavailable
+	default:
+		reason = ErrorReasonUnknown
+	}
+
+	return newRepoError(reason, path, message, fmt.Errorf("%s", status))
+}
+
+// ReasonForError returns the reason of the error, ErrorReasonUnknown if it's not a RepoError
+func ReasonForError(err error) ErrorReason {
+	var repoErr *RepoError
+	if errors.As(err, &repoErr) {
+		return repoErr.Reason
+	}
+
+	return ErrorReasonUnknown
+}
+
+// IsNotFound returns true if the codebase or file doesn't exist
+func IsNotFound(err error) bool {
+	return ReasonForError(err) == ErrorReasonNotFound
+}
+
+// IsConflict returns true if the codebase has been changed by another writer
+func IsConflict(err error) bool {
+	return ReasonForError(err) == ErrorReasonConflict
+}
+
+// IsUnavailable returns true if the repo cannot be reached or it fails to handle the request
+func IsUnavailable(err error) bool {
+	return ReasonForError(err) == ErrorReasonUnavailable
+}
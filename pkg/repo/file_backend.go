@@ -0,0 +1,642 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// codebaseMetadataFile is the file in the directory of codebase which keeps the metadata of it
+	codebaseMetadataFile = ".codebase.json"
+	// stagingDir is the directory under root which keeps the uncommitted changes of codebases
+	stagingDir = ".staging"
+	// stagedChangesFile is the file in the staging directory of codebase which records the deleted files
+	stagedChangesFile = ".changes.json"
+)
+
+// FileBackend keeps codebases in a directory, each codebase is a sub-directory with the same path.
+// Changes are staged in a separate directory and moved into the codebase on commit, so that the directory of
+// codebase only contains committed files. Once a codebase is committed, the files inherited from its bases are
+// copied into the directory, so that the directory is complete and can be loaded by Pipy directly.
+type FileBackend struct {
+	root string
+	mu   sync.Mutex
+}
+
+type fileCodebaseMetadata struct {
+	Version int64  `json:"version"`
+	Base    string `json:"base,omitempty"`
+	// Files are the files of the codebase itself, the others in the directory are inherited from bases
+	Files []string `json:"files,omitempty"`
+}
+
+type fileStagedChanges struct {
+	// Deleted are the files of the codebase itself which are deleted since the last commit
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+var _ stagingBackend = &FileBackend{}
+
+func NewFileBackend(root string) *FileBackend {
+	return &FileBackend{root: root}
+}
+
+func (b *FileBackend) GetCodebase(_ context.Context, path string) (*Codebase, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.getCodebase(path)
+}
+
+func (b *FileBackend) getCodebase(path string) (*Codebase, error) {
+	meta, err := b.readMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+
+	codebase := &Codebase{
+		Version: meta.Version,
+		Path:    path,
+		Base:    meta.Base,
+		Files:   meta.Files,
+	}
+
+	derived, err := b.derivedOf(path)
+	if err != nil {
+		return nil, err
+	}
+	codebase.Derived = derived
+
+	return codebase, nil
+}
+
+func (b *FileBackend) CreateCodebase(ctx context.Context, path string) (*Codebase, error) {
+	return b.DeriveCodebase(ctx, path, "")
+}
+
+func (b *FileBackend) DeriveCodebase(_ context.Context, path, base string) (*Codebase, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := b.readMetadata(path); err == nil {
+		return nil, newRepoError(ErrorReasonConflict, path, "failed to create codebase", fmt.Errorf("already exists"))
+	}
+
+	if base != "" {
+		if _, err := b.readMetadata(base); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := b.writeMetadata(path, &fileCodebaseMetadata{Version: 1, Base: base}); err != nil {
+		return nil, err
+	}
+
+	return b.getCodebase(path)
+}
+
+func (b *FileBackend) CommitCodebase(_ context.Context, path string, version int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	meta, err := b.readMetadata(path)
+	if err != nil {
+		return err
+	}
+
+	if version <= meta.Version {
+		return newRepoError(ErrorReasonConflict, path, "failed to commit codebase",
+			fmt.Errorf("version %d is not newer than %d", version, meta.Version))
+	}
+
+	if err := b.applyStaged(path, meta); err != nil {
+		return err
+	}
+
+	// files are in place before the version is moved, so that readers never see the new version with old files
+	if err := b.materialize(path, meta); err != nil {
+		return err
+	}
+
+	meta.Version = version
+	if err := b.writeMetadata(path, meta); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(b.stagingPath(path)); err != nil {
+		return newRepoError(ErrorReasonUnavailable, path, "failed to clean staged changes", err)
+	}
+
+	return nil
+}
+
+// applyStaged moves the staged files into the directory of codebase and removes the deleted ones,
+// files of meta are updated accordingly
+func (b *FileBackend) applyStaged(path string, meta *fileCodebaseMetadata) error {
+	changes, err := b.readStagedChanges(path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range changes.Deleted {
+		if err := os.Remove(b.filePath(path, file)); err != nil && !os.IsNotExist(err) {
+			return newRepoError(ErrorReasonUnavailable, path, "failed to delete file", err)
+		}
+		meta.Files = removeString(meta.Files, file)
+	}
+
+	staged, err := b.stagedFiles(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range staged {
+		target := b.filePath(path, file)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return newRepoError(ErrorReasonUnavailable, path, "failed to commit file", err)
+		}
+		if err := os.Rename(filepath.Join(b.stagingPath(path), filepath.FromSlash(file)), target); err != nil {
+			return newRepoError(ErrorReasonUnavailable, path, "failed to commit file", err)
+		}
+		if !containsString(meta.Files, file) {
+			meta.Files = append(meta.Files, file)
+		}
+	}
+	sort.Strings(meta.Files)
+
+	return nil
+}
+
+// DiscardStaged implements stagingBackend
+func (b *FileBackend) DiscardStaged(_ context.Context, path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := os.RemoveAll(b.stagingPath(path)); err != nil {
+		return newRepoError(ErrorReasonUnavailable, path, "failed to discard staged changes", err)
+	}
+
+	return nil
+}
+
+func (b *FileBackend) DeleteCodebase(_ context.Context, path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := os.RemoveAll(b.dir(path)); err != nil {
+		return newRepoError(ErrorReasonUnknown, path, "failed to delete codebase", err)
+	}
+
+	if err := os.RemoveAll(b.stagingPath(path)); err != nil {
+		return newRepoError(ErrorReasonUnknown, path, "failed to delete staged changes of codebase", err)
+	}
+
+	return nil
+}
+
+func (b *FileBackend) ListCodebases(_ context.Context) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.listCodebases()
+}
+
+func (b *FileBackend) listCodebases() ([]string, error) {
+	result := make([]string, 0)
+	err := filepath.WalkDir(b.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() && p == b.stagingRoot() {
+			return filepath.SkipDir
+		}
+
+		if !d.IsDir() && d.Name() == codebaseMetadataFile {
+			rel, err := filepath.Rel(b.root, filepath.Dir(p))
+			if err != nil {
+				return err
+			}
+			result = append(result, "/"+filepath.ToSlash(rel))
+		}
+
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, newRepoError(ErrorReasonUnavailable, "/", "failed to list codebases", err)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+func (b *FileBackend) GetFile(_ context.Context, path string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	codebase, file, err := b.locate(path)
+	if err != nil {
+		return "", err
+	}
+
+	// the file may be inherited but not materialized yet, look up the bases
+	for codebase != "" {
+		content, err := os.ReadFile(b.filePath(codebase, file))
+		if err == nil {
+			return string(content), nil
+		}
+
+		meta, err := b.readMetadata(codebase)
+		if err != nil {
+			return "", err
+		}
+		codebase = meta.Base
+	}
+
+	return "", newRepoError(ErrorReasonNotFound, path, "failed to get file", nil)
+}
+
+func (b *FileBackend) UpsertFile(_ context.Context, path string, content interface{}) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	codebase, file, err := b.locate(path)
+	if err != nil {
+		return err
+	}
+
+	data, err := contentBytes(content)
+	if err != nil {
+		return newRepoError(ErrorReasonUnknown, path, "failed to upsert file", err)
+	}
+
+	if err := writeFile(filepath.Join(b.stagingPath(codebase), filepath.FromSlash(file)), data); err != nil {
+		return newRepoError(ErrorReasonUnavailable, path, "failed to upsert file", err)
+	}
+
+	changes, err := b.readStagedChanges(codebase)
+	if err != nil {
+		return err
+	}
+	if containsString(changes.Deleted, file) {
+		changes.Deleted = removeString(changes.Deleted, file)
+		return b.writeStagedChanges(codebase, changes)
+	}
+
+	return nil
+}
+
+func (b *FileBackend) DeleteFile(_ context.Context, path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	codebase, file, err := b.locate(path)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(b.stagingPath(codebase), filepath.FromSlash(file))); err != nil && !os.IsNotExist(err) {
+		return newRepoError(ErrorReasonUnavailable, path, "failed to delete file", err)
+	}
+
+	meta, err := b.readMetadata(codebase)
+	if err != nil {
+		return err
+	}
+	if !containsString(meta.Files, file) {
+		return nil
+	}
+
+	changes, err := b.readStagedChanges(codebase)
+	if err != nil {
+		return err
+	}
+	if !containsString(changes.Deleted, file) {
+		changes.Deleted = append(changes.Deleted, file)
+	}
+
+	return b.writeStagedChanges(codebase, changes)
+}
+
+// materialize copies the files inherited from the base into the directory of codebase and removes the stale ones,
+// then does the same for all codebases derived from it
+func (b *FileBackend) materialize(path string, meta *fileCodebaseMetadata) error {
+	inherited := make(map[string]bool)
+	if meta.Base != "" {
+		baseFiles, err := b.filesOf(meta.Base)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range baseFiles {
+			if containsString(meta.Files, file) {
+				continue
+			}
+
+			content, err := os.ReadFile(b.filePath(meta.Base, file))
+			if err != nil {
+				return newRepoError(ErrorReasonUnavailable, path, "failed to read inherited file", err)
+			}
+			if err := writeFile(b.filePath(path, file), content); err != nil {
+				return newRepoError(ErrorReasonUnavailable, path, "failed to write inherited file", err)
+			}
+			inherited[file] = true
+		}
+	}
+
+	current, err := b.filesOf(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range current {
+		if !inherited[file] && !containsString(meta.Files, file) {
+			if err := os.Remove(b.filePath(path, file)); err != nil && !os.IsNotExist(err) {
+				return newRepoError(ErrorReasonUnavailable, path, "failed to remove stale file", err)
+			}
+		}
+	}
+
+	derived, err := b.derivedOf(path)
+	if err != nil {
+		return err
+	}
+	for _, d := range derived {
+		derivedMeta, err := b.readMetadata(d)
+		if err != nil {
+			return err
+		}
+		if err := b.materialize(d, derivedMeta); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// filesOf returns all files in the directory of codebase except the metadata and nested codebases
+func (b *FileBackend) filesOf(path string) ([]string, error) {
+	dir := b.dir(path)
+	result := make([]string, 0)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if p != dir {
+				if _, err := os.Stat(filepath.Join(p, codebaseMetadataFile)); err == nil {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		if p == filepath.Join(dir, codebaseMetadataFile) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		result = append(result, "/"+filepath.ToSlash(rel))
+
+		return nil
+	})
+	if err != nil {
+		return nil, newRepoError(ErrorReasonUnavailable, path, "failed to list files", err)
+	}
+
+	return result, nil
+}
+
+func (b *FileBackend) derivedOf(path string) ([]string, error) {
+	codebases, err := b.listCodebases()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0)
+	for _, c := range codebases {
+		meta, err := b.readMetadata(c)
+		if err != nil {
+			return nil, err
+		}
+		if meta.Base == path {
+			result = append(result, c)
+		}
+	}
+
+	return result, nil
+}
+
+// locate finds the codebase which the file belongs to, it's the one with the longest matched path
+func (b *FileBackend) locate(path string) (string, string, error) {
+	codebases, err := b.listCodebases()
+	if err != nil {
+		return "", "", err
+	}
+
+	codebase := ""
+	for _, c := range codebases {
+		if strings.HasPrefix(path, c+"/") && len(c) > len(codebase) {
+			codebase = c
+		}
+	}
+
+	if codebase == "" {
+		return "", "", newRepoError(ErrorReasonNotFound, path, "failed to locate file", fmt.Errorf("no codebase contains it"))
+	}
+
+	return codebase, strings.TrimPrefix(path, codebase), nil
+}
+
+func (b *FileBackend) readMetadata(path string) (*fileCodebaseMetadata, error) {
+	data, err := os.ReadFile(filepath.Join(b.dir(path), codebaseMetadataFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, newRepoError(ErrorReasonNotFound, path, "failed to get codebase", nil)
+		}
+		return nil, newRepoError(ErrorReasonUnavailable, path, "failed to get codebase", err)
+	}
+
+	meta := &fileCodebaseMetadata{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, newRepoError(ErrorReasonUnknown, path, "failed to decode codebase metadata", err)
+	}
+
+	return meta, nil
+}
+
+func (b *FileBackend) writeMetadata(path string, meta *fileCodebaseMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return newRepoError(ErrorReasonUnknown, path, "failed to encode codebase metadata", err)
+	}
+
+	if err := writeFile(filepath.Join(b.dir(path), codebaseMetadataFile), data); err != nil {
+		return newRepoError(ErrorReasonUnavailable, path, "failed to write codebase metadata", err)
+	}
+
+	return nil
+}
+
+// stagedFiles returns the files staged for the codebase
+func (b *FileBackend) stagedFiles(path string) ([]string, error) {
+	dir := b.stagingPath(path)
+	result := make([]string, 0)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			// staged changes of nested codebases
+			if p != dir {
+				if _, err := os.Stat(filepath.Join(b.dir(b.codebaseOfStagingPath(p)), codebaseMetadataFile)); err == nil {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		if p == filepath.Join(dir, stagedChangesFile) || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		result = append(result, "/"+filepath.ToSlash(rel))
+
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, newRepoError(ErrorReasonUnavailable, path, "failed to list staged files", err)
+	}
+
+	return result, nil
+}
+
+func (b *FileBackend) readStagedChanges(path string) (*fileStagedChanges, error) {
+	changes := &fileStagedChanges{}
+	data, err := os.ReadFile(filepath.Join(b.stagingPath(path), stagedChangesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return changes, nil
+		}
+		return nil, newRepoError(ErrorReasonUnavailable, path, "failed to read staged changes", err)
+	}
+
+	if err := json.Unmarshal(data, changes); err != nil {
+		return nil, newRepoError(ErrorReasonUnknown, path, "failed to decode staged changes", err)
+	}
+
+	return changes, nil
+}
+
+func (b *FileBackend) writeStagedChanges(path string, changes *fileStagedChanges) error {
+	data, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return newRepoError(ErrorReasonUnknown, path, "failed to encode staged changes", err)
+	}
+
+	if err := writeFile(filepath.Join(b.stagingPath(path), stagedChangesFile), data); err != nil {
+		return newRepoError(ErrorReasonUnavailable, path, "failed to write staged changes", err)
+	}
+
+	return nil
+}
+
+func (b *FileBackend) stagingRoot() string {
+	return filepath.Join(b.root, stagingDir)
+}
+
+func (b *FileBackend) stagingPath(path string) string {
+	return filepath.Join(b.stagingRoot(), filepath.FromSlash(path))
+}
+
+func (b *FileBackend) codebaseOfStagingPath(p string) string {
+	rel, err := filepath.Rel(b.stagingRoot(), p)
+	if err != nil {
+		return ""
+	}
+
+	return "/" + filepath.ToSlash(rel)
+}
+
+func (b *FileBackend) dir(path string) string {
+	return filepath.Join(b.root, filepath.FromSlash(path))
+}
+
+func (b *FileBackend) filePath(codebase, file string) string {
+	return filepath.Join(b.dir(codebase), filepath.FromSlash(file))
+}
+
+// writeFile writes the file atomically, so that readers never see a partially written file
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func removeString(items []string, s string) []string {
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
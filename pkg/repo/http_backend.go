@@ -0,0 +1,345 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package repo
+
+import (
+	"context"
+	"fmt"
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
+	"github.com/flomesh-io/fsm-classic/pkg/metrics"
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"k8s.io/klog/v2"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTPBackend stores codebases in Pipy repo through its REST API
+type HTTPBackend struct {
+	baseUrl          string
+	defaultTransport *http.Transport
+	httpClient       *resty.Client
+}
+
+var _ Backend = &HTTPBackend{}
+
+func defaultTransport() *http.Transport {
+	return &http.Transport{
+		DisableKeepAlives:  false,
+		MaxIdleConns:       10,
+		IdleConnTimeout:    60 * time.Second,
+		DisableCompression: false,
+	}
+}
+
+// NewHTTPBackend creates the backend with the client options of the repo root URL, see SetClientOptions
+func NewHTTPBackend(repoRootUrl string, transport *http.Transport) *HTTPBackend {
+	return NewHTTPBackendWithOptions(repoRootUrl, transport, clientOptionsFor(repoRootUrl))
+}
+
+func NewHTTPBackendWithOptions(repoRootUrl string, transport *http.Transport, opts ClientOptions) *HTTPBackend {
+	backend := &HTTPBackend{
+		baseUrl:          repoRootUrl,
+		defaultTransport: transport,
+	}
+
+	if opts.TLSConfig != nil {
+		backend.defaultTransport.TLSClientConfig = opts.TLSConfig.Clone()
+	}
+
+	backend.httpClient = resty.New().
+		SetTransport(backend.defaultTransport).
+		SetScheme(schemeOf(repoRootUrl)).
+		SetAllowGetMethodPayload(true).
+		SetBaseURL(backend.baseUrl).
+		SetTimeout(5 * time.Second).
+		SetDebug(true).
+		EnableTrace().
+		OnBeforeRequest(func(_ *resty.Client, req *resty.Request) error {
+			// propagates the trace to repo in the traceparent header
+			otel.GetTextMapPropagator().Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+			return nil
+		}).
+		OnAfterResponse(func(_ *resty.Client, resp *resty.Response) error {
+			metrics.ObserveRepoRequest(resp.Request.Method, repoEndpoint(resp.Request.URL), resp.StatusCode(), resp.Time())
+			return nil
+		}).
+		OnError(func(req *resty.Request, err error) {
+			// requests with response are observed by OnAfterResponse
+			if re, ok := err.(*resty.ResponseError); ok && re.Response != nil && re.Response.RawResponse != nil {
+				return
+			}
+			metrics.ObserveRepoRequest(req.Method, repoEndpoint(req.URL), 0, time.Since(req.Time))
+		})
+
+	if opts.TokenSource != nil {
+		backend.httpClient.
+			OnBeforeRequest(func(_ *resty.Client, req *resty.Request) error {
+				token, err := opts.TokenSource(req.Context())
+				if err != nil {
+					return newRepoError(ErrorReasonUnavailable, req.URL, "failed to get token of repo", err)
+				}
+				if token != "" {
+					req.SetAuthToken(token)
+				}
+				return nil
+			}).
+			OnRequestLog(func(rl *resty.RequestLog) error {
+				// debug logging is enabled, never leak the token into logs
+				if rl.Header.Get("Authorization") != "" {
+					rl.Header.Set("Authorization", "Bearer ******")
+				}
+				return nil
+			})
+	}
+
+	return backend
+}
+
+// repoEndpoint returns the API endpoint of request URL, it's used as the metrics label instead of the path
+func repoEndpoint(url string) string {
+	if strings.Contains(url, commons.DefaultPipyFileApiPath) {
+		return metrics.RepoEndpointFiles
+	}
+
+	return metrics.RepoEndpointCodebases
+}
+
+// schemeOf returns the scheme of repo root URL, it's used for requests of which URL has no scheme
+// readProxy forwards requests for codebases to the /repo path of Pipy repo, the path of request is the codebase path
+func (b *HTTPBackend) readProxy() http.Handler {
+	target, err := url.Parse(b.baseUrl)
+	if err != nil {
+		klog.Errorf("Invalid repo root URL %q: %s", b.baseUrl, err)
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		})
+	}
+
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = strings.TrimSuffix(target.Path, "/") + commons.DefaultPipyRepoPath + req.URL.Path
+			req.URL.RawPath = ""
+			req.Host = target.Host
+		},
+		Transport: b.defaultTransport,
+	}
+}
+
+func schemeOf(repoRootUrl string) string {
+	if u, err := url.Parse(repoRootUrl); err == nil && strings.EqualFold(u.Scheme, "https") {
+		return "https"
+	}
+
+	return commons.DefaultHttpSchema
+}
+
+func (b *HTTPBackend) GetCodebase(ctx context.Context, path string) (*Codebase, error) {
+	resp, err := b.httpClient.R().
+		SetContext(ctx).
+		SetResult(&Codebase{}).
+		Get(fullRepoApiPath(path))
+
+	if err != nil {
+		klog.Errorf("Failed to get path %q, error: %s", path, err.Error())
+		return nil, newRepoError(ErrorReasonUnavailable, path, "failed to get codebase", err)
+	}
+
+	if resp.IsError() {
+		return nil, errorForStatus(resp.StatusCode(), resp.Status(), path, "failed to get codebase")
+	}
+
+	return resp.Result().(*Codebase), nil
+}
+
+func (b *HTTPBackend) CreateCodebase(ctx context.Context, path string) (*Codebase, error) {
+	return b.postCodebase(ctx, path, Codebase{Version: 1})
+}
+
+func (b *HTTPBackend) DeriveCodebase(ctx context.Context, path, base string) (*Codebase, error) {
+	if _, err := b.GetCodebase(ctx, base); err != nil {
+		return nil, fmt.Errorf("parent %q of codebase %q doesn't exists: %w", base, path, err)
+	}
+
+	return b.postCodebase(ctx, path, Codebase{Version: 1, Base: base})
+}
+
+func (b *HTTPBackend) postCodebase(ctx context.Context, path string, codebase Codebase) (*Codebase, error) {
+	resp, err := b.httpClient.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(codebase).
+		Post(fullRepoApiPath(path))
+
+	if err != nil {
+		klog.Errorf("Failed to create codebase: path: %q, base: %q, error: %s", path, codebase.Base, err.Error())
+		return nil, newRepoError(ErrorReasonUnavailable, path, "failed to create codebase", err)
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusOK, http.StatusCreated:
+		klog.V(5).Infof("Status code is %d, stands for success.", resp.StatusCode())
+	default:
+		klog.Errorf("Response contains error: %#v", resp.Status())
+		return nil, errorForStatus(resp.StatusCode(), resp.Status(), path, "failed to create codebase")
+	}
+
+	return b.GetCodebase(ctx, path)
+}
+
+func (b *HTTPBackend) CommitCodebase(ctx context.Context, path string, version int64) error {
+	resp, err := b.httpClient.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(Codebase{Version: version}).
+		SetResult(&Codebase{}).
+		Patch(fullRepoApiPath(path))
+
+	if err != nil {
+		return newRepoError(ErrorReasonUnavailable, path, "failed to commit codebase", err)
+	}
+
+	if resp.IsSuccess() {
+		return nil
+	}
+
+	return errorForStatus(resp.StatusCode(), resp.Status(), path, "failed to commit codebase")
+}
+
+func (b *HTTPBackend) DeleteCodebase(ctx context.Context, path string) error {
+	resp, err := b.httpClient.R().
+		SetContext(ctx).
+		Delete(fullRepoApiPath(path))
+
+	if err != nil {
+		klog.Errorf("error happened while trying to delete codebase %q, %s", path, err.Error())
+		return newRepoError(ErrorReasonUnavailable, path, "failed to delete codebase", err)
+	}
+
+	switch {
+	case resp.IsSuccess(), resp.StatusCode() == http.StatusNotFound:
+		return nil
+	}
+
+	return errorForStatus(resp.StatusCode(), resp.Status(), path, "failed to delete codebase")
+}
+
+func (b *HTTPBackend) ListCodebases(ctx context.Context) ([]string, error) {
+	resp, err := b.httpClient.R().
+		SetContext(ctx).
+		Get(commons.DefaultPipyRepoApiPath)
+
+	if err != nil {
+		klog.Errorf("Failed to list codebases, error: %s", err.Error())
+		return nil, newRepoError(ErrorReasonUnavailable, "/", "failed to list codebases", err)
+	}
+
+	if resp.IsError() {
+		return nil, errorForStatus(resp.StatusCode(), resp.Status(), "/", "failed to list codebases")
+	}
+
+	result := make([]string, 0)
+	for _, line := range strings.Split(string(resp.Body()), "\n") {
+		if path := strings.TrimSpace(line); path != "" {
+			result = append(result, path)
+		}
+	}
+
+	return result, nil
+}
+
+func (b *HTTPBackend) GetFile(ctx context.Context, path string) (string, error) {
+	resp, err := b.httpClient.R().
+		SetContext(ctx).
+		Get(fullFileApiPath(path))
+
+	if err != nil {
+		klog.Errorf("Failed to get path %q, error: %s", path, err.Error())
+		return "", newRepoError(ErrorReasonUnavailable, path, "failed to get file", err)
+	}
+
+	if resp.IsError() {
+		return "", errorForStatus(resp.StatusCode(), resp.Status(), path, "failed to get file")
+	}
+
+	return string(resp.Body()), nil
+}
+
+func (b *HTTPBackend) UpsertFile(ctx context.Context, path string, content interface{}) error {
+	// FIXME: temp solution, refine it later
+	contentType := "text/plain"
+	if strings.HasSuffix(path, ".json") {
+		contentType = "application/json"
+	}
+
+	resp, err := b.httpClient.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", contentType).
+		SetBody(content).
+		Post(fullFileApiPath(path))
+
+	if err != nil {
+		klog.Errorf("error happened while trying to upsert %q to repo, %s", path, err.Error())
+		return newRepoError(ErrorReasonUnavailable, path, "failed to upsert file", err)
+	}
+
+	if resp.IsSuccess() {
+		return nil
+	}
+
+	klog.Errorf("repo server responsed with error HTTP code: %d, error: %s", resp.StatusCode(), resp.Status())
+	return errorForStatus(resp.StatusCode(), resp.Status(), path, "failed to upsert file")
+}
+
+func (b *HTTPBackend) DeleteFile(ctx context.Context, path string) error {
+	resp, err := b.httpClient.R().
+		SetContext(ctx).
+		Delete(fullFileApiPath(path))
+
+	if err != nil {
+		klog.Errorf("error happened while trying to delete %q from repo, %s", path, err.Error())
+		return newRepoError(ErrorReasonUnavailable, path, "failed to delete file", err)
+	}
+
+	switch {
+	case resp.IsSuccess(), resp.StatusCode() == http.StatusNotFound:
+		return nil
+	}
+
+	return errorForStatus(resp.StatusCode(), resp.Status(), path, "failed to delete file")
+}
+
+func fullRepoApiPath(path string) string {
+	return fmt.Sprintf("%s%s", commons.DefaultPipyRepoApiPath, path)
+}
+
+func fullFileApiPath(path string) string {
+	return fmt.Sprintf("%s%s", commons.DefaultPipyFileApiPath, path)
+}
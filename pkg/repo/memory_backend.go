@@ -0,0 +1,230 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package repo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryBackend keeps codebases in memory, it's intended for unit tests
+type MemoryBackend struct {
+	mu        sync.RWMutex
+	codebases map[string]*memoryCodebase
+}
+
+type memoryCodebase struct {
+	version int64
+	base    string
+	// files of the codebase itself, keyed by the path relative to the codebase
+	files map[string][]byte
+}
+
+var _ Backend = &MemoryBackend{}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		codebases: make(map[string]*memoryCodebase),
+	}
+}
+
+func (b *MemoryBackend) GetCodebase(_ context.Context, path string) (*Codebase, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.getCodebase(path)
+}
+
+func (b *MemoryBackend) getCodebase(path string) (*Codebase, error) {
+	cb, ok := b.codebases[path]
+	if !ok {
+		return nil, newRepoError(ErrorReasonNotFound, path, "failed to get codebase", nil)
+	}
+
+	codebase := &Codebase{
+		Version: cb.version,
+		Path:    path,
+		Base:    cb.base,
+	}
+	for file := range cb.files {
+		codebase.Files = append(codebase.Files, file)
+	}
+	for p, other := range b.codebases {
+		if other.base == path {
+			codebase.Derived = append(codebase.Derived, p)
+		}
+	}
+	sort.Strings(codebase.Files)
+	sort.Strings(codebase.Derived)
+
+	return codebase, nil
+}
+
+func (b *MemoryBackend) CreateCodebase(ctx context.Context, path string) (*Codebase, error) {
+	return b.DeriveCodebase(ctx, path, "")
+}
+
+func (b *MemoryBackend) DeriveCodebase(_ context.Context, path, base string) (*Codebase, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.codebases[path]; ok {
+		return nil, newRepoError(ErrorReasonConflict, path, "failed to create codebase", fmt.Errorf("already exists"))
+	}
+
+	if base != "" {
+		if _, ok := b.codebases[base]; !ok {
+			return nil, newRepoError(ErrorReasonNotFound, base, "failed to derive codebase", fmt.Errorf("parent of %q doesn't exist", path))
+		}
+	}
+
+	b.codebases[path] = &memoryCodebase{version: 1, base: base, files: make(map[string][]byte)}
+
+	return b.getCodebase(path)
+}
+
+func (b *MemoryBackend) CommitCodebase(_ context.Context, path string, version int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cb, ok := b.codebases[path]
+	if !ok {
+		return newRepoError(ErrorReasonNotFound, path, "failed to commit codebase", nil)
+	}
+
+	if version <= cb.version {
+		return newRepoError(ErrorReasonConflict, path, "failed to commit codebase",
+			fmt.Errorf("version %d is not newer than %d", version, cb.version))
+	}
+
+	cb.version = version
+
+	return nil
+}
+
+func (b *MemoryBackend) DeleteCodebase(_ context.Context, path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.codebases, path)
+
+	return nil
+}
+
+func (b *MemoryBackend) ListCodebases(_ context.Context) ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	result := make([]string, 0, len(b.codebases))
+	for path := range b.codebases {
+		result = append(result, path)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+func (b *MemoryBackend) GetFile(_ context.Context, path string) (string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	codebase, file, ok := b.locate(path)
+	if !ok {
+		return "", newRepoError(ErrorReasonNotFound, path, "failed to get file", nil)
+	}
+
+	content, ok := b.effectiveFiles(codebase)[file]
+	if !ok {
+		return "", newRepoError(ErrorReasonNotFound, path, "failed to get file", nil)
+	}
+
+	return string(content), nil
+}
+
+func (b *MemoryBackend) UpsertFile(_ context.Context, path string, content interface{}) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	codebase, file, ok := b.locate(path)
+	if !ok {
+		return newRepoError(ErrorReasonNotFound, path, "failed to upsert file", fmt.Errorf("no codebase contains it"))
+	}
+
+	data, err := contentBytes(content)
+	if err != nil {
+		return newRepoError(ErrorReasonUnknown, path, "failed to upsert file", err)
+	}
+
+	b.codebases[codebase].files[file] = data
+
+	return nil
+}
+
+func (b *MemoryBackend) DeleteFile(_ context.Context, path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if codebase, file, ok := b.locate(path); ok {
+		delete(b.codebases[codebase].files, file)
+	}
+
+	return nil
+}
+
+// locate finds the codebase which the file belongs to, it's the one with the longest matched path
+func (b *MemoryBackend) locate(path string) (string, string, bool) {
+	codebase := ""
+	for p := range b.codebases {
+		if strings.HasPrefix(path, p+"/") && len(p) > len(codebase) {
+			codebase = p
+		}
+	}
+
+	if codebase == "" {
+		return "", "", false
+	}
+
+	return codebase, strings.TrimPrefix(path, codebase), true
+}
+
+// effectiveFiles returns files of the codebase overlaid on the files inherited from its bases
+func (b *MemoryBackend) effectiveFiles(path string) map[string][]byte {
+	cb, ok := b.codebases[path]
+	if !ok {
+		return map[string][]byte{}
+	}
+
+	files := make(map[string][]byte)
+	if cb.base != "" {
+		files = b.effectiveFiles(cb.base)
+	}
+	for file, content := range cb.files {
+		files[file] = content
+	}
+
+	return files
+}
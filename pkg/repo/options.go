@@ -0,0 +1,67 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package repo
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+)
+
+// TokenSource returns the bearer token of repo, it's called for each request, so that a rotated token takes effect
+// without restarting, it may cache the token for a while.
+type TokenSource func(ctx context.Context) (string, error)
+
+// ClientOptions are the security settings of connections to Pipy repo
+type ClientOptions struct {
+	// TLSConfig is used for https:// repo root URLs, it carries the trusted CAs and the optional client certificate,
+	// GetClientCertificate should be used to present a certificate which is rotated
+	TLSConfig *tls.Config
+	// TokenSource provides the bearer token in the Authorization header of each request if it's not nil
+	TokenSource TokenSource
+}
+
+var (
+	clientOptionsLock sync.RWMutex
+	// clientOptions are keyed by repo root URL
+	clientOptions = map[string]ClientOptions{}
+)
+
+// SetClientOptions sets the options used by clients of the repo root URL created afterwards, clients of other repos,
+// e.g. the repos of remote clusters, are not affected. It should be called at startup before any client of the repo
+// is created.
+func SetClientOptions(repoRootUrl string, opts ClientOptions) {
+	clientOptionsLock.Lock()
+	defer clientOptionsLock.Unlock()
+
+	clientOptions[repoRootUrl] = opts
+}
+
+func clientOptionsFor(repoRootUrl string) ClientOptions {
+	clientOptionsLock.RLock()
+	defer clientOptionsLock.RUnlock()
+
+	return clientOptions[repoRootUrl]
+}
@@ -0,0 +1,94 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package repo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientOptionsScopedPerRepo(t *testing.T) {
+	authorization := map[string]string{}
+	newRepo := func(name string) *httptest.Server {
+		repo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization[name] = r.Header.Get("Authorization")
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		t.Cleanup(repo.Close)
+		return repo
+	}
+
+	local, remote := newRepo("local"), newRepo("remote")
+
+	token := "token-1"
+	SetClientOptions(local.URL, ClientOptions{
+		TokenSource: func(ctx context.Context) (string, error) {
+			return token, nil
+		},
+	})
+	t.Cleanup(func() {
+		SetClientOptions(local.URL, ClientOptions{})
+	})
+
+	localBackend := NewHTTPBackend(local.URL, defaultTransport())
+	remoteBackend := NewHTTPBackend(remote.URL, defaultTransport())
+
+	for _, want := range []string{"token-1", "token-2"} {
+		token = want
+		if _, err := localBackend.GetCodebase(context.TODO(), "/base"); !IsNotFound(err) {
+			t.Fatalf("GetCodebase of local repo = %v, want NotFound", err)
+		}
+		if got := authorization["local"]; got != fmt.Sprintf("Bearer %s", want) {
+			t.Errorf("Authorization to local repo = %q, want %q", got, "Bearer "+want)
+		}
+	}
+
+	if _, err := remoteBackend.GetCodebase(context.TODO(), "/base"); !IsNotFound(err) {
+		t.Fatalf("GetCodebase of remote repo = %v, want NotFound", err)
+	}
+	if got := authorization["remote"]; got != "" {
+		t.Errorf("Authorization to remote repo = %q, want none", got)
+	}
+}
+
+func TestTokenSourceError(t *testing.T) {
+	repo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s without token", r.Method, r.URL)
+	}))
+	defer repo.Close()
+
+	backend := NewHTTPBackendWithOptions(repo.URL, defaultTransport(), ClientOptions{
+		TokenSource: func(ctx context.Context) (string, error) {
+			return "", fmt.Errorf("secret not found")
+		},
+	})
+
+	if _, err := backend.GetCodebase(context.TODO(), "/base"); ReasonForError(err) != ErrorReasonUnavailable {
+		t.Errorf("GetCodebase = %v, want reason %s", err, ErrorReasonUnavailable)
+	}
+}
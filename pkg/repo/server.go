@@ -0,0 +1,184 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package repo
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
+	"k8s.io/klog/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Server serves the committed codebases of a backend to Pipy in the same way as Pipy repo does, so that sidecars
+// and ingress can load codebases of the file and ConfigMap backends from the URL of it, i.e.
+// http://fsm-manager.flomesh.svc:6070/repo/base/ingress/. Reads of the Pipy repo HTTP backend are forwarded to the
+// repo, so that sidecars and ingress never connect to the repo which accepts writes. It only serves GET and HEAD.
+type Server struct {
+	backend   Backend
+	addr      string
+	tlsConfig *tls.Config
+	// proxy forwards reads to Pipy repo, it's nil for other backends
+	proxy http.Handler
+}
+
+func NewServer(backend Backend, port int32) *Server {
+	return NewTLSServer(backend, port, nil)
+}
+
+// NewTLSServer creates the server which serves over TLS with tlsConfig, it serves plain HTTP if tlsConfig is nil
+func NewTLSServer(backend Backend, port int32, tlsConfig *tls.Config) *Server {
+	s := &Server{
+		backend:   backend,
+		addr:      fmt.Sprintf(":%d", port),
+		tlsConfig: tlsConfig,
+	}
+	if b, ok := backend.(*HTTPBackend); ok {
+		s.proxy = b.readProxy()
+	}
+
+	return s
+}
+
+// Start implements manager.Runnable, it serves until the context is done
+func (s *Server) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle(commons.DefaultPipyRepoPath+"/", http.StripPrefix(commons.DefaultPipyRepoPath, s))
+	server := &http.Server{Addr: s.addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second, TLSConfig: s.tlsConfig}
+
+	errCh := make(chan error, 1)
+	go func() {
+		klog.Infof("Starting repo server on %s", s.addr)
+		var err error
+		if s.tlsConfig != nil {
+			// the certificate is provided by tlsConfig
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- fmt.Errorf("repo server on %s: %w", s.addr, err)
+		}
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errCh:
+		klog.Error(err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = server.Shutdown(shutdownCtx)
+
+	return err
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, all replicas serve codebases while only the leader
+// writes them, so the backend must be shared by replicas if there are more than one, i.e. the ConfigMap backend or
+// the file backend on a ReadWriteMany volume.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// ServeHTTP returns the list of files for a path ends with /, and the content of file for others.
+// The list has one file per line, the ETag is derived from the versions of the codebase and all its bases,
+// so that Pipy reloads the codebase once any of them is committed.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.proxy != nil {
+		s.proxy.ServeHTTP(w, r)
+		return
+	}
+
+	if strings.HasSuffix(r.URL.Path, "/") {
+		s.serveCodebase(w, r, strings.TrimSuffix(r.URL.Path, "/"))
+		return
+	}
+
+	content, err := s.backend.GetFile(r.Context(), r.URL.Path)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(content))
+}
+
+func (s *Server) serveCodebase(w http.ResponseWriter, r *http.Request, path string) {
+	files := make(map[string]bool)
+	versions := make([]string, 0)
+	for p := path; p != ""; {
+		codebase, err := s.backend.GetCodebase(r.Context(), p)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		for _, file := range codebase.Files {
+			files[file] = true
+		}
+		versions = append(versions, fmt.Sprintf("%d", codebase.Version))
+		p = codebase.Base
+	}
+
+	list := make([]string, 0, len(files))
+	for file := range files {
+		list = append(list, file)
+	}
+	sort.Strings(list)
+
+	etag := strings.Join(versions, "-")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", "text/plain")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	_, _ = w.Write([]byte(strings.Join(list, "\n")))
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case IsNotFound(err):
+		w.WriteHeader(http.StatusNotFound)
+	case IsUnavailable(err):
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		klog.Errorf("Failed to serve codebase: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,246 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package repo
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"k8s.io/klog/v2"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// snapshotMetadataFile is the metadata of a snapshot in the tarball, it's placed in the directory of the codebase
+	snapshotMetadataFile = ".snapshot.json"
+)
+
+// Snapshot is the content of a codebase at a version, files are its own files keyed by the path relative to the codebase
+type Snapshot struct {
+	Path      string            `json:"path"`
+	Base      string            `json:"base,omitempty"`
+	Version   int64             `json:"version"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Files     map[string]string `json:"-"`
+}
+
+// Snapshot reads all files of the codebase with its version, inherited files are not included
+// as they're restored along with the base codebase.
+func (p *PipyRepoClient) Snapshot(ctx context.Context, path string) (*Snapshot, error) {
+	codebase, err := p.backend.GetCodebase(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		Path:      path,
+		Base:      codebase.Base,
+		Version:   codebase.Version,
+		CreatedAt: time.Now().UTC(),
+		Files:     make(map[string]string),
+	}
+
+	for _, file := range codebase.Files {
+		content, err := p.backend.GetFile(ctx, path+file)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Files[file] = content
+	}
+
+	return snapshot, nil
+}
+
+// Restore replaces files of the codebase with the ones in snapshot and commits it, files which are not in the
+// snapshot are deleted. The codebase gets a new version, it's retried with backoff on conflicts like Batch.
+func (p *PipyRepoClient) Restore(ctx context.Context, snapshot *Snapshot) error {
+	return p.retry(ctx, snapshot.Path, func() error {
+		return p.write(ctx, snapshot.Path, func() error {
+			return p.restore(ctx, snapshot)
+		})
+	})
+}
+
+func (p *PipyRepoClient) restore(ctx context.Context, snapshot *Snapshot) error {
+	codebase, err := p.backend.GetCodebase(ctx, snapshot.Path)
+	switch {
+	case err == nil:
+	case IsNotFound(err) && snapshot.Base != "":
+		codebase, err = p.backend.DeriveCodebase(ctx, snapshot.Path, snapshot.Base)
+	case IsNotFound(err):
+		codebase, err = p.backend.CreateCodebase(ctx, snapshot.Path)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, file := range codebase.Files {
+		if _, ok := snapshot.Files[file]; !ok {
+			if err := p.backend.DeleteFile(ctx, snapshot.Path+file); err != nil {
+				return err
+			}
+		}
+	}
+
+	for file, content := range snapshot.Files {
+		if err := p.backend.UpsertFile(ctx, snapshot.Path+file, content); err != nil {
+			return err
+		}
+	}
+
+	if err := p.commit(ctx, snapshot.Path, codebase.Version); err != nil {
+		return err
+	}
+
+	klog.V(2).Infof("Codebase %q is restored to the snapshot of version %d taken at %s", snapshot.Path, snapshot.Version, snapshot.CreatedAt)
+	return nil
+}
+
+// WriteSnapshots writes the snapshots as a gzipped tarball, files of each snapshot are placed in the directory of
+// the codebase path along with the metadata file .snapshot.json
+func WriteSnapshots(w io.Writer, snapshots []*Snapshot) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	for _, snapshot := range snapshots {
+		dir := strings.Trim(snapshot.Path, "/")
+
+		metadata, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, path.Join(dir, snapshotMetadataFile), metadata, snapshot.CreatedAt); err != nil {
+			return err
+		}
+
+		files := make([]string, 0, len(snapshot.Files))
+		for file := range snapshot.Files {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+
+		for _, file := range files {
+			if err := writeTarFile(tw, path.Join(dir, file), []byte(snapshot.Files[file]), snapshot.CreatedAt); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, content []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: modTime,
+	}); err != nil {
+		return err
+	}
+
+	_, err := tw.Write(content)
+	return err
+}
+
+// ReadSnapshots reads the snapshots from a gzipped tarball written by WriteSnapshots
+func ReadSnapshots(r io.Reader) ([]*Snapshot, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	// snapshots keyed by the directory, files are collected before knowing which snapshot they belong to
+	snapshots := make(map[string]*Snapshot)
+	files := make(map[string][]byte)
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean(hdr.Name)
+		if path.Base(name) == snapshotMetadataFile {
+			snapshot := &Snapshot{}
+			if err := json.Unmarshal(content, snapshot); err != nil {
+				return nil, fmt.Errorf("invalid metadata %q: %w", name, err)
+			}
+			snapshot.Files = make(map[string]string)
+			snapshots[path.Dir(name)] = snapshot
+			continue
+		}
+
+		files[name] = content
+	}
+
+	for name, content := range files {
+		// a file belongs to the snapshot of the longest matched directory
+		dir := ""
+		for d := range snapshots {
+			if strings.HasPrefix(name, d+"/") && len(d) > len(dir) {
+				dir = d
+			}
+		}
+		if dir == "" {
+			return nil, fmt.Errorf("file %q doesn't belong to any snapshot", name)
+		}
+
+		snapshots[dir].Files[strings.TrimPrefix(name, dir)] = string(content)
+	}
+
+	result := make([]*Snapshot, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		result = append(result, snapshot)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+
+	return result, nil
+}
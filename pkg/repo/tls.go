@@ -0,0 +1,79 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package repo
+
+import (
+	"context"
+	"github.com/flomesh-io/fsm-classic/pkg/commons"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"reflect"
+)
+
+// EnsureClientTLSSecret copies the Secret of repo client certificate from the mesh namespace to the given namespace,
+// so that pods in it are able to mount it. The copies are kept in sync by the manager once the certificate is renewed.
+func EnsureClientTLSSecret(api kubernetes.Interface, meshNamespace, namespace string) error {
+	if namespace == meshNamespace {
+		return nil
+	}
+
+	source, err := api.CoreV1().
+		Secrets(meshNamespace).
+		Get(context.TODO(), commons.RepoClientTLSSecretName, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("Failed to get Secret %s/%s of repo client certificate: %s", meshNamespace, commons.RepoClientTLSSecretName, err)
+		return err
+	}
+
+	secrets := api.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(context.TODO(), commons.RepoClientTLSSecretName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      commons.RepoClientTLSSecretName,
+				Namespace: namespace,
+				Labels:    map[string]string{commons.RepoClientTLSSecretLabel: "true"},
+			},
+			Data: source.Data,
+		}
+		_, err = secrets.Create(context.TODO(), secret, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	case err != nil:
+		return err
+	case !reflect.DeepEqual(secret.Data, source.Data):
+		secret.Data = source.Data
+		_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+		return err
+	default:
+		return nil
+	}
+}
@@ -24,6 +24,8 @@
 
 package repo
 
+import "time"
+
 type Codebase struct {
 	Version     int64    `json:"version,string,omitempty"`
 	Path        string   `json:"path,omitempty"`
@@ -36,6 +38,16 @@ type Codebase struct {
 	// Instances []interface, this field is not used so far by operator, just ignore it
 }
 
+// CommitMetadataFile is written to the codebase along with the changes if the change is traced
+const CommitMetadataFile = "/.commit.json"
+
+// CommitMetadata correlates a version of codebase with the trace of the change
+type CommitMetadata struct {
+	TraceID   string    `json:"traceId"`
+	Version   int64     `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
 type Router struct {
 	Routes RouterEntry `json:"routes"`
 }
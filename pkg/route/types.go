@@ -30,6 +30,8 @@ type IngressData struct {
 	Hash string `json:"hash" hash:"ignore"`
 	// Routes
 	Routes []IngressRouteSpec `json:"routes" hash:"set"`
+	// TCPRoutes, routes of the services exported with TCP protocol
+	TCPRoutes []TCPRouteSpec `json:"tcpRoutes" hash:"set"`
 }
 
 type IngressRouteSpec struct {
@@ -38,6 +40,13 @@ type IngressRouteSpec struct {
 	TLSSpec      `json:",inline"`
 }
 
+// TCPRouteSpec routes the connections accepted on the gateway port to the service
+type TCPRouteSpec struct {
+	Port         int32  `json:"-"`
+	Service      string `json:"service"`
+	BalancerSpec `json:",inline"`
+}
+
 type RouterSpec struct {
 	Host    string   `json:"-"`
 	Path    string   `json:"-"`
@@ -49,6 +58,15 @@ type BalancerSpec struct {
 	Sticky   bool          `json:"sticky,omitempty"`
 	Balancer AlgoBalancer  `json:"balancer,omitempty"`
 	Upstream *UpstreamSpec `json:"upstream,omitempty"`
+	Topology *TopologySpec `json:"topology,omitempty"`
+}
+
+type TopologySpec struct {
+	// Enabled, if true, the balancer prefers endpoints in the same zone as the ingress pod
+	Enabled bool `json:"enabled"`
+	// MinLocalEndpoints, if the number of endpoints in the same zone is less than it,
+	// the balancer spills over to endpoints in all zones
+	MinLocalEndpoints int `json:"minLocalEndpoints,omitempty"`
 }
 
 type UpstreamSpec struct {
@@ -83,6 +101,8 @@ type UpstreamEndpoint struct {
 	// Protocol is the entry's Protocol.  The protocols of entries in the same ip set are all
 	// the same.  The accepted protocols are TCP, UDP and SCTP.
 	Protocol string `json:"protocol,omitempty"`
+	// Zone is the topology zone the endpoint resides in, it's empty if unknown.
+	Zone string `json:"zone,omitempty"`
 }
 
 type ServiceRoute struct {
@@ -108,6 +128,9 @@ type Target struct {
 	Address string `json:"address"`
 	// Tag, reserved placeholder for futher features
 	Tags map[string]string `json:"tags,omitempty" hash:"set"`
+	// Weight, the weight of the target if the traffic is spread across clusters by a GlobalTrafficPolicy, the address
+	// is repeated in registry.json in proportion to it
+	Weight int `json:"weight,omitempty"`
 }
 
 type IngressConfig struct {
@@ -115,6 +138,12 @@ type IngressConfig struct {
 	TLSConfig      `json:",inline"`
 	RouterConfig   `json:",inline"`
 	BalancerConfig `json:",inline"`
+	TCPRouter      TCPRouterConfig `json:"tcpRouter"`
+}
+
+type CertificateConfig struct {
+	TrustedCAs []string `json:"trustedCAs"`
+	TLSConfig  `json:",inline"`
 }
 
 type TLSConfig struct {
@@ -125,6 +154,11 @@ type RouterConfig struct {
 	Routes map[string]RouterSpec `json:"routes"`
 }
 
+type TCPRouterConfig struct {
+	// Routes, the gateway port to the service
+	Routes map[string]string `json:"routes"`
+}
+
 type BalancerConfig struct {
 	Services map[string]BalancerSpec `json:"services"`
 }
@@ -0,0 +1,171 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package tracing exports spans to an OpenTelemetry collector through OTLP, it's a no-op until Setup is called
+// with tracing enabled.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+	"sync"
+)
+
+const (
+	tracerName = "github.com/flomesh-io/fsm-classic"
+)
+
+var (
+	providerMu sync.Mutex
+	// shutdownProvider shuts the provider installed by Reconfigure down, it's nil if tracing is disabled
+	shutdownProvider func(context.Context) error
+)
+
+// Options of the OTLP exporter
+type Options struct {
+	// ServiceName is the service.name of the resource, i.e. fsm-manager
+	ServiceName string
+	// Endpoint is the host:port of the OTLP gRPC collector
+	Endpoint string
+	// Insecure disables TLS of the connection to collector
+	Insecure bool
+	// SampleRate is the ratio of traces to be sampled, from 0 to 1
+	SampleRate float64
+}
+
+// Setup installs the global tracer provider which exports spans to the collector, and the W3C trace context
+// propagator. The returned function flushes pending spans and shuts the exporter down.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	if opts.Endpoint == "" {
+		return nil, fmt.Errorf("endpoint of OTLP collector is required")
+	}
+
+	exporterOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(opts.Endpoint)}
+	if opts.Insecure {
+		exporterOpts = append(exporterOpts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, exporterOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(opts.ServiceName)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRate))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	klog.Infof("Tracing is enabled, spans are exported to %s with sample rate %v", opts.Endpoint, opts.SampleRate)
+
+	return provider.Shutdown, nil
+}
+
+// Reconfigure replaces the global tracer provider with a new one of opts, or a no-op one if enabled is false, so that
+// spans started afterwards are exported with the new options. The previous provider is flushed and shut down.
+func Reconfigure(ctx context.Context, enabled bool, opts Options) error {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+
+	var shutdown func(context.Context) error
+	if enabled {
+		var err error
+		if shutdown, err = Setup(ctx, opts); err != nil {
+			return err
+		}
+	} else {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		if shutdownProvider != nil {
+			klog.Infof("Tracing is disabled")
+		}
+	}
+
+	previous := shutdownProvider
+	shutdownProvider = shutdown
+	if previous != nil {
+		if err := previous(ctx); err != nil {
+			klog.Warningf("Failed to shutdown the previous tracer provider: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// Shutdown flushes pending spans and shuts the provider installed by Reconfigure down, it must be called before
+// exiting
+func Shutdown(ctx context.Context) error {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+
+	if shutdownProvider == nil {
+		return nil
+	}
+
+	err := shutdownProvider(ctx)
+	shutdownProvider = nil
+
+	return err
+}
+
+// Tracer returns the tracer of FSM from the global provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// TraceID returns the trace ID of the sampled span in ctx, it's empty if there's none
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.IsSampled() {
+		return ""
+	}
+
+	return sc.TraceID().String()
+}
+
+// EndSpan records the error if any and ends the span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,56 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) since 2021,  flomesh.io Authors.
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReconfigure(t *testing.T) {
+	ctx := context.TODO()
+
+	if err := Reconfigure(ctx, true, Options{ServiceName: "test"}); err == nil {
+		t.Errorf("expected an error if endpoint of collector is missing")
+	}
+
+	if err := Reconfigure(ctx, true, Options{ServiceName: "test", Endpoint: "127.0.0.1:4317", Insecure: true, SampleRate: 1}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if _, span := Tracer().Start(ctx, "test"); !span.SpanContext().IsSampled() {
+		t.Errorf("expected spans to be sampled once tracing is enabled")
+	}
+
+	if err := Reconfigure(ctx, false, Options{}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if _, span := Tracer().Start(ctx, "test"); span.SpanContext().IsValid() {
+		t.Errorf("expected spans to be no-op once tracing is disabled")
+	}
+
+	if err := Shutdown(ctx); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
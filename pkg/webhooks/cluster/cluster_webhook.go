@@ -37,7 +37,6 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/validation"
-	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/klog/v2"
 	"net"
 )
@@ -139,7 +138,8 @@ func (w *ClusterDefaulter) SetDefaults(obj interface{}) {
 }
 
 type ClusterValidator struct {
-	k8sAPI *kube.K8sAPI
+	k8sAPI      *kube.K8sAPI
+	configStore *config.Store
 }
 
 func (w *ClusterValidator) RuntimeObject() runtime.Object {
@@ -176,7 +176,7 @@ func (w *ClusterValidator) ValidateCreate(obj interface{}) error {
 		}
 	}
 
-	return doValidation(obj)
+	return w.doValidation(obj)
 }
 
 func (w *ClusterValidator) ValidateUpdate(oldObj, obj interface{}) error {
@@ -194,20 +194,21 @@ func (w *ClusterValidator) ValidateUpdate(oldObj, obj interface{}) error {
 		return errors.New("cannot update an immutable field: spec.IsInCluster")
 	}
 
-	return doValidation(obj)
+	return w.doValidation(obj)
 }
 
 func (w *ClusterValidator) ValidateDelete(obj interface{}) error {
 	return nil
 }
 
-func NewValidator(k8sAPI *kube.K8sAPI) *ClusterValidator {
+func NewValidator(k8sAPI *kube.K8sAPI, configStore *config.Store) *ClusterValidator {
 	return &ClusterValidator{
-		k8sAPI: k8sAPI,
+		k8sAPI:      k8sAPI,
+		configStore: configStore,
 	}
 }
 
-func doValidation(obj interface{}) error {
+func (w *ClusterValidator) doValidation(obj interface{}) error {
 	c, ok := obj.(*clusterv1alpha1.Cluster)
 	if !ok {
 		return nil
@@ -241,44 +242,53 @@ func doValidation(obj interface{}) error {
 			return errors.New("GatewayHost is required in OutCluster mode")
 		}
 
-		if c.Spec.Kubeconfig == "" {
-			return fmt.Errorf("kubeconfig must be set in OutCluster mode")
+		if c.Spec.Kubeconfig == "" && c.Spec.KubeconfigSecretRef == nil {
+			return fmt.Errorf("either kubeconfigSecretRef or kubeconfig must be set in OutCluster mode")
+		}
+
+		if c.Spec.Kubeconfig != "" {
+			if c.Spec.KubeconfigSecretRef != nil {
+				return fmt.Errorf("kubeconfig and kubeconfigSecretRef are mutually exclusive")
+			}
+
+			mc := w.configStore.MeshConfig.GetConfig()
+			if mc.Cluster.StrictKubeconfig {
+				return fmt.Errorf("inline kubeconfig is not allowed in strict mode, please store it in a Secret and reference it by kubeconfigSecretRef")
+			}
+		}
+
+		if c.Spec.KubeconfigSecretRef != nil && c.Spec.KubeconfigSecretRef.Name == "" {
+			return fmt.Errorf("kubeconfigSecretRef.name is required")
 		}
 
 		//if c.Name == "local" {
 		//	return errors.New("Cluster Name 'local' is reserved for InCluster Mode ONLY, please change the cluster name")
 		//}
 
-		isDNSName := false
-		if ipErrs := validation.IsValidIPv4Address(field.NewPath(""), host); len(ipErrs) > 0 {
-			// Not IPv4 address
-			klog.Warningf("%q is NOT a valid IPv4 address: %v", host, ipErrs)
+		// Both IPv4 and IPv6 addresses are supported
+		gwIP := net.ParseIP(host)
+		if gwIP == nil {
+			// Not IP address
+			klog.Warningf("%q is NOT a valid IP address", host)
 			if dnsErrs := validation.IsDNS1123Subdomain(host); len(dnsErrs) > 0 {
 				// Not valid DNS domain name
 				return fmt.Errorf("invalid DNS name %q: %v", host, dnsErrs)
-			} else {
-				// is DNS name
-				isDNSName = true
 			}
-		}
 
-		var gwIPv4 net.IP
-		if isDNSName {
-			ipAddr, err := net.ResolveIPAddr("ip4", host)
+			// is DNS name
+			ipAddr, err := net.ResolveIPAddr("ip", host)
 			if err != nil {
 				return fmt.Errorf("%q cannot be resolved to IP", host)
 			}
 			klog.Infof("%q is resolved to IP: %s", host, ipAddr.IP)
-			gwIPv4 = ipAddr.IP.To4()
-		} else {
-			gwIPv4 = net.ParseIP(host).To4()
+			gwIP = ipAddr.IP
 		}
 
-		if gwIPv4 == nil {
-			return fmt.Errorf("%q cannot be resolved to a IPv4 address", host)
+		if gwIP == nil {
+			return fmt.Errorf("%q cannot be resolved to a IP address", host)
 		}
 
-		if gwIPv4 != nil && (gwIPv4.IsLoopback() || gwIPv4.IsUnspecified()) {
+		if gwIP.IsLoopback() || gwIP.IsUnspecified() {
 			return fmt.Errorf("gateway Host %s is resolved to Loopback IP or Unspecified", host)
 		}
 
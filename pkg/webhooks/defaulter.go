@@ -27,6 +27,7 @@ package webhooks
 import (
 	"context"
 	"encoding/json"
+	"github.com/flomesh-io/fsm-classic/pkg/metrics"
 	"net/http"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
@@ -45,7 +46,11 @@ func (h *mutatingHandler) InjectDecoder(d *admission.Decoder) error {
 }
 
 // Handle handles admission requests.
-func (h *mutatingHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+func (h *mutatingHandler) Handle(ctx context.Context, req admission.Request) (resp admission.Response) {
+	defer func() {
+		metrics.ObserveAdmission(metrics.WebhookMutating, req, resp)
+	}()
+
 	if h.defaulter == nil {
 		panic("defaulter should never be nil")
 	}
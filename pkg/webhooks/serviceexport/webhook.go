@@ -25,6 +25,7 @@
 package serviceexport
 
 import (
+	"fmt"
 	svcexpv1alpha1 "github.com/flomesh-io/fsm-classic/apis/serviceexport/v1alpha1"
 	flomeshadmission "github.com/flomesh-io/fsm-classic/pkg/admission"
 	"github.com/flomesh-io/fsm-classic/pkg/commons"
@@ -143,10 +144,29 @@ func NewValidator(k8sAPI *kube.K8sAPI) *ServiceExportValidator {
 }
 
 func doValidation(obj interface{}) error {
-	//serviceExport, ok := obj.(*svcexpv1alpha1.ServiceExport)
-	//if !ok {
-	//    return nil
-	//}
+	serviceExport, ok := obj.(*svcexpv1alpha1.ServiceExport)
+	if !ok {
+		return nil
+	}
+
+	if serviceExport.IsTCP() {
+		// each port of service is exported on a dedicated gateway port
+		ports := make(map[int32]bool)
+		for _, r := range serviceExport.Spec.Rules {
+			if ports[r.PortNumber] {
+				return fmt.Errorf("duplicated portNumber %d in rules of TCP ServiceExport", r.PortNumber)
+			}
+			ports[r.PortNumber] = true
+		}
+
+		return nil
+	}
+
+	for _, r := range serviceExport.Spec.Rules {
+		if r.Path == "" || r.PathType == nil {
+			return fmt.Errorf("path and pathType are required in rules of HTTP ServiceExport, portNumber=%d", r.PortNumber)
+		}
+	}
 
 	return nil
 }
@@ -27,6 +27,7 @@ package webhooks
 import (
 	"context"
 	goerrors "errors"
+	"github.com/flomesh-io/fsm-classic/pkg/metrics"
 	admissionv1 "k8s.io/api/admission/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -48,7 +49,11 @@ func (h *validatingHandler) InjectDecoder(d *admission.Decoder) error {
 }
 
 // Handle handles admission requests.
-func (h *validatingHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+func (h *validatingHandler) Handle(ctx context.Context, req admission.Request) (resp admission.Response) {
+	defer func() {
+		metrics.ObserveAdmission(metrics.WebhookValidating, req, resp)
+	}()
+
 	if h.validator == nil {
 		panic("validator should never be nil")
 	}